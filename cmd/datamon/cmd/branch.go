@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"text/template"
+
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var branchCmd = &cobra.Command{
+	Use:   "branch",
+	Short: "Commands to manage branches for a repo",
+	Long: `Commands to manage branches for a repo.
+
+A branch is a mutable reference to a bundle, its head. Bundles uploaded
+on a branch have the head of the branch as parent, and become the new head.
+
+Heads are moved with a compare-and-swap, so that concurrent uploads on the same
+branch are detected: only the first one moves the branch.
+`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+var branchDescriptorTemplate *template.Template
+
+func applyBranchTemplate(branch model.BranchDescriptor) error {
+	var buf bytes.Buffer
+	if err := branchDescriptorTemplate.Execute(&buf, branch); err != nil {
+		return fmt.Errorf("executing template: %w", err)
+	}
+	log.Println(buf.String())
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(branchCmd)
+
+	branchDescriptorTemplate = func() *template.Template {
+		const listLineTemplateString = `{{.Name}} , {{.BundleID}} , {{.Generation}} , {{.Timestamp}}`
+		return template.Must(template.New("list line").Parse(listLineTemplateString))
+	}()
+}
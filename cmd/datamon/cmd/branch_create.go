@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/dlogger"
+	"github.com/spf13/cobra"
+)
+
+var branchCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a branch",
+	Long: `Create a branch of a repo.
+
+The head of the branch is the bundle given by --bundle or --label.
+Without any, the branch starts without bundle, and its first upload has no parent.
+`,
+	Example: `% datamon branch create --repo ritesh-test-repo --branch main --label init`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		contributor, err := paramsToContributor(datamonFlags)
+		if err != nil {
+			wrapFatalln("populate contributor struct", err)
+			return
+		}
+		remoteStores, err := paramsToDatamonContext(ctx, datamonFlags)
+		if err != nil {
+			wrapFatalln("create remote stores", err)
+			return
+		}
+		if datamonFlags.bundle.ID != "" || datamonFlags.label.Name != "" {
+			// the latest bundle is never picked implicitly
+			if err = setLatestOrLabelledBundle(ctx, remoteStores); err != nil {
+				wrapFatalln("determine bundle id", err)
+				return
+			}
+		}
+		logger, err := dlogger.GetLogger(datamonFlags.root.logLevel)
+		if err != nil {
+			wrapFatalln("failed to set log level", err)
+			return
+		}
+		branch, err := core.CreateBranch(ctx, remoteStores, datamonFlags.repo.RepoName, datamonFlags.branch.Name,
+			datamonFlags.bundle.ID, contributor, core.BranchLogger(logger))
+		if err != nil {
+			wrapFatalln(fmt.Sprintf("create branch %s", datamonFlags.branch.Name), err)
+			return
+		}
+		if err = applyBranchTemplate(branch); err != nil {
+			wrapFatalln("print branch", err)
+			return
+		}
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+func init() {
+	requiredFlags := []string{addRepoNameOptionFlag(branchCreateCmd)}
+	requiredFlags = append(requiredFlags, addBranchFlag(branchCreateCmd))
+	addBundleFlag(branchCreateCmd)
+	addLabelNameFlag(branchCreateCmd)
+
+	for _, flag := range requiredFlags {
+		err := branchCreateCmd.MarkFlagRequired(flag)
+		if err != nil {
+			wrapFatalln("mark required flag", err)
+			return
+		}
+	}
+
+	branchCmd.AddCommand(branchCreateCmd)
+}
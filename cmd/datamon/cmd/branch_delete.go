@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/dlogger"
+	"github.com/spf13/cobra"
+)
+
+var branchDeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Delete a branch",
+	Long: `Delete a branch of a repo.
+
+The bundles of the branch are kept. A branch with the same name may be created again.
+`,
+	Example: `% datamon branch delete --repo ritesh-test-repo --branch dev`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		contributor, err := paramsToContributor(datamonFlags)
+		if err != nil {
+			wrapFatalln("populate contributor struct", err)
+			return
+		}
+		remoteStores, err := paramsToDatamonContext(ctx, datamonFlags)
+		if err != nil {
+			wrapFatalln("create remote stores", err)
+			return
+		}
+		logger, err := dlogger.GetLogger(datamonFlags.root.logLevel)
+		if err != nil {
+			wrapFatalln("failed to set log level", err)
+			return
+		}
+		err = core.DeleteBranch(ctx, remoteStores, datamonFlags.repo.RepoName, datamonFlags.branch.Name, contributor,
+			core.BranchLogger(logger))
+		if err != nil {
+			wrapFatalln("delete branch", err)
+			return
+		}
+		infoLogger.Printf("deleted branch %s", datamonFlags.branch.Name)
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+func init() {
+	requiredFlags := []string{addRepoNameOptionFlag(branchDeleteCmd)}
+	requiredFlags = append(requiredFlags, addBranchFlag(branchDeleteCmd))
+
+	for _, flag := range requiredFlags {
+		err := branchDeleteCmd.MarkFlagRequired(flag)
+		if err != nil {
+			wrapFatalln("mark required flag", err)
+			return
+		}
+	}
+
+	branchCmd.AddCommand(branchDeleteCmd)
+}
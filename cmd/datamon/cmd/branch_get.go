@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+
+	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/core/status"
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+)
+
+var branchGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Get the head of a branch",
+	Long: `Performs a direct lookup of branches by name.
+Prints the head of the branch if it exists,
+exits with ENOENT status otherwise.`,
+	Example: `% datamon branch get --repo ritesh-test-repo --branch main
+main , 1INzQ5TV4vAAfU2PbRFgPfnzEwR , 3 , 2019-03-12 22:10:24.159704 -0700 PDT`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		remoteStores, err := paramsToDatamonContext(ctx, datamonFlags)
+		if err != nil {
+			wrapFatalln("create remote stores", err)
+			return
+		}
+		branch, err := core.GetBranch(ctx, remoteStores, datamonFlags.repo.RepoName, datamonFlags.branch.Name)
+		if errors.Is(err, status.ErrNotFound) {
+			wrapFatalWithCode(int(unix.ENOENT), "didn't find branch %q", datamonFlags.branch.Name)
+			return
+		}
+		if err != nil {
+			wrapFatalln("error downloading branch information", err)
+			return
+		}
+		if err = applyBranchTemplate(branch); err != nil {
+			wrapFatalln("print branch", err)
+			return
+		}
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+func init() {
+	requiredFlags := []string{addRepoNameOptionFlag(branchGetCmd)}
+	requiredFlags = append(requiredFlags, addBranchFlag(branchGetCmd))
+
+	for _, flag := range requiredFlags {
+		err := branchGetCmd.MarkFlagRequired(flag)
+		if err != nil {
+			wrapFatalln("mark required flag", err)
+			return
+		}
+	}
+
+	branchCmd.AddCommand(branchGetCmd)
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/spf13/cobra"
+)
+
+var branchListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List branches",
+	Long:  "List the branches in a repo, with their head",
+	Example: `% datamon branch list --repo ritesh-test-repo
+dev , 1INzQ6WHNrDyRpkgszRuFmeqQFv , 1 , 2019-03-12 22:11:02.118203 -0700 PDT
+main , 1INzQ5TV4vAAfU2PbRFgPfnzEwR , 3 , 2019-03-12 22:10:24.159704 -0700 PDT`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		remoteStores, err := paramsToDatamonContext(ctx, datamonFlags)
+		if err != nil {
+			wrapFatalln("create remote stores", err)
+			return
+		}
+		branches, err := core.ListBranches(ctx, remoteStores, datamonFlags.repo.RepoName,
+			core.BatchSize(datamonFlags.core.BatchSize))
+		if err != nil {
+			wrapFatalln("download branch list", err)
+			return
+		}
+		for _, branch := range branches {
+			if err = applyBranchTemplate(branch); err != nil {
+				wrapFatalln("print branch", err)
+				return
+			}
+		}
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+func init() {
+	requiredFlags := []string{addRepoNameOptionFlag(branchListCmd)}
+	addBatchSizeFlag(branchListCmd)
+
+	for _, flag := range requiredFlags {
+		err := branchListCmd.MarkFlagRequired(flag)
+		if err != nil {
+			wrapFatalln("mark required flag", err)
+			return
+		}
+	}
+
+	branchCmd.AddCommand(branchListCmd)
+}
@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"log"
 	"text/template"
+	"time"
 
 	context2 "github.com/oneconcern/datamon/pkg/context"
 
 	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/model"
 	"github.com/spf13/cobra"
 )
 
@@ -67,3 +69,20 @@ func setLatestOrLabelledBundle(ctx context.Context, remote context2.Stores) erro
 	log.Printf("Using bundle: %s", datamonFlags.bundle.ID)
 	return nil
 }
+
+// logBundleRead records the read of the bundle selected by the flags in the read log of the context
+func logBundleRead(ctx context.Context, remote context2.Stores, operation model.ReadOperation, file string) error {
+	contributor, err := paramsToContributor(datamonFlags)
+	if err != nil {
+		return err
+	}
+	return core.AppendReadLog(ctx, remote, model.ReadLogEntry{
+		Repo:        datamonFlags.repo.RepoName,
+		BundleID:    datamonFlags.bundle.ID,
+		Label:       datamonFlags.label.Name,
+		File:        file,
+		Operation:   operation,
+		Contributor: contributor,
+		Timestamp:   time.Now(),
+	})
+}
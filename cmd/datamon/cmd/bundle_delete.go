@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/dlogger"
+	"github.com/spf13/cobra"
+)
+
+var bundleDeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Delete a bundle",
+	Long: `Delete a bundle from a repo.
+
+The bundle is no longer listed, nor used as the latest bundle of the repo.
+Its metadata is only removed by "datamon repo purge", after some retention period,
+and its blobs by "datamon bundle gc".
+
+A bundle which is still pointed to by some labels or branches is not deleted, unless --force is set.
+`,
+	Example: `% datamon bundle delete --repo ritesh-test-repo --bundle 1INzQ5TV4vAAfU2PbRFgPfnzEwR`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		contributor, err := paramsToContributor(datamonFlags)
+		if err != nil {
+			wrapFatalln("populate contributor struct", err)
+			return
+		}
+		remoteStores, err := paramsToDatamonContext(ctx, datamonFlags)
+		if err != nil {
+			wrapFatalln("create remote stores", err)
+			return
+		}
+		logger, err := dlogger.GetLogger(datamonFlags.root.logLevel)
+		if err != nil {
+			wrapFatalln("failed to set log level", err)
+			return
+		}
+		err = core.DeleteBundle(ctx, remoteStores, datamonFlags.repo.RepoName, datamonFlags.bundle.ID, contributor,
+			core.ForceDelete(datamonFlags.deletion.Force),
+			core.DeleteLogger(logger),
+		)
+		if err != nil {
+			wrapFatalln("delete bundle", err)
+			return
+		}
+		infoLogger.Printf("deleted bundle %s from repo %s", datamonFlags.bundle.ID, datamonFlags.repo.RepoName)
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+func init() {
+	requiredFlags := []string{addRepoNameOptionFlag(bundleDeleteCmd)}
+	requiredFlags = append(requiredFlags, addBundleFlag(bundleDeleteCmd))
+	addForceDeleteFlag(bundleDeleteCmd)
+	addLogLevel(bundleDeleteCmd)
+
+	for _, flag := range requiredFlags {
+		err := bundleDeleteCmd.MarkFlagRequired(flag)
+		if err != nil {
+			wrapFatalln("mark required flag", err)
+			return
+		}
+	}
+
+	bundleCmd.AddCommand(bundleDeleteCmd)
+}
@@ -8,6 +8,7 @@ import (
 	"regexp"
 
 	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/model"
 	"github.com/spf13/cobra"
 )
 
@@ -28,7 +29,11 @@ var BundleDownloadCmd = &cobra.Command{
 			wrapFatalln("create remote stores", err)
 			return
 		}
-		destinationStore, err := paramsToDestStore(datamonFlags, destTEmpty, "")
+		var destT DestT = destTEmpty
+		if datamonFlags.bundle.Resume {
+			destT = destTMaybeNonEmpty
+		}
+		destinationStore, err := paramsToDestStore(datamonFlags, destT, "")
 		if err != nil {
 			wrapFatalln("create destination store", err)
 			return
@@ -47,6 +52,7 @@ var BundleDownloadCmd = &cobra.Command{
 			datamonFlags.bundle.ConcurrencyFactor/fileDownloadsByConcurrencyFactor))
 		bundleOpts = append(bundleOpts, core.ConcurrentFilelistDownloads(
 			datamonFlags.bundle.ConcurrencyFactor/filelistDownloadsByConcurrencyFactor))
+		bundleOpts = append(bundleOpts, core.ResumeDownload(datamonFlags.bundle.Resume))
 
 		bundle := core.NewBundle(core.NewBDescriptor(),
 			bundleOpts...,
@@ -73,6 +79,11 @@ var BundleDownloadCmd = &cobra.Command{
 				return
 			}
 		}
+		err = logBundleRead(ctx, remoteStores, model.ReadOperationDownload, "")
+		if err != nil {
+			wrapFatalln("write read log", err)
+			return
+		}
 	},
 	PreRun: func(cmd *cobra.Command, args []string) {
 		config.populateRemoteConfig(&datamonFlags)
@@ -91,10 +102,13 @@ func init() {
 	addBundleFlag(BundleDownloadCmd)
 
 	addLabelNameFlag(BundleDownloadCmd)
+	addCacheDirFlag(BundleDownloadCmd)
+	addCacheSizeFlag(BundleDownloadCmd)
 
 	addConcurrencyFactorFlag(BundleDownloadCmd, 100)
 
 	addNameFilterFlag(BundleDownloadCmd)
+	addResumeDownloadFlag(BundleDownloadCmd)
 
 	for _, flag := range requiredFlags {
 		err := BundleDownloadCmd.MarkFlagRequired(flag)
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/model"
 	"github.com/spf13/cobra"
 )
 
@@ -47,6 +48,11 @@ var bundleDownloadFileCmd = &cobra.Command{
 			wrapFatalln("publish bundle", err)
 			return
 		}
+		err = logBundleRead(ctx, remoteStores, model.ReadOperationDownloadFile, datamonFlags.bundle.File)
+		if err != nil {
+			wrapFatalln("write read log", err)
+			return
+		}
 	},
 	PreRun: func(cmd *cobra.Command, args []string) {
 		config.populateRemoteConfig(&datamonFlags)
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"text/template"
+
+	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/dlogger"
+	"github.com/spf13/cobra"
+)
+
+var gcReportTemplate *template.Template
+
+func applyGCReportTemplate(report core.GCReport) error {
+	var buf bytes.Buffer
+	if err := gcReportTemplate.Execute(&buf, report); err != nil {
+		return fmt.Errorf("executing template: %w", err)
+	}
+	log.Println(buf.String())
+	return nil
+}
+
+var bundleGCCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Collect blobs no longer referenced by any bundle",
+	Long: `Remove from the blob store the blobs which no bundle of any repo references.
+
+All bundles of all repos in the context are scanned to mark the blobs in use.
+The remaining blobs are deleted, unless they are more recent than the grace period.
+
+Use --dry-run to report what would be collected, without deleting anything.
+`,
+	Example: `% datamon bundle gc --context ctx --dry-run
+repos: 2, bundles: 14, referenced blobs: 230, missing roots: 0
+orphan blobs: 12, within grace period: 2, collected: 10, bytes reclaimed: 20972150 (dry run)`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		remoteStores, err := paramsToDatamonContext(ctx, datamonFlags)
+		if err != nil {
+			wrapFatalln("create remote stores", err)
+			return
+		}
+		logger, err := dlogger.GetLogger(datamonFlags.root.logLevel)
+		if err != nil {
+			wrapFatalln("failed to set log level", err)
+			return
+		}
+		report, err := core.CollectGarbage(ctx, remoteStores,
+			core.GCDryRun(datamonFlags.gc.DryRun),
+			core.GCGracePeriod(datamonFlags.gc.GracePeriod),
+			core.GCListOptions(
+				core.ConcurrentList(datamonFlags.core.ConcurrencyFactor),
+				core.BatchSize(datamonFlags.core.BatchSize),
+			),
+			core.GCLogger(logger),
+		)
+		if err != nil {
+			wrapFatalln("collect garbage", err)
+			return
+		}
+		if err = applyGCReportTemplate(report); err != nil {
+			wrapFatalln("print gc report", err)
+			return
+		}
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+func init() {
+	addGCDryRunFlag(bundleGCCmd)
+	addGCGracePeriodFlag(bundleGCCmd)
+	addCoreConcurrencyFactorFlag(bundleGCCmd, 500)
+	addBatchSizeFlag(bundleGCCmd)
+	addLogLevel(bundleGCCmd)
+
+	bundleCmd.AddCommand(bundleGCCmd)
+
+	gcReportTemplate = func() *template.Template {
+		const reportTemplateString = `repos: {{.Repos}}, bundles: {{.Bundles}}, referenced blobs: {{.Referenced}}, missing roots: {{.MissingRoots}}
+orphan blobs: {{.Orphans}}, within grace period: {{.Skipped}}, collected: {{.Deleted}}, bytes reclaimed: {{.BytesReclaimed}}` +
+			`{{if .DryRun}} (dry run){{end}}`
+		return template.Must(template.New("gc report").Parse(reportTemplateString))
+	}()
+}
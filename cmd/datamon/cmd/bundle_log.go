@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var bundleLogCmd = &cobra.Command{
+	Use:   "log",
+	Short: "Show the history of a bundle",
+	Long: `Show a bundle and its ancestors, following the parents of bundles.
+
+Bundles are listed like "git log --topo-order": a bundle always comes before its parents,
+even when the clocks of the clients which uploaded them disagree. Otherwise, the most recent bundle comes first.
+The bundle is given by --bundle, --label or --branch, and defaults to the latest bundle of the repo.
+
+With --graph, the ancestry of bundles is drawn next to them.
+`,
+	Example: `% datamon bundle log --repo ritesh-test-repo --branch main --graph
+* 1INzQ8MiRfBB1uPJqaJcQnv4vOZ , 2019-03-12 22:14:05.118203 -0700 PDT , merge dev into main
+|\
+| * 1INzQ7Wy0ZuZ3Ar2Sd2Mm9hsEFB , 2019-03-12 22:13:12.417823 -0700 PDT , new features
+* | 1INzQ6WHNrDyRpkgszRuFmeqQFv , 2019-03-12 22:12:45.532018 -0700 PDT , daily dump
+|/
+* 1INzQ5TV4vAAfU2PbRFgPfnzEwR , 2019-03-12 22:10:24.159704 -0700 PDT , initial commit`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		remoteStores, err := paramsToDatamonContext(ctx, datamonFlags)
+		if err != nil {
+			wrapFatalln("create remote stores", err)
+			return
+		}
+		if datamonFlags.branch.Name != "" {
+			if datamonFlags.bundle.ID != "" || datamonFlags.label.Name != "" {
+				wrapFatalln(fmt.Sprintf("--%s is mutually exclusive with --%s and --%s",
+					addBranchFlag(nil), addBundleFlag(nil), addLabelNameFlag(nil)), nil)
+				return
+			}
+			branch, e := core.GetBranch(ctx, remoteStores, datamonFlags.repo.RepoName, datamonFlags.branch.Name)
+			if e != nil {
+				wrapFatalln(fmt.Sprintf("get branch %s", datamonFlags.branch.Name), e)
+				return
+			}
+			if branch.BundleID == "" {
+				log.Printf("branch %s has no bundle yet", branch.Name)
+				return
+			}
+			datamonFlags.bundle.ID = branch.BundleID
+		} else if err = setLatestOrLabelledBundle(ctx, remoteStores); err != nil {
+			wrapFatalln("determine bundle id", err)
+			return
+		}
+
+		history, err := core.BundleHistory(ctx, remoteStores, datamonFlags.repo.RepoName, datamonFlags.bundle.LogMax,
+			datamonFlags.bundle.ID)
+		if err != nil {
+			wrapFatalln("get bundle history", err)
+			return
+		}
+		format := func(bd model.BundleDescriptor) string {
+			var buf bytes.Buffer
+			if e := bundleDescriptorTemplate.Execute(&buf, bd); e != nil {
+				wrapFatalln("executing template", e)
+			}
+			return buf.String()
+		}
+		if !datamonFlags.bundle.LogGraph {
+			for _, bd := range history {
+				log.Println(format(bd))
+			}
+			return
+		}
+		for _, line := range renderBundleGraph(history, format) {
+			log.Println(line)
+		}
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+// renderBundleGraph draws the ancestry of bundles sorted by core.BundleHistory, with one column per line of descent.
+//
+// Parents which are not in the history are not drawn.
+func renderBundleGraph(history model.BundleDescriptors, format func(model.BundleDescriptor) string) []string {
+	inHistory := make(map[string]bool, len(history))
+	for _, bd := range history {
+		inHistory[bd.ID] = true
+	}
+	var (
+		lines   []string
+		columns []string // the bundles expected next in each column
+	)
+	for _, bd := range history {
+		col := indexOf(columns, bd.ID)
+		if col < 0 {
+			columns = append(columns, bd.ID)
+			col = len(columns) - 1
+		}
+		marks := make([]string, len(columns))
+		for i := range columns {
+			marks[i] = "|"
+		}
+		marks[col] = "*"
+		lines = append(lines, strings.Join(marks, " ")+" "+format(bd))
+
+		parents := make([]string, 0, len(bd.Parents))
+		for _, parent := range bd.Parents {
+			if inHistory[parent] {
+				parents = append(parents, parent)
+			}
+		}
+		if len(parents) == 0 {
+			// the line of descent ends, the columns on its right move left
+			if col < len(columns)-1 {
+				lines = append(lines, graphLine(len(columns), func(i int) (int, string) {
+					if i < col {
+						return 2 * i, "|"
+					}
+					return 2*i - 1, "/"
+				}, col))
+			}
+			columns = append(columns[:col], columns[col+1:]...)
+		} else {
+			columns[col] = parents[0]
+			for _, parent := range parents[1:] {
+				if indexOf(columns, parent) >= 0 {
+					continue
+				}
+				// a new column forks on the right of the bundle
+				lines = append(lines, graphLine(len(columns)+1, func(i int) (int, string) {
+					switch {
+					case i <= col:
+						return 2 * i, "|"
+					case i == col+1:
+						return 2*col + 1, "\\"
+					default:
+						return 2*i - 1, "\\"
+					}
+				}))
+				columns = append(columns[:col+1], append([]string{parent}, columns[col+1:]...)...)
+			}
+		}
+
+		// columns expecting the same bundle join
+		for {
+			j := duplicateColumn(columns)
+			if j < 0 {
+				break
+			}
+			lines = append(lines, graphLine(len(columns), func(i int) (int, string) {
+				if i < j {
+					return 2 * i, "|"
+				}
+				return 2*i - 1, "/"
+			}))
+			columns = append(columns[:j], columns[j+1:]...)
+		}
+	}
+	return lines
+}
+
+// graphLine draws a line of the graph, with a mark for each column but the skipped ones
+func graphLine(width int, mark func(int) (int, string), skipped ...int) string {
+	line := []byte(strings.Repeat(" ", 2*width))
+	for i := 0; i < width; i++ {
+		if indexOfInt(skipped, i) >= 0 {
+			continue
+		}
+		pos, char := mark(i)
+		line[pos] = char[0]
+	}
+	return strings.TrimRight(string(line), " ")
+}
+
+// duplicateColumn returns the first column expecting the same bundle as some column on its left, or -1
+func duplicateColumn(columns []string) int {
+	seen := make(map[string]bool, len(columns))
+	for j, id := range columns {
+		if seen[id] {
+			return j
+		}
+		seen[id] = true
+	}
+	return -1
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func indexOfInt(values []int, value int) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func init() {
+	requiredFlags := []string{addRepoNameOptionFlag(bundleLogCmd)}
+	addBundleFlag(bundleLogCmd)
+	addLabelNameFlag(bundleLogCmd)
+	addBranchFlag(bundleLogCmd)
+	addLogGraphFlag(bundleLogCmd)
+	addLogMaxFlag(bundleLogCmd)
+
+	for _, flag := range requiredFlags {
+		err := bundleLogCmd.MarkFlagRequired(flag)
+		if err != nil {
+			wrapFatalln("mark required flag", err)
+			return
+		}
+	}
+
+	bundleCmd.AddCommand(bundleLogCmd)
+}
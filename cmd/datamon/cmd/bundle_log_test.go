@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/localfs"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderBundleGraph(t *testing.T) {
+	bundle := func(id string, parents ...string) model.BundleDescriptor {
+		return model.BundleDescriptor{ID: id, Parents: parents}
+	}
+	render := func(history ...model.BundleDescriptor) string {
+		return strings.Join(renderBundleGraph(history, func(bd model.BundleDescriptor) string { return bd.ID }), "\n")
+	}
+
+	require.Equal(t, `* c
+* b
+* a`, render(bundle("c", "b"), bundle("b", "a"), bundle("a")))
+
+	require.Equal(t, `* merge
+|\
+| * feature
+* | main
+|/
+* root`, render(bundle("merge", "main", "feature"), bundle("feature", "root"), bundle("main", "root"), bundle("root")))
+
+	// parents out of the history are not drawn, and unrelated lines of descent end
+	require.Equal(t, `* b
+| * other
+|/
+* a`, render(bundle("b", "a"), bundle("other", "a", "purged"), bundle("a")))
+	require.Equal(t, `* b
+| * other
+| * unrelated
+* a`, render(bundle("b", "a"), bundle("other", "unrelated"), bundle("unrelated"), bundle("a")))
+
+	require.Equal(t, `* x
+| * y
+|/
+* a`, render(bundle("x", "a"), bundle("y", "a"), bundle("a")))
+}
+
+func TestRenderBundleGraphSkewedClocks(t *testing.T) {
+	ctx := context.Background()
+	memStore := func() storage.Store { return localfs.New(afero.NewMemMapFs()) }
+	stores := context2.NewStores(nil, nil, memStore(), memStore(), memStore())
+	const repo = "log-repo"
+	require.NoError(t, core.CreateRepo(model.RepoDescriptor{
+		Name:        repo,
+		Description: "test",
+		Timestamp:   time.Now(),
+		Contributor: model.Contributor{Name: "test", Email: "t@test.com"},
+	}, stores, nil))
+
+	now := time.Now()
+	uploadAt := func(offset time.Duration, message string, parents ...string) string {
+		source := memStore()
+		require.NoError(t, source.Put(ctx, "file", bytes.NewBufferString(message), storage.NoOverWrite))
+		bd := core.NewBDescriptor(core.Message(message), core.Parents(parents))
+		bd.Timestamp = now.Add(offset)
+		bundle := core.NewBundle(bd, core.Repo(repo), core.ConsumableStore(source), core.ContextStores(stores))
+		require.NoError(t, core.Upload(ctx, bundle))
+		return bundle.BundleID
+	}
+
+	// the client which uploaded f1 lags behind: f1 looks older than its parent, yet is drawn above it
+	root := uploadAt(0, "root")
+	f1 := uploadAt(-time.Hour, "f1", root)
+	feature := uploadAt(3*time.Minute, "feature", f1)
+	main := uploadAt(2*time.Minute, "main", root)
+	merge := uploadAt(4*time.Minute, "merge", main, feature)
+
+	history, err := core.BundleHistory(ctx, stores, repo, 0, merge)
+	require.NoError(t, err)
+	lines := renderBundleGraph(history, func(bd model.BundleDescriptor) string { return bd.Message })
+	require.Equal(t, `* merge
+|\
+| * feature
+* | main
+| * f1
+|/
+* root`, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"text/template"
+
+	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/core/status"
+	"github.com/oneconcern/datamon/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var bundleMergeCmd = &cobra.Command{
+	Use:   "merge",
+	Short: "Merge two bundles",
+	Long: `Create a bundle with the changes of two bundles since a base bundle, i.e. a three-way merge.
+
+The merged bundle has both bundles as parents, --ours first. The base defaults to their best common ancestor.
+No data is uploaded: the merged bundle refers to the files of the merged ones.
+
+Paths changed differently in both bundles are conflicts, reported as:
+  name , change in ours , change in theirs
+where changes are A (added), D (deleted) or U (updated).
+With --strategy fail, the default, no bundle is created when there are conflicts.
+With --strategy ours or theirs, conflicts are resolved by keeping the change of that side.
+`,
+	Example: `% datamon bundle merge --repo ritesh-test-repo --ours 1INzQ6WHNrDyRpkgszRuFmeqQFv --theirs 1INzQ7Wy0ZuZ3Ar2Sd2Mm9hsEFB --message "merge dev"
+conflict , U , D
+1 conflicts: merge conflict: 1 paths changed differently in bundles 1INzQ6WHNrDyRpkgszRuFmeqQFv and 1INzQ7Wy0ZuZ3Ar2Sd2Mm9hsEFB`,
+	Run: func(cmd *cobra.Command, args []string) {
+		const conflictTemplateString = `{{.Name}} , {{.Ours.Type}} , {{.Theirs.Type}}`
+		conflictTemplate := template.Must(template.New("conflict").Parse(conflictTemplateString))
+
+		ctx := context.Background()
+
+		contributor, err := paramsToContributor(datamonFlags)
+		if err != nil {
+			wrapFatalln("populate contributor struct", err)
+			return
+		}
+		remoteStores, err := paramsToDatamonContext(ctx, datamonFlags)
+		if err != nil {
+			wrapFatalln("create remote stores", err)
+			return
+		}
+		strategy := core.MergeStrategy(datamonFlags.merge.Strategy)
+		if !strategy.IsValid() {
+			wrapFatalln("invalid strategy", fmt.Errorf("unsupported merge strategy %q", strategy))
+			return
+		}
+		repo := datamonFlags.repo.RepoName
+		base := datamonFlags.merge.Base
+		if base == "" {
+			ancestor, e := core.CommonAncestor(ctx, remoteStores, repo, datamonFlags.merge.Ours, datamonFlags.merge.Theirs)
+			if e != nil {
+				wrapFatalln("find the base of the merge", e)
+				return
+			}
+			base = ancestor.ID
+			log.Printf("merging from bundle %s", base)
+		}
+
+		bundle := func(id string) *core.Bundle {
+			bundleOpts := paramsToBundleOpts(remoteStores)
+			bundleOpts = append(bundleOpts, core.Repo(repo))
+			bundleOpts = append(bundleOpts, core.BundleID(id))
+			return core.NewBundle(core.NewBDescriptor(), bundleOpts...)
+		}
+		bundleOpts := paramsToBundleOpts(remoteStores)
+		bundleOpts = append(bundleOpts, core.Repo(repo))
+		merged := core.NewBundle(core.NewBDescriptor(
+			core.Message(datamonFlags.bundle.Message),
+			core.Contributor(contributor),
+		), bundleOpts...)
+
+		conflicts, err := core.Merge(ctx, bundle(base), bundle(datamonFlags.merge.Ours), bundle(datamonFlags.merge.Theirs),
+			merged, strategy)
+		for _, conflict := range conflicts {
+			var buf bytes.Buffer
+			if e := conflictTemplate.Execute(&buf, conflict); e != nil {
+				wrapFatalln("executing template", e)
+				return
+			}
+			log.Println(buf.String())
+		}
+		if errors.Is(err, status.ErrConflict) {
+			wrapFatalWithCode(1, "%d conflicts: %v", len(conflicts), err)
+			return
+		}
+		if err != nil {
+			wrapFatalln("merge bundles", err)
+			return
+		}
+		log.Printf("Merged bundle id:%s ", merged.BundleID)
+
+		if datamonFlags.label.Name != "" {
+			labelDescriptor := core.NewLabelDescriptor(
+				core.LabelContributor(contributor),
+			)
+			label := core.NewLabel(labelDescriptor,
+				core.LabelName(datamonFlags.label.Name),
+			)
+			err = label.UploadDescriptor(ctx, merged)
+			if err != nil {
+				wrapFatalln("upload label", err)
+				return
+			}
+			log.Printf("set label '%v'", datamonFlags.label.Name)
+		}
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+func init() {
+	requiredFlags := []string{addRepoNameOptionFlag(bundleMergeCmd)}
+	requiredFlags = append(requiredFlags, addMergeOursFlag(bundleMergeCmd))
+	requiredFlags = append(requiredFlags, addMergeTheirsFlag(bundleMergeCmd))
+	requiredFlags = append(requiredFlags, addCommitMessageFlag(bundleMergeCmd))
+	addMergeBaseFlag(bundleMergeCmd)
+	addMergeStrategyFlag(bundleMergeCmd)
+	addLabelNameFlag(bundleMergeCmd)
+
+	for _, flag := range requiredFlags {
+		err := bundleMergeCmd.MarkFlagRequired(flag)
+		if err != nil {
+			wrapFatalln("mark required flag", err)
+			return
+		}
+	}
+
+	bundleCmd.AddCommand(bundleMergeCmd)
+}
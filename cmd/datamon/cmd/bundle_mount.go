@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"os"
 
+	units "github.com/docker/go-units"
 	daemonizer "github.com/jacobsa/daemonize"
 
 	"github.com/oneconcern/datamon/pkg/core"
 	"github.com/oneconcern/datamon/pkg/dlogger"
+	"github.com/oneconcern/datamon/pkg/model"
 
 	"github.com/spf13/cobra"
 )
@@ -106,12 +108,19 @@ var mountBundleCmd = &cobra.Command{
 			onDaemonError("determine bundle id", err)
 			return
 		}
+		prefetchMemory, err := units.FromHumanSize(datamonFlags.bundle.PrefetchMemory)
+		if err != nil {
+			onDaemonError("invalid prefetch memory", err)
+			return
+		}
 		bd := core.NewBDescriptor()
 		bundleOpts := paramsToBundleOpts(remoteStores)
 		bundleOpts = append(bundleOpts, core.Repo(datamonFlags.repo.RepoName))
 		bundleOpts = append(bundleOpts, core.ConsumableStore(consumableStore))
 		bundleOpts = append(bundleOpts, core.BundleID(datamonFlags.bundle.ID))
 		bundleOpts = append(bundleOpts, core.Streaming(datamonFlags.bundle.Stream))
+		bundleOpts = append(bundleOpts, core.PrefetchLeaves(datamonFlags.bundle.PrefetchLeaves))
+		bundleOpts = append(bundleOpts, core.PrefetchMemory(prefetchMemory))
 		bundleOpts = append(bundleOpts, core.ConcurrentFilelistDownloads(
 			datamonFlags.bundle.ConcurrencyFactor/filelistDownloadsByConcurrencyFactor))
 		bundle := core.NewBundle(bd,
@@ -127,6 +136,10 @@ var mountBundleCmd = &cobra.Command{
 			onDaemonError("create read only filesystem", err)
 			return
 		}
+		if err = logBundleRead(ctx, remoteStores, model.ReadOperationMount, ""); err != nil {
+			onDaemonError("write read log", err)
+			return
+		}
 		if err = fs.MountReadOnly(datamonFlags.bundle.MountPath); err != nil {
 			onDaemonError("mount read only filesystem", err)
 			return
@@ -155,6 +168,10 @@ func init() {
 	addLogLevel(mountBundleCmd)
 	addStreamFlag(mountBundleCmd)
 	addLabelNameFlag(mountBundleCmd)
+	addCacheDirFlag(mountBundleCmd)
+	addCacheSizeFlag(mountBundleCmd)
+	addPrefetchFlag(mountBundleCmd)
+	addPrefetchMemoryFlag(mountBundleCmd)
 	addConcurrencyFactorFlag(mountBundleCmd, 100)
 	// todo: #165 add --cpuprof to all commands via root
 	addCPUProfFlag(mountBundleCmd)
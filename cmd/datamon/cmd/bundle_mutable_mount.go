@@ -4,20 +4,97 @@ package cmd
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
 
 	daemonizer "github.com/jacobsa/daemonize"
 
+	"github.com/oneconcern/datamon/pkg/cafs"
+	context2 "github.com/oneconcern/datamon/pkg/context"
 	"github.com/oneconcern/datamon/pkg/core"
 	"github.com/spf13/cobra"
 )
 
+// sourceBundleID returns the bundle a new bundle starts from, selected by --from-bundle or --from-label, if any
+func sourceBundleID(ctx context.Context, remote context2.Stores) (string, error) {
+	switch {
+	case datamonFlags.mutable.FromBundle != "" && datamonFlags.mutable.FromLabel != "":
+		return "", fmt.Errorf("--%s and --%s flags are mutually exclusive",
+			addFromBundleFlag(nil),
+			addFromLabelFlag(nil))
+	case datamonFlags.mutable.FromLabel != "":
+		label := core.NewLabel(nil,
+			core.LabelName(datamonFlags.mutable.FromLabel),
+		)
+		bundle := core.NewBundle(core.NewBDescriptor(),
+			core.Repo(datamonFlags.repo.RepoName),
+			core.ContextStores(remote),
+		)
+		if err := label.DownloadDescriptor(ctx, bundle, true); err != nil {
+			return "", err
+		}
+		return label.Descriptor.BundleID, nil
+	}
+	return datamonFlags.mutable.FromBundle, nil
+}
+
+// stagingPath returns the staging directory of a mutable mount, and the options to recover an interrupted one
+func stagingPath() (string, []core.MutableFSOption, error) {
+	if datamonFlags.mutable.Recover != "" {
+		if datamonFlags.mutable.FromBundle != "" || datamonFlags.mutable.FromLabel != "" {
+			return "", nil, fmt.Errorf("a recovered mount starts from its original bundle: --%s and --%s flags are not allowed",
+				addFromBundleFlag(nil),
+				addFromLabelFlag(nil))
+		}
+		return datamonFlags.mutable.Recover, []core.MutableFSOption{core.RecoverStaging()}, nil
+	}
+	if datamonFlags.bundle.DataPath != "" {
+		return datamonFlags.bundle.DataPath, nil, nil
+	}
+	staging, err := ioutil.TempDir("", "datamon-mutable-staging")
+	if err != nil {
+		return "", nil, fmt.Errorf("couldn't create temporary directory: %w", err)
+	}
+	infoLogger.Printf("staging files in %s", staging)
+	return staging, nil, nil
+}
+
 // Mount a mutable view of a bundle
 var mutableMountBundleCmd = &cobra.Command{
 	Use:   "new",
 	Short: "Create a bundle incrementally with filesystem operations",
-	Long:  "Write directories and files to the mountpoint.  Unmount or send SIGINT to this process to save.",
+	Long: `Write directories and files to the mountpoint.  Unmount or send SIGINT to this process to save.
+
+With --from-bundle or --from-label, the mountpoint starts with the files of an existing bundle, which
+becomes the parent of the new bundle. Its files are streamed, and only the files modified are staged
+locally and uploaded. The new bundle keeps the leaf size and chunking of its parent.
+
+Changes are journaled to the staging directory given with --destination. When the mount is interrupted before
+it is saved, e.g. by a crash, --recover mounts the staging directory again, to resume the changes and save them,
+or --recover with --discard removes them.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
+		if datamonFlags.mutable.Discard {
+			if datamonFlags.mutable.Recover == "" {
+				wrapFatalln("discard interrupted mount", fmt.Errorf("--%s flag is required", addRecoverFlag(nil)))
+				return
+			}
+			if err := core.DiscardStaging(datamonFlags.mutable.Recover); err != nil {
+				wrapFatalln("discard interrupted mount", err)
+				return
+			}
+			infoLogger.Printf("discarded interrupted mount staged in %s", datamonFlags.mutable.Recover)
+			return
+		}
+		for flag, value := range map[string]string{
+			addMountPathFlag(nil):     datamonFlags.bundle.MountPath,
+			addCommitMessageFlag(nil): datamonFlags.bundle.Message,
+		} {
+			if value == "" {
+				wrapFatalln("mount mutable filesystem", fmt.Errorf("required flag(s) %q not set", flag))
+				return
+			}
+		}
 		contributor, err := paramsToContributor(datamonFlags)
 		if err != nil {
 			wrapFatalln("populate contributor struct", err)
@@ -39,18 +116,47 @@ var mutableMountBundleCmd = &cobra.Command{
 			return
 		}
 
+		chunking := cafs.ChunkingScheme(datamonFlags.bundle.Chunking)
+		if !chunking.IsValid() {
+			onDaemonError("invalid chunking", fmt.Errorf("unsupported chunking scheme %q", chunking))
+			return
+		}
 		bd := core.NewBDescriptor(
 			core.Message(datamonFlags.bundle.Message),
 			core.Contributor(contributor),
+			core.Chunking(chunking),
 		)
+		compression := cafs.CompressionScheme(datamonFlags.bundle.Compression)
+		if !compression.IsValid() {
+			onDaemonError("invalid compression", fmt.Errorf("unsupported compression scheme %q", compression))
+			return
+		}
 		bundleOpts := paramsToBundleOpts(remoteStores)
 		bundleOpts = append(bundleOpts, core.Repo(datamonFlags.repo.RepoName))
 		bundleOpts = append(bundleOpts, core.ConsumableStore(consumableStore))
 		bundleOpts = append(bundleOpts, core.BundleID(datamonFlags.bundle.ID))
+		bundleOpts = append(bundleOpts, core.Compression(compression))
 		bundle := core.NewBundle(bd,
 			bundleOpts...,
 		)
-		fs, err := core.NewMutableFS(bundle, datamonFlags.bundle.DataPath)
+		staging, fsOpts, err := stagingPath()
+		if err != nil {
+			onDaemonError("prepare staging directory", err)
+			return
+		}
+		sourceID, err := sourceBundleID(ctx, remoteStores)
+		if err != nil {
+			onDaemonError("determine source bundle id", err)
+			return
+		}
+		if sourceID != "" {
+			fsOpts = append(fsOpts, core.SourceBundle(core.NewBundle(core.NewBDescriptor(),
+				core.Repo(datamonFlags.repo.RepoName),
+				core.BundleID(sourceID),
+				core.ContextStores(remoteStores),
+			)))
+		}
+		fs, err := core.NewMutableFS(bundle, staging, fsOpts...)
 		if err != nil {
 			onDaemonError("create mutable filesystem", err)
 			return
@@ -85,8 +191,15 @@ func init() {
 	requiredFlags := []string{addRepoNameOptionFlag(mutableMountBundleCmd)}
 	addDaemonizeFlag(mutableMountBundleCmd)
 	addDataPathFlag(mutableMountBundleCmd)
-	requiredFlags = append(requiredFlags, addMountPathFlag(mutableMountBundleCmd))
-	requiredFlags = append(requiredFlags, addCommitMessageFlag(mutableMountBundleCmd))
+	// mount and message are only required to mount: they are checked when running
+	addMountPathFlag(mutableMountBundleCmd)
+	addCommitMessageFlag(mutableMountBundleCmd)
+	addChunkingFlag(mutableMountBundleCmd)
+	addCompressionFlag(mutableMountBundleCmd)
+	addFromBundleFlag(mutableMountBundleCmd)
+	addFromLabelFlag(mutableMountBundleCmd)
+	addRecoverFlag(mutableMountBundleCmd)
+	addDiscardFlag(mutableMountBundleCmd)
 
 	for _, flag := range requiredFlags {
 		err := mutableMountBundleCmd.MarkFlagRequired(flag)
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"text/template"
+
+	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var readLogEntryTemplate *template.Template
+
+func applyReadLogEntryTemplate(entry model.ReadLogEntry) error {
+	var buf bytes.Buffer
+	if err := readLogEntryTemplate.Execute(&buf, entry); err != nil {
+		return fmt.Errorf("executing template: %w", err)
+	}
+	log.Println(buf.String())
+	return nil
+}
+
+var bundleReadersCmd = &cobra.Command{
+	Use:   "readers",
+	Short: "List the readers of a bundle",
+	Long: `List who downloaded or mounted a bundle, and when, as recorded in the read log.
+
+Use this command to find which pipelines consumed a bundle.
+If --bundle is not specified, the readers of the latest bundle are listed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		remoteStores, err := paramsToDatamonContext(ctx, datamonFlags)
+		if err != nil {
+			wrapFatalln("create remote stores", err)
+			return
+		}
+		err = setLatestOrLabelledBundle(ctx, remoteStores)
+		if err != nil {
+			wrapFatalln("determine bundle id", err)
+			return
+		}
+		entries, err := core.ListReadLog(ctx, remoteStores, datamonFlags.repo.RepoName, datamonFlags.bundle.ID,
+			core.BatchSize(datamonFlags.core.BatchSize))
+		if err != nil {
+			wrapFatalln("list read log", err)
+			return
+		}
+		for _, entry := range entries {
+			if err = applyReadLogEntryTemplate(entry); err != nil {
+				wrapFatalln("print read log entry", err)
+				return
+			}
+		}
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+func init() {
+	requiredFlags := []string{addRepoNameOptionFlag(bundleReadersCmd)}
+	addBundleFlag(bundleReadersCmd)
+	addLabelNameFlag(bundleReadersCmd)
+	addBatchSizeFlag(bundleReadersCmd)
+
+	for _, flag := range requiredFlags {
+		err := bundleReadersCmd.MarkFlagRequired(flag)
+		if err != nil {
+			wrapFatalln("mark required flag", err)
+			return
+		}
+	}
+
+	bundleCmd.AddCommand(bundleReadersCmd)
+
+	readLogEntryTemplate = func() *template.Template {
+		const listLineTemplateString = `{{.Timestamp}} , {{.Operation}} , {{with .Contributor}}{{.Name}} , {{.Email}}{{end}}` +
+			`{{if .Label}} , label={{.Label}}{{end}}{{if .File}} , file={{.File}}{{end}}`
+		return template.Must(template.New("list line").Parse(listLineTemplateString))
+	}()
+}
@@ -7,8 +7,10 @@ import (
 	"log"
 	"os"
 
+	"github.com/oneconcern/datamon/pkg/cafs"
 	"github.com/oneconcern/datamon/pkg/core"
 	"github.com/oneconcern/datamon/pkg/dlogger"
+	"github.com/oneconcern/datamon/pkg/model"
 
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
@@ -46,10 +48,44 @@ var uploadBundleCmd = &cobra.Command{
 			wrapFatalln("failed to set log level", err)
 			return
 		}
+		chunking := cafs.ChunkingScheme(datamonFlags.bundle.Chunking)
+		if !chunking.IsValid() {
+			wrapFatalln("invalid chunking", fmt.Errorf("unsupported chunking scheme %q", chunking))
+			return
+		}
+		var (
+			branch  model.BranchDescriptor
+			parents []string
+		)
+		if datamonFlags.branch.Name != "" {
+			// the new bundle descends from the head of the branch
+			branch, err = core.GetBranch(ctx, remoteStores, datamonFlags.repo.RepoName, datamonFlags.branch.Name)
+			if err != nil {
+				wrapFatalln(fmt.Sprintf("get branch %s", datamonFlags.branch.Name), err)
+				return
+			}
+			if branch.BundleID != "" {
+				parents = []string{branch.BundleID}
+			}
+		}
 		bd := core.NewBDescriptor(
 			core.Message(datamonFlags.bundle.Message),
 			core.Contributor(contributor),
+			core.Chunking(chunking),
+			core.Parents(parents),
 		)
+		compression := cafs.CompressionScheme(datamonFlags.bundle.Compression)
+		if !compression.IsValid() {
+			wrapFatalln("invalid compression", fmt.Errorf("unsupported compression scheme %q", compression))
+			return
+		}
+
+		// uploads are always journaled, so that an interrupted upload may be resumed
+		journal, err := paramsToUploadJournal(datamonFlags, logger)
+		if err != nil {
+			wrapFatalln("locate upload journal", err)
+			return
+		}
 
 		bundleOpts := paramsToBundleOpts(remoteStores)
 		bundleOpts = append(bundleOpts, core.ConsumableStore(sourceStore))
@@ -58,6 +94,11 @@ var uploadBundleCmd = &cobra.Command{
 		bundleOpts = append(bundleOpts,
 			core.ConcurrentFileUploads(datamonFlags.bundle.ConcurrencyFactor/fileUploadsByConcurrencyFactor))
 		bundleOpts = append(bundleOpts, core.Logger(logger))
+		bundleOpts = append(bundleOpts, core.Compression(compression))
+		if journal != "" {
+			bundleOpts = append(bundleOpts, core.UploadJournal(journal))
+			bundleOpts = append(bundleOpts, core.ResumeUpload(datamonFlags.bundle.Resume))
+		}
 
 		bundle := core.NewBundle(bd,
 			bundleOpts...,
@@ -91,6 +132,17 @@ var uploadBundleCmd = &cobra.Command{
 		}
 		log.Printf("Uploaded bundle id:%s ", bundle.BundleID)
 
+		if datamonFlags.branch.Name != "" {
+			_, err = core.AdvanceBranch(ctx, remoteStores, datamonFlags.repo.RepoName, branch, bundle.BundleID, contributor,
+				core.BranchLogger(logger))
+			if err != nil {
+				wrapFatalln(fmt.Sprintf("bundle %s is uploaded, but branch %s is not advanced",
+					bundle.BundleID, datamonFlags.branch.Name), err)
+				return
+			}
+			log.Printf("advanced branch '%v'", datamonFlags.branch.Name)
+		}
+
 		if datamonFlags.label.Name != "" {
 			labelDescriptor := core.NewLabelDescriptor(
 				core.LabelContributor(contributor),
@@ -116,9 +168,13 @@ func init() {
 	requiredFlags := []string{addRepoNameOptionFlag(uploadBundleCmd)}
 	requiredFlags = append(requiredFlags, addPathFlag(uploadBundleCmd))
 	requiredFlags = append(requiredFlags, addCommitMessageFlag(uploadBundleCmd))
+	addChunkingFlag(uploadBundleCmd)
+	addCompressionFlag(uploadBundleCmd)
 	addFileListFlag(uploadBundleCmd)
 	addLabelNameFlag(uploadBundleCmd)
+	addBranchFlag(uploadBundleCmd)
 	addSkipMissingFlag(uploadBundleCmd)
+	addResumeFlag(uploadBundleCmd)
 	addConcurrencyFactorFlag(uploadBundleCmd, 100)
 	addLogLevel(uploadBundleCmd)
 	for _, flag := range requiredFlags {
@@ -0,0 +1,113 @@
+// Copyright © 2019 One Concern
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"text/template"
+
+	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/storage/localfs"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+var bundleVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify a downloaded bundle against a remote bundle.",
+	Long: "Report the drift between a local directory and a remote bundle, without downloading any file.  " +
+		"Local files are fingerprinted and compared with the files of the bundle. " +
+		"Each line reports a file missing (A), modified (U) or not in the bundle (D). " +
+		"The command fails when the directory has drifted from the bundle.",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		const driftLineTemplateString = `{{.Type}} , {{.Name}} , {{with .Additional}}{{.Size}} , {{.Hash}}{{end}} , {{with .Existing}}{{.Size}} , {{.Hash}}{{end}}`
+		driftLineTemplate := template.Must(template.New("drift line").Parse(driftLineTemplateString))
+
+		ctx := context.Background()
+
+		remoteStores, err := paramsToDatamonContext(ctx, datamonFlags)
+		if err != nil {
+			wrapFatalln("failed to initialize remote stores", err)
+			return
+		}
+		path, err := sanitizePath(datamonFlags.bundle.DataPath)
+		if err != nil {
+			wrapFatalln("failed path validation", err)
+			return
+		}
+		DieIfNotDirectory(path)
+		destinationStore := localfs.New(afero.NewBasePathFs(afero.NewOsFs(), path+"/"))
+
+		err = setLatestOrLabelledBundle(ctx, remoteStores)
+		if err != nil {
+			wrapFatalln("determine bundle id", err)
+			return
+		}
+
+		bundleOpts := paramsToBundleOpts(remoteStores)
+		bundleOpts = append(bundleOpts, core.Repo(datamonFlags.repo.RepoName))
+		bundleOpts = append(bundleOpts, core.BundleID(datamonFlags.bundle.ID))
+		bundleOpts = append(bundleOpts, core.ConsumableStore(destinationStore))
+		bundleOpts = append(bundleOpts, core.ConcurrentFileDownloads(
+			datamonFlags.bundle.ConcurrencyFactor/fileDownloadsByConcurrencyFactor))
+		bundleOpts = append(bundleOpts,
+			core.ConcurrentFilelistDownloads(datamonFlags.bundle.ConcurrencyFactor/filelistDownloadsByConcurrencyFactor))
+
+		bundle := core.NewBundle(core.NewBDescriptor(),
+			bundleOpts...,
+		)
+
+		drift, err := core.Verify(ctx, bundle)
+		if err != nil {
+			wrapFatalln("bundle verify", err)
+			return
+		}
+
+		if len(drift.Entries) == 0 {
+			log.Println("no drift")
+			return
+		}
+		for _, de := range drift.Entries {
+			var buf bytes.Buffer
+			err := driftLineTemplate.Execute(&buf, de)
+			if err != nil {
+				log.Println("executing template:", err)
+			}
+			log.Println(buf.String())
+		}
+		wrapFatalWithCode(1, "%s has drifted from bundle %s", path, bundle.BundleID)
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+func init() {
+
+	// Source
+	requiredFlags := []string{addRepoNameOptionFlag(bundleVerifyCmd)}
+
+	// Destination
+	requiredFlags = append(requiredFlags, addDataPathFlag(bundleVerifyCmd))
+
+	// Bundle to verify
+	addBundleFlag(bundleVerifyCmd)
+
+	addLabelNameFlag(bundleVerifyCmd)
+
+	addConcurrencyFactorFlag(bundleVerifyCmd, 100)
+
+	for _, flag := range requiredFlags {
+		err := bundleVerifyCmd.MarkFlagRequired(flag)
+		if err != nil {
+			wrapFatalln("mark required flag", err)
+			return
+		}
+	}
+
+	bundleCmd.AddCommand(bundleVerifyCmd)
+}
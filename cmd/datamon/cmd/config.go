@@ -5,7 +5,7 @@ import (
 	"io/ioutil"
 
 	"github.com/oneconcern/datamon/pkg/model"
-	"github.com/oneconcern/datamon/pkg/storage/gcs"
+	"github.com/oneconcern/datamon/pkg/storage/location"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"gopkg.in/yaml.v2"
@@ -38,7 +38,7 @@ func (c *CLIConfig) setDatamonParams(flags *flagsT) {
 }
 
 func (*CLIConfig) populateRemoteConfig(flags *flagsT) {
-	configStore, err := gcs.New(context.Background(), flags.core.Config, config.Credential)
+	configStore, err := location.New(context.Background(), flags.core.Config, config.Credential)
 	if err != nil {
 		wrapFatalln("failed to get context details", err)
 		return
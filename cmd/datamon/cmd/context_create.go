@@ -9,7 +9,8 @@ import (
 	context2 "context"
 
 	"github.com/oneconcern/datamon/pkg/context"
-	"github.com/oneconcern/datamon/pkg/storage/gcs"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/oneconcern/datamon/pkg/storage/location"
 	"github.com/spf13/cobra"
 )
 
@@ -23,10 +24,14 @@ var ContextCreateCommand = &cobra.Command{
 }
 
 func createContext() {
-	configStore, err := gcs.New(context2.Background(), datamonFlags.core.Config, config.Credential)
+	configStore, err := location.New(context2.Background(), datamonFlags.core.Config, config.Credential)
 	if err != nil {
 		wrapFatalln("failed to create config store. ", err)
 	}
+	if datamonFlags.context.Encryption != (model.Encryption{}) {
+		encryption := datamonFlags.context.Encryption
+		datamonFlags.context.Descriptor.Encryption = &encryption
+	}
 	err = context.CreateContext(context2.Background(), configStore, datamonFlags.context.Descriptor)
 	if err != nil {
 		wrapFatalln("failed to create context: "+datamonFlags.context.Descriptor.Name, err)
@@ -42,6 +47,9 @@ func init() {
 	requiredFlags = append(requiredFlags, addWALBucket(ContextCreateCommand))
 	requiredFlags = append(requiredFlags, addReadLogBucket(ContextCreateCommand))
 	requiredFlags = append(requiredFlags, addContextFlag(ContextCreateCommand))
+	addEncryptionKeyFileFlag(ContextCreateCommand)
+	addEncryptionKMSDirFlag(ContextCreateCommand)
+	addEncryptionKeyIDFlag(ContextCreateCommand)
 
 	for _, flag := range requiredFlags {
 		err := ContextCreateCommand.MarkFlagRequired(flag)
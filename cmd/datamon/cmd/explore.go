@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"context"
+
+	units "github.com/docker/go-units"
+	daemonizer "github.com/jacobsa/daemonize"
+
+	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/dlogger"
+
+	"github.com/spf13/cobra"
+)
+
+// Mount a read only view of all the repos of a context
+var exploreCmd = &cobra.Command{
+	Use:   "explore",
+	Short: "Mount a view to browse all repos",
+	Long: `Mount a readonly view of all the repos of a context, to browse any bundle without knowing its ID.
+
+Every repo has three directories:
+  bundles/<bundle>   the files of a bundle
+  labels/<label>     a symbolic link to the bundle of a label
+  branches/<branch>  a symbolic link to the head of a branch
+
+Directories are listed when they are first browsed, and listings of repos, bundles, labels and branches
+are refreshed every minute. The files of bundles are streamed as they are read.`,
+	Example: `% datamon explore --mount /datamon --daemonize
+% ls /datamon/ritesh-test-repo/labels
+init  latest
+% cat /datamon/ritesh-test-repo/labels/latest/data/train.csv`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		// cf. comments on runDaemonized
+		if datamonFlags.bundle.Daemonize {
+			runDaemonized()
+			return
+		}
+		remoteStores, err := paramsToDatamonContext(ctx, datamonFlags)
+		if err != nil {
+			onDaemonError("create remote stores", err)
+			return
+		}
+		prefetchMemory, err := units.FromHumanSize(datamonFlags.bundle.PrefetchMemory)
+		if err != nil {
+			onDaemonError("invalid prefetch memory", err)
+			return
+		}
+		bundleOpts := paramsToBundleOpts(remoteStores)
+		bundleOpts = append(bundleOpts, core.PrefetchLeaves(datamonFlags.bundle.PrefetchLeaves))
+		bundleOpts = append(bundleOpts, core.PrefetchMemory(prefetchMemory))
+		bundleOpts = append(bundleOpts, core.ConcurrentFilelistDownloads(
+			datamonFlags.bundle.ConcurrencyFactor/filelistDownloadsByConcurrencyFactor))
+
+		logger, err := dlogger.GetLogger(datamonFlags.root.logLevel)
+		if err != nil {
+			onDaemonError("failed to set log level", err)
+			return
+		}
+		fs, err := core.NewExplorerFS(remoteStores, logger, bundleOpts...)
+		if err != nil {
+			onDaemonError("create explorer filesystem", err)
+			return
+		}
+		if err = fs.MountReadOnly(datamonFlags.bundle.MountPath); err != nil {
+			onDaemonError("mount explorer filesystem", err)
+			return
+		}
+
+		registerSIGINTHandlerMount(datamonFlags.bundle.MountPath)
+		if err = daemonizer.SignalOutcome(nil); err != nil {
+			wrapFatalln("send event from possibly daemonized process", err)
+			return
+		}
+		if err = fs.JoinMount(ctx); err != nil {
+			wrapFatalln("block on os mount", err)
+			return
+		}
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+func init() {
+
+	requiredFlags := []string{addMountPathFlag(exploreCmd)}
+	addDaemonizeFlag(exploreCmd)
+	addLogLevel(exploreCmd)
+	addCacheDirFlag(exploreCmd)
+	addCacheSizeFlag(exploreCmd)
+	addPrefetchFlag(exploreCmd)
+	addPrefetchMemoryFlag(exploreCmd)
+	addConcurrencyFactorFlag(exploreCmd, 100)
+
+	for _, flag := range requiredFlags {
+		err := exploreCmd.MarkFlagRequired(flag)
+		if err != nil {
+			wrapFatalln("mark required flag", err)
+			return
+		}
+	}
+
+	rootCmd.AddCommand(exploreCmd)
+}
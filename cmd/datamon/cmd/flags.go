@@ -4,20 +4,30 @@ package cmd
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
+	units "github.com/docker/go-units"
+	"github.com/oneconcern/datamon/pkg/cafs"
 	context2 "github.com/oneconcern/datamon/pkg/context"
 
 	"github.com/oneconcern/datamon/pkg/core"
 
 	"github.com/oneconcern/datamon/pkg/model"
 	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/cache"
 	"github.com/oneconcern/datamon/pkg/storage/gcs"
 	"github.com/oneconcern/datamon/pkg/storage/localfs"
+	"github.com/oneconcern/datamon/pkg/storage/location"
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
 )
 
 type flagsT struct {
@@ -33,16 +43,64 @@ type flagsT struct {
 		SkipOnError       bool
 		ConcurrencyFactor int
 		NameFilter        string
+		Chunking          string
+		Compression       string
+		Resume            bool
+		LogGraph          bool
+		LogMax            int
+		PrefetchLeaves    int
+		PrefetchMemory    string
 	}
 	web struct {
 		port int
 	}
+	mount struct {
+		Spec string
+	}
+	mutable struct {
+		FromBundle string
+		FromLabel  string
+		Recover    string
+		Discard    bool
+	}
 	label struct {
-		Prefix string
-		Name   string
+		Prefix       string
+		Name         string
+		ExpectBundle string
+	}
+	branch struct {
+		Name string
+	}
+	wal struct {
+		FromToken    string
+		Max          int
+		Follow       bool
+		PollInterval time.Duration
+	}
+	deletion struct {
+		Force     bool
+		Retention time.Duration
+	}
+	gc struct {
+		DryRun      bool
+		GracePeriod time.Duration
+	}
+	fsck struct {
+		Rehash bool
+	}
+	merge struct {
+		Base     string
+		Ours     string
+		Theirs   string
+		Strategy string
+	}
+	cache struct {
+		Dir  string
+		Size string
 	}
 	context struct {
 		Descriptor model.Context
+		Encryption model.Encryption
 	}
 	repo struct {
 		RepoName    string
@@ -76,6 +134,48 @@ func addDataPathFlag(cmd *cobra.Command) string {
 	return destination
 }
 
+func addMountSpecFlag(cmd *cobra.Command) string {
+	spec := "spec"
+	cmd.Flags().StringVar(&datamonFlags.mount.Spec, spec, "", "The path to a yaml file listing the bundles to mount")
+	return spec
+}
+
+func addFromBundleFlag(cmd *cobra.Command) string {
+	fromBundle := "from-bundle"
+	if cmd != nil {
+		cmd.Flags().StringVar(&datamonFlags.mutable.FromBundle, fromBundle, "",
+			"The bundle to start from: its files are presented and only the files modified are uploaded")
+	}
+	return fromBundle
+}
+
+func addFromLabelFlag(cmd *cobra.Command) string {
+	fromLabel := "from-label"
+	if cmd != nil {
+		cmd.Flags().StringVar(&datamonFlags.mutable.FromLabel, fromLabel, "",
+			"The label of the bundle to start from: its files are presented and only the files modified are uploaded")
+	}
+	return fromLabel
+}
+
+func addRecoverFlag(cmd *cobra.Command) string {
+	recoverStaging := "recover"
+	if cmd != nil {
+		cmd.Flags().StringVar(&datamonFlags.mutable.Recover, recoverStaging, "",
+			"The staging directory of an interrupted mount, to recover its files")
+	}
+	return recoverStaging
+}
+
+func addDiscardFlag(cmd *cobra.Command) string {
+	discard := "discard"
+	if cmd != nil {
+		cmd.Flags().BoolVar(&datamonFlags.mutable.Discard, discard, false,
+			"Discard the files of the interrupted mount given with --recover, instead of mounting them")
+	}
+	return discard
+}
+
 func addNameFilterFlag(cmd *cobra.Command) string {
 	nameFilter := "name-filter"
 	cmd.Flags().StringVar(&datamonFlags.bundle.NameFilter, nameFilter, "",
@@ -85,7 +185,9 @@ func addNameFilterFlag(cmd *cobra.Command) string {
 
 func addMountPathFlag(cmd *cobra.Command) string {
 	mount := "mount"
-	cmd.Flags().StringVar(&datamonFlags.bundle.MountPath, mount, "", "The path to the mount dir")
+	if cmd != nil {
+		cmd.Flags().StringVar(&datamonFlags.bundle.MountPath, mount, "", "The path to the mount dir")
+	}
 	return mount
 }
 
@@ -97,10 +199,28 @@ func addPathFlag(cmd *cobra.Command) string {
 
 func addCommitMessageFlag(cmd *cobra.Command) string {
 	message := "message"
-	cmd.Flags().StringVar(&datamonFlags.bundle.Message, message, "", "The message describing the new bundle")
+	if cmd != nil {
+		cmd.Flags().StringVar(&datamonFlags.bundle.Message, message, "", "The message describing the new bundle")
+	}
 	return message
 }
 
+func addChunkingFlag(cmd *cobra.Command) string {
+	chunking := "chunking"
+	cmd.Flags().StringVar(&datamonFlags.bundle.Chunking, chunking, string(cafs.ChunkingFixed),
+		fmt.Sprintf("How files are split into blobs: %q (fixed size leaves) or %q (content-defined leaves, "+
+			"which dedupe better across versions of files with insertions or deletions)", cafs.ChunkingFixed, cafs.ChunkingFastCDC))
+	return chunking
+}
+
+func addCompressionFlag(cmd *cobra.Command) string {
+	compression := "compression"
+	cmd.Flags().StringVar(&datamonFlags.bundle.Compression, compression, string(cafs.CompressionNone),
+		fmt.Sprintf("How blobs are compressed: %q or %q (blobs which don't shrink are stored uncompressed)",
+			cafs.CompressionNone, cafs.CompressionZstd))
+	return compression
+}
+
 func addFileListFlag(cmd *cobra.Command) string {
 	fileList := "files"
 	cmd.Flags().StringVar(&datamonFlags.bundle.FileList, fileList, "", "Text file containing list of files separated by newline.")
@@ -161,7 +281,7 @@ func addContextFlag(cmd *cobra.Command) string {
 
 func addConfigFlag(cmd *cobra.Command) string {
 	config := "config"
-	cmd.Flags().StringVar(&datamonFlags.core.Config, config, "", "Set the config to use")
+	cmd.Flags().StringVar(&datamonFlags.core.Config, config, "", "Set the config to use: a bucket name, or a location such as gs://bucket, s3://bucket, az://account/container, file:///path or mem://name")
 	return config
 }
 
@@ -183,12 +303,126 @@ func addLabelNameFlag(cmd *cobra.Command) string {
 	return labelName
 }
 
+func addLogGraphFlag(cmd *cobra.Command) string {
+	graph := "graph"
+	cmd.Flags().BoolVar(&datamonFlags.bundle.LogGraph, graph, false, "Draw the ancestry of bundles")
+	return graph
+}
+
+func addLogMaxFlag(cmd *cobra.Command) string {
+	max := "max"
+	cmd.Flags().IntVar(&datamonFlags.bundle.LogMax, max, 0, "The maximum number of bundles to show, all of them when 0")
+	return max
+}
+
+func addBranchFlag(cmd *cobra.Command) string {
+	branch := "branch"
+	if cmd != nil {
+		cmd.Flags().StringVar(&datamonFlags.branch.Name, branch, "", "The name of a branch")
+	}
+	return branch
+}
+
+func addMergeBaseFlag(cmd *cobra.Command) string {
+	base := "base"
+	cmd.Flags().StringVar(&datamonFlags.merge.Base, base, "",
+		"The bundle both bundles are merged from, if not specified their best common ancestor will be used")
+	return base
+}
+
+func addMergeOursFlag(cmd *cobra.Command) string {
+	ours := "ours"
+	cmd.Flags().StringVar(&datamonFlags.merge.Ours, ours, "", "The bundle merged into, first parent of the merged bundle")
+	return ours
+}
+
+func addMergeTheirsFlag(cmd *cobra.Command) string {
+	theirs := "theirs"
+	cmd.Flags().StringVar(&datamonFlags.merge.Theirs, theirs, "", "The bundle merged, second parent of the merged bundle")
+	return theirs
+}
+
+func addMergeStrategyFlag(cmd *cobra.Command) string {
+	strategy := "strategy"
+	cmd.Flags().StringVar(&datamonFlags.merge.Strategy, strategy, string(core.MergeStrategyFail),
+		"How to resolve paths changed differently in both bundles: fail, ours or theirs")
+	return strategy
+}
+
+func addExpectBundleFlag(cmd *cobra.Command) string {
+	expectBundle := "expect-bundle"
+	if cmd != nil {
+		cmd.Flags().StringVar(&datamonFlags.label.ExpectBundle, expectBundle, "",
+			"Only set the label if it points to this bundle, or doesn't exist yet when empty")
+	}
+	return expectBundle
+}
+
 func addLabelPrefixFlag(cmd *cobra.Command) string {
 	prefixString := "prefix"
 	cmd.Flags().StringVar(&datamonFlags.label.Prefix, prefixString, "", "List labels starting with a prefix.")
 	return prefixString
 }
 
+func addWALFromTokenFlag(cmd *cobra.Command) string {
+	fromToken := "from-token"
+	cmd.Flags().StringVar(&datamonFlags.wal.FromToken, fromToken, "",
+		"Read the WAL starting from this token. Entries written shortly before the token are included. "+
+			"Defaults to the beginning of the WAL.")
+	return fromToken
+}
+
+func addWALMaxFlag(cmd *cobra.Command, defaultMax int) string {
+	max := "max"
+	cmd.Flags().IntVar(&datamonFlags.wal.Max, max, defaultMax, "The maximum number of WAL entries to read")
+	return max
+}
+
+func addWALFollowFlag(cmd *cobra.Command) string {
+	follow := "follow"
+	cmd.Flags().BoolVar(&datamonFlags.wal.Follow, follow, false, "Keep polling the WAL for new entries")
+	return follow
+}
+
+func addWALPollIntervalFlag(cmd *cobra.Command) string {
+	pollInterval := "poll-interval"
+	cmd.Flags().DurationVar(&datamonFlags.wal.PollInterval, pollInterval, 10*time.Second,
+		"The interval between two polls of the WAL when following it")
+	return pollInterval
+}
+
+func addForceDeleteFlag(cmd *cobra.Command) string {
+	force := "force"
+	cmd.Flags().BoolVar(&datamonFlags.deletion.Force, force, false, "Delete even though some labels or branches still point to the deleted bundles")
+	return force
+}
+
+func addRetentionFlag(cmd *cobra.Command) string {
+	retention := "retention"
+	cmd.Flags().DurationVar(&datamonFlags.deletion.Retention, retention, core.DefaultPurgeRetention,
+		"Only purge repos and bundles deleted for longer than this period")
+	return retention
+}
+
+func addGCDryRunFlag(cmd *cobra.Command) string {
+	dryRun := "dry-run"
+	cmd.Flags().BoolVar(&datamonFlags.gc.DryRun, dryRun, false, "Report what would be collected, without deleting anything")
+	return dryRun
+}
+
+func addGCGracePeriodFlag(cmd *cobra.Command) string {
+	gracePeriod := "grace-period"
+	cmd.Flags().DurationVar(&datamonFlags.gc.GracePeriod, gracePeriod, core.DefaultGCGracePeriod,
+		"Only collect unreferenced blobs older than this period, to protect uploads in progress")
+	return gracePeriod
+}
+
+func addFsckRehashFlag(cmd *cobra.Command) string {
+	rehash := "rehash"
+	cmd.Flags().BoolVar(&datamonFlags.fsck.Rehash, rehash, false, "Read back and hash all blobs again, to detect bit rot")
+	return rehash
+}
+
 func addRepoNameOptionFlag(cmd *cobra.Command) string {
 	repo := "repo"
 	cmd.Flags().StringVar(&datamonFlags.repo.RepoName, repo, "", "The name of this repository")
@@ -203,34 +437,99 @@ func addRepoDescription(cmd *cobra.Command) string {
 
 func addBlobBucket(cmd *cobra.Command) string {
 	blob := "blob"
-	cmd.Flags().StringVar(&datamonFlags.context.Descriptor.Blob, blob, "", "The name of the bucket hosting the datamon blobs")
+	cmd.Flags().StringVar(&datamonFlags.context.Descriptor.Blob, blob, "", "The name or location of the bucket hosting the datamon blobs")
 	return blob
 }
 
 func addMetadataBucket(cmd *cobra.Command) string {
 	meta := "meta"
-	cmd.Flags().StringVar(&datamonFlags.context.Descriptor.Metadata, meta, "", "The name of the bucket used by datamon metadata")
+	cmd.Flags().StringVar(&datamonFlags.context.Descriptor.Metadata, meta, "", "The name or location of the bucket used by datamon metadata")
 	return meta
 }
 
 func addVMetadataBucket(cmd *cobra.Command) string {
 	vm := "vmeta"
-	cmd.Flags().StringVar(&datamonFlags.context.Descriptor.VMetadata, vm, "", "The name of the bucket hosting the versioned metadata")
+	cmd.Flags().StringVar(&datamonFlags.context.Descriptor.VMetadata, vm, "", "The name or location of the bucket hosting the versioned metadata")
 	return vm
 }
 
 func addWALBucket(cmd *cobra.Command) string {
 	b := "wal"
-	cmd.Flags().StringVar(&datamonFlags.context.Descriptor.WAL, b, "", "The name of the bucket hosting the WAL")
+	cmd.Flags().StringVar(&datamonFlags.context.Descriptor.WAL, b, "", "The name or location of the bucket hosting the WAL")
 	return b
 }
 
 func addReadLogBucket(cmd *cobra.Command) string {
 	b := "read-log"
-	cmd.Flags().StringVar(&datamonFlags.context.Descriptor.ReadLog, b, "", "The name of the bucket hosting the read log")
+	cmd.Flags().StringVar(&datamonFlags.context.Descriptor.ReadLog, b, "", "The name or location of the bucket hosting the read log")
 	return b
 }
 
+func addEncryptionKeyFileFlag(cmd *cobra.Command) string {
+	keyFile := "encryption-key-file"
+	cmd.Flags().StringVar(&datamonFlags.context.Encryption.KeyFile, keyFile, "",
+		"The path to a local master key file, to encrypt blobs and metadata of the context")
+	return keyFile
+}
+
+func addEncryptionKMSDirFlag(cmd *cobra.Command) string {
+	kmsDir := "encryption-kms-dir"
+	cmd.Flags().StringVar(&datamonFlags.context.Encryption.KMSDir, kmsDir, "",
+		"The path to a directory of master key files, to encrypt blobs and metadata of the context")
+	return kmsDir
+}
+
+func addEncryptionKeyIDFlag(cmd *cobra.Command) string {
+	keyID := "encryption-key-id"
+	cmd.Flags().StringVar(&datamonFlags.context.Encryption.KeyID, keyID, "",
+		"The id of the master key in the KMS directory")
+	return keyID
+}
+
+func addResumeFlag(cmd *cobra.Command) string {
+	resume := "resume"
+	cmd.Flags().BoolVar(&datamonFlags.bundle.Resume, resume, false,
+		"Resume an interrupted upload of the same path to the same repo: "+
+			"unchanged files are not read again, and the upload continues with the same bundle ID")
+	return resume
+}
+
+func addResumeDownloadFlag(cmd *cobra.Command) string {
+	resume := "resume"
+	cmd.Flags().BoolVar(&datamonFlags.bundle.Resume, resume, false,
+		"Resume an interrupted download of the same bundle: files already downloaded are verified, "+
+			"and only missing or mismatched files are downloaded")
+	return resume
+}
+
+func addCacheDirFlag(cmd *cobra.Command) string {
+	cacheDir := "cache-dir"
+	cmd.Flags().StringVar(&datamonFlags.cache.Dir, cacheDir, "",
+		"A local directory to cache blobs, which may be shared by concurrent mounts and downloads")
+	return cacheDir
+}
+
+func addCacheSizeFlag(cmd *cobra.Command) string {
+	cacheSize := "cache-size"
+	cmd.Flags().StringVar(&datamonFlags.cache.Size, cacheSize, "10GB",
+		"The maximum size of the blob cache, e.g. 500MB or 10GB. Least recently used blobs are evicted")
+	return cacheSize
+}
+
+func addPrefetchFlag(cmd *cobra.Command) string {
+	prefetch := "prefetch"
+	cmd.Flags().IntVar(&datamonFlags.bundle.PrefetchLeaves, prefetch, 4,
+		"The number of blobs fetched ahead of sequential reads of a file, when streaming. 0 disables prefetching")
+	return prefetch
+}
+
+func addPrefetchMemoryFlag(cmd *cobra.Command) string {
+	prefetchMemory := "prefetch-memory"
+	cmd.Flags().StringVar(&datamonFlags.bundle.PrefetchMemory, prefetchMemory, "256MB",
+		"The memory used to keep blobs read or prefetched, when streaming, e.g. 256MB or 2GB")
+	return prefetchMemory
+}
+
 func addCredentialFile(cmd *cobra.Command) string {
 	credential := "credential"
 	cmd.Flags().StringVar(&datamonFlags.root.credFile, credential, "", "The path to the credential file")
@@ -254,37 +553,48 @@ func addCPUProfFlag(cmd *cobra.Command) string {
 func paramsToDatamonContext(ctx context.Context, params flagsT) (context2.Stores, error) {
 	stores := context2.Stores{}
 
-	meta, err := gcs.New(ctx, params.context.Descriptor.Metadata, config.Credential)
+	meta, err := location.New(ctx, params.context.Descriptor.Metadata, config.Credential)
 	if err != nil {
 		return context2.Stores{}, fmt.Errorf("failed to initialize metadata store, err:%s", err)
 	}
 	stores.SetMetadata(meta)
 
-	blob, err := gcs.New(ctx, params.context.Descriptor.Blob, config.Credential)
+	blob, err := location.New(ctx, params.context.Descriptor.Blob, config.Credential)
 	if err != nil {
 		return context2.Stores{}, fmt.Errorf("failed to initialize blob store, err:%s", err)
 	}
+	if params.cache.Dir != "" {
+		size, erc := units.FromHumanSize(params.cache.Size)
+		if erc != nil {
+			return context2.Stores{}, fmt.Errorf("invalid cache size %q: %w", params.cache.Size, erc)
+		}
+		// blobs are cached as stored, i.e. encrypted when the context is
+		blob, err = cache.New(blob, params.cache.Dir, size)
+		if err != nil {
+			return context2.Stores{}, fmt.Errorf("failed to initialize blob cache, err:%s", err)
+		}
+	}
 	stores.SetBlob(blob)
 
-	v, err := gcs.New(ctx, params.context.Descriptor.VMetadata, config.Credential)
+	v, err := location.New(ctx, params.context.Descriptor.VMetadata, config.Credential)
 	if err != nil {
 		return context2.Stores{}, fmt.Errorf("failed to initialize vmetadata store, err:%s", err)
 	}
 	stores.SetVMetadata(v)
 
-	w, err := gcs.New(ctx, params.context.Descriptor.WAL, config.Credential)
+	w, err := location.New(ctx, params.context.Descriptor.WAL, config.Credential)
 	if err != nil {
 		return context2.Stores{}, fmt.Errorf("failed to initialize wal store, err:%s", err)
 	}
 	stores.SetWal(w)
 
-	r, err := gcs.New(ctx, params.context.Descriptor.ReadLog, config.Credential)
+	r, err := location.New(ctx, params.context.Descriptor.ReadLog, config.Credential)
 	if err != nil {
 		return context2.Stores{}, fmt.Errorf("failed to initialize read log store, err:%s", err)
 	}
 	stores.SetReadLog(r)
 
-	return stores, nil
+	return context2.EncryptStores(stores, params.context.Descriptor)
 }
 
 func paramsToBundleOpts(stores context2.Stores) []core.BundleOption {
@@ -294,6 +604,27 @@ func paramsToBundleOpts(stores context2.Stores) []core.BundleOption {
 	return ops
 }
 
+// paramsToUploadJournal returns the path to the local journal of the upload of a path to a repo.
+//
+// The path is empty when there is no home directory to keep journals in: the upload is not journaled then.
+func paramsToUploadJournal(params flagsT, logger *zap.Logger) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		logger.Warn("no home directory for upload journals: the upload can't be resumed", zap.Error(err))
+		return "", nil
+	}
+	source := params.bundle.DataPath
+	if !strings.HasPrefix(source, "gs://") {
+		if source, err = sanitizePath(source); err != nil {
+			return "", fmt.Errorf("failed to sanitize source: %v: %w", params.bundle.DataPath, err)
+		}
+	}
+	h := sha256.Sum256([]byte(strings.Join([]string{
+		params.context.Descriptor.Name, params.repo.RepoName, source, params.bundle.FileList,
+	}, "\x00")))
+	return filepath.Join(home, datamonDir, "uploads", hex.EncodeToString(h[:])+".journal"), nil
+}
+
 func paramsToSrcStore(ctx context.Context, params flagsT, create bool) (storage.Store, error) {
 	var err error
 	var consumableStorePath string
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"text/template"
+
+	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/core/status"
+	"github.com/oneconcern/datamon/pkg/errors"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+)
+
+// LabelHistoryCommand lists the successive targets of a label
+var LabelHistoryCommand = &cobra.Command{
+	Use:   "history",
+	Short: "Show the history of a label",
+	Long: `List the bundles a label has pointed to, the latest first.
+
+Each line shows the generation of the label, i.e. the number of times it has been set,
+with the bundle, the time the label was set and who set it.
+Labels set before their history was recorded start at generation 0.`,
+	Example: `% datamon label history --repo ritesh-test-repo --label latest
+2 , 1INzQ6WHNrDyRpkgszRuFmeqQFv , 2019-03-12 22:12:45.532018 -0700 PDT , ritesh@oneconcern.com
+1 , 1INzQ5TV4vAAfU2PbRFgPfnzEwR , 2019-03-12 22:10:24.159704 -0700 PDT , ritesh@oneconcern.com`,
+	Run: func(cmd *cobra.Command, args []string) {
+		const historyLineTemplateString = `{{.Generation}} , {{.BundleID}} , {{.Timestamp}}{{range .Contributors}} , {{.Email}}{{end}}`
+		historyLineTemplate := template.Must(template.New("history line").Parse(historyLineTemplateString))
+
+		ctx := context.Background()
+		remoteStores, err := paramsToDatamonContext(ctx, datamonFlags)
+		if err != nil {
+			wrapFatalln("create remote stores", err)
+			return
+		}
+		history, err := core.LabelHistory(ctx, remoteStores, datamonFlags.repo.RepoName, datamonFlags.label.Name,
+			core.BatchSize(datamonFlags.core.BatchSize))
+		if errors.Is(err, status.ErrNotFound) {
+			wrapFatalWithCode(int(unix.ENOENT), "didn't find label %q", datamonFlags.label.Name)
+			return
+		}
+		if err != nil {
+			wrapFatalln("get label history", err)
+			return
+		}
+		for _, label := range history {
+			var buf bytes.Buffer
+			if err = historyLineTemplate.Execute(&buf, label); err != nil {
+				wrapFatalln("executing template", err)
+				return
+			}
+			log.Println(buf.String())
+		}
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+func init() {
+	requiredFlags := []string{addRepoNameOptionFlag(LabelHistoryCommand)}
+
+	requiredFlags = append(requiredFlags, addLabelNameFlag(LabelHistoryCommand))
+	addBatchSizeFlag(LabelHistoryCommand)
+
+	for _, flag := range requiredFlags {
+		err := LabelHistoryCommand.MarkFlagRequired(flag)
+		if err != nil {
+			wrapFatalln("mark required flag", err)
+			return
+		}
+	}
+
+	labelCmd.AddCommand(LabelHistoryCommand)
+}
@@ -5,13 +5,18 @@ import (
 	"fmt"
 
 	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/core/status"
+	"github.com/oneconcern/datamon/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
 var SetLabelCommand = &cobra.Command{
 	Use:   "set",
 	Short: "Set labels",
-	Long:  "Set the label corresponding to a bundle",
+	Long: `Set the label corresponding to a bundle.
+
+Every move of a label is kept in its history. With --expect-bundle, the label is only set
+if it currently points to the expected bundle, or doesn't exist yet when the expected bundle is empty.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
 
@@ -50,7 +55,15 @@ var SetLabelCommand = &cobra.Command{
 			core.LabelName(datamonFlags.label.Name),
 		)
 
-		err = label.UploadDescriptor(ctx, bundle)
+		if cmd.Flags().Changed(addExpectBundleFlag(nil)) {
+			err = label.UploadDescriptorIf(ctx, bundle, datamonFlags.label.ExpectBundle)
+		} else {
+			err = label.UploadDescriptor(ctx, bundle)
+		}
+		if errors.Is(err, status.ErrLabelMoved) {
+			wrapFatalWithCode(1, "label %q not set: %v", datamonFlags.label.Name, err)
+			return
+		}
 		if err != nil {
 			wrapFatalln("upload label", err)
 			return
@@ -66,6 +79,7 @@ func init() {
 
 	requiredFlags = append(requiredFlags, addLabelNameFlag(SetLabelCommand))
 	requiredFlags = append(requiredFlags, addBundleFlag(SetLabelCommand))
+	addExpectBundleFlag(SetLabelCommand)
 
 	for _, flag := range requiredFlags {
 		err := SetLabelCommand.MarkFlagRequired(flag)
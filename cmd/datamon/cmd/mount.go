@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	units "github.com/docker/go-units"
+	daemonizer "github.com/jacobsa/daemonize"
+	"gopkg.in/yaml.v2"
+
+	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/dlogger"
+	"github.com/oneconcern/datamon/pkg/model"
+
+	"github.com/spf13/cobra"
+)
+
+// mountSpec lists the bundles mounted together
+type mountSpec struct {
+	Mounts []mountSpecEntry `json:"mounts" yaml:"mounts"`
+}
+
+// mountSpecEntry is a bundle of a repo, selected by its ID or a label, or else the latest one.
+type mountSpecEntry struct {
+	Repo   string `json:"repo" yaml:"repo"`
+	Bundle string `json:"bundle,omitempty" yaml:"bundle,omitempty"`
+	Label  string `json:"label,omitempty" yaml:"label,omitempty"`
+	// Path of the bundle under the mount point, defaults to <repo>/<label> or <repo>/<bundle>
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+}
+
+func readMountSpec(file string) (mountSpec, error) {
+	var spec mountSpec
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return spec, err
+	}
+	if err = yaml.UnmarshalStrict(b, &spec); err != nil {
+		return spec, err
+	}
+	if len(spec.Mounts) == 0 {
+		return spec, fmt.Errorf("no bundle to mount in %s", file)
+	}
+	for _, entry := range spec.Mounts {
+		if entry.Repo == "" {
+			return spec, fmt.Errorf("missing repo in %s", file)
+		}
+	}
+	return spec, nil
+}
+
+// selectSpecEntry sets the flags selecting a bundle to those of an entry of the mount spec
+func selectSpecEntry(entry mountSpecEntry) {
+	datamonFlags.repo.RepoName = entry.Repo
+	datamonFlags.bundle.ID = entry.Bundle
+	datamonFlags.label.Name = entry.Label
+}
+
+// Mount read only views of several bundles
+var mountCmd = &cobra.Command{
+	Use:   "mount",
+	Short: "Mount several bundles",
+	Long: `Mount readonly, non-interactive views of several bundles in one filesystem.
+
+The bundles are listed in a yaml spec. Each bundle is mounted in its own directory,
+by default <repo>/<label> or <repo>/<bundle>, under the mount point.
+Streamed bundles share the memory used to cache the files read.`,
+	Example: `% cat mounts.yaml
+mounts:
+  - repo: images
+    label: latest
+  - repo: annotations
+    bundle: 1INzQ5TV4vAAfU2PbRFgPfnzEwR
+  - repo: models
+    label: production
+    path: model
+
+% datamon mount --spec mounts.yaml --mount /data --daemonize
+% ls /data
+annotations  images  model`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		// cf. comments on runDaemonized
+		if datamonFlags.bundle.Daemonize {
+			runDaemonized()
+			return
+		}
+		spec, err := readMountSpec(datamonFlags.mount.Spec)
+		if err != nil {
+			onDaemonError("read mount spec", err)
+			return
+		}
+		remoteStores, err := paramsToDatamonContext(ctx, datamonFlags)
+		if err != nil {
+			onDaemonError("create remote stores", err)
+			return
+		}
+		prefetchMemory, err := units.FromHumanSize(datamonFlags.bundle.PrefetchMemory)
+		if err != nil {
+			onDaemonError("invalid prefetch memory", err)
+			return
+		}
+
+		mounts := make([]core.BundleMount, 0, len(spec.Mounts))
+		for i, entry := range spec.Mounts {
+			selectSpecEntry(entry)
+			if err = setLatestOrLabelledBundle(ctx, remoteStores); err != nil {
+				onDaemonError(fmt.Sprintf("determine bundle id for repo %s", entry.Repo), err)
+				return
+			}
+			spec.Mounts[i].Bundle = datamonFlags.bundle.ID
+			consumableStore, err := paramsToDestStore(datamonFlags, destTEmpty, "datamon-mount-destination")
+			if err != nil {
+				onDaemonError("create destination store", err)
+				return
+			}
+			bundleOpts := paramsToBundleOpts(remoteStores)
+			bundleOpts = append(bundleOpts, core.Repo(entry.Repo))
+			bundleOpts = append(bundleOpts, core.ConsumableStore(consumableStore))
+			bundleOpts = append(bundleOpts, core.BundleID(datamonFlags.bundle.ID))
+			bundleOpts = append(bundleOpts, core.Streaming(datamonFlags.bundle.Stream))
+			bundleOpts = append(bundleOpts, core.PrefetchLeaves(datamonFlags.bundle.PrefetchLeaves))
+			bundleOpts = append(bundleOpts, core.PrefetchMemory(prefetchMemory))
+			bundleOpts = append(bundleOpts, core.ConcurrentFilelistDownloads(
+				datamonFlags.bundle.ConcurrencyFactor/filelistDownloadsByConcurrencyFactor))
+
+			mountPath := entry.Path
+			if mountPath == "" {
+				selected := entry.Label
+				if selected == "" {
+					selected = datamonFlags.bundle.ID
+				}
+				mountPath = path.Join(entry.Repo, selected)
+			}
+			mounts = append(mounts, core.BundleMount{
+				Path:   mountPath,
+				Bundle: core.NewBundle(core.NewBDescriptor(), bundleOpts...),
+			})
+		}
+
+		logger, err := dlogger.GetLogger(datamonFlags.root.logLevel)
+		if err != nil {
+			onDaemonError("failed to set log level", err)
+			return
+		}
+		fs, err := core.NewMultiReadOnlyFS(mounts, logger)
+		if err != nil {
+			onDaemonError("create read only filesystem", err)
+			return
+		}
+		for _, entry := range spec.Mounts {
+			selectSpecEntry(entry)
+			if err = logBundleRead(ctx, remoteStores, model.ReadOperationMount, ""); err != nil {
+				onDaemonError("write read log", err)
+				return
+			}
+		}
+		if err = fs.MountReadOnly(datamonFlags.bundle.MountPath); err != nil {
+			onDaemonError("mount read only filesystem", err)
+			return
+		}
+
+		registerSIGINTHandlerMount(datamonFlags.bundle.MountPath)
+		if err = daemonizer.SignalOutcome(nil); err != nil {
+			wrapFatalln("send event from possibly daemonized process", err)
+			return
+		}
+		if err = fs.JoinMount(ctx); err != nil {
+			wrapFatalln("block on os mount", err)
+			return
+		}
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+func init() {
+
+	requiredFlags := []string{addMountSpecFlag(mountCmd)}
+	addDaemonizeFlag(mountCmd)
+	addLogLevel(mountCmd)
+	addStreamFlag(mountCmd)
+	addCacheDirFlag(mountCmd)
+	addCacheSizeFlag(mountCmd)
+	addPrefetchFlag(mountCmd)
+	addPrefetchMemoryFlag(mountCmd)
+	addConcurrencyFactorFlag(mountCmd, 100)
+	requiredFlags = append(requiredFlags, addMountPathFlag(mountCmd))
+
+	for _, flag := range requiredFlags {
+		err := mountCmd.MarkFlagRequired(flag)
+		if err != nil {
+			wrapFatalln("mark required flag", err)
+			return
+		}
+	}
+
+	rootCmd.AddCommand(mountCmd)
+}
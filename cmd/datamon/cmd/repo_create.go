@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/dlogger"
 
 	"github.com/oneconcern/datamon/pkg/model"
 
@@ -28,13 +29,18 @@ var repoCreate = &cobra.Command{
 			wrapFatalln("create remote stores", err)
 			return
 		}
+		logger, err := dlogger.GetLogger(datamonFlags.root.logLevel)
+		if err != nil {
+			wrapFatalln("failed to set log level", err)
+			return
+		}
 		repo := model.RepoDescriptor{
 			Name:        datamonFlags.repo.RepoName,
 			Description: datamonFlags.repo.Description,
 			Timestamp:   time.Now(),
 			Contributor: contributor,
 		}
-		err = core.CreateRepo(repo, remoteStores)
+		err = core.CreateRepo(repo, remoteStores, logger)
 		if err != nil {
 			wrapFatalln("create repo", err)
 			return
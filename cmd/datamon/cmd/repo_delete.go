@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/dlogger"
+	"github.com/spf13/cobra"
+)
+
+var repoDeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Delete a named repo",
+	Long: `Delete a repo and all its bundles.
+
+The repo is no longer listed and its bundles may no longer be listed nor labelled.
+Its metadata is only removed by "datamon repo purge", after some retention period,
+and its blobs by "datamon bundle gc".
+
+A repo with labels or branches is not deleted, unless --force is set.
+`,
+	Example: `% datamon repo delete --repo ritesh-test-repo`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		contributor, err := paramsToContributor(datamonFlags)
+		if err != nil {
+			wrapFatalln("populate contributor struct", err)
+			return
+		}
+		remoteStores, err := paramsToDatamonContext(ctx, datamonFlags)
+		if err != nil {
+			wrapFatalln("create remote stores", err)
+			return
+		}
+		logger, err := dlogger.GetLogger(datamonFlags.root.logLevel)
+		if err != nil {
+			wrapFatalln("failed to set log level", err)
+			return
+		}
+		err = core.DeleteRepo(ctx, remoteStores, datamonFlags.repo.RepoName, contributor,
+			core.ForceDelete(datamonFlags.deletion.Force),
+			core.DeleteLogger(logger),
+		)
+		if err != nil {
+			wrapFatalln("delete repo", err)
+			return
+		}
+		infoLogger.Printf("deleted repo %s", datamonFlags.repo.RepoName)
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+func init() {
+	requiredFlags := []string{addRepoNameOptionFlag(repoDeleteCmd)}
+	addForceDeleteFlag(repoDeleteCmd)
+	addLogLevel(repoDeleteCmd)
+
+	for _, flag := range requiredFlags {
+		err := repoDeleteCmd.MarkFlagRequired(flag)
+		if err != nil {
+			wrapFatalln("mark required flag", err)
+			return
+		}
+	}
+
+	repoCmd.AddCommand(repoDeleteCmd)
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/dlogger"
+	"github.com/spf13/cobra"
+)
+
+var repoFsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Check that all bundles of a repo may be downloaded",
+	Long: `Check the integrity of a repo.
+
+The file lists of all bundles of the repo are walked, and every blob they reference
+is looked up in the blob store. With --rehash, blobs are also read back and hashed again,
+to detect bit rot. This reads the whole content of the repo.
+
+The report is printed as JSON. The command fails if any bundle is damaged.
+`,
+	Example: `% datamon repo fsck --repo ritesh-test-repo
+{
+  "repo": "ritesh-test-repo",
+  "bundles": 2,
+  "files": 6,
+  "roots": 5,
+  "rehashed": false,
+  "damaged": [
+    {
+      "bundle": "1INzQ5TV4vAAfU2PbRFgPfnzEwR",
+      "files": [
+        {
+          "name": "data/train.csv",
+          "hash": "1b3f...",
+          "missing": [
+            "9ac2..."
+          ]
+        }
+      ]
+    }
+  ]
+}`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		remoteStores, err := paramsToDatamonContext(ctx, datamonFlags)
+		if err != nil {
+			wrapFatalln("create remote stores", err)
+			return
+		}
+		logger, err := dlogger.GetLogger(datamonFlags.root.logLevel)
+		if err != nil {
+			wrapFatalln("failed to set log level", err)
+			return
+		}
+		report, err := core.Fsck(ctx, datamonFlags.repo.RepoName, remoteStores,
+			core.FsckRehash(datamonFlags.fsck.Rehash),
+			core.FsckListOptions(
+				core.ConcurrentList(datamonFlags.core.ConcurrencyFactor),
+				core.BatchSize(datamonFlags.core.BatchSize),
+			),
+			core.FsckLogger(logger),
+		)
+		if err != nil {
+			wrapFatalln("check repo", err)
+			return
+		}
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			wrapFatalln("print fsck report", err)
+			return
+		}
+		infoLogger.Println(string(out))
+		if !report.Healthy() {
+			wrapFatalWithCode(1, "%d damaged bundle(s) in repo %s", len(report.Damaged), report.Repo)
+			return
+		}
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+func init() {
+	requiredFlags := []string{addRepoNameOptionFlag(repoFsckCmd)}
+	addFsckRehashFlag(repoFsckCmd)
+	addCoreConcurrencyFactorFlag(repoFsckCmd, 500)
+	addBatchSizeFlag(repoFsckCmd)
+	addLogLevel(repoFsckCmd)
+
+	for _, flag := range requiredFlags {
+		err := repoFsckCmd.MarkFlagRequired(flag)
+		if err != nil {
+			wrapFatalln("mark required flag", err)
+			return
+		}
+	}
+
+	repoCmd.AddCommand(repoFsckCmd)
+}
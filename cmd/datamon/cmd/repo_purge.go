@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"text/template"
+
+	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/dlogger"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var tombstoneTemplate *template.Template
+
+func applyTombstoneTemplate(tombstone model.Tombstone) error {
+	var buf bytes.Buffer
+	if err := tombstoneTemplate.Execute(&buf, tombstone); err != nil {
+		return fmt.Errorf("executing template: %w", err)
+	}
+	log.Println(buf.String())
+	return nil
+}
+
+var repoPurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Purge the metadata of deleted repos and bundles",
+	Long: `Remove the metadata of the repos and bundles deleted for longer than the retention period.
+
+The purged repos and bundles are listed. Their blobs are left to "datamon bundle gc".
+A purged repo may be created again.
+`,
+	Example: `% datamon repo purge --retention 720h
+2019-03-12 22:10:24.159704 -0700 PDT , ritesh-test-repo , 1INzQ5TV4vAAfU2PbRFgPfnzEwR , Ritesh , ritesh@oneconcern.com`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		remoteStores, err := paramsToDatamonContext(ctx, datamonFlags)
+		if err != nil {
+			wrapFatalln("create remote stores", err)
+			return
+		}
+		logger, err := dlogger.GetLogger(datamonFlags.root.logLevel)
+		if err != nil {
+			wrapFatalln("failed to set log level", err)
+			return
+		}
+		purged, err := core.PurgeDeleted(ctx, remoteStores, datamonFlags.deletion.Retention,
+			core.DeleteListOptions(core.BatchSize(datamonFlags.core.BatchSize)),
+			core.DeleteLogger(logger),
+		)
+		for _, tombstone := range purged {
+			if e := applyTombstoneTemplate(tombstone); e != nil {
+				wrapFatalln("print purged tombstone", e)
+				return
+			}
+		}
+		if err != nil {
+			wrapFatalln("purge deleted repos and bundles", err)
+			return
+		}
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+func init() {
+	addRetentionFlag(repoPurgeCmd)
+	addBatchSizeFlag(repoPurgeCmd)
+	addLogLevel(repoPurgeCmd)
+
+	repoCmd.AddCommand(repoPurgeCmd)
+
+	tombstoneTemplate = func() *template.Template {
+		const listLineTemplateString = `{{.Timestamp}} , {{.Repo}} , {{if .BundleID}}{{.BundleID}}{{else}}(repo){{end}} , ` +
+			`{{with .Contributor}}{{.Name}} , {{.Email}}{{end}}`
+		return template.Must(template.New("list line").Parse(listLineTemplateString))
+	}()
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/oneconcern/datamon/pkg/auth"
 	gauth "github.com/oneconcern/datamon/pkg/auth/google"
+	lauth "github.com/oneconcern/datamon/pkg/auth/local"
 	"github.com/spf13/cobra"
 )
 
@@ -83,7 +84,7 @@ func Execute() {
 func init() {
 	log.SetFlags(0)
 	cobra.OnInitialize(initConfig)
-	authorizer = gauth.New()
+	authorizer = lauth.New(gauth.New())
 	addConfigFlag(rootCmd)
 }
 
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"text/template"
+
+	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/dlogger"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/oneconcern/datamon/pkg/wal"
+
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/spf13/cobra"
+)
+
+var walCmd = &cobra.Command{
+	Use:   "wal",
+	Short: "Commands to inspect the WAL",
+	Long: `Commands to inspect the write ahead log (WAL) of a context.
+
+Every creation or deletion of a repo, commit or deletion of a bundle and label set is recorded in the WAL
+before it completes. The WAL is an ordered audit trail of who changed what in a context.
+`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+var walEntryTemplate *template.Template
+
+func applyWALEntryTemplate(entry model.Entry) error {
+	var buf bytes.Buffer
+	if err := walEntryTemplate.Execute(&buf, entry); err != nil {
+		return fmt.Errorf("executing template: %w", err)
+	}
+	log.Println(buf.String())
+	return nil
+}
+
+func paramsToWAL(stores context2.Stores) (*wal.WAL, error) {
+	logger, err := dlogger.GetLogger(datamonFlags.root.logLevel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set log level: %w", err)
+	}
+	w, err := core.GetWAL(stores, logger)
+	if err != nil {
+		return nil, err
+	}
+	if w == nil {
+		return nil, fmt.Errorf("no WAL configured for context %s", datamonFlags.context.Descriptor.Name)
+	}
+	return w, nil
+}
+
+func init() {
+	rootCmd.AddCommand(walCmd)
+
+	walEntryTemplate = func() *template.Template {
+		const listLineTemplateString = `{{.Token}} , {{.Payload}} , ` +
+			`{{range $i, $c := .Payload.Contributors}}{{if $i}} ; {{end}}{{$c.Name}} , {{$c.Email}}{{end}}`
+		return template.Must(template.New("list line").Parse(listLineTemplateString))
+	}()
+}
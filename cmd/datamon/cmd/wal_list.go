@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+)
+
+var walListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List WAL entries",
+	Long: `List the entries of the WAL, in token order.
+
+Entries written shortly before the token passed with --from-token are listed as well,
+since the WAL only loosely orders updates.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		remoteStores, err := paramsToDatamonContext(ctx, datamonFlags)
+		if err != nil {
+			wrapFatalln("create remote stores", err)
+			return
+		}
+		w, err := paramsToWAL(remoteStores)
+		if err != nil {
+			wrapFatalln("create wal", err)
+			return
+		}
+		entries, next, err := w.ListEntries(ctx, datamonFlags.wal.FromToken, datamonFlags.wal.Max)
+		if err != nil {
+			wrapFatalln("list wal entries", err)
+			return
+		}
+		for _, entry := range entries {
+			if err = applyWALEntryTemplate(entry); err != nil {
+				wrapFatalln("print wal entry", err)
+				return
+			}
+		}
+		if next != "" && len(entries) > 0 {
+			infoLogger.Printf("more entries available, continue with --from-token %s", entries[len(entries)-1].Token)
+		}
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+func init() {
+	addWALFromTokenFlag(walListCmd)
+	addWALMaxFlag(walListCmd, 1000)
+	addLogLevel(walListCmd)
+	walCmd.AddCommand(walListCmd)
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/oneconcern/datamon/pkg/core"
+	"github.com/oneconcern/datamon/pkg/dlogger"
+	"github.com/spf13/cobra"
+)
+
+var walReplayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Rebuild labels from the WAL",
+	Long: `Rebuild the labels in the versioned metadata store from the label set entries recorded in the WAL.
+
+Use this command to recover labels after the versioned metadata store has been corrupted.
+Each label is set to the most recent bundle recorded in the WAL. Restrict the replay to a repo with --repo.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		remoteStores, err := paramsToDatamonContext(ctx, datamonFlags)
+		if err != nil {
+			wrapFatalln("create remote stores", err)
+			return
+		}
+		logger, err := dlogger.GetLogger(datamonFlags.root.logLevel)
+		if err != nil {
+			wrapFatalln("failed to set log level", err)
+			return
+		}
+		replayed, err := core.ReplayLabels(ctx, remoteStores, datamonFlags.repo.RepoName, logger)
+		for _, p := range replayed {
+			infoLogger.Printf("replayed label %s , %s , %s", p.Repo, p.LabelDescriptor.Name, p.LabelDescriptor.BundleID)
+		}
+		if err != nil {
+			wrapFatalln("replay wal", err)
+			return
+		}
+		infoLogger.Printf("replayed %d labels", len(replayed))
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+func init() {
+	addRepoNameOptionFlag(walReplayCmd)
+	addLogLevel(walReplayCmd)
+	walCmd.AddCommand(walReplayCmd)
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/oneconcern/datamon/pkg/wal"
+	"github.com/segmentio/ksuid"
+	"github.com/spf13/cobra"
+)
+
+// walFollower prints WAL entries once, remembering the recent tokens already printed
+type walFollower struct {
+	w    *wal.WAL
+	seen map[string]struct{}
+	last string
+}
+
+func (f *walFollower) print(entry *model.Entry) error {
+	if _, ok := f.seen[entry.Token]; ok {
+		return nil
+	}
+	f.seen[entry.Token] = struct{}{}
+	if entry.Token > f.last {
+		f.last = entry.Token
+	}
+	return applyWALEntryTemplate(*entry)
+}
+
+// prune forgets about tokens which are too old to be listed again when polling from the last token
+func (f *walFollower) prune() {
+	last, err := ksuid.Parse(f.last)
+	if err != nil {
+		return
+	}
+	horizon := last.Time().Add(-2 * f.w.GetExpirationDuration())
+	for token := range f.seen {
+		k, err := ksuid.Parse(token)
+		if err != nil || k.Time().Before(horizon) {
+			delete(f.seen, token)
+		}
+	}
+}
+
+// tail prints the last max entries of the WAL
+func (f *walFollower) tail(ctx context.Context, max int) error {
+	tokens := make([]string, 0, max)
+	err := f.w.WalkTokens(ctx, "", func(token string) error {
+		if len(tokens) == max {
+			tokens = tokens[1:]
+		}
+		tokens = append(tokens, token)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		entry, err := f.w.GetEntry(ctx, token)
+		if err != nil {
+			return err
+		}
+		if err = f.print(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var walTailCmd = &cobra.Command{
+	Use:   "tail",
+	Short: "Print the latest WAL entries",
+	Long: `Print the latest entries of the WAL.
+
+With --from-token, all the entries from this token are printed instead of the last --max entries.
+With --follow, the WAL is polled for new entries until the command is interrupted.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		remoteStores, err := paramsToDatamonContext(ctx, datamonFlags)
+		if err != nil {
+			wrapFatalln("create remote stores", err)
+			return
+		}
+		w, err := paramsToWAL(remoteStores)
+		if err != nil {
+			wrapFatalln("create wal", err)
+			return
+		}
+		follower := &walFollower{
+			w:    w,
+			seen: make(map[string]struct{}),
+		}
+		if datamonFlags.wal.FromToken != "" {
+			err = w.Walk(ctx, datamonFlags.wal.FromToken, follower.print)
+		} else {
+			err = follower.tail(ctx, datamonFlags.wal.Max)
+		}
+		if err != nil {
+			wrapFatalln("read wal", err)
+			return
+		}
+		if !datamonFlags.wal.Follow {
+			return
+		}
+		for {
+			time.Sleep(datamonFlags.wal.PollInterval)
+			if err = w.Walk(ctx, follower.last, follower.print); err != nil {
+				wrapFatalln("follow wal", err)
+				return
+			}
+			follower.prune()
+		}
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		config.populateRemoteConfig(&datamonFlags)
+	},
+}
+
+func init() {
+	addWALFromTokenFlag(walTailCmd)
+	addWALMaxFlag(walTailCmd, 10)
+	addWALFollowFlag(walTailCmd)
+	addWALPollIntervalFlag(walTailCmd)
+	addLogLevel(walTailCmd)
+	walCmd.AddCommand(walTailCmd)
+}
@@ -176,7 +176,7 @@ var uploadCmd = &cobra.Command{
 			Timestamp:   time.Now(),
 			Contributor: contributors[0],
 		}
-		err = core.CreateRepo(repo, dmc)
+		err = core.CreateRepo(repo, dmc, logger)
 		if err != nil {
 			log.Fatalln(err)
 		}
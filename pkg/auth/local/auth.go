@@ -0,0 +1,45 @@
+// Package local implements Authable with an identity set in the local environment,
+// for use without any identity provider, e.g. on a laptop or in CI.
+package local
+
+import (
+	"os"
+
+	"github.com/oneconcern/datamon/pkg/auth"
+	"github.com/oneconcern/datamon/pkg/model"
+)
+
+// Environment variables holding the local identity
+const (
+	EnvName  = "DATAMON_CONTRIBUTOR_NAME"
+	EnvEmail = "DATAMON_CONTRIBUTOR_EMAIL"
+)
+
+// New returns a new instance of local Auth.
+//
+// When no identity is set in the environment, the principal is retrieved from the fallback authenticator.
+func New(fallback auth.Authable) Auth {
+	return Auth{fallback: fallback}
+}
+
+// Auth implements Authable for an identity set in the environment
+type Auth struct {
+	fallback auth.Authable
+}
+
+// Principal returns the contributor set by the DATAMON_CONTRIBUTOR_EMAIL and DATAMON_CONTRIBUTOR_NAME
+// environment variables. The name defaults to the email.
+func (l Auth) Principal(credFile string) (model.Contributor, error) {
+	email := os.Getenv(EnvEmail)
+	if email == "" {
+		return l.fallback.Principal(credFile)
+	}
+	name := os.Getenv(EnvName)
+	if name == "" {
+		name = email
+	}
+	return model.Contributor{
+		Email: email,
+		Name:  name,
+	}, nil
+}
@@ -0,0 +1,36 @@
+package local
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/stretchr/testify/require"
+)
+
+type failingAuth struct{}
+
+func (failingAuth) Principal(string) (model.Contributor, error) {
+	return model.Contributor{}, errors.New("no identity provider")
+}
+
+func TestPrincipal(t *testing.T) {
+	defer os.Unsetenv(EnvEmail)
+	defer os.Unsetenv(EnvName)
+	a := New(failingAuth{})
+
+	require.NoError(t, os.Unsetenv(EnvEmail))
+	_, err := a.Principal("")
+	require.Error(t, err)
+
+	require.NoError(t, os.Setenv(EnvEmail, "dev@example.com"))
+	p, err := a.Principal("")
+	require.NoError(t, err)
+	require.Equal(t, model.Contributor{Name: "dev@example.com", Email: "dev@example.com"}, p)
+
+	require.NoError(t, os.Setenv(EnvName, "Dev"))
+	p, err = a.Principal("")
+	require.NoError(t, err)
+	require.Equal(t, model.Contributor{Name: "Dev", Email: "dev@example.com"}, p)
+}
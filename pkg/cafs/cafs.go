@@ -108,19 +108,21 @@ func New(opts ...Option) (Fs, error) {
 		leafSize:                    uint32(5 * units.MiB),
 		concurrentFlushes:           10,
 		readerConcurrentChunkWrites: 3,
+		prefetchLeaves:              defaultPrefetchLeaves,
 	}
-	f.leafPool = newLeafFreelist()
-	f.lru, _ = lru.NewWithEvict(10, func(lruKey interface{}, lruVal interface{}) {
-		lbuf := lruVal.(*leafBuffer)
-		f.leafPool.put(lbuf)
-	})
-	f.lru, _ = lru.New(10)
 	for _, apply := range opts {
 		apply(f)
 	}
 	if f.leafSize > MaxLeafSize {
 		return nil, fmt.Errorf("%v exceeds maximum cafs leaf size %v", f.leafSize, MaxLeafSize)
 	}
+	if !f.chunking.IsValid() {
+		return nil, fmt.Errorf("unsupported cafs chunking scheme %q", f.chunking)
+	}
+	if !f.compression.IsValid() {
+		return nil, fmt.Errorf("unsupported cafs compression scheme %q", f.compression)
+	}
+	f.initReadCache()
 	return f, nil
 }
 
@@ -135,10 +137,16 @@ type defaultFs struct {
 	zl                          zap.Logger //nolint:structcheck,unused
 	l                           log.Logger //nolint:structcheck,unused
 	leafTruncation              bool
+	chunking                    ChunkingScheme
+	compression                 CompressionScheme
 	lru                         *lru.Cache
-	leafPool                    *leafFreelist
 	concurrentFlushes           int
 	readerConcurrentChunkWrites int
+	prefetchLeaves              int
+	prefetchMemory              int64
+	leafCache                   *LeafCache
+	fetcher                     *leafFetcher
+	readers                     *lru.Cache // readers of GetAt, which keep track of sequential reads
 }
 
 func (d *defaultFs) Put(ctx context.Context, src io.Reader) (PutRes, error) {
@@ -157,7 +165,7 @@ func (d *defaultFs) Put(ctx context.Context, src io.Reader) (PutRes, error) {
 	}
 	destinations := make([]storage.MultiStoreUnit, 0)
 
-	found, _ := d.store.backend.Has(ctx, d.prefix+key.String())
+	found := hasBlob(ctx, d.store.backend, d.prefix+key.String())
 	if !found {
 		destinations = append(destinations, storage.MultiStoreUnit{
 			Store:           d.store.backend,
@@ -181,8 +189,19 @@ func (d *defaultFs) Get(ctx context.Context, hash Key) (io.ReadCloser, error) {
 	return d.reader(hash)
 }
 
+// GetAt returns a reader of a file, which is shared by the callers reading the same file.
+//
+// Leaves are cached, and prefetched ahead of sequential reads.
 func (d *defaultFs) GetAt(ctx context.Context, hash Key) (io.ReaderAt, error) {
-	return d.reader(hash)
+	if r, ok := d.readers.Get(hash); ok {
+		return r.(Reader), nil
+	}
+	r, err := d.reader(hash)
+	if err != nil {
+		return nil, err
+	}
+	d.readers.Add(hash, r)
+	return r, nil
 }
 
 func (d *defaultFs) reader(hash Key) (Reader, error) {
@@ -191,10 +210,24 @@ func (d *defaultFs) reader(hash Key) (Reader, error) {
 		VerifyHash(true),
 		ConcurrentChunkWrites(d.readerConcurrentChunkWrites),
 		SetCache(d.lru),
-		SetLeafPool(d.leafPool),
+		withFetcher(d.fetcher, d.prefetchLeaves),
 	)
 }
 
+// initReadCache sets the cache of leaves, and bounds prefetching ahead of reads to half of it
+func (d *defaultFs) initReadCache() {
+	if d.leafCache == nil {
+		d.leafCache = NewLeafCache(d.prefetchMemory, d.leafSize)
+	}
+	cachedLeaves := d.leafCache.leaves
+	if d.prefetchLeaves > cachedLeaves/2 {
+		d.prefetchLeaves = cachedLeaves / 2
+	}
+	d.lru = d.leafCache.cache
+	d.fetcher = newLeafFetcher(d.store.backend, d.prefix, d.leafCache)
+	d.readers, _ = lru.New(defaultCachedReaders)
+}
+
 func (d *defaultFs) writer(prefix string) Writer {
 	maxGoRoutines := d.concurrentFlushes
 	if maxGoRoutines < 1 {
@@ -203,6 +236,7 @@ func (d *defaultFs) writer(prefix string) Writer {
 	w := &fsWriter{
 		store:               d.store.backend,
 		leafSize:            d.leafSize,
+		encode:              d.compression.encoder(),
 		buf:                 make([]byte, d.leafSize),
 		prefix:              prefix,
 		flushChan:           make(chan blobFlush),
@@ -212,6 +246,10 @@ func (d *defaultFs) writer(prefix string) Writer {
 		blobFlushes:         make([]blobFlush, 0),
 		errors:              make([]error, 0),
 	}
+	if d.chunking.isContentDefined() {
+		w.chunker = newFastCDC(d.leafSize)
+		w.buf = make([]byte, w.chunker.maxSize)
+	}
 	go w.flushThread()
 	return w
 }
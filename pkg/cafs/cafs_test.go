@@ -1,13 +1,17 @@
 package cafs
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/oneconcern/datamon/pkg/storage"
 	"github.com/oneconcern/datamon/pkg/storage/localfs"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/require"
@@ -357,3 +361,46 @@ func TestCAFS_Clear(t *testing.T) {
 		require.False(t, has)
 	}
 }
+
+// touchingStore reports blobs updated at some time, and counts touches, which may fail
+type touchingStore struct {
+	storage.Store
+	updated time.Time
+	touches int
+	fail    bool
+}
+
+func (s *touchingStore) GetAttr(ctx context.Context, key string) (storage.Attributes, error) {
+	attrs, err := s.Store.GetAttr(ctx, key)
+	attrs.Updated = s.updated
+	return attrs, err
+}
+
+func (s *touchingStore) Touch(ctx context.Context, key string) error {
+	s.touches++
+	if s.fail {
+		return errors.New("touch failed")
+	}
+	return s.Store.Touch(ctx, key)
+}
+
+func TestCAFS_HasBlobTouch(t *testing.T) {
+	ctx := context.Background()
+	store := &touchingStore{Store: localfs.New(afero.NewMemMapFs()), updated: time.Now()}
+	require.NoError(t, store.Put(ctx, "blob", bytes.NewBufferString("blob"), storage.NoOverWrite))
+
+	require.False(t, hasBlob(ctx, store, "missing"))
+
+	// recent blobs are not touched
+	require.True(t, hasBlob(ctx, store, "blob"))
+	require.Equal(t, 0, store.touches)
+
+	store.updated = time.Now().Add(-2 * BlobTouchAge)
+	require.True(t, hasBlob(ctx, store, "blob"))
+	require.Equal(t, 1, store.touches)
+
+	// a blob which can't be touched is still reused
+	store.fail = true
+	require.True(t, hasBlob(ctx, store, "blob"))
+	require.Equal(t, 2, store.touches)
+}
@@ -0,0 +1,279 @@
+package cafs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+
+	"github.com/minio/blake2b-simd"
+)
+
+// ChunkingScheme tells how files are split into leaves
+type ChunkingScheme string
+
+const (
+	// ChunkingFixed splits files into leaves of a fixed size. This is the default.
+	ChunkingFixed ChunkingScheme = "fixed"
+
+	// ChunkingFastCDC splits files into leaves of variable size, with cut points depending on the content only
+	// (content-defined chunking, using the FastCDC algorithm).
+	//
+	// Inserting or removing bytes in a file only changes the leaves around the modification,
+	// so that near-duplicate versions of a file share most of their leaves.
+	ChunkingFastCDC ChunkingScheme = "fastcdc"
+)
+
+// Chunking sets the chunking scheme used to write files. Files are always read whatever their chunking scheme.
+func Chunking(scheme ChunkingScheme) Option {
+	return func(w *defaultFs) {
+		w.chunking = scheme
+	}
+}
+
+// IsValid tells if a chunking scheme is supported. The empty scheme stands for ChunkingFixed.
+func (c ChunkingScheme) IsValid() bool {
+	switch c {
+	case "", ChunkingFixed, ChunkingFastCDC:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c ChunkingScheme) isContentDefined() bool {
+	return c == ChunkingFastCDC
+}
+
+/* root keys of content-defined leaves are stored in a format of their own:
+ *
+ *    magic | leaf key | leaf size (uint32, big endian) | ... | root key
+ *
+ * the magic header tells them apart from fixed size roots, which start with a leaf key.
+ */
+var cdcRootMagic = []byte("DMCDC001")
+
+const (
+	cdcLeafSizeSize = 4
+	cdcEntrySize    = KeySize + cdcLeafSizeSize
+)
+
+var (
+	cdcLeafPerson = []byte("datamon-cdc-leaf")
+	cdcRootPerson = []byte("datamon-cdc-root")
+)
+
+// cdcLeafKey hashes a content-defined leaf. Unlike fixed size leaves, the key does not depend on the position
+// of the leaf in the file, so that shifted content dedupes.
+func cdcLeafKey(leaf []byte) (Key, error) {
+	return cdcHash(cdcLeafPerson, leaf)
+}
+
+func cdcHash(person, data []byte) (Key, error) {
+	hasher, err := blake2b.New(&blake2b.Config{
+		Size:   blake2b.Size,
+		Person: person,
+	})
+	if err != nil {
+		return Key{}, err
+	}
+	if _, err = hasher.Write(data); err != nil {
+		return Key{}, err
+	}
+	return NewKey(hasher.Sum(nil))
+}
+
+// encodeCDCRoot builds the content of a root key for content-defined leaves, without the trailing root key
+func encodeCDCRoot(leaves []Key, sizes []uint32) ([]byte, error) {
+	if len(leaves) != len(sizes) {
+		return nil, fmt.Errorf("mismatched number of leaves (%d) and leaf sizes (%d)", len(leaves), len(sizes))
+	}
+	buf := make([]byte, len(cdcRootMagic), len(cdcRootMagic)+len(leaves)*cdcEntrySize)
+	copy(buf, cdcRootMagic)
+	var size [cdcLeafSizeSize]byte
+	for i, leaf := range leaves {
+		buf = append(buf, leaf[:]...)
+		binary.BigEndian.PutUint32(size[:], sizes[i])
+		buf = append(buf, size[:]...)
+	}
+	return buf, nil
+}
+
+func cdcRootHash(body []byte) (Key, error) {
+	return cdcHash(cdcRootPerson, body)
+}
+
+func isCDCRoot(data []byte) bool {
+	return bytes.HasPrefix(data, cdcRootMagic)
+}
+
+// decodeCDCRoot returns the leaves of a content-defined root key, and their sizes
+func decodeCDCRoot(data []byte) ([]Key, []uint32, error) {
+	if !isCDCRoot(data) || len(data) < len(cdcRootMagic)+KeySize ||
+		(len(data)-len(cdcRootMagic)-KeySize)%cdcEntrySize != 0 {
+		return nil, nil, errors.New("invalid content-defined root key")
+	}
+	body := data[:len(data)-KeySize]
+	verify, err := NewKey(data[len(data)-KeySize:])
+	if err != nil {
+		return nil, nil, err
+	}
+	checksum, err := cdcRootHash(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if verify != checksum {
+		return nil, nil, fmt.Errorf("content-defined leaves checksum doesn't match hash value\n\t%s\n\t%s", verify, checksum)
+	}
+
+	n := (len(body) - len(cdcRootMagic)) / cdcEntrySize
+	keys := make([]Key, 0, n)
+	sizes := make([]uint32, 0, n)
+	for i := len(cdcRootMagic); i < len(body); i += cdcEntrySize {
+		key, err := NewKey(body[i : i+KeySize])
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+		sizes = append(sizes, binary.BigEndian.Uint32(body[i+KeySize:i+cdcEntrySize]))
+	}
+	return keys, sizes, nil
+}
+
+// FingerprintCDC computes the root key of a file split into content-defined leaves with some leaf size,
+// as written by a Fs using ChunkingFastCDC. Nothing is stored.
+func FingerprintCDC(rdr io.Reader, leafSize uint32) (Key, error) {
+	chunker := newFastCDC(leafSize)
+	buf := make([]byte, chunker.maxSize)
+	var (
+		leaves []Key
+		sizes  []uint32
+		offset int
+	)
+	cutLeaf := func() error {
+		cut := chunker.cut(buf[:offset])
+		key, err := cdcLeafKey(buf[:cut])
+		if err != nil {
+			return err
+		}
+		leaves = append(leaves, key)
+		sizes = append(sizes, uint32(cut))
+		offset = copy(buf, buf[cut:offset])
+		return nil
+	}
+
+	// leaves are cut from a full buffer, as the writer does
+	for {
+		n, err := io.ReadFull(rdr, buf[offset:])
+		offset += n
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return Key{}, err
+		}
+		if err = cutLeaf(); err != nil {
+			return Key{}, err
+		}
+	}
+	for offset > 0 {
+		if err := cutLeaf(); err != nil {
+			return Key{}, err
+		}
+	}
+	body, err := encodeCDCRoot(leaves, sizes)
+	if err != nil {
+		return Key{}, err
+	}
+	return cdcRootHash(body)
+}
+
+// fastCDC finds content-defined cut points with the FastCDC algorithm (normalized chunking, level 2).
+//
+// See: W. Xia et al., "FastCDC: a Fast and Efficient Content-Defined Chunking Approach for Data Deduplication",
+// USENIX ATC 2016.
+type fastCDC struct {
+	minSize, avgSize, maxSize int
+	maskS, maskL              uint64
+}
+
+// newFastCDC derives the chunk sizes from the leaf size, which is the expected average size of leaves
+func newFastCDC(leafSize uint32) *fastCDC {
+	if leafSize < 64 {
+		leafSize = 64
+	}
+	// the average size is rounded down to a power of 2
+	b := bits.Len32(leafSize) - 1
+	avg := 1 << b
+	max := 4 * avg
+	if max > MaxLeafSize {
+		max = MaxLeafSize
+	}
+	return &fastCDC{
+		minSize: avg / 4,
+		avgSize: avg,
+		maxSize: max,
+		maskS:   highBitsMask(b + 2),
+		maskL:   highBitsMask(b - 2),
+	}
+}
+
+// highBitsMask spreads the bits to test over the most significant bits of the gear hash,
+// which depend on the longest window of input
+func highBitsMask(n int) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	return ^uint64(0) << uint(64-n)
+}
+
+// cut returns the length of the next chunk at the beginning of data.
+//
+// When data is shorter than the maximum chunk size, it is assumed to be the end of the stream.
+func (c *fastCDC) cut(data []byte) int {
+	n := len(data)
+	if n <= c.minSize {
+		return n
+	}
+	if n > c.maxSize {
+		n = c.maxSize
+	}
+	normal := c.avgSize
+	if n < normal {
+		normal = n
+	}
+	var fp uint64
+	i := c.minSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// gearTable holds the random values of the gear rolling hash.
+//
+// The table must never change: chunk boundaries, hence leaf keys, depend on it.
+var gearTable = func() [256]uint64 {
+	// splitmix64, with a fixed seed
+	var table [256]uint64
+	seed := uint64(0x6461746d6f6e4344) // "datmonCD"
+	for i := range table {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
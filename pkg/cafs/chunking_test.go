@@ -0,0 +1,174 @@
+package cafs
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/localfs"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+const cdcTestLeafSize = 16 * 1024
+
+func cdcTestData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	_, _ = rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func cdcTestFs(t testing.TB, chunking ChunkingScheme) (storage.Store, Fs) {
+	blobs := localfs.New(afero.NewMemMapFs())
+	fs, err := New(
+		LeafSize(cdcTestLeafSize),
+		Backend(blobs),
+		Chunking(chunking),
+	)
+	require.NoError(t, err)
+	return blobs, fs
+}
+
+func TestChunking_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	blobs, fs := cdcTestFs(t, ChunkingFastCDC)
+
+	for _, size := range []int{0, 100, cdcTestLeafSize, 1024*1024 + 17} {
+		data := cdcTestData(int64(size), size)
+		res, err := fs.Put(ctx, bytes.NewReader(data))
+		require.NoError(t, err)
+		require.Equal(t, int64(size), res.Written)
+
+		keys, sizes, err := leafsAndSizesForHash(blobs, res.Key, cdcTestLeafSize, "", true)
+		require.NoError(t, err)
+		require.Len(t, sizes, len(keys))
+		var total int
+		for _, sz := range sizes {
+			require.True(t, sz <= 4*cdcTestLeafSize)
+			total += int(sz)
+		}
+		require.Equal(t, size, total)
+		if size > 0 {
+			require.True(t, IsRootKey(blobs, res.Key, cdcTestLeafSize))
+		}
+
+		// sequential read, with leaf verification
+		rdr, err := fs.Get(ctx, res.Key)
+		require.NoError(t, err)
+		if size > 0 {
+			b, err := ioutil.ReadAll(rdr)
+			require.NoError(t, err)
+			require.Equal(t, data, b)
+		}
+		require.NoError(t, rdr.Close())
+
+		// random access
+		rdrAt, err := fs.GetAt(ctx, res.Key)
+		require.NoError(t, err)
+		for _, off := range []int{0, size / 3, size / 2, size - 10} {
+			if off < 0 || off >= size {
+				continue
+			}
+			p := make([]byte, 2*cdcTestLeafSize)
+			n, err := rdrAt.ReadAt(p, int64(off))
+			require.NoError(t, err)
+			end := off + len(p)
+			if end > size {
+				end = size
+			}
+			require.Equal(t, end-off, n)
+			require.Equal(t, data[off:end], p[:n])
+		}
+
+		// parallel write at leaf offsets
+		if size > 0 {
+			dir, err := ioutil.TempDir("", "cafs-cdc")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			f, err := os.Create(filepath.Join(dir, "file"))
+			require.NoError(t, err)
+			r, err := newReader(blobs, res.Key, cdcTestLeafSize, "")
+			require.NoError(t, err)
+			written, err := r.(*chunkReader).WriteTo(f)
+			require.NoError(t, err)
+			require.Equal(t, int64(size), written)
+			require.NoError(t, f.Close())
+			b, err := ioutil.ReadFile(f.Name())
+			require.NoError(t, err)
+			require.Equal(t, data, b)
+		}
+	}
+
+	require.NoError(t, fs.Delete(ctx, mustPut(t, fs, cdcTestData(1, 100*1024))))
+}
+
+func mustPut(t testing.TB, fs Fs, data []byte) Key {
+	res, err := fs.Put(context.Background(), bytes.NewReader(data))
+	require.NoError(t, err)
+	return res.Key
+}
+
+func TestChunking_Dedupe(t *testing.T) {
+	original := cdcTestData(42, 2*1024*1024)
+	shifted := append(cdcTestData(43, 100), original...)
+
+	sharedLeaves := func(chunking ChunkingScheme) (int, int) {
+		blobs, fs := cdcTestFs(t, chunking)
+		k1, k2 := mustPut(t, fs, original), mustPut(t, fs, shifted)
+		leaves1, err := LeafsForHash(blobs, k1, cdcTestLeafSize, "")
+		require.NoError(t, err)
+		leaves2, err := LeafsForHash(blobs, k2, cdcTestLeafSize, "")
+		require.NoError(t, err)
+		index := make(map[Key]struct{}, len(leaves1))
+		for _, leaf := range leaves1 {
+			index[leaf] = struct{}{}
+		}
+		var shared int
+		for _, leaf := range leaves2 {
+			if _, ok := index[leaf]; ok {
+				shared++
+			}
+		}
+		return shared, len(leaves2)
+	}
+
+	shared, _ := sharedLeaves(ChunkingFixed)
+	require.Zero(t, shared)
+
+	// inserting bytes at the beginning of a file only changes the leaves around the insertion
+	shared, total := sharedLeaves(ChunkingFastCDC)
+	require.True(t, shared >= total-2, "expected most leaves to be shared, got %d/%d", shared, total)
+}
+
+func TestChunking_Fingerprint(t *testing.T) {
+	ctx := context.Background()
+	_, fs := cdcTestFs(t, ChunkingFastCDC)
+
+	for _, size := range []int{0, 100, cdcTestLeafSize, 4 * cdcTestLeafSize, 1024*1024 + 17} {
+		data := cdcTestData(int64(size), size)
+		res, err := fs.Put(ctx, bytes.NewReader(data))
+		require.NoError(t, err)
+		key, err := FingerprintCDC(bytes.NewReader(data), cdcTestLeafSize)
+		require.NoError(t, err)
+		require.Equal(t, res.Key, key, "size %d", size)
+	}
+
+	key, err := FingerprintCDC(bytes.NewReader(cdcTestData(1, 1000)), cdcTestLeafSize)
+	require.NoError(t, err)
+	other, err := FingerprintCDC(bytes.NewReader(cdcTestData(2, 1000)), cdcTestLeafSize)
+	require.NoError(t, err)
+	require.NotEqual(t, key, other)
+}
+
+func TestChunking_Options(t *testing.T) {
+	_, err := New(Chunking("unknown"))
+	require.Error(t, err)
+
+	_, _, err = decodeCDCRoot(append(append([]byte{}, cdcRootMagic...), make([]byte, KeySize)...))
+	require.Error(t, err)
+}
@@ -0,0 +1,130 @@
+package cafs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/oneconcern/datamon/pkg/storage"
+)
+
+// CompressionScheme tells how leaves are compressed in the blob store
+type CompressionScheme string
+
+const (
+	// CompressionNone stores leaves raw. This is the default.
+	CompressionNone CompressionScheme = "none"
+
+	// CompressionZstd compresses leaves with zstd. Leaves that don't shrink are stored raw.
+	CompressionZstd CompressionScheme = "zstd"
+)
+
+// Compression sets the compression of the leaves written to the blob store.
+//
+// Compressed leaves are self-describing: leaves are always read whatever their compression.
+// Keys are computed from the uncompressed content, so compressed and raw leaves dedupe.
+func Compression(scheme CompressionScheme) Option {
+	return func(w *defaultFs) {
+		w.compression = scheme
+	}
+}
+
+// IsValid tells if a compression scheme is supported. The empty scheme stands for CompressionNone.
+func (c CompressionScheme) IsValid() bool {
+	switch c {
+	case "", CompressionNone, CompressionZstd:
+		return true
+	default:
+		return false
+	}
+}
+
+/* compressed leaves are stored as:
+ *
+ *    magic | zstd frame
+ *
+ * raw leaves which happen to start like the magic header are always compressed, so that the format remains unambiguous.
+ */
+var zstdLeafMagic = []byte("\x00DMZSTD\x01")
+
+// leafEncoder returns the content of a leaf as stored in the blob store
+type leafEncoder func([]byte) []byte
+
+var (
+	zstdEncoderOnce sync.Once
+	zstdEncoder     *zstd.Encoder
+)
+
+func (c CompressionScheme) encoder() leafEncoder {
+	if c != CompressionZstd {
+		return encodeRawLeaf
+	}
+	return encodeZstdLeaf
+}
+
+func encodeRawLeaf(leaf []byte) []byte {
+	if bytes.HasPrefix(leaf, zstdLeafMagic) {
+		return compressLeaf(leaf)
+	}
+	return leaf
+}
+
+func encodeZstdLeaf(leaf []byte) []byte {
+	compressed := compressLeaf(leaf)
+	if len(compressed) >= len(leaf) && !bytes.HasPrefix(leaf, zstdLeafMagic) {
+		return leaf
+	}
+	return compressed
+}
+
+func compressLeaf(leaf []byte) []byte {
+	zstdEncoderOnce.Do(func() {
+		// EncodeAll may be called concurrently
+		zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	})
+	return zstdEncoder.EncodeAll(leaf, append(make([]byte, 0, len(leaf)), zstdLeafMagic...))
+}
+
+// getLeaf reads a leaf from the blob store, decompressing it if needed
+func getLeaf(ctx context.Context, blobs storage.Store, pth string) (io.ReadCloser, error) {
+	rdr, err := blobs.Get(ctx, pth)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewReaderSize(rdr, len(zstdLeafMagic))
+	header, err := buffered.Peek(len(zstdLeafMagic))
+	if err != nil && err != io.EOF {
+		_ = rdr.Close()
+		return nil, err
+	}
+	if !bytes.Equal(header, zstdLeafMagic) {
+		return &leafReader{Reader: buffered, closer: rdr}, nil
+	}
+	if _, err = buffered.Discard(len(zstdLeafMagic)); err != nil {
+		_ = rdr.Close()
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		_ = rdr.Close()
+		return nil, err
+	}
+	return &leafReader{Reader: decoder, closer: rdr, decoder: decoder}, nil
+}
+
+type leafReader struct {
+	io.Reader
+	closer  io.Closer
+	decoder *zstd.Decoder
+}
+
+func (l *leafReader) Close() error {
+	if l.decoder != nil {
+		l.decoder.Close()
+	}
+	return l.closer.Close()
+}
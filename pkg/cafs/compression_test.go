@@ -0,0 +1,103 @@
+package cafs
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/localfs"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func compressionTestFs(t testing.TB, blobs storage.Store, compression CompressionScheme) Fs {
+	fs, err := New(
+		LeafSize(cdcTestLeafSize),
+		Backend(blobs),
+		Compression(compression),
+	)
+	require.NoError(t, err)
+	return fs
+}
+
+func storedSize(t testing.TB, blobs storage.Store, keys []Key) int {
+	var size int
+	for _, key := range keys {
+		rdr, err := blobs.Get(context.Background(), key.String())
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(rdr)
+		require.NoError(t, err)
+		require.NoError(t, rdr.Close())
+		size += len(b)
+	}
+	return size
+}
+
+func assertFsContent(t testing.TB, fs Fs, key Key, expected []byte) {
+	ctx := context.Background()
+	rdr, err := fs.Get(ctx, key)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(rdr)
+	require.NoError(t, err)
+	require.NoError(t, rdr.Close())
+	require.True(t, bytes.Equal(expected, b))
+
+	rdrAt, err := fs.GetAt(ctx, key)
+	require.NoError(t, err)
+	p := make([]byte, 100)
+	off := len(expected) / 2
+	n, err := rdrAt.ReadAt(p, int64(off))
+	require.NoError(t, err)
+	require.Equal(t, expected[off:off+n], p[:n])
+}
+
+func TestCompression(t *testing.T) {
+	ctx := context.Background()
+	text := []byte(strings.Repeat("timestamp,sensor,value\n2019-10-01T00:00:00Z,s1,0.5\n", 10000))
+	random := cdcTestData(7, 3*cdcTestLeafSize+10)
+
+	rawBlobs := localfs.New(afero.NewMemMapFs())
+	raw := compressionTestFs(t, rawBlobs, CompressionNone)
+	zBlobs := localfs.New(afero.NewMemMapFs())
+	compressed := compressionTestFs(t, zBlobs, CompressionZstd)
+
+	for _, data := range [][]byte{text, random} {
+		rawRes, err := raw.Put(ctx, bytes.NewReader(data))
+		require.NoError(t, err)
+		zRes, err := compressed.Put(ctx, bytes.NewReader(data))
+		require.NoError(t, err)
+
+		// keys depend on the uncompressed content only
+		require.Equal(t, rawRes.Key, zRes.Key)
+		assertFsContent(t, compressed, zRes.Key, data)
+
+		leaves, err := LeafsForHash(zBlobs, zRes.Key, cdcTestLeafSize, "")
+		require.NoError(t, err)
+		if bytes.Equal(data, text) {
+			require.True(t, storedSize(t, zBlobs, leaves) < len(data)/5)
+		} else {
+			// leaves which don't shrink are stored raw
+			require.Equal(t, len(data), storedSize(t, zBlobs, leaves))
+		}
+	}
+
+	// existing uncompressed leaves are read by a compressing fs, and conversely
+	rawRes, err := raw.Put(ctx, bytes.NewReader(text))
+	require.NoError(t, err)
+	assertFsContent(t, compressionTestFs(t, rawBlobs, CompressionZstd), rawRes.Key, text)
+	zRes, err := compressed.Put(ctx, bytes.NewReader(text))
+	require.NoError(t, err)
+	assertFsContent(t, compressionTestFs(t, zBlobs, CompressionNone), zRes.Key, text)
+
+	// content looking like a compressed leaf is never stored raw
+	tricky := append(append([]byte{}, zstdLeafMagic...), []byte("not compressed")...)
+	res, err := raw.Put(ctx, bytes.NewReader(tricky))
+	require.NoError(t, err)
+	assertFsContent(t, raw, res.Key, tricky)
+
+	_, err = New(Compression("lz4"))
+	require.Error(t, err)
+}
@@ -0,0 +1,43 @@
+package cafs
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/localfs"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorruptLeafs(t *testing.T) {
+	ctx := context.Background()
+	for _, chunking := range []ChunkingScheme{ChunkingFixed, ChunkingFastCDC} {
+		for _, compression := range []CompressionScheme{CompressionNone, CompressionZstd} {
+			blobs := localfs.New(afero.NewMemMapFs())
+			fs, err := New(LeafSize(cdcTestLeafSize), Backend(blobs), Chunking(chunking), Compression(compression))
+			require.NoError(t, err)
+			for _, size := range []int{100, cdcTestLeafSize, 3*cdcTestLeafSize + 10} {
+				key := mustPut(t, fs, cdcTestData(int64(size), size))
+				corrupt, err := CorruptLeafs(ctx, blobs, key, cdcTestLeafSize, "")
+				require.NoError(t, err)
+				require.Empty(t, corrupt, "%s, %s, size %d", chunking, compression, size)
+			}
+
+			key := mustPut(t, fs, cdcTestData(1, 3*cdcTestLeafSize+10))
+			leaves, err := LeafsForHash(blobs, key, cdcTestLeafSize, "")
+			require.NoError(t, err)
+			require.NoError(t, blobs.Put(ctx, leaves[1].String(), bytes.NewBufferString("bit rot"), storage.OverWrite))
+			require.NoError(t, blobs.Delete(ctx, leaves[2].String()))
+			corrupt, err := CorruptLeafs(ctx, blobs, key, cdcTestLeafSize, "")
+			require.NoError(t, err)
+			require.Equal(t, []Key{leaves[1]}, corrupt, "%s, %s", chunking, compression)
+
+			require.NoError(t, blobs.Put(ctx, key.String(), bytes.NewBufferString("bit rot"), storage.OverWrite))
+			corrupt, err = CorruptLeafs(ctx, blobs, key, cdcTestLeafSize, "")
+			require.NoError(t, err)
+			require.Equal(t, []Key{key}, corrupt, "%s, %s", chunking, compression)
+		}
+	}
+}
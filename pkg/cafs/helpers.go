@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
 	"strings"
 
 	blake2b "github.com/minio/blake2b-simd"
 	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/status"
 )
 
 func CopyPaddedJSON(w io.Writer, buf *bytes.Buffer) {
@@ -63,6 +65,54 @@ func LeafsForHash(blobs storage.Store, hash Key, leafSize uint32, prefix string)
 	return LeafKeys(hash, b, leafSize)
 }
 
+// CorruptLeafs re-hashes the leaves of a root key, and returns those which content doesn't match their key.
+//
+// Missing leaves are not reported: they are found with Fs.Has and HasGatherIncomplete.
+// When the root itself doesn't match its checksum, only the root key is returned.
+func CorruptLeafs(ctx context.Context, blobs storage.Store, hash Key, leafSize uint32, prefix string) ([]Key, error) {
+	b, err := leafBytesForHash(blobs, hash, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if verifyRootChecksum(hash, b) != nil {
+		return []Key{hash}, nil
+	}
+	keys, sizes, err := leafKeysAndSizes(b, leafSize)
+	if err != nil {
+		return []Key{hash}, nil
+	}
+	var corrupt []Key
+	for i, key := range keys {
+		rdr, err := getLeaf(ctx, blobs, key.StringWithPrefix(prefix))
+		if err != nil {
+			if errors.Is(err, status.ErrNotExists) || os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		leaf, err := ioutil.ReadAll(rdr)
+		_ = rdr.Close()
+		if err != nil {
+			// e.g. a compressed leaf which doesn't decode
+			corrupt = append(corrupt, key)
+			continue
+		}
+		// fixed size leaves are numbered from 1, except for a trailing partial leaf
+		nodeOffset, isLastNode := uint64(i+1), false
+		if i == len(keys)-1 && uint32(len(leaf)) != leafSize {
+			nodeOffset, isLastNode = uint64(i), true
+		}
+		actual, err := leafKey(leaf, leafSize, sizes != nil, nodeOffset, isLastNode)
+		if err != nil {
+			return nil, err
+		}
+		if actual != key {
+			corrupt = append(corrupt, key)
+		}
+	}
+	return corrupt, nil
+}
+
 func leafsForHashInternVerify(blobs storage.Store, hash Key, leafSize uint32, prefix string) ([]Key, error) {
 	b, err := leafBytesForHash(blobs, hash, prefix)
 	if err != nil {
@@ -72,6 +122,22 @@ func leafsForHashInternVerify(blobs storage.Store, hash Key, leafSize uint32, pr
 	return leafKeysInternVerify(b, leafSize)
 }
 
+// leafsAndSizesForHash returns the leaves of a root key, as well as their sizes for content-defined leaves.
+//
+// Sizes are nil for fixed size leaves.
+func leafsAndSizesForHash(blobs storage.Store, hash Key, leafSize uint32, prefix string, verify bool) ([]Key, []uint32, error) {
+	b, err := leafBytesForHash(blobs, hash, prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	if verify {
+		if err = verifyRootChecksum(hash, b); err != nil {
+			return nil, nil, err
+		}
+	}
+	return leafKeysAndSizes(b, leafSize)
+}
+
 func leafBytesForHash(blobs storage.Store, hash Key, prefix string) ([]byte, error) {
 	rdr, err := blobs.Get(context.Background(), hash.StringWithPrefix(prefix))
 	if err != nil {
@@ -91,17 +157,39 @@ func leafBytesForHash(blobs storage.Store, hash Key, prefix string) ([]byte, err
 }
 
 func LeafKeys(verify Key, data []byte, leafSize uint32) ([]Key, error) {
-	if len(data) < KeySize {
-		return nil, errors.New("the last hash in the file is not the checksum")
-	}
-	if !bytes.Equal(data[len(data)-KeySize:], verify[:]) {
-		return nil, errors.New("the last hash in the file is not the checksum")
+	if err := verifyRootChecksum(verify, data); err != nil {
+		return nil, err
 	}
 
 	return leafKeysInternVerify(data, leafSize)
 }
 
+func verifyRootChecksum(verify Key, data []byte) error {
+	if len(data) < KeySize || !bytes.Equal(data[len(data)-KeySize:], verify[:]) {
+		return errors.New("the last hash in the file is not the checksum")
+	}
+	return nil
+}
+
 func leafKeysInternVerify(data []byte, leafSize uint32) ([]Key, error) {
+	keys, _, err := leafKeysAndSizes(data, leafSize)
+	return keys, err
+}
+
+// leafKeysAndSizes decodes the content of a root key, with fixed size or content-defined leaves
+func leafKeysAndSizes(data []byte, leafSize uint32) ([]Key, []uint32, error) {
+	if isCDCRoot(data) {
+		keys, sizes, err := decodeCDCRoot(data)
+		if err == nil {
+			return keys, sizes, nil
+		}
+		// in the very unlikely event of a fixed size root starting like the magic header, fall through
+	}
+	keys, err := fixedLeafKeys(data, leafSize)
+	return keys, nil, err
+}
+
+func fixedLeafKeys(data []byte, leafSize uint32) ([]Key, error) {
 	if len(data) < KeySize {
 		return nil, errors.New("the last hash in the file is not the checksum")
 	}
@@ -0,0 +1,162 @@
+package cafs
+
+import (
+	"context"
+	"io/ioutil"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+
+	"github.com/oneconcern/datamon/pkg/storage"
+)
+
+const (
+	defaultPrefetchLeaves = 1
+	defaultCachedLeaves   = 10
+	defaultCachedReaders  = 64
+)
+
+// PrefetchLeaves sets how many leaves are fetched ahead of sequential reads with GetAt.
+//
+// Random reads don't prefetch anything.
+func PrefetchLeaves(leaves int) Option {
+	return func(w *defaultFs) {
+		w.prefetchLeaves = leaves
+	}
+}
+
+// PrefetchMemory sets the approximate memory used to cache the leaves read with GetAt, including prefetched ones.
+//
+// Leaves are prefetched no further than half of the cache, so that they are not evicted before they are read.
+func PrefetchMemory(bytes int64) Option {
+	return func(w *defaultFs) {
+		w.prefetchMemory = bytes
+	}
+}
+
+// SharedLeafCache sets the cache of the leaves read with GetAt, instead of a cache sized after PrefetchMemory.
+func SharedLeafCache(cache *LeafCache) Option {
+	return func(w *defaultFs) {
+		w.leafCache = cache
+	}
+}
+
+// LeafCache caches the leaves read with GetAt.
+//
+// Leaves are content addressed, so that a cache may be shared by several Fs, e.g. to read the files
+// of several repositories within one memory budget. The Fs sharing a cache also share the leaves being
+// fetched, and the bound on background prefetches.
+type LeafCache struct {
+	cache         *lru.Cache
+	leaves        int
+	maxPrefetches int
+
+	mu       sync.Mutex
+	inflight map[Key]*leafFetch
+}
+
+// NewLeafCache creates a cache of leaves, sized after a memory budget and the largest leaf size of the Fs using it.
+//
+// Leaves are prefetched no further than half of the cache.
+func NewLeafCache(memory int64, leafSize uint32) *LeafCache {
+	leaves := defaultCachedLeaves
+	if memory > 0 && leafSize > 0 {
+		leaves = int(memory / int64(leafSize))
+	}
+	if leaves < 2 {
+		leaves = 2
+	}
+	cache, _ := lru.New(leaves)
+	return newLeafCache(cache, leaves, leaves/2)
+}
+
+func newLeafCache(cache *lru.Cache, leaves, maxPrefetches int) *LeafCache {
+	if cache == nil {
+		leaves = defaultCachedLeaves
+		cache, _ = lru.New(leaves)
+	}
+	return &LeafCache{
+		cache:         cache,
+		leaves:        leaves,
+		maxPrefetches: maxPrefetches,
+		inflight:      make(map[Key]*leafFetch),
+	}
+}
+
+// leafFetcher gets leaves from some blob store through a cache shared by readers.
+//
+// A leaf requested concurrently, e.g. by a read and a prefetch, is fetched once.
+type leafFetcher struct {
+	blobs  storage.Store
+	prefix string
+	leaves *LeafCache
+}
+
+type leafFetch struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func newLeafFetcher(blobs storage.Store, prefix string, leaves *LeafCache) *leafFetcher {
+	return &leafFetcher{
+		blobs:  blobs,
+		prefix: prefix,
+		leaves: leaves,
+	}
+}
+
+// get returns the content of a leaf, from the cache when possible
+func (f *leafFetcher) get(key Key) ([]byte, error) {
+	c := f.leaves
+	c.mu.Lock()
+	if data, ok := c.cache.Get(key); ok {
+		c.mu.Unlock()
+		return data.([]byte), nil
+	}
+	fetch, ok := c.inflight[key]
+	if !ok {
+		fetch = f.start(key)
+	}
+	c.mu.Unlock()
+	<-fetch.done
+	return fetch.data, fetch.err
+}
+
+// prefetch fetches a leaf in the background, unless it is cached or too many leaves are being fetched already
+func (f *leafFetcher) prefetch(key Key) {
+	c := f.leaves
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache.Contains(key) || c.inflight[key] != nil || len(c.inflight) >= c.maxPrefetches {
+		return
+	}
+	f.start(key)
+}
+
+// start fetches a leaf in the background. The caller holds the lock of the cache.
+func (f *leafFetcher) start(key Key) *leafFetch {
+	c := f.leaves
+	fetch := &leafFetch{done: make(chan struct{})}
+	c.inflight[key] = fetch
+	go func() {
+		fetch.data, fetch.err = f.fetch(key)
+		c.mu.Lock()
+		if fetch.err == nil {
+			c.cache.Add(key, fetch.data)
+		}
+		delete(c.inflight, key)
+		c.mu.Unlock()
+		close(fetch.done)
+	}()
+	return fetch
+}
+
+func (f *leafFetcher) fetch(key Key) ([]byte, error) {
+	rdr, err := getLeaf(context.Background(), f.blobs, key.StringWithPrefix(f.prefix))
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	return ioutil.ReadAll(rdr)
+}
@@ -0,0 +1,180 @@
+package cafs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+
+	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/localfs"
+)
+
+// countingStore counts the retrievals of each blob, and holds back the retrieval of a gated blob
+type countingStore struct {
+	storage.Store
+	mu    sync.Mutex
+	gets  map[string]int
+	gated string
+	gate  chan struct{}
+}
+
+func (c *countingStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	c.mu.Lock()
+	c.gets[key]++
+	gated := c.gate != nil && key == c.gated
+	c.mu.Unlock()
+	if gated {
+		<-c.gate
+	}
+	return c.Store.Get(ctx, key)
+}
+
+func (c *countingStore) count(key Key) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gets[key.String()]
+}
+
+func TestPrefetch(t *testing.T) {
+	const prefetchLeafSize = 4096
+	ctx := context.Background()
+	blobs := &countingStore{Store: localfs.New(afero.NewMemMapFs()), gets: make(map[string]int)}
+	fs, err := New(
+		LeafSize(prefetchLeafSize),
+		Backend(blobs),
+		PrefetchLeaves(3),
+		PrefetchMemory(20*prefetchLeafSize),
+	)
+	require.NoError(t, err)
+
+	content := cdcTestData(11, 20*prefetchLeafSize)
+	res, err := fs.Put(ctx, bytes.NewReader(content))
+	require.NoError(t, err)
+	keys, err := LeafsForHash(blobs, res.Key, prefetchLeafSize, "")
+	require.NoError(t, err)
+	require.Len(t, keys, 20)
+
+	fetched := func(leaves ...int) func() bool {
+		return func() bool {
+			for _, i := range leaves {
+				if blobs.count(keys[i]) != 1 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
+	// a random read doesn't prefetch anything
+	rdr, err := fs.GetAt(ctx, res.Key)
+	require.NoError(t, err)
+	p := make([]byte, 100)
+	n, err := rdr.ReadAt(p, 10*prefetchLeafSize+10)
+	require.NoError(t, err)
+	require.Equal(t, content[10*prefetchLeafSize+10:10*prefetchLeafSize+10+n], p[:n])
+	time.Sleep(10 * time.Millisecond)
+	require.Zero(t, blobs.count(keys[11]))
+
+	// sequential reads prefetch the following leaves once
+	p = make([]byte, prefetchLeafSize/2)
+	var read []byte
+	for off := 0; off < 4*prefetchLeafSize; off += len(p) {
+		rdr, err = fs.GetAt(ctx, res.Key)
+		require.NoError(t, err)
+		n, err = rdr.ReadAt(p, int64(off))
+		require.NoError(t, err)
+		read = append(read, p[:n]...)
+	}
+	require.Equal(t, content[:4*prefetchLeafSize], read)
+	require.Eventually(t, fetched(0, 1, 2, 3, 4, 5, 6), time.Second, time.Millisecond)
+	require.Zero(t, blobs.count(keys[7]))
+}
+
+func TestSharedLeafCache(t *testing.T) {
+	const prefetchLeafSize = 4096
+	ctx := context.Background()
+	cache := NewLeafCache(8*prefetchLeafSize, prefetchLeafSize)
+	newFs := func(blobs storage.Store) Fs {
+		fs, err := New(LeafSize(prefetchLeafSize), Backend(blobs), PrefetchLeaves(0), SharedLeafCache(cache))
+		require.NoError(t, err)
+		return fs
+	}
+	first := &countingStore{Store: localfs.New(afero.NewMemMapFs()), gets: make(map[string]int)}
+	second := &countingStore{Store: localfs.New(afero.NewMemMapFs()), gets: make(map[string]int)}
+
+	content := cdcTestData(12, 2*prefetchLeafSize)
+	res, err := newFs(first).Put(ctx, bytes.NewReader(content))
+	require.NoError(t, err)
+	_, err = newFs(second).Put(ctx, bytes.NewReader(content))
+	require.NoError(t, err)
+	keys, err := LeafsForHash(first, res.Key, prefetchLeafSize, "")
+	require.NoError(t, err)
+
+	// a leaf read from one store is not fetched again from another one holding the same content
+	p := make([]byte, 100)
+	for _, fs := range []Fs{newFs(first), newFs(second)} {
+		rdr, err := fs.GetAt(ctx, res.Key)
+		require.NoError(t, err)
+		n, err := rdr.ReadAt(p, 10)
+		require.NoError(t, err)
+		require.Equal(t, content[10:10+n], p[:n])
+	}
+	require.Equal(t, 1, first.count(keys[0]))
+	require.Zero(t, second.count(keys[0]))
+}
+
+func TestSharedLeafFetches(t *testing.T) {
+	const prefetchLeafSize = 4096
+	ctx := context.Background()
+	cache := NewLeafCache(8*prefetchLeafSize, prefetchLeafSize)
+	newFs := func(blobs storage.Store) Fs {
+		fs, err := New(LeafSize(prefetchLeafSize), Backend(blobs), PrefetchLeaves(0), SharedLeafCache(cache))
+		require.NoError(t, err)
+		return fs
+	}
+	first := &countingStore{Store: localfs.New(afero.NewMemMapFs()), gets: make(map[string]int)}
+	second := &countingStore{Store: localfs.New(afero.NewMemMapFs()), gets: make(map[string]int)}
+
+	content := cdcTestData(13, 2*prefetchLeafSize)
+	res, err := newFs(first).Put(ctx, bytes.NewReader(content))
+	require.NoError(t, err)
+	_, err = newFs(second).Put(ctx, bytes.NewReader(content))
+	require.NoError(t, err)
+	keys, err := LeafsForHash(first, res.Key, prefetchLeafSize, "")
+	require.NoError(t, err)
+
+	gate := make(chan struct{})
+	for _, store := range []*countingStore{first, second} {
+		store.mu.Lock()
+		store.gated, store.gate = keys[0].String(), gate
+		store.mu.Unlock()
+	}
+
+	// a leaf being fetched from one store is not fetched again from another one holding the same content
+	var wg sync.WaitGroup
+	read := func(fs Fs) {
+		defer wg.Done()
+		rdr, err := fs.GetAt(ctx, res.Key)
+		require.NoError(t, err)
+		p := make([]byte, 100)
+		n, err := rdr.ReadAt(p, 10)
+		require.NoError(t, err)
+		require.Equal(t, content[10:10+n], p[:n])
+	}
+	wg.Add(2)
+	go read(newFs(first))
+	require.Eventually(t, func() bool { return first.count(keys[0]) == 1 }, time.Second, time.Millisecond)
+	go read(newFs(second))
+	time.Sleep(50 * time.Millisecond)
+	close(gate)
+	wg.Wait()
+
+	require.Equal(t, 1, first.count(keys[0]))
+	require.Zero(t, second.count(keys[0]))
+}
@@ -3,14 +3,12 @@ package cafs
 import (
 	"context"
 	"errors"
-	"fmt"
 	"io"
-	"strings"
+	"sort"
 	"sync"
 
 	lru "github.com/hashicorp/golang-lru"
 
-	"github.com/minio/blake2b-simd"
 	"github.com/oneconcern/datamon/pkg/storage"
 )
 
@@ -51,9 +49,11 @@ func ConcurrentChunkWrites(concurrentChunkWrites int) ReaderOption {
 	}
 }
 
-func SetLeafPool(leafPool *leafFreelist) ReaderOption {
+// withFetcher shares a leaf fetcher between readers, which prefetch leaves ahead of sequential reads
+func withFetcher(fetcher *leafFetcher, prefetchLeaves int) ReaderOption {
 	return func(reader *chunkReader) {
-		reader.leafPool = leafPool
+		reader.fetcher = fetcher
+		reader.prefetchLeaves = prefetchLeaves
 	}
 }
 
@@ -62,6 +62,7 @@ func newReader(blobs storage.Store, hash Key, leafSize uint32, prefix string, op
 		fs:                    blobs,
 		hash:                  hash,
 		leafSize:              leafSize,
+		prefix:                prefix,
 		currLeaf:              make([]byte, 0),
 		concurrentChunkWrites: 3,
 	}
@@ -69,18 +70,22 @@ func newReader(blobs storage.Store, hash Key, leafSize uint32, prefix string, op
 	for _, apply := range opts {
 		apply(c)
 	}
+	if c.fetcher == nil {
+		c.fetcher = newLeafFetcher(blobs, prefix, newLeafCache(c.lru, 0, 0))
+	}
 	var err error
 	if c.keys == nil {
-		// ??? distinguish these two functions?
-		if c.verifyHash {
-			c.keys, err = LeafsForHash(blobs, hash, leafSize, prefix)
-		} else {
-			c.keys, err = leafsForHashInternVerify(blobs, hash, leafSize, prefix)
-		}
+		c.keys, c.sizes, err = leafsAndSizesForHash(blobs, hash, leafSize, prefix, c.verifyHash)
 		if err != nil {
 			return nil, err
 		}
-
+	}
+	if c.sizes != nil {
+		// content-defined leaves: locate leaves from their cumulated sizes
+		c.offsets = make([]int64, len(c.sizes)+1)
+		for i, size := range c.sizes {
+			c.offsets[i+1] = c.offsets[i] + int64(size)
+		}
 	}
 	return c, nil
 }
@@ -91,6 +96,8 @@ type chunkReader struct {
 	hash     Key
 	prefix   string
 	keys     []Key
+	sizes    []uint32 // sizes of content-defined leaves, nil for fixed size leaves
+	offsets  []int64  // offsets of content-defined leaves in the file
 	idx      int
 
 	rdr                   io.ReadCloser
@@ -100,8 +107,12 @@ type chunkReader struct {
 	currLeaf              []byte
 	verifyHash            bool
 	lru                   *lru.Cache
-	leafPool              *leafFreelist
 	concurrentChunkWrites int
+
+	fetcher        *leafFetcher
+	prefetchLeaves int
+	mu             sync.Mutex
+	nextIndex      int64 // the leaf following the previous read with ReadAt
 }
 
 func (r *chunkReader) Close() error {
@@ -155,22 +166,28 @@ func (r *chunkReader) WriteTo(writer io.Writer) (n int64, err error) {
 	concurrencyControl := make(chan struct{}, concurrentChunkWrites)
 	for index, key := range r.keys {
 		wg.Add(1)
-		var truncation uint32
-		if r.leafTruncation {
-			truncation = 32 * 1024 // Buffer size used by io.Copy
+		var i int64
+		if r.offsets != nil {
+			i = r.offsets[index]
+		} else {
+			var truncation uint32
+			if r.leafTruncation {
+				truncation = 32 * 1024 // Buffer size used by io.Copy
+			}
+			i = int64(index) * int64(r.leafSize-truncation)
 		}
-		i := int64(index) * int64(r.leafSize-truncation)
 		concurrencyControl <- struct{}{}
 		go func(writeAt int64, writer io.WriterAt, key Key, cafs storage.Store, wg *sync.WaitGroup) {
 			defer func() {
 				<-concurrencyControl
 				wg.Done()
 			}()
-			rdr, err := cafs.Get(context.Background(), key.StringWithPrefix(r.prefix)) // thread safe
+			rdr, err := getLeaf(context.Background(), cafs, key.StringWithPrefix(r.prefix)) // thread safe
 			if err != nil {
 				errC <- err
 				return
 			}
+			defer rdr.Close()
 			w := &cafsWriterAt{
 				w:      writer,
 				offset: writeAt,
@@ -207,99 +224,72 @@ func calculateKeyAndOffset(off int64, leafSize uint32) (index int64, offset int6
 	return
 }
 
-func (r *chunkReader) ReadAt(p []byte, off int64) (totread int, err error) {
-
-	readLeaf := func(k Key) (*leafBuffer, error) {
-		rdr, e := r.fs.Get(context.Background(), k.StringWithPrefix(r.prefix))
-		if e != nil {
-			return nil, e
-		}
-		lb := r.leafPool.get()
-		if len(lb.slice) != 0 {
-			return nil, fmt.Errorf("non-zero slice length out of leaf-pool")
-		}
-		for {
-			m, e := rdr.Read(lb.slice[len(lb.slice):cap(lb.slice)])
-			if m < 0 {
-				panic(fmt.Errorf("read negative number of bytes"))
-			}
-			lb.slice = lb.slice[:len(lb.slice)+m]
-			if e == io.EOF {
-				return lb, nil // e is EOF, so return nil explicitly
-			}
-			if e != nil {
-				r.leafPool.put(lb)
-				return lb, e
-			}
-		}
+// locate returns the index of the leaf holding some offset, and the offset within that leaf
+func (r *chunkReader) locate(off int64) (index int64, offset int64) {
+	if r.offsets == nil {
+		return calculateKeyAndOffset(off, r.leafSize)
+	}
+	i := sort.Search(len(r.sizes), func(i int) bool { return r.offsets[i+1] > off })
+	if i == len(r.sizes) {
+		return int64(i), 0
 	}
+	return int64(i), off - r.offsets[i]
+}
 
+// ReadAt reads the leaves holding some range of the file, through the cache of leaves.
+//
+// Reads which continue the previous one are sequential: the following leaves are prefetched.
+// ReadAt may be called concurrently.
+func (r *chunkReader) ReadAt(p []byte, off int64) (totread int, err error) {
 	// Calculate first key and offset.
-	index, offset := calculateKeyAndOffset(off, r.leafSize)
+	index, offset := r.locate(off)
 	if index >= int64(len(r.keys)) {
 		return 0, nil
 	}
+	sequential := r.continues(index)
 
-	var leafread int
-
-	addToCache := func(key Key, buffer *leafBuffer) {
-		if buffer == nil {
-			return
-		}
-		alreadyContained, _ := r.lru.ContainsOrAdd(key.StringWithPrefix(r.prefix), buffer)
-		if alreadyContained {
-			r.leafPool.put(buffer)
-		}
-	}
-
-	// seekAhead makes sure the next blob in CAFS is already in memory when the reader reaches that blob.
-	seekAhead := func() {
-		nextIndex := index + 1
-		if nextIndex >= int64(len(r.keys)) {
-			return
+	for {
+		data, err := r.fetcher.get(r.keys[index])
+		if err != nil {
+			return totread, err
 		}
-		if r.lru.Contains(r.keys[nextIndex].StringWithPrefix(r.prefix)) {
-			return
+		if sequential {
+			r.prefetch(index)
 		}
-		go func(i int64) {
-			k := r.keys[i]
-			b, e := readLeaf(k)
-			if e != nil {
-				return
-			}
-			addToCache(k, b)
-		}(nextIndex)
-	}
-
-	for {
-		// Fetch Blob
-		var buffer *leafBuffer
-		key := r.keys[index]
-		if b, ok := r.lru.Get(key.StringWithPrefix(r.prefix)); ok {
-			buffer = b.(*leafBuffer)
-		} else {
-			buffer, err = readLeaf(key)
-			if err != nil {
-				return
-			}
-			addToCache(key, buffer)
+		if offset < int64(len(data)) {
+			totread += copy(p[totread:], data[offset:])
 		}
-		seekAhead()
-
-		// Read first leaf
-		leafread = copy(p[totread:], buffer.slice[offset:])
-		totread += leafread
 		index++
 		offset = 0
-		if err != nil && !strings.Contains(err.Error(), "EOF") {
-			return
-		}
 		if (len(p) == totread) || (index >= int64(len(r.keys))) {
-			return
+			r.readUntil(index)
+			return totread, nil
 		}
 	}
 }
 
+// continues tells if a read starting at some leaf continues the previous read, or starts the file.
+//
+// Concurrent reads of a sequential scan may come slightly out of order: a read of the previous leaf continues as well.
+func (r *chunkReader) continues(index int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return index == 0 || (index >= r.nextIndex-1 && index <= r.nextIndex)
+}
+
+func (r *chunkReader) readUntil(index int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextIndex = index
+}
+
+// prefetch fetches the leaves following some leaf in the background
+func (r *chunkReader) prefetch(index int64) {
+	for i := index + 1; i <= index+int64(r.prefetchLeaves) && i < int64(len(r.keys)); i++ {
+		r.fetcher.prefetch(r.keys[i])
+	}
+}
+
 func (r *chunkReader) Read(data []byte) (int, error) {
 	bytesToRead := len(data)
 
@@ -309,7 +299,7 @@ func (r *chunkReader) Read(data []byte) (int, error) {
 	for {
 		key := r.keys[r.idx]
 		if r.rdr == nil {
-			rdr, err := r.fs.Get(context.Background(), key.StringWithPrefix(r.prefix))
+			rdr, err := getLeaf(context.Background(), r.fs, key.StringWithPrefix(r.prefix))
 			if err != nil {
 				return r.readSoFar, err
 			}
@@ -334,25 +324,7 @@ func (r *chunkReader) Read(data []byte) (int, error) {
 						nodeOffset--
 						isLastNode = true
 					}
-					hasher, err := blake2b.New(&blake2b.Config{
-						Size: blake2b.Size,
-						Tree: &blake2b.Tree{
-							Fanout:        0,
-							MaxDepth:      2,
-							LeafSize:      r.leafSize,
-							NodeOffset:    uint64(nodeOffset),
-							NodeDepth:     0,
-							InnerHashSize: blake2b.Size,
-							IsLastNode:    isLastNode,
-						},
-					})
-					if err != nil {
-						return 0, err
-					}
-					if _, err = hasher.Write(r.currLeaf); err != nil {
-						return 0, err
-					}
-					leafKey, err := NewKey(hasher.Sum(nil))
+					leafKey, err := leafKey(r.currLeaf, r.leafSize, r.sizes != nil, uint64(nodeOffset), isLastNode)
 					if err != nil {
 						return 0, err
 					}
@@ -7,6 +7,7 @@ import (
 	"hash/crc32"
 	"io"
 	"sync/atomic"
+	"time"
 
 	"github.com/oneconcern/datamon/pkg/storage"
 
@@ -23,6 +24,8 @@ type fsWriter struct {
 	store               storage.Store       // CAFS backing store
 	prefix              string              // Prefix for store paths
 	leafSize            uint32              // Size of chunks
+	chunker             *fastCDC            // Content-defined chunker, nil for fixed size chunks
+	encode              leafEncoder         // Encoding of leaves in the store (e.g. compression)
 	leafs               []Key               // List of keys backing a file
 	buf                 []byte              // Buffer stage a chunk == leafsize
 	offset              int                 // till where buffer is used
@@ -44,28 +47,15 @@ func (w *fsWriter) Write(p []byte) (n int, err error) {
 			return len(p), nil
 		}
 		// Copy p to w.buf
-		writable := len(w.buf) - w.offset
-		if len(p) < writable {
-			writable = len(p)
-		}
-		c := copy(w.buf[w.offset:], p[written:writable])
+		c := copy(w.buf[w.offset:], p[written:])
 		w.offset += c
 		written += c
 		if w.offset == len(w.buf) { // sizes line up, flush and continue
-			w.count++ // next leaf
-			w.maxGoRoutines <- struct{}{}
-			go pFlush(
-				false,
-				w.buf,
-				w.prefix,
-				w.leafSize,
-				w.count,
-				w.flushChan,
-				w.errC,
-				w.maxGoRoutines,
-				w.pather,
-				w.store,
-			)
+			if w.chunker != nil {
+				w.cutLeaf()
+				continue
+			}
+			w.flushLeaf(w.buf)
 			w.buf = make([]byte, w.leafSize) // new buffer
 			w.offset = 0                     // new offset for new buffer
 			continue
@@ -73,9 +63,90 @@ func (w *fsWriter) Write(p []byte) (n int, err error) {
 	}
 }
 
+// flushLeaf writes a leaf in the background
+func (w *fsWriter) flushLeaf(leaf []byte) {
+	w.count++ // next leaf
+	w.maxGoRoutines <- struct{}{}
+	go pFlush(
+		false,
+		leaf,
+		w.prefix,
+		w.leafSize,
+		w.chunker != nil,
+		w.encode,
+		w.count,
+		w.flushChan,
+		w.errC,
+		w.maxGoRoutines,
+		w.pather,
+		w.store,
+	)
+}
+
+// cutLeaf flushes the next content-defined leaf from the buffer, and keeps the remainder for the next leaf
+func (w *fsWriter) cutLeaf() {
+	cut := w.chunker.cut(w.buf[:w.offset])
+	leaf, rest := w.buf[:cut], w.buf[cut:w.offset]
+	w.buf = make([]byte, len(w.buf))
+	w.offset = copy(w.buf, rest)
+	w.flushLeaf(leaf)
+}
+
 type blobFlush struct {
 	count uint64
 	key   Key
+	size  uint32
+}
+
+// leafKey computes the key of a leaf.
+//
+// Fixed size leaves are hashed as nodes of a blake2b tree. Content-defined leaves are hashed
+// independently of their position.
+func leafKey(leaf []byte, leafSize uint32, contentDefined bool, nodeOffset uint64, isLastNode bool) (Key, error) {
+	if contentDefined {
+		return cdcLeafKey(leaf)
+	}
+	hasher, err := blake2b.New(&blake2b.Config{
+		Size: blake2b.Size,
+		Tree: &blake2b.Tree{
+			Fanout:        0,
+			MaxDepth:      2,
+			LeafSize:      leafSize,
+			NodeOffset:    nodeOffset,
+			NodeDepth:     0,
+			InnerHashSize: blake2b.Size,
+			IsLastNode:    isLastNode,
+		},
+	})
+	if err != nil {
+		return Key{}, err
+	}
+	if _, err = hasher.Write(leaf); err != nil {
+		return Key{}, fmt.Errorf("flush segment hash: %v", err)
+	}
+	key, err := NewKey(hasher.Sum(nil))
+	if err != nil {
+		return Key{}, fmt.Errorf("flush key segment: %v", err)
+	}
+	return key, nil
+}
+
+// BlobTouchAge is the age from which a blob reused by an upload is touched. It is a fraction of the grace period
+// of the garbage collector, which should not be set any shorter.
+const BlobTouchAge = 6 * time.Hour
+
+// hasBlob tells if a blob is stored already, so that it is not written again.
+//
+// A blob older than BlobTouchAge is touched, so that the grace period of the garbage collector covers orphan blobs
+// reused by uploads in progress. Failing to touch the blob is not an error: the blob is still reused.
+func hasBlob(ctx context.Context, store storage.Store, key string) bool {
+	if found, _ := store.Has(ctx, key); !found {
+		return false
+	}
+	if attrs, err := store.GetAttr(ctx, key); err == nil && time.Since(attrs.Updated) > BlobTouchAge {
+		_ = store.Touch(ctx, key)
+	}
+	return true
 }
 
 func pFlush(
@@ -83,6 +154,8 @@ func pFlush(
 	buffer []byte,
 	prefix string,
 	leafSize uint32,
+	contentDefined bool,
+	encode leafEncoder,
 	count uint64,
 	flushChan chan blobFlush,
 	errC chan error,
@@ -94,47 +167,29 @@ func pFlush(
 		<-maxGoRoutines
 	}()
 	// Calculate hash value
-	hasher, err := blake2b.New(&blake2b.Config{
-		Size: blake2b.Size,
-		Tree: &blake2b.Tree{
-			Fanout:        0,
-			MaxDepth:      2,
-			LeafSize:      leafSize,
-			NodeOffset:    count,
-			NodeDepth:     0,
-			InnerHashSize: blake2b.Size,
-			IsLastNode:    isLastNode,
-		},
-	})
+	leafKey, err := leafKey(buffer, leafSize, contentDefined, count, isLastNode)
 	if err != nil {
 		errC <- err
 		return
 	}
-	_, err = hasher.Write(buffer)
-	if err != nil {
-		errC <- fmt.Errorf("flush segment hash: %v", err)
-		return
-	}
-
-	leafKey, err := NewKey(hasher.Sum(nil))
-	if err != nil {
-		errC <- fmt.Errorf("flush key segment: %v", err)
-		return
-	}
 
 	// Write the blob
 	if pather == nil {
 		// w.pather = func(lks string) string { return filepath.Join(lks[:3], lks[3:6], lks[6:]) }
 		pather = func(lks string) string { return prefix + lks }
 	}
-	found, _ := destination.Has(context.TODO(), pather(leafKey.String()))
+	found := hasBlob(context.TODO(), destination, pather(leafKey.String()))
 	if !found {
+		stored := buffer
+		if encode != nil {
+			stored = encode(buffer)
+		}
 		d, ok := destination.(storage.StoreCRC)
 		if ok {
-			crc := crc32.Checksum(buffer, crc32.MakeTable(crc32.Castagnoli))
-			err = d.PutCRC(context.TODO(), pather(leafKey.String()), bytes.NewReader(buffer), storage.OverWrite, crc)
+			crc := crc32.Checksum(stored, crc32.MakeTable(crc32.Castagnoli))
+			err = d.PutCRC(context.TODO(), pather(leafKey.String()), bytes.NewReader(stored), storage.OverWrite, crc)
 		} else {
-			err = destination.Put(context.TODO(), pather(leafKey.String()), bytes.NewReader(buffer), storage.OverWrite)
+			err = destination.Put(context.TODO(), pather(leafKey.String()), bytes.NewReader(stored), storage.OverWrite)
 		}
 		if err != nil {
 			errC <- fmt.Errorf("write segment file: %s, err: %w", pather(leafKey.String()), err)
@@ -147,6 +202,7 @@ func pFlush(
 	flushChan <- blobFlush{
 		count: count,
 		key:   leafKey,
+		size:  uint32(len(buffer)),
 	}
 }
 
@@ -154,44 +210,27 @@ func (w *fsWriter) flush(isLastNode bool) (int, error) {
 	if w.offset == 0 {
 		return 0, nil
 	}
-	hasher, err := blake2b.New(&blake2b.Config{
-		Size: blake2b.Size,
-		Tree: &blake2b.Tree{
-			Fanout:        0,
-			MaxDepth:      2,
-			LeafSize:      w.leafSize,
-			NodeOffset:    uint64(len(w.leafs)),
-			NodeDepth:     0,
-			InnerHashSize: blake2b.Size,
-			IsLastNode:    isLastNode,
-		},
-	})
+	leafKey, err := leafKey(w.buf[:w.offset], w.leafSize, false, uint64(len(w.leafs)), isLastNode)
 	if err != nil {
 		return 0, err
 	}
 
-	_, err = hasher.Write(w.buf[:w.offset])
-	if err != nil {
-		return 0, fmt.Errorf("flush segment hash: %v", err)
-	}
-
-	leafKey, err := NewKey(hasher.Sum(nil))
-	if err != nil {
-		return 0, fmt.Errorf("flush key segment: %v", err)
-	}
-
 	if w.pather == nil {
 		// w.pather = func(lks string) string { return filepath.Join(lks[:3], lks[3:6], lks[6:]) }
 		w.pather = func(lks string) string { return w.prefix + lks }
 	}
-	found, _ := w.store.Has(context.TODO(), w.pather(leafKey.String()))
+	found := hasBlob(context.TODO(), w.store, w.pather(leafKey.String()))
 	if !found {
+		stored := w.buf[:w.offset]
+		if w.encode != nil {
+			stored = w.encode(stored)
+		}
 		d, ok := w.store.(storage.StoreCRC)
 		if ok {
-			crc := crc32.Checksum(w.buf[:w.offset], crc32.MakeTable(crc32.Castagnoli))
-			err = d.PutCRC(context.TODO(), w.pather(leafKey.String()), bytes.NewReader(w.buf[:w.offset]), storage.OverWrite, crc)
+			crc := crc32.Checksum(stored, crc32.MakeTable(crc32.Castagnoli))
+			err = d.PutCRC(context.TODO(), w.pather(leafKey.String()), bytes.NewReader(stored), storage.OverWrite, crc)
 		} else {
-			err = w.store.Put(context.TODO(), w.pather(leafKey.String()), bytes.NewReader(w.buf[:w.offset]), storage.OverWrite)
+			err = w.store.Put(context.TODO(), w.pather(leafKey.String()), bytes.NewReader(stored), storage.OverWrite)
 		}
 		if err != nil {
 			return 0, fmt.Errorf("write segment file: %s err:%w", w.pather(leafKey.String()), err)
@@ -226,6 +265,12 @@ func (w *fsWriter) flushThread() {
 
 // don't Write() during Flush()
 func (w *fsWriter) Flush() (Key, []byte, error) {
+	if w.chunker != nil {
+		// content-defined leaves are all flushed in the background, including the last ones
+		for w.offset > 0 {
+			w.cutLeaf()
+		}
+	}
 	for i := 0; i < cap(w.maxGoRoutines); i++ {
 		w.maxGoRoutines <- struct{}{}
 	}
@@ -240,6 +285,10 @@ func (w *fsWriter) Flush() (Key, []byte, error) {
 	}
 	atomic.StoreUint32(&w.flushed, 1)
 
+	if w.chunker != nil {
+		return w.contentDefinedRoot()
+	}
+
 	_, err := w.flush(true)
 	if err != nil {
 		return Key{}, nil, err
@@ -258,6 +307,22 @@ func (w *fsWriter) Flush() (Key, []byte, error) {
 	return rhash, leafHashes, nil
 }
 
+func (w *fsWriter) contentDefinedRoot() (Key, []byte, error) {
+	sizes := make([]uint32, len(w.blobFlushes))
+	for _, bf := range w.blobFlushes {
+		sizes[bf.count-1] = bf.size
+	}
+	body, err := encodeCDCRoot(w.leafs, sizes)
+	if err != nil {
+		return Key{}, nil, err
+	}
+	rhash, err := cdcRootHash(body)
+	if err != nil {
+		return Key{}, nil, fmt.Errorf("flush make root hash: %v", err)
+	}
+	return rhash, body, nil
+}
+
 func (w *fsWriter) Close() error {
 	if !atomic.CompareAndSwapUint32(&w.flushed, 1, 0) {
 		return fmt.Errorf("stream closed without being flushed")
@@ -6,9 +6,11 @@
 package context
 
 import (
+	"bytes"
 	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -17,6 +19,7 @@ import (
 	"github.com/oneconcern/datamon/pkg/model"
 
 	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/encrypted"
 	"github.com/oneconcern/datamon/pkg/storage/localfs"
 	"github.com/spf13/afero"
 )
@@ -156,3 +159,40 @@ func TestCreateContext(t *testing.T) {
 		})
 	}
 }
+
+func TestEncryptStores(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "context-keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "master.key")
+	require.NoError(t, encrypted.GenerateKeyFile(keyFile))
+
+	blob, meta, wal := localfs.New(afero.NewMemMapFs()), localfs.New(afero.NewMemMapFs()), localfs.New(afero.NewMemMapFs())
+	vmeta, readLog := localfs.New(afero.NewMemMapFs()), localfs.New(afero.NewMemMapFs())
+	stores := NewStores(wal, readLog, blob, meta, vmeta)
+
+	unchanged, err := EncryptStores(stores, model.Context{Name: "ctx"})
+	require.NoError(t, err)
+	require.Equal(t, blob, unchanged.Blob())
+
+	_, err = EncryptStores(stores, model.Context{Name: "ctx", Encryption: &model.Encryption{KMSDir: dir}})
+	require.Error(t, err)
+
+	encryptedStores, err := EncryptStores(stores, model.Context{Name: "ctx", Encryption: &model.Encryption{KeyFile: keyFile}})
+	require.NoError(t, err)
+	for _, s := range []struct{ encrypted, raw storage.Store }{
+		{encryptedStores.Blob(), blob},
+		{encryptedStores.Metadata(), meta},
+		{encryptedStores.VMetadata(), vmeta},
+		{encryptedStores.Wal(), wal},
+		{encryptedStores.ReadLog(), readLog},
+	} {
+		require.NoError(t, s.encrypted.Put(ctx, "key", bytes.NewBufferString("secret"), storage.NoOverWrite))
+		rdr, err := s.raw.Get(ctx, "key")
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(rdr)
+		require.NoError(t, err)
+		require.NotContains(t, string(b), "secret")
+	}
+}
@@ -0,0 +1,56 @@
+/*
+ * Copyright © 2019 One Concern
+ *
+ */
+
+package context
+
+import (
+	"fmt"
+
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/oneconcern/datamon/pkg/storage/encrypted"
+)
+
+// EncryptStores wraps all the stores of a context with client-side encryption, when the context descriptor
+// configures it: the versioned metadata hold labels and branches, the WAL and the read log hold full descriptors.
+//
+// Data keys are named after the context, so that blobs dedupe across all repos of the context.
+func EncryptStores(stores Stores, descriptor model.Context) (Stores, error) {
+	if descriptor.Encryption == nil {
+		return stores, nil
+	}
+	if err := descriptor.Encryption.Validate(); err != nil {
+		return stores, err
+	}
+	var (
+		kms encrypted.KMS
+		err error
+	)
+	if descriptor.Encryption.KeyFile != "" {
+		kms, err = encrypted.NewKeyFileKMS(descriptor.Encryption.KeyFile)
+		if err != nil {
+			return stores, fmt.Errorf("context %s: %w", descriptor.Name, err)
+		}
+	} else {
+		kms = encrypted.NewFileKMS(descriptor.Encryption.KMSDir)
+	}
+
+	keyName := encrypted.KeyName(descriptor.Name)
+	if stores.blob != nil {
+		stores.blob = encrypted.New(stores.blob, kms, descriptor.Encryption.KeyID, keyName)
+	}
+	if stores.metadata != nil {
+		stores.metadata = encrypted.New(stores.metadata, kms, descriptor.Encryption.KeyID, keyName)
+	}
+	if stores.vMetadata != nil {
+		stores.vMetadata = encrypted.New(stores.vMetadata, kms, descriptor.Encryption.KeyID, keyName)
+	}
+	if stores.wal != nil {
+		stores.wal = encrypted.New(stores.wal, kms, descriptor.Encryption.KeyID, keyName)
+	}
+	if stores.readLog != nil {
+		stores.readLog = encrypted.New(stores.readLog, kms, descriptor.Encryption.KeyID, keyName)
+	}
+	return stores, nil
+}
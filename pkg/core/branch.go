@@ -0,0 +1,204 @@
+/*
+ * Copyright © 2019 One Concern
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/core/status"
+	"github.com/oneconcern/datamon/pkg/dlogger"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/oneconcern/datamon/pkg/storage"
+)
+
+// BranchOption sets options for the moves of branches
+type BranchOption func(*branchSettings)
+
+type branchSettings struct {
+	l *zap.Logger
+}
+
+// BranchLogger sets the logger used when moving branches
+func BranchLogger(l *zap.Logger) BranchOption {
+	return func(s *branchSettings) {
+		if l != nil {
+			s.l = l
+		}
+	}
+}
+
+func defaultBranchSettings(opts []BranchOption) branchSettings {
+	l, _ := dlogger.GetLogger("info")
+	settings := branchSettings{
+		l: l,
+	}
+	for _, apply := range opts {
+		apply(&settings)
+	}
+	return settings
+}
+
+// GetBranch returns the head of a branch of a repo.
+//
+// It fails with status.ErrNotFound when the branch doesn't exist.
+func GetBranch(ctx context.Context, stores context2.Stores, repo, name string) (model.BranchDescriptor, error) {
+	if err := RepoExists(repo, stores); err != nil {
+		return model.BranchDescriptor{}, err
+	}
+	head, err := getBranchHead(ctx, getVMetaStore(stores), repo, name)
+	if err != nil {
+		return head, err
+	}
+	if head.Generation == 0 || head.Deleted {
+		return head, fmt.Errorf("%w: no branch %s in repo %s", status.ErrNotFound, name, repo)
+	}
+	return head, nil
+}
+
+// CreateBranch creates a branch of a repo, with some bundle as its head.
+//
+// The bundle may be empty, for a branch without bundle yet. A deleted branch may be created again.
+func CreateBranch(ctx context.Context, stores context2.Stores, repo, name, bundleID string,
+	contributor model.Contributor, opts ...BranchOption) (model.BranchDescriptor, error) {
+	if err := model.ValidateBranchName(name); err != nil {
+		return model.BranchDescriptor{}, err
+	}
+	if err := RepoExists(repo, stores); err != nil {
+		return model.BranchDescriptor{}, err
+	}
+	if bundleID != "" {
+		exists, err := NewBundle(NewBDescriptor(), Repo(repo), BundleID(bundleID), ContextStores(stores)).Exists(ctx)
+		if err != nil {
+			return model.BranchDescriptor{}, err
+		}
+		if !exists {
+			return model.BranchDescriptor{}, fmt.Errorf("%w: no bundle %s in repo %s", status.ErrNotFound, bundleID, repo)
+		}
+	}
+	head, err := getBranchHead(ctx, getVMetaStore(stores), repo, name)
+	if err != nil {
+		return head, err
+	}
+	if head.Generation != 0 && !head.Deleted {
+		return head, fmt.Errorf("%w: %s in repo %s", status.ErrBranchExists, name, repo)
+	}
+	return moveBranch(ctx, stores, repo, head, bundleID, false, contributor, defaultBranchSettings(opts))
+}
+
+// AdvanceBranch moves the head of a branch to some bundle, provided the branch is still at head.
+//
+// This is a compare-and-swap: it fails with status.ErrBranchMoved when the branch has been moved since head
+// was retrieved, e.g. by a concurrent upload.
+func AdvanceBranch(ctx context.Context, stores context2.Stores, repo string, head model.BranchDescriptor, bundleID string,
+	contributor model.Contributor, opts ...BranchOption) (model.BranchDescriptor, error) {
+	if head.Generation == 0 || head.Deleted {
+		return head, fmt.Errorf("%w: no branch %s in repo %s", status.ErrNotFound, head.Name, repo)
+	}
+	return moveBranch(ctx, stores, repo, head, bundleID, false, contributor, defaultBranchSettings(opts))
+}
+
+// DeleteBranch deletes a branch of a repo. The bundles of the branch are kept.
+func DeleteBranch(ctx context.Context, stores context2.Stores, repo, name string, contributor model.Contributor,
+	opts ...BranchOption) error {
+	head, err := GetBranch(ctx, stores, repo, name)
+	if err != nil {
+		return err
+	}
+	_, err = moveBranch(ctx, stores, repo, head, "", true, contributor, defaultBranchSettings(opts))
+	return err
+}
+
+// ListBranches returns the branches of a repo, sorted by name
+func ListBranches(ctx context.Context, stores context2.Stores, repo string, opts ...ListOption) (model.BranchDescriptors, error) {
+	settings := defaultSettings()
+	for _, apply := range opts {
+		apply(&settings)
+	}
+	if err := RepoExists(repo, stores); err != nil {
+		return nil, err
+	}
+	store := getVMetaStore(stores)
+	prefix := model.GetArchivePathPrefixToBranches(repo)
+	keys, err := listKeysPrefix(ctx, store, prefix, settings.batchSize)
+	if err != nil {
+		return nil, err
+	}
+	branches := make(model.BranchDescriptors, 0, len(keys))
+	for _, key := range keys {
+		name := strings.TrimSuffix(strings.TrimPrefix(key, prefix), ".yaml")
+		head, err := getBranchHead(ctx, store, repo, name)
+		if err != nil {
+			return nil, err
+		}
+		if head.Generation == 0 || head.Deleted {
+			continue
+		}
+		branches = append(branches, head)
+	}
+	sort.Sort(branches)
+	return branches, nil
+}
+
+// branchRef locates the generations of a branch
+func branchRef(store storage.Store, repo, name string) generationRef {
+	return generationRef{
+		store: store,
+		hint:  model.GetArchivePathToBranch(repo, name),
+		generation: func(generation uint64) string {
+			return model.GetArchivePathToBranchGeneration(repo, name, generation)
+		},
+	}
+}
+
+// getBranchHead returns the latest generation of a branch, or an empty generation 0 when the branch has never existed.
+func getBranchHead(ctx context.Context, store storage.Store, repo, name string) (model.BranchDescriptor, error) {
+	head := model.BranchDescriptor{Name: name}
+	_, buffer, err := branchRef(store, repo, name).head(ctx)
+	if err != nil || buffer == nil {
+		return head, err
+	}
+	err = yaml.Unmarshal(buffer, &head)
+	return head, err
+}
+
+// moveBranch writes the generation of a branch following head.
+func moveBranch(ctx context.Context, stores context2.Stores, repo string, head model.BranchDescriptor, bundleID string,
+	deleted bool, contributor model.Contributor, settings branchSettings) (model.BranchDescriptor, error) {
+	ref := branchRef(getVMetaStore(stores), repo, head.Name)
+	next := model.BranchDescriptor{
+		Name:        head.Name,
+		BundleID:    bundleID,
+		Generation:  head.Generation + 1,
+		Deleted:     deleted,
+		Timestamp:   time.Now().UTC(),
+		Contributor: contributor,
+	}
+	buffer, err := yaml.Marshal(next)
+	if err != nil {
+		return head, err
+	}
+	moved, err := ref.put(ctx, next.Generation, buffer)
+	if err != nil {
+		return head, err
+	}
+	if moved {
+		return head, fmt.Errorf("%w: branch %s of repo %s is no longer at generation %d (bundle %s)",
+			status.ErrBranchMoved, head.Name, repo, head.Generation, head.BundleID)
+	}
+	// the WAL records the move once it has happened, since it may be rejected
+	if err = appendWALEntry(ctx, stores, settings.l, model.NewBranchSetPayload(repo, next)); err != nil {
+		return next, err
+	}
+	return next, ref.setHint(ctx, buffer)
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright © 2019 One Concern
+ *
+ */
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/core/status"
+	"github.com/oneconcern/datamon/pkg/errors"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/oneconcern/datamon/pkg/storage"
+)
+
+func TestBranches(t *testing.T) {
+	ctx := context.Background()
+	blobs, meta, vmeta := memStore(), memStore(), memStore()
+	uploads := context2.NewStores(nil, nil, blobs, meta, vmeta)
+	createTestRepo(t, uploads)
+	first := uploadTestBundle(t, uploads, map[string]string{"file": "first"})
+	second := uploadTestBundle(t, uploads, map[string]string{"file": "second"})
+
+	// branch moves are recorded in the WAL
+	walStore := memStore()
+	stores := context2.NewStores(walStore, nil, blobs, meta, vmeta)
+	contributor := model.Contributor{Name: "test", Email: "t@test.com"}
+
+	_, err := GetBranch(ctx, stores, repo, "main")
+	require.True(t, errors.Is(err, status.ErrNotFound))
+	_, err = CreateBranch(ctx, stores, repo, "main", "nosuchbundle", contributor)
+	require.True(t, errors.Is(err, status.ErrNotFound))
+	_, err = CreateBranch(ctx, stores, repo, "not/valid", "", contributor)
+	require.Error(t, err)
+
+	main, err := CreateBranch(ctx, stores, repo, "main", first.BundleID, contributor)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), main.Generation)
+	_, err = CreateBranch(ctx, stores, repo, "main", second.BundleID, contributor)
+	require.True(t, errors.Is(err, status.ErrBranchExists))
+	empty, err := CreateBranch(ctx, stores, repo, "empty", "", contributor)
+	require.NoError(t, err)
+	require.Empty(t, empty.BundleID)
+
+	// concurrent writers from the same head: only the first one moves the branch
+	moved, err := AdvanceBranch(ctx, stores, repo, main, second.BundleID, contributor)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), moved.Generation)
+	_, err = AdvanceBranch(ctx, stores, repo, main, first.BundleID, contributor)
+	require.True(t, errors.Is(err, status.ErrBranchMoved))
+
+	head, err := GetBranch(ctx, stores, repo, "main")
+	require.NoError(t, err)
+	require.Equal(t, second.BundleID, head.BundleID)
+	require.Equal(t, uint64(2), head.Generation)
+
+	// the head written next to the generations lags behind
+	stale, err := yaml.Marshal(main)
+	require.NoError(t, err)
+	require.NoError(t, vmeta.Put(ctx, model.GetArchivePathToBranch(repo, "main"), bytes.NewReader(stale), storage.OverWrite))
+	head, err = GetBranch(ctx, stores, repo, "main")
+	require.NoError(t, err)
+	require.Equal(t, second.BundleID, head.BundleID)
+
+	branches, err := ListBranches(ctx, stores, repo)
+	require.NoError(t, err)
+	require.Len(t, branches, 2)
+	require.Equal(t, "empty", branches[0].Name)
+	require.Equal(t, "main", branches[1].Name)
+
+	require.NoError(t, DeleteBranch(ctx, stores, repo, "empty", contributor))
+	require.True(t, errors.Is(DeleteBranch(ctx, stores, repo, "empty", contributor), status.ErrNotFound))
+	branches, err = ListBranches(ctx, stores, repo)
+	require.NoError(t, err)
+	require.Len(t, branches, 1)
+
+	// a deleted branch may be created again
+	empty, err = CreateBranch(ctx, stores, repo, "empty", first.BundleID, contributor)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), empty.Generation)
+
+	payloads := readWALPayloads(t, walStore)
+	require.Contains(t, payloads, "branch-set repo="+repo+" branch=main bundle="+second.BundleID+" generation=2")
+	require.Contains(t, payloads, "branch-set repo="+repo+" branch=empty bundle= generation=2")
+}
@@ -38,6 +38,13 @@ type Bundle struct {
 	concurrentFileUploads       int
 	concurrentFileDownloads     int
 	concurrentFilelistDownloads int
+	compression                 cafs.CompressionScheme
+	uploadJournal               string
+	resumeUpload                bool
+	resumeDownload              bool
+	prefetchLeaves              int
+	prefetchMemory              int64
+	leafCache                   *cafs.LeafCache // shared by the bundles mounted together
 }
 
 // SetBundleID for the bundle
@@ -86,6 +93,14 @@ func Parents(p []string) BundleDescriptorOption {
 	}
 }
 
+// Chunking sets the scheme used to split the files of a bundle into blobs, e.g. cafs.ChunkingFastCDC.
+// Files are split into fixed size leaves by default.
+func Chunking(scheme cafs.ChunkingScheme) BundleDescriptorOption {
+	return func(b *model.BundleDescriptor) {
+		b.Chunking = string(scheme)
+	}
+}
+
 func NewBDescriptor(descriptorOps ...BundleDescriptorOption) *model.BundleDescriptor {
 	bd := model.BundleDescriptor{
 		LeafSize:               cafs.DefaultLeafSize, // For now, fixed leaf size
@@ -193,6 +208,53 @@ func ConcurrentFilelistDownloads(concurrentFilelistDownloads int) BundleOption {
 	}
 }
 
+// Compression sets the compression of the blobs uploaded with the bundle, e.g. cafs.CompressionZstd.
+// Blobs are downloaded whatever their compression.
+func Compression(scheme cafs.CompressionScheme) BundleOption {
+	return func(b *Bundle) {
+		b.compression = scheme
+	}
+}
+
+// UploadJournal sets a local file to checkpoint the progress of uploads, so an interrupted upload may be resumed.
+// The journal is removed once the upload completes.
+func UploadJournal(path string) BundleOption {
+	return func(b *Bundle) {
+		b.uploadJournal = path
+	}
+}
+
+// ResumeUpload resumes the upload checkpointed in the upload journal, if any: files unchanged since they were
+// packed are not read again, and the upload continues with the same bundle ID.
+func ResumeUpload(resume bool) BundleOption {
+	return func(b *Bundle) {
+		b.resumeUpload = resume
+	}
+}
+
+// ResumeDownload resumes an interrupted download to the consumable store: files already downloaded are
+// fingerprinted, and only missing or mismatched files are downloaded.
+func ResumeDownload(resume bool) BundleOption {
+	return func(b *Bundle) {
+		b.resumeDownload = resume
+	}
+}
+
+// PrefetchLeaves sets how many leaves of a file are fetched ahead of sequential reads of a streamed bundle
+func PrefetchLeaves(leaves int) BundleOption {
+	return func(b *Bundle) {
+		b.prefetchLeaves = leaves
+	}
+}
+
+// PrefetchMemory sets the approximate memory used to cache the leaves read from a streamed bundle,
+// including prefetched ones
+func PrefetchMemory(bytes int64) BundleOption {
+	return func(b *Bundle) {
+		b.prefetchMemory = bytes
+	}
+}
+
 func defaultBundle() Bundle {
 	return Bundle{
 		RepoID:                      "",
@@ -203,6 +265,7 @@ func defaultBundle() Bundle {
 		concurrentFileUploads:       20,
 		concurrentFileDownloads:     10,
 		concurrentFilelistDownloads: 10,
+		prefetchLeaves:              1,
 	}
 }
 
@@ -215,18 +278,29 @@ func NewBundle(bd *model.BundleDescriptor, bundleOps ...BundleOption) *Bundle {
 	for _, bApply := range bundleOps {
 		bApply(&b)
 	}
-	if b.Streamed {
-		ls := b.BundleDescriptor.LeafSize
-		fs, _ := cafs.New(
-			cafs.LeafSize(ls),
-			cafs.LeafTruncation(b.BundleDescriptor.Version < 1),
-			cafs.Backend(b.BlobStore()),
-		)
-		b.cafs = fs
-	}
 	return &b
 }
 
+// initStreaming prepares reading the files of a streamed bundle from its blobs, once its descriptor is known
+func (b *Bundle) initStreaming() error {
+	opts := []cafs.Option{
+		cafs.LeafSize(b.BundleDescriptor.LeafSize),
+		cafs.LeafTruncation(b.BundleDescriptor.Version < 1),
+		cafs.Backend(b.BlobStore()),
+		cafs.PrefetchLeaves(b.prefetchLeaves),
+		cafs.PrefetchMemory(b.prefetchMemory),
+	}
+	if b.leafCache != nil {
+		opts = append(opts, cafs.SharedLeafCache(b.leafCache))
+	}
+	fs, err := cafs.New(opts...)
+	if err != nil {
+		return err
+	}
+	b.cafs = fs
+	return nil
+}
+
 // Publish an bundle to a consumable store
 func Publish(ctx context.Context, bundle *Bundle) error {
 	return implPublish(ctx, bundle, defaultBundleEntriesPerFile, func(s string) (bool, error) { return true, nil })
@@ -239,6 +313,11 @@ func PublishSelectBundleEntries(ctx context.Context, bundle *Bundle, selectionPr
 // implementation of Publish() with some additional parameters for test
 func implPublish(ctx context.Context, bundle *Bundle, bundleEntriesPerFile uint,
 	selectionPredicate func(string) (bool, error)) error {
+	if bundle.resumeDownload {
+		if err := removePublishedMetadata(ctx, bundle); err != nil {
+			return fmt.Errorf("failed to publish, err:%s", err)
+		}
+	}
 	err := implPublishMetadata(ctx, bundle, true, bundleEntriesPerFile)
 	if err != nil {
 		return fmt.Errorf("failed to publish, err:%s", err)
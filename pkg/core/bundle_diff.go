@@ -49,7 +49,7 @@ func diffBundles(bundleExisting *Bundle, bundleAdditional *Bundle) (BundleDiff,
 	for nameWithPath, bundleEntryExisting := range bundleEntriesExisting {
 		bundleEntryAdditional, ok := bundleEntriesAdditional[nameWithPath]
 		if ok {
-			if bundleEntryAdditional.Hash != bundleEntryExisting.Hash {
+			if !sameBundleEntry(bundleEntryExisting, bundleEntryAdditional) {
 				diffEntries = append(diffEntries, DiffEntry{
 					Type:       DiffEntryTypeDif,
 					Name:       nameWithPath,
@@ -79,3 +79,16 @@ func diffBundles(bundleExisting *Bundle, bundleAdditional *Bundle) (BundleDiff,
 		Entries: diffEntries,
 	}, nil
 }
+
+// sameBundleEntry compares the content, mode and link target of two entries.
+//
+// Entries without a recorded file mode, from bundles before version 2, are compared by content only.
+func sameBundleEntry(existing, additional model.BundleEntry) bool {
+	if existing.Hash != additional.Hash || existing.Target != additional.Target {
+		return false
+	}
+	if !existing.HasFileMode() || !additional.HasFileMode() {
+		return true
+	}
+	return existing.FileMode == additional.FileMode
+}
@@ -169,7 +169,14 @@ func listBundlesChan(repo string, stores context2.Stores, opts ...ListOption) (c
 
 	batchChan := make(chan bundlesEvent, 1) // buffered to 1 to avoid blocking on early errors
 
-	if err := RepoExists(repo, stores); err != nil {
+	if err := repoExists(repo, stores, settings.includeDeleted); err != nil {
+		batchChan <- bundlesEvent{err: err}
+		close(batchChan)
+		return batchChan, &wg
+	}
+
+	deleted, err := listDeleted(stores, model.GetArchivePathPrefixToBundleTombstones(repo), settings)
+	if err != nil {
 		batchChan <- bundlesEvent{err: err}
 		close(batchChan)
 		return batchChan, &wg
@@ -196,7 +203,7 @@ func listBundlesChan(repo string, stores context2.Stores, opts ...ListOption) (c
 
 	// start bundle metadata retrieval
 	wg.Add(1)
-	go fetchBundles(repo, getMetaStore(stores), settings, keysChan, batchChan, doneWithKeysChan, doneWithBundlesChan, &wg)
+	go fetchBundles(repo, getMetaStore(stores), settings, deleted, keysChan, batchChan, doneWithKeysChan, doneWithBundlesChan, &wg)
 
 	// let the gc clean up internal signaling channels left open after wg goroutines are done.
 
@@ -205,7 +212,7 @@ func listBundlesChan(repo string, stores context2.Stores, opts ...ListOption) (c
 }
 
 // fetchBundles waits on a channel of key batches and outputs batches of descriptors corresponding to these keys
-func fetchBundles(repo string, store storage.Store, settings Settings,
+func fetchBundles(repo string, store storage.Store, settings Settings, deleted map[string]struct{},
 	keysChan <-chan keyBatchEvent, batchChan chan<- bundlesEvent,
 	doneWithKeysChan chan<- struct{}, doneChan <-chan struct{}, wg *sync.WaitGroup) {
 	defer func() {
@@ -233,7 +240,7 @@ func fetchBundles(repo string, store storage.Store, settings Settings,
 				return
 			}
 			// send out a single batch of (ordered) bundle descriptors
-			batchChan <- bundlesEvent{bundles: batch}
+			batchChan <- bundlesEvent{bundles: skipDeletedBundles(batch, deleted)}
 		}
 	}
 }
@@ -348,14 +355,19 @@ func GetLatestBundle(repo string, stores context2.Stores) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	if len(ks) == 0 {
-		return "", fmt.Errorf("no bundles uploaded to repo: %s", repo)
-	}
-
-	apc, err := model.GetArchivePathComponents(ks[len(ks)-1])
+	deleted, err := listDeleted(stores, model.GetArchivePathPrefixToBundleTombstones(repo), defaultSettings())
 	if err != nil {
 		return "", err
 	}
 
-	return apc.BundleID, nil
+	for i := len(ks) - 1; i >= 0; i-- {
+		apc, err := model.GetArchivePathComponents(ks[i])
+		if err != nil {
+			return "", err
+		}
+		if _, isDeleted := deleted[apc.BundleID]; !isDeleted {
+			return apc.BundleID, nil
+		}
+	}
+	return "", fmt.Errorf("no bundles uploaded to repo: %s", repo)
 }
@@ -207,7 +207,7 @@ func mockedStore(testcase string) storage.Store {
 	// builds mocked up test scenarios
 	switch testcase {
 	case happyPath:
-		return &mockstorage.StoreMock{
+		return withoutTombstones(&mockstorage.StoreMock{
 			HasFunc: func(_ context.Context, _ string) (bool, error) {
 				return true, nil
 			},
@@ -222,9 +222,9 @@ func mockedStore(testcase string) storage.Store {
 				id := parts[3]
 				return ioutil.NopCloser(strings.NewReader(buildYaml(id))), nil
 			},
-		}
+		})
 	case happyWithBatches:
-		return &mockstorage.StoreMock{
+		return withoutTombstones(&mockstorage.StoreMock{
 			HasFunc: func(_ context.Context, _ string) (bool, error) {
 				return true, nil
 			},
@@ -259,24 +259,24 @@ func mockedStore(testcase string) storage.Store {
 				id := parts[3]
 				return ioutil.NopCloser(strings.NewReader(buildYaml(id))), nil
 			},
-		}
+		})
 	case "no repo":
-		return &mockstorage.StoreMock{
+		return withoutTombstones(&mockstorage.StoreMock{
 			HasFunc: func(_ context.Context, _ string) (bool, error) {
 				return false, nil
 			},
-		}
+		})
 	case "no key":
-		return &mockstorage.StoreMock{
+		return withoutTombstones(&mockstorage.StoreMock{
 			HasFunc: func(_ context.Context, _ string) (bool, error) {
 				return true, nil
 			},
 			KeysPrefixFunc: func(_ context.Context, _ string, prefix string, delimiter string, count int) ([]string, string, error) {
 				return nil, "", errors.New("storage error")
 			},
-		}
+		})
 	case "invalid file name":
-		return &mockstorage.StoreMock{
+		return withoutTombstones(&mockstorage.StoreMock{
 			HasFunc: func(_ context.Context, _ string) (bool, error) {
 				return true, nil
 			},
@@ -288,9 +288,9 @@ func mockedStore(testcase string) storage.Store {
 				id := parts[3]
 				return ioutil.NopCloser(strings.NewReader(buildYaml(id))), nil
 			},
-		}
+		})
 	case "no archive path":
-		return &mockstorage.StoreMock{
+		return withoutTombstones(&mockstorage.StoreMock{
 			HasFunc: func(_ context.Context, _ string) (bool, error) {
 				return true, nil
 			},
@@ -300,9 +300,9 @@ func mockedStore(testcase string) storage.Store {
 			GetFunc: func(_ context.Context, pth string) (io.ReadCloser, error) {
 				return nil, errors.New("get store error")
 			},
-		}
+		})
 	case "invalid yaml":
-		return &mockstorage.StoreMock{
+		return withoutTombstones(&mockstorage.StoreMock{
 			HasFunc: func(_ context.Context, _ string) (bool, error) {
 				return true, nil
 			},
@@ -321,9 +321,9 @@ leafSize: 16
 message: 'this is a message'
 version: 4`, id))), nil
 			},
-		}
+		})
 	case "inconsistent bundle ID":
-		return &mockstorage.StoreMock{
+		return withoutTombstones(&mockstorage.StoreMock{
 			HasFunc: func(_ context.Context, _ string) (bool, error) {
 				return true, nil
 			},
@@ -336,9 +336,9 @@ version: 4`, id))), nil
 			GetFunc: func(_ context.Context, pth string) (io.ReadCloser, error) {
 				return ioutil.NopCloser(strings.NewReader(buildYaml("wrong"))), nil
 			},
-		}
+		})
 	case "io error":
-		return &mockstorage.StoreMock{
+		return withoutTombstones(&mockstorage.StoreMock{
 			HasFunc: func(_ context.Context, _ string) (bool, error) {
 				return true, nil
 			},
@@ -348,9 +348,9 @@ version: 4`, id))), nil
 			GetFunc: func(_ context.Context, pth string) (io.ReadCloser, error) {
 				return testReadCloserWithErr{}, nil
 			},
-		}
+		})
 	case "skipped bundle":
-		return &mockstorage.StoreMock{
+		return withoutTombstones(&mockstorage.StoreMock{
 			HasFunc: func(_ context.Context, _ string) (bool, error) {
 				return true, nil
 			},
@@ -368,9 +368,9 @@ version: 4`, id))), nil
 				}
 				return ioutil.NopCloser(strings.NewReader(buildYaml(id))), nil
 			},
-		}
+		})
 	case batchErrorTestcase:
-		return &mockstorage.StoreMock{
+		return withoutTombstones(&mockstorage.StoreMock{
 			HasFunc: func(_ context.Context, _ string) (bool, error) {
 				return true, nil
 			},
@@ -409,9 +409,9 @@ version: 4`, id))), nil
 				id := parts[3]
 				return ioutil.NopCloser(strings.NewReader(buildYaml(id))), nil
 			},
-		}
+		})
 	case batchErrorRepoTestcase:
-		return &mockstorage.StoreMock{
+		return withoutTombstones(&mockstorage.StoreMock{
 			HasFunc: func(_ context.Context, _ string) (bool, error) {
 				return true, nil
 			},
@@ -457,7 +457,7 @@ version: 4`, id))), nil
 
 				return ioutil.NopCloser(strings.NewReader(buildYaml(id))), nil
 			},
-		}
+		})
 	}
 	return nil
 }
@@ -0,0 +1,272 @@
+/*
+ * Copyright © 2019 One Concern
+ *
+ */
+
+package core
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/core/status"
+	"github.com/oneconcern/datamon/pkg/errors"
+	"github.com/oneconcern/datamon/pkg/model"
+)
+
+// BundleHistory returns some bundles and all their ancestors, following the parents of bundles.
+//
+// Bundles are sorted like "git log --topo-order": a bundle always comes before its parents, even when the clocks
+// of the clients which uploaded them disagree. Otherwise, the most recent bundle is picked first among those whose
+// children are all listed. Parents which are no longer available, e.g. purged bundles, are skipped.
+//
+// At most max bundles are returned, or all of them if max is not positive. All the ancestors are walked to sort the
+// bundles, fetching the parents of a generation of bundles concurrently.
+func BundleHistory(ctx context.Context, stores context2.Stores, repo string, max int,
+	bundleIDs ...string) (model.BundleDescriptors, error) {
+	if err := RepoExists(repo, stores); err != nil {
+		return nil, err
+	}
+	graph := newBundleGraph(ctx, stores, repo)
+	if err := graph.load(bundleIDs); err != nil {
+		return nil, err
+	}
+	for _, id := range bundleIDs {
+		if _, err := graph.get(id); err != nil {
+			return nil, err
+		}
+	}
+	walked, err := graph.ancestors(bundleIDs...)
+	if err != nil {
+		return nil, err
+	}
+
+	// a bundle is ready once all its children in the walked bundles are listed
+	children := make(map[string]int, len(walked))
+	for id := range walked {
+		for _, parent := range graph.parents(id, walked) {
+			children[parent]++
+		}
+	}
+	ready := make(bundlesByTime, 0, len(bundleIDs))
+	for id := range walked {
+		if children[id] == 0 {
+			ready = append(ready, graph.bundles[id])
+		}
+	}
+	heap.Init(&ready)
+
+	history := make(model.BundleDescriptors, 0, len(walked))
+	for ready.Len() > 0 && (max <= 0 || len(history) < max) {
+		bd := heap.Pop(&ready).(model.BundleDescriptor)
+		history = append(history, bd)
+		for _, parent := range graph.parents(bd.ID, walked) {
+			children[parent]--
+			if children[parent] == 0 {
+				heap.Push(&ready, graph.bundles[parent])
+			}
+		}
+	}
+	return history, nil
+}
+
+// CommonAncestor returns the best common ancestor of two bundles, e.g. to merge them.
+//
+// Among the bundles which are ancestors of both, the best ones are not ancestors of any other one. When there are
+// several, e.g. after criss-cross merges, the most recent one is returned. A bundle is its own ancestor.
+//
+// It fails with status.ErrNotFound when the bundles have no common ancestor.
+func CommonAncestor(ctx context.Context, stores context2.Stores, repo, bundleID, otherBundleID string) (model.BundleDescriptor, error) {
+	if err := RepoExists(repo, stores); err != nil {
+		return model.BundleDescriptor{}, err
+	}
+	graph := newBundleGraph(ctx, stores, repo)
+	for _, id := range []string{bundleID, otherBundleID} {
+		if _, err := graph.get(id); err != nil {
+			return model.BundleDescriptor{}, err
+		}
+	}
+	ancestors, err := graph.ancestors(bundleID)
+	if err != nil {
+		return model.BundleDescriptor{}, err
+	}
+	otherAncestors, err := graph.ancestors(otherBundleID)
+	if err != nil {
+		return model.BundleDescriptor{}, err
+	}
+	common := make([]string, 0)
+	parents := make([]string, 0)
+	for id := range ancestors {
+		if otherAncestors[id] {
+			common = append(common, id)
+			parents = append(parents, graph.bundles[id].Parents...)
+		}
+	}
+	if len(common) == 0 {
+		return model.BundleDescriptor{}, fmt.Errorf("%w: bundles %s and %s have no common ancestor",
+			status.ErrNotFound, bundleID, otherBundleID)
+	}
+
+	// common ancestors of other common ancestors are not the best ones
+	redundant, err := graph.ancestors(parents...)
+	if err != nil {
+		return model.BundleDescriptor{}, err
+	}
+	var best bundlesByTime
+	for _, id := range common {
+		if !redundant[id] {
+			best = append(best, graph.bundles[id])
+		}
+	}
+	heap.Init(&best)
+	return heap.Pop(&best).(model.BundleDescriptor), nil
+}
+
+// bundleGraph loads bundle descriptors lazily, to follow their parents
+type bundleGraph struct {
+	ctx     context.Context
+	stores  context2.Stores
+	repo    string
+	bundles map[string]model.BundleDescriptor
+	missing map[string]bool
+}
+
+func newBundleGraph(ctx context.Context, stores context2.Stores, repo string) *bundleGraph {
+	return &bundleGraph{
+		ctx:     ctx,
+		stores:  stores,
+		repo:    repo,
+		bundles: make(map[string]model.BundleDescriptor),
+		missing: make(map[string]bool),
+	}
+}
+
+// get returns the descriptor of a bundle, or status.ErrNotFound
+func (g *bundleGraph) get(id string) (model.BundleDescriptor, error) {
+	if err := g.load([]string{id}); err != nil {
+		return model.BundleDescriptor{}, err
+	}
+	if g.missing[id] {
+		return model.BundleDescriptor{}, fmt.Errorf("%w: no bundle %s in repo %s", status.ErrNotFound, id, g.repo)
+	}
+	return g.bundles[id], nil
+}
+
+// load fetches the descriptors of some bundles which are not known yet, concurrently
+func (g *bundleGraph) load(ids []string) error {
+	unknown := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := g.bundles[id]; ok || g.missing[id] {
+			continue
+		}
+		g.missing[id] = true // until fetched, so that duplicate ids are fetched once
+		unknown = append(unknown, id)
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	type fetched struct {
+		bd  model.BundleDescriptor
+		err error
+	}
+	results := make([]fetched, len(unknown))
+	concurrencyControl := make(chan struct{}, defaultListConcurrency)
+	var wg sync.WaitGroup
+	for i, id := range unknown {
+		concurrencyControl <- struct{}{}
+		wg.Add(1)
+		go func(i int, id string) {
+			defer func() {
+				<-concurrencyControl
+				wg.Done()
+			}()
+			bundle := NewBundle(NewBDescriptor(), Repo(g.repo), BundleID(id), ContextStores(g.stores))
+			results[i].err = unpackBundleDescriptor(g.ctx, bundle, false)
+			results[i].bd = bundle.BundleDescriptor
+		}(i, id)
+	}
+	wg.Wait()
+
+	var err error
+	for i, id := range unknown {
+		switch {
+		case results[i].err == nil:
+			delete(g.missing, id)
+			g.bundles[id] = results[i].bd
+		case errors.Is(results[i].err, status.ErrNotFound):
+		default:
+			// fetched again next time
+			delete(g.missing, id)
+			if err == nil {
+				err = results[i].err
+			}
+		}
+	}
+	return err
+}
+
+// ancestors returns the ids of some bundles and of all their ancestors which are available.
+//
+// The ancestors are walked one generation at a time, fetching the parents of a generation concurrently.
+func (g *bundleGraph) ancestors(ids ...string) (map[string]bool, error) {
+	ancestors := make(map[string]bool)
+	next := append([]string{}, ids...)
+	for len(next) > 0 {
+		if err := g.load(next); err != nil {
+			return nil, err
+		}
+		current := next
+		next = nil
+		for _, id := range current {
+			if ancestors[id] || g.missing[id] {
+				continue
+			}
+			ancestors[id] = true
+			next = append(next, g.bundles[id].Parents...)
+		}
+	}
+	return ancestors, nil
+}
+
+// parents returns the distinct parents of a loaded bundle which are among some bundles
+func (g *bundleGraph) parents(id string, among map[string]bool) []string {
+	parents := make([]string, 0, len(g.bundles[id].Parents))
+	seen := make(map[string]bool, len(g.bundles[id].Parents))
+	for _, parent := range g.bundles[id].Parents {
+		if among[parent] && !seen[parent] {
+			seen[parent] = true
+			parents = append(parents, parent)
+		}
+	}
+	return parents
+}
+
+// bundlesByTime is a heap of bundle descriptors, the most recent first
+type bundlesByTime model.BundleDescriptors
+
+func (b bundlesByTime) Len() int {
+	return len(b)
+}
+func (b bundlesByTime) Less(i, j int) bool {
+	if b[i].Timestamp.Equal(b[j].Timestamp) {
+		return b[i].ID > b[j].ID
+	}
+	return b[i].Timestamp.After(b[j].Timestamp)
+}
+func (b bundlesByTime) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+func (b *bundlesByTime) Push(x interface{}) {
+	*b = append(*b, x.(model.BundleDescriptor))
+}
+func (b *bundlesByTime) Pop() interface{} {
+	old := *b
+	n := len(old)
+	x := old[n-1]
+	*b = old[:n-1]
+	return x
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright © 2019 One Concern
+ *
+ */
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/core/status"
+	"github.com/oneconcern/datamon/pkg/errors"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/localfs"
+)
+
+// uploadTestChild uploads a bundle with some parents to the test repo
+func uploadTestChild(t *testing.T, stores context2.Stores, content string, parents ...*Bundle) *Bundle {
+	ctx := context.Background()
+	source := localfs.New(afero.NewMemMapFs())
+	require.NoError(t, source.Put(ctx, "file", bytes.NewBufferString(content), storage.NoOverWrite))
+	ids := make([]string, 0, len(parents))
+	for _, parent := range parents {
+		ids = append(ids, parent.BundleID)
+	}
+	bundle := NewBundle(NewBDescriptor(Message(content), Parents(ids)),
+		Repo(repo), ConsumableStore(source), ContextStores(stores))
+	require.NoError(t, Upload(ctx, bundle))
+	return bundle
+}
+
+func TestBundleHistory(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, nil, memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+
+	root := uploadTestChild(t, stores, "root")
+	a := uploadTestChild(t, stores, "a", root)
+	b1 := uploadTestChild(t, stores, "b1", a)
+	c1 := uploadTestChild(t, stores, "c1", a)
+	b2 := uploadTestChild(t, stores, "b2", b1)
+	merge := uploadTestChild(t, stores, "merge", b2, c1)
+	unrelated := uploadTestChild(t, stores, "unrelated")
+
+	messages := func(ids ...string) []string {
+		history, err := BundleHistory(ctx, stores, repo, 0, ids...)
+		require.NoError(t, err)
+		res := make([]string, 0, len(history))
+		for _, bd := range history {
+			res = append(res, bd.Message)
+		}
+		return res
+	}
+	require.Equal(t, []string{"merge", "b2", "c1", "b1", "a", "root"}, messages(merge.BundleID))
+	require.Equal(t, []string{"c1", "a", "root"}, messages(c1.BundleID))
+	require.Equal(t, []string{"unrelated", "merge", "b2", "c1", "b1", "a", "root"},
+		messages(merge.BundleID, unrelated.BundleID))
+	history, err := BundleHistory(ctx, stores, repo, 3, merge.BundleID)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	require.Equal(t, "c1", history[2].Message)
+
+	// each ancestor is read once, to sort the bundles
+	meta := &readsStore{Store: stores.Metadata(), reads: map[string]int{}}
+	counted := context2.NewStores(nil, nil, stores.Blob(), meta, stores.VMetadata())
+	history, err = BundleHistory(ctx, counted, repo, 2, merge.BundleID)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	for _, bundle := range []*Bundle{root, a, b1, b2, c1, merge} {
+		require.Equal(t, 1, meta.reads[model.GetArchivePathToBundle(repo, bundle.BundleID)], bundle.BundleDescriptor.Message)
+	}
+	require.Zero(t, meta.reads[model.GetArchivePathToBundle(repo, unrelated.BundleID)])
+	_, err = BundleHistory(ctx, stores, repo, 0, "nosuchbundle")
+	require.True(t, errors.Is(err, status.ErrNotFound))
+
+	ancestor := func(x, y *Bundle) string {
+		bd, err := CommonAncestor(ctx, stores, repo, x.BundleID, y.BundleID)
+		require.NoError(t, err)
+		return bd.Message
+	}
+	require.Equal(t, "a", ancestor(b2, c1))
+	require.Equal(t, "a", ancestor(c1, b2))
+	require.Equal(t, "b1", ancestor(merge, b1))
+	require.Equal(t, "c1", ancestor(c1, merge))
+	require.Equal(t, "root", ancestor(root, root))
+
+	// criss-cross merges: both merged bundles are best common ancestors, the most recent one wins
+	x := uploadTestChild(t, stores, "x", b2, c1)
+	y := uploadTestChild(t, stores, "y", c1, b2)
+	require.Equal(t, "b2", ancestor(x, y))
+
+	_, err = CommonAncestor(ctx, stores, repo, unrelated.BundleID, merge.BundleID)
+	require.True(t, errors.Is(err, status.ErrNotFound))
+}
+
+func TestBundleHistorySkewedClocks(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, nil, memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+
+	now := time.Now()
+	uploadAt := func(offset time.Duration, content string, parents ...*Bundle) *Bundle {
+		source := localfs.New(afero.NewMemMapFs())
+		require.NoError(t, source.Put(ctx, "file", bytes.NewBufferString(content), storage.NoOverWrite))
+		ids := make([]string, 0, len(parents))
+		for _, parent := range parents {
+			ids = append(ids, parent.BundleID)
+		}
+		bd := NewBDescriptor(Message(content), Parents(ids))
+		bd.Timestamp = now.Add(offset)
+		bundle := NewBundle(bd, Repo(repo), ConsumableStore(source), ContextStores(stores))
+		require.NoError(t, Upload(ctx, bundle))
+		return bundle
+	}
+
+	// the client which uploaded f1 lags behind: f1 looks older than its parent
+	root := uploadAt(0, "root")
+	f1 := uploadAt(-time.Hour, "f1", root)
+	feature := uploadAt(3*time.Minute, "feature", f1)
+	main := uploadAt(2*time.Minute, "main", root)
+	merge := uploadAt(4*time.Minute, "merge", main, feature)
+
+	history, err := BundleHistory(ctx, stores, repo, 0, merge.BundleID)
+	require.NoError(t, err)
+	messages := make([]string, 0, len(history))
+	for _, bd := range history {
+		messages = append(messages, bd.Message)
+	}
+	require.Equal(t, []string{"merge", "feature", "main", "f1", "root"}, messages)
+}
@@ -0,0 +1,207 @@
+/*
+ * Copyright © 2019 One Concern
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/oneconcern/datamon/pkg/core/status"
+	"github.com/oneconcern/datamon/pkg/model"
+)
+
+// MergeStrategy tells how a merge resolves the paths changed differently on both sides
+type MergeStrategy string
+
+const (
+	// MergeStrategyFail doesn't merge bundles with conflicts
+	MergeStrategyFail MergeStrategy = "fail"
+	// MergeStrategyOurs keeps our side of conflicts
+	MergeStrategyOurs MergeStrategy = "ours"
+	// MergeStrategyTheirs keeps their side of conflicts
+	MergeStrategyTheirs MergeStrategy = "theirs"
+)
+
+// IsValid tells if the strategy is known
+func (s MergeStrategy) IsValid() bool {
+	switch s {
+	case MergeStrategyFail, MergeStrategyOurs, MergeStrategyTheirs:
+		return true
+	default:
+		return false
+	}
+}
+
+// MergeConflict is a path changed differently on both sides of a merge, with the change of each side since the base
+type MergeConflict struct {
+	Name   string
+	Ours   DiffEntry
+	Theirs DiffEntry
+}
+
+// Merge creates a bundle with the changes of two bundles since their base, i.e. a three-way merge.
+//
+// The merged bundle records ours and theirs as its parents. Its message and contributors are set by the caller.
+// No data is copied: the merged bundle refers to the blobs of the other ones, which must have the same leaf size
+// and chunking scheme.
+//
+// Paths changed differently on both sides are conflicts, resolved according to the strategy. With MergeStrategyFail,
+// no bundle is created when there are conflicts, and Merge fails with status.ErrConflict. Conflicts are returned
+// in any case, sorted by name.
+func Merge(ctx context.Context, base, ours, theirs, merged *Bundle, strategy MergeStrategy) ([]MergeConflict, error) {
+	if !strategy.IsValid() {
+		return nil, fmt.Errorf("unknown merge strategy %q", strategy)
+	}
+	for _, bundle := range []*Bundle{base, ours, theirs} {
+		if err := PopulateFiles(ctx, bundle); err != nil {
+			return nil, err
+		}
+	}
+	for _, bundle := range []*Bundle{base, theirs} {
+		if bundle.BundleDescriptor.LeafSize != ours.BundleDescriptor.LeafSize ||
+			bundle.BundleDescriptor.Chunking != ours.BundleDescriptor.Chunking ||
+			(bundle.BundleDescriptor.Version < 1) != (ours.BundleDescriptor.Version < 1) {
+			return nil, fmt.Errorf("cannot merge bundles %s and %s: their files are split into blobs differently",
+				bundle.BundleID, ours.BundleID)
+		}
+	}
+
+	oursDiff, err := diffBundles(base, ours)
+	if err != nil {
+		return nil, err
+	}
+	theirsDiff, err := diffBundles(base, theirs)
+	if err != nil {
+		return nil, err
+	}
+	theirChanges := make(map[string]DiffEntry, len(theirsDiff.Entries))
+	for _, change := range theirsDiff.Entries {
+		theirChanges[change.Name] = change
+	}
+
+	entries := make(map[string]model.BundleEntry, len(base.BundleEntries))
+	for _, entry := range base.BundleEntries {
+		entries[entry.NameWithPath] = entry
+	}
+	conflicts := make([]MergeConflict, 0)
+	for _, ourChange := range oursDiff.Entries {
+		theirChange, ok := theirChanges[ourChange.Name]
+		if !ok {
+			applyDiffEntry(entries, ourChange)
+			continue
+		}
+		delete(theirChanges, ourChange.Name)
+		if sameChange(ourChange, theirChange) {
+			applyDiffEntry(entries, ourChange)
+			continue
+		}
+		conflicts = append(conflicts, MergeConflict{Name: ourChange.Name, Ours: ourChange, Theirs: theirChange})
+		switch strategy {
+		case MergeStrategyOurs:
+			applyDiffEntry(entries, ourChange)
+		case MergeStrategyTheirs:
+			applyDiffEntry(entries, theirChange)
+		}
+	}
+	for _, theirChange := range theirChanges {
+		applyDiffEntry(entries, theirChange)
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Name < conflicts[j].Name })
+	if len(conflicts) > 0 && strategy == MergeStrategyFail {
+		return conflicts, fmt.Errorf("%w: %d paths changed differently in bundles %s and %s",
+			status.ErrConflict, len(conflicts), ours.BundleID, theirs.BundleID)
+	}
+
+	fileList, err := mergedFileList(entries)
+	if err != nil {
+		return conflicts, err
+	}
+	return conflicts, uploadMergedBundle(ctx, merged, ours, theirs, fileList)
+}
+
+// sameChange tells if both sides made the same change to a path
+func sameChange(ours, theirs DiffEntry) bool {
+	if ours.Type == DiffEntryTypeDel || theirs.Type == DiffEntryTypeDel {
+		return ours.Type == theirs.Type
+	}
+	return sameBundleEntry(ours.Additional, theirs.Additional)
+}
+
+func applyDiffEntry(entries map[string]model.BundleEntry, change DiffEntry) {
+	if change.Type == DiffEntryTypeDel {
+		delete(entries, change.Name)
+		return
+	}
+	entries[change.Name] = change.Additional
+}
+
+// mergedFileList sorts the entries of a merged bundle by name.
+//
+// Empty directories which got some content on the other side are no longer recorded, and a path
+// cannot be both a file and a directory.
+func mergedFileList(entries map[string]model.BundleEntry) ([]model.BundleEntry, error) {
+	fileList := make([]model.BundleEntry, 0, len(entries))
+	for _, entry := range entries {
+		fileList = append(fileList, entry)
+	}
+	sort.Slice(fileList, func(i, j int) bool { return fileList[i].NameWithPath < fileList[j].NameWithPath })
+
+	merged := fileList[:0]
+	for i, entry := range fileList {
+		// entries under a path come right after it, save for names sorted before "/"
+		var child string
+		for _, next := range fileList[i+1:] {
+			if strings.HasPrefix(next.NameWithPath, entry.NameWithPath+"/") {
+				child = next.NameWithPath
+				break
+			}
+			if !strings.HasPrefix(next.NameWithPath, entry.NameWithPath) {
+				break
+			}
+		}
+		switch {
+		case child == "":
+			merged = append(merged, entry)
+		case !entry.IsDir():
+			return nil, fmt.Errorf("%w: %s is a file on one side and a directory holding %s on the other side",
+				status.ErrConflict, entry.NameWithPath, child)
+		}
+	}
+	return merged, nil
+}
+
+func uploadMergedBundle(ctx context.Context, merged, ours, theirs *Bundle, fileList []model.BundleEntry) error {
+	merged.BundleDescriptor.Parents = []string{ours.BundleID, theirs.BundleID}
+	merged.BundleDescriptor.LeafSize = ours.BundleDescriptor.LeafSize
+	merged.BundleDescriptor.Chunking = ours.BundleDescriptor.Chunking
+	merged.BundleDescriptor.Version = ours.BundleDescriptor.Version
+	if theirs.BundleDescriptor.Version > merged.BundleDescriptor.Version {
+		merged.BundleDescriptor.Version = theirs.BundleDescriptor.Version
+	}
+	merged.BundleDescriptor.BundleEntriesFileCount = 0
+	if merged.BundleID == "" {
+		if err := merged.InitializeBundleID(); err != nil {
+			return err
+		}
+	}
+	for i := 0; i*defaultBundleEntriesPerFile < len(fileList); i++ {
+		firstIdx := i * defaultBundleEntriesPerFile
+		nextFirstIdx := (i + 1) * defaultBundleEntriesPerFile
+		if nextFirstIdx > len(fileList) {
+			nextFirstIdx = len(fileList)
+		}
+		if err := uploadBundleEntriesFileList(ctx, merged, fileList[firstIdx:nextFirstIdx]); err != nil {
+			return err
+		}
+	}
+	if err := appendWALEntry(ctx, merged.contextStores, merged.l,
+		model.NewBundleCommitPayload(merged.RepoID, merged.BundleDescriptor)); err != nil {
+		return err
+	}
+	return uploadBundleDescriptor(ctx, merged)
+}
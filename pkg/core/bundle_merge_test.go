@@ -0,0 +1,108 @@
+/*
+ * Copyright © 2019 One Concern
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/core/status"
+	"github.com/oneconcern/datamon/pkg/errors"
+)
+
+func TestMerge(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, nil, memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+
+	base := uploadTestBundle(t, stores, map[string]string{"kept": "kept", "changed": "base", "conflict": "base", "deleted": "deleted"})
+	ours := uploadTestBundle(t, stores, map[string]string{"kept": "kept", "changed": "changed", "conflict": "ours", "deleted": "deleted",
+		"added": "same"})
+	theirs := uploadTestBundle(t, stores, map[string]string{"kept": "kept", "changed": "base", "conflict": "theirs",
+		"added": "same", "dir/new": "new"})
+
+	bundle := func(id string) *Bundle {
+		return NewBundle(NewBDescriptor(), Repo(repo), BundleID(id), ContextStores(stores))
+	}
+	merge := func(strategy MergeStrategy) ([]MergeConflict, *Bundle, error) {
+		merged := NewBundle(NewBDescriptor(Message("merge")), Repo(repo), ContextStores(stores))
+		conflicts, err := Merge(ctx, bundle(base.BundleID), bundle(ours.BundleID), bundle(theirs.BundleID), merged, strategy)
+		return conflicts, merged, err
+	}
+	contents := func(merged *Bundle) map[string]string {
+		hashes := make(map[string]string)
+		for _, b := range []*Bundle{ours, theirs} {
+			b = bundle(b.BundleID)
+			require.NoError(t, PopulateFiles(ctx, b))
+			for _, entry := range b.BundleEntries {
+				hashes[entry.Hash] = b.BundleID + ":" + entry.NameWithPath
+			}
+		}
+		b := bundle(merged.BundleID)
+		require.NoError(t, PopulateFiles(ctx, b))
+		res := make(map[string]string)
+		for _, entry := range b.BundleEntries {
+			res[entry.NameWithPath] = hashes[entry.Hash]
+		}
+		return res
+	}
+
+	_, _, err := merge(MergeStrategy("nosuchstrategy"))
+	require.Error(t, err)
+
+	conflicts, merged, err := merge(MergeStrategyFail)
+	require.True(t, errors.Is(err, status.ErrConflict))
+	require.Len(t, conflicts, 1)
+	require.Equal(t, "conflict", conflicts[0].Name)
+	require.Equal(t, DiffEntryType(DiffEntryTypeDif), conflicts[0].Ours.Type)
+	require.Equal(t, DiffEntryType(DiffEntryTypeDif), conflicts[0].Theirs.Type)
+	require.Empty(t, merged.BundleID)
+
+	for _, strategy := range []MergeStrategy{MergeStrategyOurs, MergeStrategyTheirs} {
+		conflicts, merged, err = merge(strategy)
+		require.NoError(t, err)
+		require.Len(t, conflicts, 1)
+		require.Equal(t, []string{ours.BundleID, theirs.BundleID}, merged.BundleDescriptor.Parents)
+
+		winner := ours.BundleID
+		if strategy == MergeStrategyTheirs {
+			winner = theirs.BundleID
+		}
+		res := contents(merged)
+		require.Len(t, res, 5)
+		require.Contains(t, res, "kept")
+		require.Contains(t, res, "added")
+		require.Equal(t, ours.BundleID+":changed", res["changed"])
+		require.Equal(t, winner+":conflict", res["conflict"])
+		require.Equal(t, theirs.BundleID+":dir/new", res["dir/new"])
+		require.NotContains(t, res, "deleted")
+
+		history, err := BundleHistory(ctx, stores, repo, 0, merged.BundleID)
+		require.NoError(t, err)
+		require.Len(t, history, 3)
+	}
+}
+
+func TestMergeFileAndDirectory(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, nil, memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+
+	base := uploadTestBundle(t, stores, map[string]string{"kept": "kept"})
+	ours := uploadTestBundle(t, stores, map[string]string{"kept": "kept", "path": "file"})
+	theirs := uploadTestBundle(t, stores, map[string]string{"kept": "kept", "path/file": "file"})
+
+	bundle := func(id string) *Bundle {
+		return NewBundle(NewBDescriptor(), Repo(repo), BundleID(id), ContextStores(stores))
+	}
+	merged := NewBundle(NewBDescriptor(), Repo(repo), ContextStores(stores))
+	conflicts, err := Merge(ctx, bundle(base.BundleID), bundle(ours.BundleID), bundle(theirs.BundleID), merged, MergeStrategyOurs)
+	require.True(t, errors.Is(err, status.ErrConflict))
+	require.Empty(t, conflicts)
+}
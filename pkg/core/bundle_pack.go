@@ -33,14 +33,18 @@ type filePacked struct {
 	size      uint64
 	duplicate bool
 	idx       int
+	mode      os.FileMode
+	target    string             // symbolic links only
+	source    storage.Attributes // attributes of the source file, when journaling the upload
 }
 
 func filePacked2BundleEntry(packedFile filePacked) model.BundleEntry {
 	return model.BundleEntry{
 		Hash:         packedFile.hash,
 		NameWithPath: packedFile.name,
-		FileMode:     0, // #TODO: #35 file mode support
+		FileMode:     packedFile.mode,
 		Size:         packedFile.size,
+		Target:       packedFile.target,
 	}
 }
 
@@ -53,6 +57,26 @@ type uploadBundleChans struct {
 	concurrencyControl <-chan struct{}
 }
 
+// uploadResume describes the files already packed by an interrupted upload
+type uploadResume struct {
+	checkpoint *uploadCheckpoint
+	// files listed in the pages of bundle entries kept from the interrupted upload
+	kept map[string]bool
+}
+
+// sourceAttributes of a file, to check whether it changes between an interrupted upload and its resumption
+func (b *Bundle) sourceAttributes(ctx context.Context, file string) storage.Attributes {
+	if b.uploadJournal == "" {
+		return storage.Attributes{}
+	}
+	attrs, err := b.ConsumableStore.GetAttr(ctx, file)
+	if err != nil {
+		// the file is packed again on resume
+		return storage.Attributes{}
+	}
+	return attrs
+}
+
 func uploadBundleEntriesFileList(ctx context.Context, bundle *Bundle, fileList []model.BundleEntry) error {
 	buffer, err := yaml.Marshal(model.BundleEntries{
 		BundleEntries: fileList,
@@ -107,6 +131,8 @@ func uploadBundleFile(
 	file string,
 	cafsArchive cafs.Fs,
 	fileReader io.Reader,
+	fileMode os.FileMode,
+	source storage.Attributes,
 	chans uploadBundleChans,
 	fileIdx int,
 	logger *zap.Logger,
@@ -134,20 +160,83 @@ func uploadBundleFile(
 		size:      uint64(putRes.Written),
 		duplicate: putRes.Found,
 		idx:       fileIdx,
+		mode:      fileMode,
+		source:    source,
 	}
 	logger.Debug("sent file packed result",
 		zap.Int("idx", fileIdx),
 	)
 }
 
+// packFileMode describes a file with its mode. Symbolic links and directories are packed as is,
+// without content.
+func packFileMode(ctx context.Context, store storage.StoreFS, file string, fileIdx int) (filePacked, error) {
+	fi, err := store.Lstat(ctx, file)
+	if err != nil {
+		return filePacked{}, err
+	}
+	packed := filePacked{
+		name: file,
+		mode: fi.Mode() & (os.ModeType | os.ModePerm),
+		idx:  fileIdx,
+	}
+	if packed.mode&os.ModeSymlink != 0 {
+		packed.target, err = store.Readlink(ctx, file)
+		if err != nil {
+			return filePacked{}, err
+		}
+		packed.size = uint64(len(packed.target))
+	}
+	return packed, nil
+}
+
+// uploadBundleEmptyDirs packs the empty directories of the consumable store, which carry no content.
+func uploadBundleEmptyDirs(ctx context.Context, bundle *Bundle, store storage.StoreFS, fileIdx int, resume uploadResume,
+	chans uploadBundleChans) {
+	dirs, err := store.EmptyDirs(ctx)
+	if err != nil {
+		chans.error <- errorHit{
+			error: err,
+		}
+		return
+	}
+	for _, dir := range dirs {
+		if model.IsGeneratedFile(dir) || resume.kept[dir] {
+			continue
+		}
+		packed, err := packFileMode(ctx, store, dir, fileIdx)
+		if err != nil {
+			if bundle.SkipOnError {
+				bundle.l.Info("skipping directory",
+					zap.String("dir", dir),
+					zap.String("repo", bundle.RepoID),
+					zap.String("bundleID", bundle.BundleID),
+					zap.Error(err),
+				)
+				continue
+			}
+			chans.error <- errorHit{
+				error: err,
+				file:  dir,
+			}
+			return
+		}
+		packed.source = bundle.sourceAttributes(ctx, dir)
+		chans.filePacked <- packed
+		fileIdx++
+	}
+}
+
 func uploadBundleFiles(
 	ctx context.Context,
 	bundle *Bundle,
 	files []string,
 	cafsArchive cafs.Fs,
+	resume uploadResume,
 	chans uploadBundleChans) {
 	concurrencyControl := make(chan struct{}, bundle.concurrentFileUploads)
 	chans.concurrencyControl = concurrencyControl
+	fsStore, withFileModes := bundle.ConsumableStore.(storage.StoreFS)
 	for fileIdx, file := range files {
 		// Check to see if the file is to be skipped.
 		if bundle.skipFile(file) {
@@ -158,6 +247,51 @@ func uploadBundleFiles(
 			)
 			continue
 		}
+		if resume.kept[file] {
+			// already listed in the bundle entries uploaded before the upload was interrupted
+			continue
+		}
+		source := bundle.sourceAttributes(ctx, file)
+		var fileMode os.FileMode
+		if withFileModes {
+			packed, err := packFileMode(ctx, fsStore, file, fileIdx)
+			if err != nil {
+				if bundle.SkipOnError {
+					bundle.l.Info("skipping file",
+						zap.String("file", file),
+						zap.String("repo", bundle.RepoID),
+						zap.String("bundleID", bundle.BundleID),
+						zap.Error(err),
+					)
+					continue
+				}
+				chans.error <- errorHit{
+					error: err,
+					file:  file,
+				}
+				break
+			}
+			if packed.mode&os.ModeSymlink != 0 {
+				packed.source = source
+				chans.filePacked <- packed
+				continue
+			}
+			fileMode = packed.mode
+		}
+		if journaled, ok := resume.checkpoint.unchanged(ctx, bundle.ConsumableStore, bundle.BlobStore(), file); ok &&
+			journaled.entry.FileMode == fileMode && journaled.entry.Hash != "" {
+			// already packed before the upload was interrupted
+			chans.filePacked <- filePacked{
+				hash:      journaled.entry.Hash,
+				name:      file,
+				size:      journaled.entry.Size,
+				duplicate: true,
+				idx:       fileIdx,
+				mode:      fileMode,
+				source:    source,
+			}
+			continue
+		}
 		fileReader, err := bundle.ConsumableStore.Get(ctx, file)
 		if err != nil {
 			if bundle.SkipOnError {
@@ -179,9 +313,12 @@ func uploadBundleFiles(
 		bundle.l.Debug("kicking off upload file",
 			zap.Int("idx", fileIdx),
 		)
-		go uploadBundleFile(ctx, file, cafsArchive, fileReader, chans,
+		go uploadBundleFile(ctx, file, cafsArchive, fileReader, fileMode, source, chans,
 			fileIdx, bundle.l)
 	}
+	if withFileModes {
+		uploadBundleEmptyDirs(ctx, bundle, fsStore, len(files), resume, chans)
+	}
 	bundle.l.Debug("awaiting last uploads to complete",
 		zap.Int("max possible remaining uploads", cap(concurrencyControl)),
 	)
@@ -209,6 +346,8 @@ func uploadBundle(ctx context.Context, bundle *Bundle, bundleEntriesPerFile uint
 	}
 	cafsArchive, err := cafs.New(
 		cafs.LeafSize(bundle.BundleDescriptor.LeafSize),
+		cafs.Chunking(cafs.ChunkingScheme(bundle.BundleDescriptor.Chunking)),
+		cafs.Compression(bundle.compression),
 		cafs.Backend(bundle.BlobStore()),
 		cafs.ConcurrentFlushes(bundle.concurrentFileUploads/fileUploadsPerFlush),
 	)
@@ -217,16 +356,23 @@ func uploadBundle(ctx context.Context, bundle *Bundle, bundleEntriesPerFile uint
 	}
 
 	// Upload the files and the bundle list
-	err = bundle.InitializeBundleID()
+	resume, done, err := startUpload(ctx, bundle)
+	if err != nil || done {
+		return err
+	}
+	journal, err := openUploadJournal(bundle, bundle.BundleDescriptor.BundleEntriesFileCount)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = journal.close()
+	}()
 
 	filePackedC := make(chan filePacked)
 	errorC := make(chan errorHit)
 	doneOkC := make(chan struct{})
 
-	go uploadBundleFiles(ctx, bundle, files, cafsArchive, uploadBundleChans{
+	go uploadBundleFiles(ctx, bundle, files, cafsArchive, resume, uploadBundleChans{
 		filePacked: filePackedC,
 		error:      errorC,
 		doneOk:     doneOkC,
@@ -261,6 +407,9 @@ func uploadBundle(ctx context.Context, bundle *Bundle, bundleEntriesPerFile uint
 				zap.Int("idx", f.idx),
 			)
 			fileList = append(fileList, filePacked2BundleEntry(f))
+			if err = journal.file(f, f.source); err != nil {
+				return err
+			}
 			// Write the bundle entry file if reached max or the last one
 			if len(fileList) == int(bundleEntriesPerFile) {
 				bundle.l.Debug("Uploading filelist (max entries reached)")
@@ -271,6 +420,9 @@ func uploadBundle(ctx context.Context, bundle *Bundle, bundleEntriesPerFile uint
 					)
 					return err
 				}
+				if err = journal.page(bundle.BundleDescriptor.BundleEntriesFileCount-1, fileList); err != nil {
+					return err
+				}
 				numFileListUploads++
 				fileList = fileList[:0]
 			}
@@ -297,12 +449,20 @@ func uploadBundle(ctx context.Context, bundle *Bundle, bundleEntriesPerFile uint
 			)
 			return err
 		}
+		if err = journal.page(bundle.BundleDescriptor.BundleEntriesFileCount-1, fileList); err != nil {
+			return err
+		}
 		numFileListUploads++
 	}
 	bundle.l.Info("uploaded filelists",
 		zap.Int("actual number uploads attempted", numFileListUploads),
 		zap.Int("approx expected number of uploads", numFilePackedRes/int(bundleEntriesPerFile)),
 	)
+	err = appendWALEntry(ctx, bundle.contextStores, bundle.l,
+		model.NewBundleCommitPayload(bundle.RepoID, bundle.BundleDescriptor))
+	if err != nil {
+		return err
+	}
 	err = uploadBundleDescriptor(ctx, bundle)
 	if err != nil {
 		return err
@@ -310,7 +470,7 @@ func uploadBundle(ctx context.Context, bundle *Bundle, bundleEntriesPerFile uint
 	bundle.l.Info("Uploaded bundle id",
 		zap.String("BundleID", bundle.BundleID),
 	)
-	return nil
+	return journal.remove()
 }
 
 func uploadBundleDescriptor(ctx context.Context, bundle *Bundle) error {
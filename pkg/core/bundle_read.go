@@ -2,6 +2,7 @@ package core
 
 import (
 	"context"
+	"io"
 
 	"go.uber.org/zap"
 
@@ -17,10 +18,13 @@ func errNotEOF(err error) bool {
 func (b *Bundle) ReadAt(file *fsEntry, destination []byte, offset int64) (int, error) {
 	if !b.Streamed {
 
-		reader, err := b.ConsumableStore.GetAt(context.Background(), file.fullPath)
+		reader, err := b.ConsumableStore.GetAt(context.Background(), file.name)
 		if err != nil {
 			return 0, fuse.EIO
 		}
+		if closer, ok := reader.(io.Closer); ok {
+			defer closer.Close()
+		}
 
 		n, err := reader.ReadAt(destination, offset)
 		if errNotEOF(err) {
@@ -4,17 +4,21 @@ import (
 	"bytes"
 	"context"
 	"math"
+	"math/rand"
 	"strconv"
+	"strings"
 
 	context2 "github.com/oneconcern/datamon/pkg/context"
 
 	"github.com/oneconcern/datamon/pkg/cafs"
 	"github.com/oneconcern/datamon/pkg/model"
 	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/encrypted"
 	"github.com/oneconcern/datamon/pkg/storage/localfs"
 	"github.com/segmentio/ksuid"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 
 	"gopkg.in/yaml.v2"
 
@@ -67,7 +71,7 @@ func TestBundle(t *testing.T) {
 			Name:  "test",
 			Email: "t@test.com",
 		},
-	}, dmc))
+	}, dmc, nil))
 	dmc.SetMetadata(reArchive)
 	require.NoError(t, CreateRepo(model.RepoDescriptor{
 		Name:        repo,
@@ -77,7 +81,7 @@ func TestBundle(t *testing.T) {
 			Name:  "test",
 			Email: "t@test.com",
 		},
-	}, dmc))
+	}, dmc, nil))
 
 	bd := NewBDescriptor()
 	dmc.SetMetadata(metaStore)
@@ -129,7 +133,7 @@ func paramedTestPublishMetadata(t *testing.T, publish bool) {
 			Name:  "test",
 			Email: "t@test.com",
 		},
-	}, dmc))
+	}, dmc, nil))
 
 	bd := NewBDescriptor()
 	dmc.SetMetadata(metaStore)
@@ -445,3 +449,289 @@ func TestBundle_StoreGet(t *testing.T) {
 	require.Equal(t, walStore, b.WALStore())
 	require.Equal(t, readLog, b.ReadLogStore())
 }
+
+func TestBundleFileModes(t *testing.T) {
+	ctx := context.Background()
+	sourceDir, err := ioutil.TempDir("", "bundle-modes-source")
+	require.NoError(t, err)
+	defer os.RemoveAll(sourceDir)
+	destDir, err := ioutil.TempDir("", "bundle-modes-dest")
+	require.NoError(t, err)
+	defer os.RemoveAll(destDir)
+
+	source := localfs.New(afero.NewBasePathFs(afero.NewOsFs(), sourceDir))
+	sourceFS := source.(storage.StoreFS)
+	require.NoError(t, source.Put(ctx, "bin/run.sh", bytes.NewBufferString("#!/bin/sh\necho run"), storage.NoOverWrite))
+	require.NoError(t, sourceFS.Chmod(ctx, "bin/run.sh", 0750))
+	require.NoError(t, source.Put(ctx, "data.txt", bytes.NewBufferString("data"), storage.NoOverWrite))
+	require.NoError(t, sourceFS.Chmod(ctx, "data.txt", 0640))
+	require.NoError(t, sourceFS.Symlink(ctx, "bin/run.sh", "run"))
+	require.NoError(t, sourceFS.Mkdir(ctx, "output/empty", 0700))
+
+	stores := context2.NewStores(nil, memStore(), memStore(), memStore(), memStore())
+	require.NoError(t, CreateRepo(model.RepoDescriptor{
+		Name:        repo,
+		Description: "test",
+		Timestamp:   time.Now(),
+		Contributor: model.Contributor{Name: "test", Email: "t@test.com"},
+	}, stores, nil))
+	bundle := NewBundle(NewBDescriptor(), Repo(repo), ConsumableStore(source), ContextStores(stores))
+	require.NoError(t, Upload(ctx, bundle))
+	require.Equal(t, uint64(model.CurrentBundleVersion), bundle.BundleDescriptor.Version)
+
+	dest := localfs.New(afero.NewBasePathFs(afero.NewOsFs(), destDir))
+	downloaded := NewBundle(NewBDescriptor(), Repo(repo), BundleID(bundle.BundleID),
+		ConsumableStore(dest), ContextStores(stores))
+	require.NoError(t, Publish(ctx, downloaded))
+
+	entries := make(map[string]model.BundleEntry)
+	for _, entry := range downloaded.BundleEntries {
+		entries[entry.NameWithPath] = entry
+	}
+	require.Len(t, entries, 4)
+	require.True(t, entries["run"].IsSymlink())
+	require.Equal(t, "bin/run.sh", entries["run"].Target)
+	require.True(t, entries["output/empty"].IsDir())
+	require.Equal(t, os.FileMode(0750), entries["bin/run.sh"].FileMode)
+
+	fi, err := os.Stat(destDir + "/bin/run.sh")
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0750), fi.Mode())
+	fi, err = os.Stat(destDir + "/data.txt")
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0640), fi.Mode())
+	target, err := os.Readlink(destDir + "/run")
+	require.NoError(t, err)
+	require.Equal(t, "bin/run.sh", target)
+	fi, err = os.Stat(destDir + "/output/empty")
+	require.NoError(t, err)
+	require.Equal(t, os.ModeDir|0700, fi.Mode())
+}
+
+// vanishingStore loses a file after it is listed and checked, before it is described
+type vanishingStore struct {
+	storage.Store
+	storage.StoreFS
+	vanished string
+}
+
+func (v vanishingStore) Lstat(ctx context.Context, key string) (os.FileInfo, error) {
+	if key == v.vanished {
+		return nil, os.ErrNotExist
+	}
+	return v.StoreFS.Lstat(ctx, key)
+}
+
+func TestBundleSkipVanishedFile(t *testing.T) {
+	ctx := context.Background()
+	sourceDir, err := ioutil.TempDir("", "bundle-skip-source")
+	require.NoError(t, err)
+	defer os.RemoveAll(sourceDir)
+	store := localfs.New(afero.NewBasePathFs(afero.NewOsFs(), sourceDir))
+	require.NoError(t, store.Put(ctx, "data.txt", bytes.NewBufferString("data"), storage.NoOverWrite))
+	require.NoError(t, store.Put(ctx, "vanished.txt", bytes.NewBufferString("vanished"), storage.NoOverWrite))
+	source := vanishingStore{Store: store, StoreFS: store.(storage.StoreFS), vanished: "vanished.txt"}
+
+	stores := context2.NewStores(nil, memStore(), memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+
+	bundle := NewBundle(NewBDescriptor(), Repo(repo), ConsumableStore(source), ContextStores(stores), SkipMissing(true))
+	require.NoError(t, Upload(ctx, bundle))
+	uploaded := NewBundle(NewBDescriptor(), Repo(repo), BundleID(bundle.BundleID), ContextStores(stores))
+	require.NoError(t, PopulateFiles(ctx, uploaded))
+	require.Len(t, uploaded.BundleEntries, 1)
+	require.Equal(t, "data.txt", uploaded.BundleEntries[0].NameWithPath)
+}
+
+func TestBundleContentDefinedChunking(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, memStore(), memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+
+	data := make([]byte, 12*1024*1024)
+	_, _ = rand.New(rand.NewSource(1)).Read(data)
+	upload := func(content []byte) *Bundle {
+		source := localfs.New(afero.NewMemMapFs())
+		require.NoError(t, source.Put(ctx, "data.bin", bytes.NewReader(content), storage.NoOverWrite))
+		bundle := NewBundle(NewBDescriptor(Chunking(cafs.ChunkingFastCDC)),
+			Repo(repo), ConsumableStore(source), ContextStores(stores))
+		require.NoError(t, Upload(ctx, bundle))
+		return bundle
+	}
+	countBlobs := func() int {
+		keys, err := stores.Blob().Keys(ctx)
+		require.NoError(t, err)
+		return len(keys)
+	}
+
+	first := upload(data)
+	blobs := countBlobs()
+
+	// a near-duplicate only adds the leaves around the insertion, and its root key
+	shifted := append([]byte("inserted at the beginning"), data...)
+	second := upload(shifted)
+	require.True(t, countBlobs()-blobs <= 3, "expected few new blobs, got %d", countBlobs()-blobs)
+
+	for _, b := range []struct {
+		bundle  *Bundle
+		content []byte
+	}{{first, data}, {second, shifted}} {
+		dest := localfs.New(afero.NewMemMapFs())
+		downloaded := NewBundle(NewBDescriptor(), Repo(repo), BundleID(b.bundle.BundleID),
+			ConsumableStore(dest), ContextStores(stores))
+		require.NoError(t, Publish(ctx, downloaded))
+		require.Equal(t, string(cafs.ChunkingFastCDC), downloaded.BundleDescriptor.Chunking)
+
+		rdr, err := dest.Get(ctx, "data.bin")
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(rdr)
+		require.NoError(t, err)
+		require.NoError(t, rdr.Close())
+		require.True(t, bytes.Equal(b.content, content))
+	}
+}
+
+func TestBundleCompression(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, memStore(), memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+
+	content := strings.Repeat("id,name,value\n1,sensor,0.5\n", 100000)
+	source := localfs.New(afero.NewMemMapFs())
+	require.NoError(t, source.Put(ctx, "data.csv", bytes.NewBufferString(content), storage.NoOverWrite))
+	bundle := NewBundle(NewBDescriptor(), Repo(repo), ConsumableStore(source), ContextStores(stores),
+		Compression(cafs.CompressionZstd))
+	require.NoError(t, Upload(ctx, bundle))
+
+	var stored int
+	keys, err := stores.Blob().Keys(ctx)
+	require.NoError(t, err)
+	for _, key := range keys {
+		attrs, e := stores.Blob().GetAttr(ctx, key)
+		require.NoError(t, e)
+		stored += int(attrs.Size)
+	}
+	require.True(t, stored < len(content)/5, "expected compressed blobs, got %d bytes", stored)
+
+	dest := localfs.New(afero.NewMemMapFs())
+	downloaded := NewBundle(NewBDescriptor(), Repo(repo), BundleID(bundle.BundleID),
+		ConsumableStore(dest), ContextStores(stores))
+	require.NoError(t, Publish(ctx, downloaded))
+	rdr, err := dest.Get(ctx, "data.csv")
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(rdr)
+	require.NoError(t, err)
+	require.NoError(t, rdr.Close())
+	require.Equal(t, content, string(b))
+}
+
+func TestBundleEncryption(t *testing.T) {
+	ctx := context.Background()
+	keyDir, err := ioutil.TempDir("", "bundle-keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(keyDir)
+	keyFile := keyDir + "/master.key"
+	require.NoError(t, encrypted.GenerateKeyFile(keyFile))
+
+	wal, blob, meta := memStore(), memStore(), memStore()
+	stores, err := context2.EncryptStores(context2.NewStores(wal, memStore(), blob, meta, memStore()),
+		model.Context{Name: "test", Encryption: &model.Encryption{KeyFile: keyFile}})
+	require.NoError(t, err)
+	createTestRepo(t, stores)
+
+	files := map[string]string{"a.txt": "confidential content", "b.txt": "confidential content"}
+	bundle := uploadTestBundle(t, stores, files)
+	other := uploadTestBundle(t, stores, files)
+
+	// blobs dedupe across bundles, and nothing is stored in clear
+	keys, err := stores.Blob().Keys(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	for _, store := range []storage.Store{blob, meta, wal} {
+		keys, err = store.Keys(ctx)
+		require.NoError(t, err)
+		for _, key := range keys {
+			rdr, e := store.Get(ctx, key)
+			require.NoError(t, e)
+			b, e := ioutil.ReadAll(rdr)
+			require.NoError(t, e)
+			require.NotContains(t, string(b), "confidential")
+			require.NotContains(t, string(b), repo)
+		}
+	}
+
+	// WAL entries are read back in clear
+	w, err := GetWAL(stores, zap.NewNop())
+	require.NoError(t, err)
+	entries, _, err := w.ListEntries(ctx, "", 100)
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+
+	for _, uploaded := range []*Bundle{bundle, other} {
+		dest := localfs.New(afero.NewMemMapFs())
+		downloaded := NewBundle(NewBDescriptor(), Repo(repo), BundleID(uploaded.BundleID),
+			ConsumableStore(dest), ContextStores(stores))
+		require.NoError(t, Publish(ctx, downloaded))
+		for name, content := range files {
+			rdr, e := dest.Get(ctx, name)
+			require.NoError(t, e)
+			b, e := ioutil.ReadAll(rdr)
+			require.NoError(t, e)
+			require.Equal(t, content, string(b))
+		}
+	}
+}
+
+func TestDiffBundlesFileModes(t *testing.T) {
+	existing := NewBundle(NewBDescriptor())
+	existing.BundleEntries = []model.BundleEntry{
+		{NameWithPath: "legacy", Hash: "h1"},
+		{NameWithPath: "script", Hash: "h2", FileMode: 0644},
+		{NameWithPath: "link", FileMode: os.ModeSymlink | 0777, Target: "a"},
+	}
+	additional := NewBundle(NewBDescriptor())
+	additional.BundleEntries = []model.BundleEntry{
+		{NameWithPath: "legacy", Hash: "h1", FileMode: 0644},
+		{NameWithPath: "script", Hash: "h2", FileMode: 0755},
+		{NameWithPath: "link", FileMode: os.ModeSymlink | 0777, Target: "b"},
+	}
+	diff, err := diffBundles(existing, additional)
+	require.NoError(t, err)
+	names := make([]string, 0, len(diff.Entries))
+	for _, de := range diff.Entries {
+		require.Equal(t, DiffEntryType(DiffEntryTypeDif), de.Type)
+		names = append(names, de.Name)
+	}
+	require.ElementsMatch(t, []string{"script", "link"}, names)
+}
+
+func TestUnpackSymlinkedEntries(t *testing.T) {
+	ctx := context.Background()
+	destDir, err := ioutil.TempDir("", "bundle-symlinked-dest")
+	require.NoError(t, err)
+	defer os.RemoveAll(destDir)
+	outsideDir, err := ioutil.TempDir("", "bundle-symlinked-outside")
+	require.NoError(t, err)
+	defer os.RemoveAll(outsideDir)
+
+	// a crafted bundle, which entries would be written through a link to some other directory
+	bundle := NewBundle(NewBDescriptor(), Repo(repo),
+		ConsumableStore(localfs.New(afero.NewBasePathFs(afero.NewOsFs(), destDir))),
+		ContextStores(context2.NewStores(nil, memStore(), memStore(), memStore(), memStore())))
+	bundle.BundleEntries = []model.BundleEntry{
+		{NameWithPath: "a", FileMode: os.ModeSymlink | 0777, Target: outsideDir},
+		{NameWithPath: "a/sub/passwd", Hash: "h1", FileMode: 0644},
+	}
+	require.Error(t, unpackDataFiles(ctx, bundle, nil, nil))
+	require.Error(t, unpackDataFile(ctx, bundle, "a/sub/passwd"))
+	infos, err := ioutil.ReadDir(outsideDir)
+	require.NoError(t, err)
+	require.Empty(t, infos)
+
+	// links are allowed next to entries with the same prefix
+	require.NoError(t, checkSymlinkedEntries([]model.BundleEntry{
+		{NameWithPath: "a", FileMode: os.ModeSymlink | 0777, Target: outsideDir},
+		{NameWithPath: "ab/passwd", Hash: "h1", FileMode: 0644},
+		{NameWithPath: "b/a", Hash: "h2", FileMode: 0644},
+	}))
+}
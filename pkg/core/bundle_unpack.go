@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"path"
 
 	"github.com/oneconcern/datamon/pkg/cafs"
 	"github.com/oneconcern/datamon/pkg/core/status"
@@ -161,6 +162,7 @@ type downloadBundleFileListChans struct {
 	bundleEntries      chan<- bundleEntriesRes
 	error              chan<- error
 	doneOk             chan<- struct{}
+	done               <-chan struct{}
 	concurrencyControl <-chan struct{}
 }
 
@@ -175,7 +177,10 @@ func downloadBundleFileListFile(ctx context.Context, bundle *Bundle,
 	var rdr io.Reader
 
 	sendErr := func(err error) {
-		chans.error <- err
+		select {
+		case chans.error <- err:
+		case <-chans.done:
+		}
 	}
 	defer func() {
 		<-chans.concurrencyControl
@@ -230,7 +235,10 @@ func downloadBundleFileListFile(ctx context.Context, bundle *Bundle,
 		sendErr(err)
 		return
 	}
-	chans.bundleEntries <- bundleEntriesRes{bundleEntries: bundleEntries, idx: i}
+	select {
+	case chans.bundleEntries <- bundleEntriesRes{bundleEntries: bundleEntries, idx: i}:
+	case <-chans.done:
+	}
 }
 
 func downloadBundleFileList(ctx context.Context, bundle *Bundle,
@@ -247,7 +255,10 @@ func downloadBundleFileList(ctx context.Context, bundle *Bundle,
 	for i := 0; i < cap(concurrencyControl); i++ {
 		concurrencyControl <- struct{}{}
 	}
-	chans.doneOk <- struct{}{}
+	select {
+	case chans.doneOk <- struct{}{}:
+	case <-chans.done:
+	}
 }
 
 func unpackBundleFileList(ctx context.Context, bundle *Bundle,
@@ -270,6 +281,7 @@ func unpackBundleFileList(ctx context.Context, bundle *Bundle,
 		bundleEntries: bundleEntriesC,
 		error:         errorC,
 		doneOk:        doneOkC,
+		done:          doneC,
 	}, publish)
 
 	// prealloc
@@ -323,6 +335,9 @@ func downloadBundleEntrySyncMaybeOverwrite(ctx context.Context, bundleEntry mode
 	overwrite bool) error {
 	bundle.l.Info("starting bundle entry download",
 		zap.String("name", bundleEntry.NameWithPath))
+	if bundleEntry.IsSymlink() || bundleEntry.IsDir() {
+		return unpackBundleEntryNoContent(ctx, bundleEntry, bundle, overwrite)
+	}
 	key, err := cafs.KeyFromString(bundleEntry.Hash)
 	if err != nil {
 		return err
@@ -347,6 +362,51 @@ func downloadBundleEntrySyncMaybeOverwrite(ctx context.Context, bundleEntry mode
 			zap.Error(err))
 		return err
 	}
+	if fsStore, ok := bundle.ConsumableStore.(storage.StoreFS); ok && bundleEntry.HasFileMode() {
+		err = fsStore.Chmod(ctx, bundleEntry.NameWithPath, bundleEntry.FileMode)
+		if err != nil {
+			bundle.l.Error("Failed to download bundle entry: chmod",
+				zap.String("name", bundleEntry.NameWithPath),
+				zap.Error(err))
+			return err
+		}
+	}
+	bundle.l.Info("downloaded bundle entry",
+		zap.String("name", bundleEntry.NameWithPath))
+	return nil
+}
+
+// unpackBundleEntryNoContent restores a symbolic link or an empty directory
+func unpackBundleEntryNoContent(ctx context.Context, bundleEntry model.BundleEntry,
+	bundle *Bundle,
+	overwrite bool) error {
+	fsStore, ok := bundle.ConsumableStore.(storage.StoreFS)
+	if !ok {
+		return fmt.Errorf("can't restore %v in store %v: %v",
+			bundleEntry.NameWithPath, bundle.ConsumableStore, storage.ErrNotSupported)
+	}
+	if overwrite {
+		err := bundle.ConsumableStore.Delete(ctx, bundleEntry.NameWithPath)
+		if err != nil {
+			bundle.l.Error("Failed to overwrite bundle entry: Delete to store",
+				zap.String("name", bundleEntry.NameWithPath),
+				zap.Error(err))
+			return err
+		}
+	}
+	var err error
+	if bundleEntry.IsSymlink() {
+		err = fsStore.Symlink(ctx, bundleEntry.Target, bundleEntry.NameWithPath)
+	} else {
+		err = fsStore.Mkdir(ctx, bundleEntry.NameWithPath, bundleEntry.FileMode)
+	}
+	if err != nil {
+		bundle.l.Error("Failed to download bundle entry",
+			zap.String("name", bundleEntry.NameWithPath),
+			zap.Stringer("mode", bundleEntry.FileMode),
+			zap.Error(err))
+		return err
+	}
 	bundle.l.Info("downloaded bundle entry",
 		zap.String("name", bundleEntry.NameWithPath))
 	return nil
@@ -475,6 +535,10 @@ func downloadBundleEntries(ctx context.Context, bundle *Bundle,
 			}
 			if selectionPredicate == nil || selectionPredicateOk {
 				concurrencyControl <- struct{}{}
+				if bundle.resumeDownload {
+					go downloadBundleEntryResume(ctx, b, bundle, fs, chans)
+					continue
+				}
 				go downloadBundleEntry(ctx, b, bundle, fs, chans)
 			}
 		}
@@ -514,9 +578,36 @@ func downloadBundleEntries(ctx context.Context, bundle *Bundle,
 	chans.doneOk <- struct{}{}
 }
 
+// checkSymlinkedEntries fails when an entry of a bundle lies under a symbolic link of the same bundle.
+//
+// Symbolic links are restored as is, and entries are downloaded concurrently: such an entry could be written
+// wherever the link points to, out of the destination.
+func checkSymlinkedEntries(entries []model.BundleEntry) error {
+	links := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsSymlink() {
+			links[path.Clean(entry.NameWithPath)] = true
+		}
+	}
+	if len(links) == 0 {
+		return nil
+	}
+	for _, entry := range entries {
+		for dir := path.Dir(path.Clean(entry.NameWithPath)); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if links[dir] {
+				return fmt.Errorf("invalid bundle: %s lies under the symbolic link %s", entry.NameWithPath, dir)
+			}
+		}
+	}
+	return nil
+}
+
 func unpackDataFiles(ctx context.Context, bundle *Bundle,
 	bundleDest *Bundle,
 	selectionPredicate func(string) (bool, error)) error {
+	if err := checkSymlinkedEntries(bundle.BundleEntries); err != nil {
+		return err
+	}
 	fs, err := cafs.New(
 		cafs.LeafSize(bundle.BundleDescriptor.LeafSize),
 		cafs.LeafTruncation(bundle.BundleDescriptor.Version < 1),
@@ -576,6 +667,9 @@ func unpackDataFiles(ctx context.Context, bundle *Bundle,
 }
 
 func unpackDataFile(ctx context.Context, bundle *Bundle, file string) error {
+	if err := checkSymlinkedEntries(bundle.BundleEntries); err != nil {
+		return err
+	}
 	fs, err := cafs.New(
 		cafs.LeafSize(bundle.BundleDescriptor.LeafSize),
 		cafs.LeafTruncation(bundle.BundleDescriptor.Version < 1),
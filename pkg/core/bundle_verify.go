@@ -0,0 +1,244 @@
+// Copyright © 2019 One Concern
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/oneconcern/datamon/pkg/cafs"
+	"github.com/oneconcern/datamon/pkg/fingerprint"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/status"
+
+	"go.uber.org/zap"
+)
+
+// Verify compares the consumable store of a bundle, e.g. a directory the bundle was downloaded to,
+// with the bundle, without downloading any file.
+//
+// Local files are fingerprinted with the leaf size of the bundle. The drift is reported as the diff to
+// apply to the local files to get the bundle: missing files are added, extra files are deleted and
+// modified files are updated.
+func Verify(ctx context.Context, bundle *Bundle) (BundleDiff, error) {
+	if err := DownloadMetadata(ctx, bundle); err != nil {
+		return BundleDiff{}, err
+	}
+	bundleEntries := make(map[string]bool, len(bundle.BundleEntries))
+	for _, entry := range bundle.BundleEntries {
+		bundleEntries[entry.NameWithPath] = true
+	}
+
+	var (
+		mu    sync.Mutex
+		drift BundleDiff
+		wg    sync.WaitGroup
+		errs  []error
+	)
+	concurrency := bundle.concurrentFileDownloads
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	concurrencyControl := make(chan struct{}, concurrency)
+	for _, entry := range bundle.BundleEntries {
+		concurrencyControl <- struct{}{}
+		wg.Add(1)
+		go func(entry model.BundleEntry) {
+			defer func() {
+				<-concurrencyControl
+				wg.Done()
+			}()
+			local, found, err := localBundleEntry(ctx, bundle, entry)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				errs = append(errs, err)
+			case !found:
+				drift.Entries = append(drift.Entries, DiffEntry{
+					Type:       DiffEntryTypeAdd,
+					Name:       entry.NameWithPath,
+					Additional: entry,
+				})
+			case !sameBundleEntry(local, entry):
+				drift.Entries = append(drift.Entries, DiffEntry{
+					Type:       DiffEntryTypeDif,
+					Name:       entry.NameWithPath,
+					Existing:   local,
+					Additional: entry,
+				})
+			}
+		}(entry)
+	}
+	wg.Wait()
+	if len(errs) != 0 {
+		return BundleDiff{}, errs[0]
+	}
+
+	extra, err := localExtraEntries(ctx, bundle, bundleEntries)
+	if err != nil {
+		return BundleDiff{}, err
+	}
+	drift.Entries = append(drift.Entries, extra...)
+	return drift, nil
+}
+
+// localExtraEntries lists the files and empty directories of the consumable store which are not in the bundle
+func localExtraEntries(ctx context.Context, bundle *Bundle, bundleEntries map[string]bool) ([]DiffEntry, error) {
+	keys, err := bundle.ConsumableStore.Keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if fsStore, ok := bundle.ConsumableStore.(storage.StoreFS); ok {
+		dirs, err := fsStore.EmptyDirs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, dirs...)
+	}
+	extra := make([]DiffEntry, 0)
+	for _, key := range keys {
+		if model.IsGeneratedFile(key) || bundleEntries[key] {
+			continue
+		}
+		extra = append(extra, DiffEntry{
+			Type:     DiffEntryTypeDel,
+			Name:     key,
+			Existing: model.BundleEntry{NameWithPath: key},
+		})
+	}
+	return extra, nil
+}
+
+// localBundleEntry describes the local copy of a bundle entry, in the consumable store of the bundle.
+//
+// found is false when there is no local copy. Local files are only fingerprinted when they have
+// the expected size.
+func localBundleEntry(ctx context.Context, bundle *Bundle, entry model.BundleEntry) (model.BundleEntry, bool, error) {
+	local := model.BundleEntry{NameWithPath: entry.NameWithPath}
+	if fsStore, ok := bundle.ConsumableStore.(storage.StoreFS); ok {
+		fi, err := fsStore.Lstat(ctx, entry.NameWithPath)
+		if err != nil {
+			if isNotExist(err) {
+				return local, false, nil
+			}
+			return local, false, err
+		}
+		local.FileMode = fi.Mode() & (os.ModeType | os.ModePerm)
+		switch {
+		case local.IsSymlink():
+			local.Target, err = fsStore.Readlink(ctx, entry.NameWithPath)
+			local.Size = uint64(len(local.Target))
+			return local, true, err
+		case local.IsDir():
+			return local, true, nil
+		}
+		local.Size = uint64(fi.Size())
+	} else {
+		attrs, err := bundle.ConsumableStore.GetAttr(ctx, entry.NameWithPath)
+		if err != nil {
+			if isNotExist(err) {
+				return local, false, nil
+			}
+			return local, false, err
+		}
+		local.Size = uint64(attrs.Size)
+	}
+	if local.Size != entry.Size || entry.IsSymlink() || entry.IsDir() {
+		return local, true, nil
+	}
+	hash, err := fingerprintFile(ctx, bundle, entry.NameWithPath)
+	if err != nil {
+		return local, true, err
+	}
+	local.Hash = hash
+	return local, true, nil
+}
+
+func isNotExist(err error) bool {
+	return os.IsNotExist(err) || errors.Is(err, status.ErrNotExists)
+}
+
+// fingerprintFile computes the key of a file, as stored in the blob store with the leaf size and chunking of the bundle
+func fingerprintFile(ctx context.Context, bundle *Bundle, file string) (string, error) {
+	rdr, err := bundle.ConsumableStore.Get(ctx, file)
+	if err != nil {
+		return "", err
+	}
+	defer rdr.Close()
+	if cafs.ChunkingScheme(bundle.BundleDescriptor.Chunking) == cafs.ChunkingFastCDC {
+		key, e := cafs.FingerprintCDC(rdr, bundle.BundleDescriptor.LeafSize)
+		if e != nil {
+			return "", fmt.Errorf("failed to fingerprint %s: %w", file, e)
+		}
+		return key.String(), nil
+	}
+	digest, err := fingerprint.New(fingerprint.LeafSize(int64(bundle.BundleDescriptor.LeafSize))).ProcessReader(rdr)
+	if err != nil {
+		return "", fmt.Errorf("failed to fingerprint %s: %w", file, err)
+	}
+	key, err := cafs.NewKey(digest)
+	if err != nil {
+		return "", err
+	}
+	return key.String(), nil
+}
+
+// downloadBundleEntryResume downloads a bundle entry, unless it has already been downloaded
+func downloadBundleEntryResume(ctx context.Context, bundleEntry model.BundleEntry,
+	bundle *Bundle,
+	fs cafs.Fs,
+	chans downloadBundleChans) {
+	defer func() {
+		<-chans.concurrencyControl
+	}()
+	local, found, err := localBundleEntry(ctx, bundle, bundleEntry)
+	if err == nil && found && sameBundleEntry(local, bundleEntry) {
+		bundle.l.Info("bundle entry already downloaded",
+			zap.String("name", bundleEntry.NameWithPath))
+		return
+	}
+	if err == nil {
+		err = downloadBundleEntrySyncMaybeOverwrite(ctx, bundleEntry, bundle, fs, found)
+	}
+	if err != nil {
+		chans.error <- errorHit{
+			err,
+			bundleEntry.NameWithPath,
+		}
+	}
+}
+
+// removePublishedMetadata removes the metadata of an interrupted download of the bundle from the consumable store,
+// so that it is published again.
+func removePublishedMetadata(ctx context.Context, bundle *Bundle) error {
+	keys, err := bundle.ConsumableStore.Keys(ctx)
+	if err != nil {
+		return err
+	}
+	published := make([]string, 0)
+	for _, key := range keys {
+		info, err := model.GetConsumableStorePathMetadata(key)
+		if err != nil {
+			if _, ok := err.(model.ConsumableStorePathMetadataErr); ok {
+				continue
+			}
+			return err
+		}
+		if info.BundleID != bundle.BundleID {
+			return fmt.Errorf("can't resume the download of bundle %s: the destination holds bundle %s",
+				bundle.BundleID, info.BundleID)
+		}
+		published = append(published, key)
+	}
+	for _, key := range published {
+		if err = bundle.ConsumableStore.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
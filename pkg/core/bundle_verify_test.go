@@ -0,0 +1,150 @@
+// Copyright © 2019 One Concern
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/oneconcern/datamon/pkg/cafs"
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/localfs"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+// writesStore records the files written to a store
+type writesStore struct {
+	storage.Store
+	mu     sync.Mutex
+	writes map[string]int
+}
+
+func (s *writesStore) Put(ctx context.Context, key string, rdr io.Reader, newKey storage.NewKey) error {
+	s.mu.Lock()
+	s.writes[key]++
+	s.mu.Unlock()
+	return s.Store.Put(ctx, key, rdr, newKey)
+}
+
+func TestVerifyAndResumeDownload(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, nil, memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+	files := map[string]string{
+		"unchanged":      "unchanged content",
+		"dir/missing":    "missing content",
+		"modified":       "modified content",
+		"truncated":      "truncated content",
+		"dir/sub/large":  strings.Repeat("large content", 1000),
+		"dir/sub/nested": "nested content",
+	}
+	uploaded := uploadTestBundle(t, stores, files)
+
+	// an interrupted download, with some local changes
+	destination := localfs.New(afero.NewMemMapFs())
+	download := func(opts ...BundleOption) *Bundle {
+		return NewBundle(NewBDescriptor(), append([]BundleOption{
+			Repo(repo), BundleID(uploaded.BundleID), ContextStores(stores), ConsumableStore(destination),
+		}, opts...)...)
+	}
+	require.NoError(t, Publish(ctx, download()))
+	require.NoError(t, destination.Delete(ctx, "dir/missing"))
+	require.NoError(t, destination.Put(ctx, "modified", bytes.NewBufferString("MODIFIED content"), storage.OverWrite))
+	require.NoError(t, destination.Put(ctx, "truncated", bytes.NewBufferString("trunc"), storage.OverWrite))
+	require.NoError(t, destination.Put(ctx, "extra", bytes.NewBufferString("extra content"), storage.NoOverWrite))
+
+	drift, err := Verify(ctx, download())
+	require.NoError(t, err)
+	reported := make(map[string]string)
+	for _, entry := range drift.Entries {
+		reported[entry.Name] = DiffEntryType(entry.Type).String()
+	}
+	require.Equal(t, map[string]string{
+		"dir/missing": "A",
+		"modified":    "U",
+		"truncated":   "U",
+		"extra":       "D",
+	}, reported)
+
+	// downloading again fails, resuming only fetches the missing and mismatched files
+	require.Error(t, Publish(ctx, download()))
+	writes := &writesStore{Store: destination, writes: map[string]int{}}
+	resumed := NewBundle(NewBDescriptor(), Repo(repo), BundleID(uploaded.BundleID), ContextStores(stores),
+		ConsumableStore(writes), ResumeDownload(true))
+	require.NoError(t, Publish(ctx, resumed))
+	for name := range files {
+		switch name {
+		case "dir/missing", "modified", "truncated":
+			require.Equal(t, 1, writes.writes[name], name)
+		default:
+			require.Zero(t, writes.writes[name], name)
+		}
+		rdr, err := destination.Get(ctx, name)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(rdr)
+		require.NoError(t, err)
+		require.Equal(t, files[name], string(b))
+	}
+
+	drift, err = Verify(ctx, download())
+	require.NoError(t, err)
+	require.Len(t, drift.Entries, 1)
+	require.Equal(t, "extra", drift.Entries[0].Name)
+
+	// the destination holds another bundle
+	other := uploadTestBundle(t, stores, map[string]string{"other": "other content"})
+	resumed = NewBundle(NewBDescriptor(), Repo(repo), BundleID(other.BundleID), ContextStores(stores),
+		ConsumableStore(destination), ResumeDownload(true))
+	require.Error(t, Publish(ctx, resumed))
+}
+
+func TestVerifyAndResumeDownloadFastCDC(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, nil, memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+	files := map[string]string{
+		"unchanged": strings.Repeat("unchanged content", 1000),
+		"modified":  strings.Repeat("modified content", 1000),
+	}
+	source := localfs.New(afero.NewMemMapFs())
+	for name, content := range files {
+		require.NoError(t, source.Put(ctx, name, bytes.NewBufferString(content), storage.NoOverWrite))
+	}
+	uploaded := NewBundle(NewBDescriptor(Chunking(cafs.ChunkingFastCDC)),
+		Repo(repo), ConsumableStore(source), ContextStores(stores))
+	require.NoError(t, Upload(ctx, uploaded))
+
+	// files of content-defined leaves are fingerprinted locally
+	destination := localfs.New(afero.NewMemMapFs())
+	download := func(opts ...BundleOption) *Bundle {
+		return NewBundle(NewBDescriptor(), append([]BundleOption{
+			Repo(repo), BundleID(uploaded.BundleID), ContextStores(stores), ConsumableStore(destination),
+		}, opts...)...)
+	}
+	require.NoError(t, Publish(ctx, download()))
+	drift, err := Verify(ctx, download())
+	require.NoError(t, err)
+	require.Empty(t, drift.Entries)
+
+	require.NoError(t, destination.Put(ctx, "modified", bytes.NewBufferString(strings.Repeat("MODIFIED content", 1000)),
+		storage.OverWrite))
+	drift, err = Verify(ctx, download())
+	require.NoError(t, err)
+	require.Len(t, drift.Entries, 1)
+	require.Equal(t, "modified", drift.Entries[0].Name)
+
+	writes := &writesStore{Store: destination, writes: map[string]int{}}
+	require.NoError(t, Publish(ctx, download(ConsumableStore(writes), ResumeDownload(true))))
+	require.Equal(t, 1, writes.writes["modified"])
+	require.Zero(t, writes.writes["unchanged"])
+	drift, err = Verify(ctx, download())
+	require.NoError(t, err)
+	require.Empty(t, drift.Entries)
+}
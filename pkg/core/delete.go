@@ -0,0 +1,405 @@
+/*
+ * Copyright © 2019 One Concern
+ *
+ */
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/core/status"
+	"github.com/oneconcern/datamon/pkg/dlogger"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/oneconcern/datamon/pkg/storage"
+)
+
+const (
+	// DefaultPurgeRetention is how long the metadata of deleted repos and bundles is kept before being purged
+	DefaultPurgeRetention = 30 * 24 * time.Hour
+)
+
+// DeleteOption sets options for the deletion of repos and bundles
+type DeleteOption func(*deleteSettings)
+
+type deleteSettings struct {
+	force    bool
+	listOpts []ListOption
+	l        *zap.Logger
+}
+
+// ForceDelete deletes bundles even though some labels or branches still point to them
+func ForceDelete(force bool) DeleteOption {
+	return func(s *deleteSettings) {
+		s.force = force
+	}
+}
+
+// DeleteListOptions sets the options used to list labels and metadata
+func DeleteListOptions(opts ...ListOption) DeleteOption {
+	return func(s *deleteSettings) {
+		s.listOpts = append(s.listOpts, opts...)
+	}
+}
+
+// DeleteLogger sets the logger used when deleting and purging
+func DeleteLogger(l *zap.Logger) DeleteOption {
+	return func(s *deleteSettings) {
+		if l != nil {
+			s.l = l
+		}
+	}
+}
+
+func defaultDeleteSettings() deleteSettings {
+	l, _ := dlogger.GetLogger("info")
+	return deleteSettings{
+		l: l,
+	}
+}
+
+// DeleteBundle marks a bundle as deleted with a tombstone.
+//
+// A deleted bundle is no longer listed, nor picked as the latest bundle of its repo.
+// Its metadata remains available until purged. Bundles with labels or branches are only deleted when forced.
+func DeleteBundle(ctx context.Context, stores context2.Stores, repo, bundleID string, contributor model.Contributor,
+	opts ...DeleteOption) error {
+	settings := defaultDeleteSettings()
+	for _, apply := range opts {
+		apply(&settings)
+	}
+	if err := RepoExists(repo, stores); err != nil {
+		return err
+	}
+	exists, err := getMetaStore(stores).Has(ctx, model.GetArchivePathToBundle(repo, bundleID))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("bundle %s in repo %s: %w", bundleID, repo, status.ErrNotFound)
+	}
+	refs, err := listRefsPointingTo(ctx, stores, repo, bundleID, settings)
+	if err != nil {
+		return err
+	}
+	if len(refs) > 0 && !settings.force {
+		return fmt.Errorf("%w: bundle %s has %s", status.ErrLabelled, bundleID, strings.Join(refs, ", "))
+	}
+	return writeTombstone(ctx, stores, model.Tombstone{
+		Repo:        repo,
+		BundleID:    bundleID,
+		Contributor: contributor,
+		Timestamp:   time.Now(),
+	}, settings)
+}
+
+// DeleteRepo marks a repo as deleted with a tombstone.
+//
+// A deleted repo is no longer listed and its bundles may no longer be listed nor labelled.
+// Its metadata remains available until purged. Repos with labels or branches are only deleted when forced.
+func DeleteRepo(ctx context.Context, stores context2.Stores, repo string, contributor model.Contributor,
+	opts ...DeleteOption) error {
+	settings := defaultDeleteSettings()
+	for _, apply := range opts {
+		apply(&settings)
+	}
+	if err := RepoExists(repo, stores); err != nil {
+		return err
+	}
+	refs, err := listRefsPointingTo(ctx, stores, repo, "", settings)
+	if err != nil {
+		return err
+	}
+	if len(refs) > 0 && !settings.force {
+		return fmt.Errorf("%w: repo %s has %s", status.ErrLabelled, repo, strings.Join(refs, ", "))
+	}
+	return writeTombstone(ctx, stores, model.Tombstone{
+		Repo:        repo,
+		Contributor: contributor,
+		Timestamp:   time.Now(),
+	}, settings)
+}
+
+// listLabelsPointingTo returns the names of the labels of a repo pointing to some bundle, or to any bundle
+// when bundleID is empty
+func listLabelsPointingTo(repo string, stores context2.Stores, bundleID string, settings deleteSettings) ([]string, error) {
+	if getLabelStore(stores) == nil {
+		return nil, nil
+	}
+	names := make([]string, 0)
+	err := ListLabelsApply(repo, stores, "", func(label model.LabelDescriptor) error {
+		if bundleID == "" || label.BundleID == bundleID {
+			names = append(names, label.Name)
+		}
+		return nil
+	}, settings.listOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// listRefsPointingTo returns the labels and branch heads of a repo pointing to some bundle, or to any bundle
+// when bundleID is empty
+func listRefsPointingTo(ctx context.Context, stores context2.Stores, repo, bundleID string, settings deleteSettings) ([]string, error) {
+	labels, err := listLabelsPointingTo(repo, stores, bundleID, settings)
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]string, 0, len(labels))
+	for _, label := range labels {
+		refs = append(refs, "label "+label)
+	}
+	if getVMetaStore(stores) == nil {
+		return refs, nil
+	}
+	branches, err := ListBranches(ctx, stores, repo, settings.listOpts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, branch := range branches {
+		if bundleID == "" || branch.BundleID == bundleID {
+			refs = append(refs, "branch "+branch.Name)
+		}
+	}
+	return refs, nil
+}
+
+func writeTombstone(ctx context.Context, stores context2.Stores, tombstone model.Tombstone, settings deleteSettings) error {
+	store := getMetaStore(stores)
+	path := tombstone.ArchivePath()
+	deleted, err := store.Has(ctx, path)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		return fmt.Errorf("already deleted: %s", path)
+	}
+	buffer, err := yaml.Marshal(tombstone)
+	if err != nil {
+		return err
+	}
+	if err = appendWALEntry(ctx, stores, settings.l, model.NewDeletePayload(tombstone)); err != nil {
+		return err
+	}
+	return store.Put(ctx, path, bytes.NewReader(buffer), storage.NoOverWrite)
+}
+
+// ListTombstones returns the repos and bundles deleted but not purged yet, sorted by time of deletion.
+func ListTombstones(ctx context.Context, stores context2.Stores, opts ...ListOption) (model.Tombstones, error) {
+	settings := defaultSettings()
+	for _, apply := range opts {
+		apply(&settings)
+	}
+	store := getMetaStore(stores)
+	keys, err := listKeysPrefix(ctx, store, model.GetArchivePathPrefixToTombstones(), settings.batchSize)
+	if err != nil {
+		return nil, err
+	}
+	tombstones := make(model.Tombstones, 0, len(keys))
+	for _, key := range keys {
+		tombstone, err := getTombstone(ctx, store, key)
+		if err != nil {
+			return nil, err
+		}
+		tombstones = append(tombstones, tombstone)
+	}
+	sort.Stable(tombstones)
+	return tombstones, nil
+}
+
+func getTombstone(ctx context.Context, store storage.Store, key string) (model.Tombstone, error) {
+	var tombstone model.Tombstone
+	rdr, err := store.Get(ctx, key)
+	if err != nil {
+		return tombstone, err
+	}
+	defer rdr.Close()
+	o, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		return tombstone, err
+	}
+	if err = yaml.Unmarshal(o, &tombstone); err != nil {
+		return tombstone, fmt.Errorf("failed to read tombstone %s: %v", key, err)
+	}
+	return tombstone, nil
+}
+
+// PurgeDeleted removes the metadata of the repos and bundles deleted for longer than the retention period.
+//
+// A bundle still pointed to by a label or a branch of its repo is not purged, until these are moved.
+// Blobs are not removed: they are left to the garbage collector. The purged tombstones are returned.
+func PurgeDeleted(ctx context.Context, stores context2.Stores, retention time.Duration, opts ...DeleteOption) (model.Tombstones, error) {
+	settings := defaultDeleteSettings()
+	for _, apply := range opts {
+		apply(&settings)
+	}
+	tombstones, err := ListTombstones(ctx, stores, settings.listOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-retention)
+	purged := make(model.Tombstones, 0, len(tombstones))
+	purgedRepos := make(map[string]struct{})
+	deletedRepos := make(map[string]struct{})
+	// repos go first, since purging a repo purges all its bundles
+	sort.SliceStable(tombstones, func(i, j int) bool {
+		return tombstones[i].IsRepo() && !tombstones[j].IsRepo()
+	})
+	for _, tombstone := range tombstones {
+		if tombstone.IsRepo() {
+			deletedRepos[tombstone.Repo] = struct{}{}
+		}
+		if tombstone.Timestamp.After(cutoff) {
+			continue
+		}
+		if _, ok := purgedRepos[tombstone.Repo]; ok {
+			continue
+		}
+		if _, ok := deletedRepos[tombstone.Repo]; !ok && !tombstone.IsRepo() {
+			// the labels and branches of a deleted repo go with it: those of a live repo must not be left dangling
+			refs, e := listRefsPointingTo(ctx, stores, tombstone.Repo, tombstone.BundleID, settings)
+			if e != nil {
+				return purged, fmt.Errorf("failed to purge %s: %w", tombstone.ArchivePath(), e)
+			}
+			if len(refs) > 0 {
+				settings.l.Warn("not purged: bundle is still referenced",
+					zap.String("repo", tombstone.Repo),
+					zap.String("bundle", tombstone.BundleID),
+					zap.Strings("refs", refs))
+				continue
+			}
+		}
+		if err = purgeTombstone(ctx, stores, tombstone, settings); err != nil {
+			return purged, fmt.Errorf("failed to purge %s: %w", tombstone.ArchivePath(), err)
+		}
+		if tombstone.IsRepo() {
+			purgedRepos[tombstone.Repo] = struct{}{}
+		}
+		settings.l.Info("purged", zap.String("repo", tombstone.Repo), zap.String("bundle", tombstone.BundleID))
+		purged = append(purged, tombstone)
+	}
+	sort.Stable(purged)
+	return purged, nil
+}
+
+func purgeTombstone(ctx context.Context, stores context2.Stores, tombstone model.Tombstone, settings deleteSettings) error {
+	if err := appendWALEntry(ctx, stores, settings.l, model.NewPurgePayload(tombstone)); err != nil {
+		return err
+	}
+	listSettings := defaultSettings()
+	for _, apply := range settings.listOpts {
+		apply(&listSettings)
+	}
+	type prefixedStore struct {
+		store  storage.Store
+		prefix string
+	}
+	var toPurge []prefixedStore
+	if tombstone.IsRepo() {
+		toPurge = []prefixedStore{
+			{store: getMetaStore(stores), prefix: model.GetArchivePathPrefixToBundles(tombstone.Repo)},
+			{store: getMetaStore(stores), prefix: model.GetArchivePathPrefixToBundleTombstones(tombstone.Repo)},
+			{store: getLabelStore(stores), prefix: model.GetArchivePathPrefixToLabels(tombstone.Repo)},
+			{store: getLabelStore(stores), prefix: model.GetArchivePathPrefixToLabelGenerations(tombstone.Repo)},
+			{store: getVMetaStore(stores), prefix: model.GetArchivePathPrefixToBranches(tombstone.Repo)},
+			{store: getVMetaStore(stores), prefix: model.GetArchivePathPrefixToBranchGenerations(tombstone.Repo)},
+			{store: getReadLogStore(stores), prefix: model.GetArchivePathPrefixToRepoReadLog(tombstone.Repo)},
+			{store: getMetaStore(stores), prefix: model.GetArchivePathToRepoDescriptor(tombstone.Repo)},
+		}
+	} else {
+		toPurge = []prefixedStore{
+			{store: getMetaStore(stores), prefix: model.GetArchivePathPrefixToBundles(tombstone.Repo) + tombstone.BundleID + "/"},
+			{store: getReadLogStore(stores), prefix: model.GetArchivePathPrefixToReadLog(tombstone.Repo, tombstone.BundleID)},
+		}
+	}
+	for _, p := range toPurge {
+		if p.store == nil {
+			continue
+		}
+		keys, err := listKeysPrefix(ctx, p.store, p.prefix, listSettings.batchSize)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err = p.store.Delete(ctx, key); err != nil {
+				return err
+			}
+		}
+	}
+	// the tombstone goes last, so an interrupted purge is resumed by the next one
+	return getMetaStore(stores).Delete(ctx, tombstone.ArchivePath())
+}
+
+// listDeleted returns the ids of the repos or bundles with a tombstone under some prefix.
+//
+// Nothing is returned when deleted items are listed.
+func listDeleted(stores context2.Stores, prefix string, settings Settings) (map[string]struct{}, error) {
+	if settings.includeDeleted {
+		return nil, nil
+	}
+	keys, err := listKeysPrefix(context.Background(), getMetaStore(stores), prefix, settings.batchSize)
+	if err != nil {
+		return nil, err
+	}
+	deleted := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		deleted[model.GetTombstoneIDFromArchivePath(key)] = struct{}{}
+	}
+	return deleted, nil
+}
+
+func skipDeletedBundles(bundles model.BundleDescriptors, deleted map[string]struct{}) model.BundleDescriptors {
+	if len(deleted) == 0 {
+		return bundles
+	}
+	kept := bundles[:0]
+	for _, bundle := range bundles {
+		if _, isDeleted := deleted[bundle.ID]; !isDeleted {
+			kept = append(kept, bundle)
+		}
+	}
+	return kept
+}
+
+func skipDeletedRepos(repos model.RepoDescriptors, deleted map[string]struct{}) model.RepoDescriptors {
+	if len(deleted) == 0 {
+		return repos
+	}
+	kept := repos[:0]
+	for _, repo := range repos {
+		if _, isDeleted := deleted[repo.Name]; !isDeleted {
+			kept = append(kept, repo)
+		}
+	}
+	return kept
+}
+
+// listKeysPrefix pages through all the keys of a store under some prefix
+func listKeysPrefix(ctx context.Context, store storage.Store, prefix string, batchSize int) ([]string, error) {
+	keys := make([]string, 0)
+	var pageToken string
+	for {
+		page, next, err := store.KeysPrefix(ctx, pageToken, prefix, "", batchSize)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, page...)
+		if next == "" || next == pageToken {
+			break
+		}
+		pageToken = next
+	}
+	return keys, nil
+}
@@ -0,0 +1,204 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/core/status"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/mockstorage"
+)
+
+func TestDeleteAndPurge(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(memStore(), memStore(), memStore(), memStore(), memStore())
+	contributor := model.Contributor{Name: "test", Email: "t@test.com"}
+	createTestRepo(t, stores)
+
+	ids := make([]string, 0, 3)
+	for _, content := range []string{"first", "second", "third"} {
+		ids = append(ids, uploadTestBundle(t, stores, map[string]string{"file.txt": content}).BundleID)
+	}
+	sort.Strings(ids)
+	latest, labelled := ids[2], ids[1]
+
+	label := NewLabel(NewLabelDescriptor(LabelContributor(contributor)), LabelName("release"))
+	require.NoError(t, label.UploadDescriptor(ctx,
+		NewBundle(NewBDescriptor(), Repo(repo), BundleID(labelled), ContextStores(stores))))
+
+	listBundleIDs := func(opts ...ListOption) []string {
+		bundles, err := ListBundles(repo, stores, opts...)
+		require.NoError(t, err)
+		res := make([]string, 0, len(bundles))
+		for _, bundle := range bundles {
+			res = append(res, bundle.ID)
+		}
+		return res
+	}
+
+	// deleted bundles are not listed
+	require.NoError(t, DeleteBundle(ctx, stores, repo, latest, contributor))
+	require.Equal(t, ids[:2], listBundleIDs())
+	require.Equal(t, ids, listBundleIDs(IncludeDeleted(true)))
+	id, err := GetLatestBundle(repo, stores)
+	require.NoError(t, err)
+	require.Equal(t, labelled, id)
+	require.Error(t, DeleteBundle(ctx, stores, repo, latest, contributor))
+	err = DeleteBundle(ctx, stores, repo, "missing", contributor)
+	require.True(t, errors.Is(err, status.ErrNotFound))
+
+	// labelled bundles are only deleted when forced
+	err = DeleteBundle(ctx, stores, repo, labelled, contributor)
+	require.True(t, errors.Is(err, status.ErrLabelled))
+	require.Contains(t, err.Error(), "release")
+	require.NoError(t, DeleteBundle(ctx, stores, repo, labelled, contributor, ForceDelete(true)))
+	require.Equal(t, ids[:1], listBundleIDs())
+
+	// so are labelled repos
+	err = DeleteRepo(ctx, stores, repo, contributor)
+	require.True(t, errors.Is(err, status.ErrLabelled))
+	require.NoError(t, DeleteRepo(ctx, stores, repo, contributor, ForceDelete(true)))
+	require.Error(t, RepoExists(repo, stores))
+	_, err = GetRepoDescriptorByRepoName(stores, repo)
+	require.True(t, errors.Is(err, status.ErrNotFound))
+	repos, err := ListRepos(stores)
+	require.NoError(t, err)
+	require.Empty(t, repos)
+	repos, err = ListRepos(stores, IncludeDeleted(true))
+	require.NoError(t, err)
+	require.Len(t, repos, 1)
+	_, err = ListBundles(repo, stores)
+	require.Error(t, err)
+
+	tombstones, err := ListTombstones(ctx, stores)
+	require.NoError(t, err)
+	require.Len(t, tombstones, 3)
+	require.Equal(t, latest, tombstones[0].BundleID)
+	require.True(t, tombstones[2].IsRepo())
+
+	// deletions are recorded in the WAL
+	w, err := GetWAL(stores, zap.NewNop())
+	require.NoError(t, err)
+	entries, _, err := w.ListEntries(ctx, "", 100)
+	require.NoError(t, err)
+	deletions := 0
+	for _, entry := range entries {
+		if entry.Payload.Type == model.PayloadTypeBundleDelete || entry.Payload.Type == model.PayloadTypeRepoDelete {
+			deletions++
+		}
+	}
+	require.Equal(t, 3, deletions)
+
+	// nothing is purged within the retention period
+	purged, err := PurgeDeleted(ctx, stores, time.Hour)
+	require.NoError(t, err)
+	require.Empty(t, purged)
+	require.Equal(t, ids, listBundleIDs(IncludeDeleted(true)))
+
+	purged, err = PurgeDeleted(ctx, stores, 0, DeleteListOptions(BatchSize(2)))
+	require.NoError(t, err)
+	require.Len(t, purged, 1)
+	require.True(t, purged[0].IsRepo())
+	for _, store := range []storage.Store{stores.Metadata(), stores.VMetadata(), stores.ReadLog()} {
+		keys, e := store.Keys(ctx)
+		require.NoError(t, e)
+		for _, key := range keys {
+			require.NotContains(t, key, repo)
+		}
+	}
+	tombstones, err = ListTombstones(ctx, stores)
+	require.NoError(t, err)
+	require.Empty(t, tombstones)
+
+	// a purged repo may be created again
+	createTestRepo(t, stores)
+	require.Empty(t, listBundleIDs())
+}
+
+func TestPurgeReferencedBundle(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(memStore(), memStore(), memStore(), memStore(), memStore())
+	contributor := model.Contributor{Name: "test", Email: "t@test.com"}
+	createTestRepo(t, stores)
+
+	first := uploadTestBundle(t, stores, map[string]string{"file.txt": "first"}).BundleID
+	second := uploadTestBundle(t, stores, map[string]string{"file.txt": "second"}).BundleID
+
+	label := NewLabel(NewLabelDescriptor(LabelContributor(contributor)), LabelName("release"))
+	require.NoError(t, label.UploadDescriptor(ctx,
+		NewBundle(NewBDescriptor(), Repo(repo), BundleID(first), ContextStores(stores))))
+	main, err := CreateBranch(ctx, stores, repo, "main", first, contributor)
+	require.NoError(t, err)
+
+	// bundles and repos with branches are only deleted when forced
+	_, err = CreateBranch(ctx, stores, repo, "dev", second, contributor)
+	require.NoError(t, err)
+	err = DeleteBundle(ctx, stores, repo, second, contributor)
+	require.True(t, errors.Is(err, status.ErrLabelled))
+	require.Contains(t, err.Error(), "branch dev")
+	err = DeleteRepo(ctx, stores, repo, contributor)
+	require.True(t, errors.Is(err, status.ErrLabelled))
+	require.Contains(t, err.Error(), "branch main")
+	require.NoError(t, DeleteBundle(ctx, stores, repo, first, contributor, ForceDelete(true)))
+
+	// a bundle still labelled or at the head of a branch is not purged
+	purged, err := PurgeDeleted(ctx, stores, 0)
+	require.NoError(t, err)
+	require.Empty(t, purged)
+	refs, err := listRefsPointingTo(ctx, stores, repo, first, defaultDeleteSettings())
+	require.NoError(t, err)
+	require.Equal(t, []string{"label release", "branch main"}, refs)
+
+	label = NewLabel(NewLabelDescriptor(LabelContributor(contributor)), LabelName("release"))
+	require.NoError(t, label.UploadDescriptor(ctx,
+		NewBundle(NewBDescriptor(), Repo(repo), BundleID(second), ContextStores(stores))))
+	purged, err = PurgeDeleted(ctx, stores, 0)
+	require.NoError(t, err)
+	require.Empty(t, purged)
+
+	// once moved, the bundle is purged
+	_, err = AdvanceBranch(ctx, stores, repo, main, second, contributor)
+	require.NoError(t, err)
+	purged, err = PurgeDeleted(ctx, stores, 0)
+	require.NoError(t, err)
+	require.Len(t, purged, 1)
+	require.Equal(t, first, purged[0].BundleID)
+
+	label = NewLabel(NewLabelDescriptor(), LabelName("release"))
+	require.NoError(t, label.DownloadDescriptor(ctx,
+		NewBundle(NewBDescriptor(), Repo(repo), ContextStores(stores)), true))
+	require.Equal(t, second, label.Descriptor.BundleID)
+}
+
+// withoutTombstones makes a mocked store, which may otherwise hold any key, hold no tombstone
+func withoutTombstones(mock *mockstorage.StoreMock) *mockstorage.StoreMock {
+	isTombstone := func(pth string) bool {
+		return strings.HasPrefix(pth, model.GetArchivePathPrefixToTombstones())
+	}
+	if has := mock.HasFunc; has != nil {
+		mock.HasFunc = func(ctx context.Context, pth string) (bool, error) {
+			if isTombstone(pth) {
+				return false, nil
+			}
+			return has(ctx, pth)
+		}
+	}
+	if keysPrefix := mock.KeysPrefixFunc; keysPrefix != nil {
+		mock.KeysPrefixFunc = func(ctx context.Context, next, prefix, delimiter string, count int) ([]string, string, error) {
+			if isTombstone(prefix) {
+				return nil, "", nil
+			}
+			return keysPrefix(ctx, next, prefix, delimiter, count)
+		}
+	}
+	return mock
+}
@@ -5,12 +5,15 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/jacobsa/fuse/fuseops"
 
+	"github.com/oneconcern/datamon/pkg/cafs"
+
 	"github.com/spf13/afero"
 	"go.uber.org/zap"
 
@@ -31,6 +34,7 @@ const (
 	fileDefaultMode                  = 0666
 	dirReadOnlyMode                  = 0555 | os.ModeDir
 	fileReadOnlyMode                 = 0444
+	linkDefaultMode                  = 0777 | os.ModeSymlink
 	defaultUID                       = 0
 	defaultGID                       = 0
 	dirInitialSize                   = 64
@@ -66,7 +70,7 @@ func NewReadOnlyFS(bundle *Bundle, l *zap.Logger) (*ReadOnlyFS, error) {
 		fsEntryStore: iradix.New(),
 		lookupTree:   iradix.New(),
 		fsDirStore:   iradix.New(),
-		l:            l,
+		l:            l.With(zap.String("repo", bundle.RepoID), zap.String("bundle", bundle.BundleID)),
 	}
 
 	// Extract the meta information needed.
@@ -76,13 +80,135 @@ func NewReadOnlyFS(bundle *Bundle, l *zap.Logger) (*ReadOnlyFS, error) {
 			zap.Error(err))
 		return nil, err
 	}
-	// TODO: Introduce streaming and caching
+	if bundle.Streamed {
+		if err = bundle.initStreaming(); err != nil {
+			l.Error("Failed to stream bundle", zap.String("id", bundle.BundleID),
+				zap.Error(err))
+			return nil, err
+		}
+	}
 	// Populate the filesystem.
 	return fs.populateFS(bundle)
 }
 
+// BundleMount is a bundle served in a directory of a filesystem which mounts several bundles.
+type BundleMount struct {
+	// Path of the directory holding the files of the bundle, relative to the mount point, e.g. "<repo>/<label>"
+	Path   string
+	Bundle *Bundle
+}
+
+// NewMultiReadOnlyFS creates a datamon filesystem serving several bundles, each in its own directory.
+//
+// Streamed bundles share one cache of leaves, sized after the largest memory set with PrefetchMemory.
+func NewMultiReadOnlyFS(mounts []BundleMount, l *zap.Logger) (*ReadOnlyFS, error) {
+	if l == nil {
+		return nil, fmt.Errorf("logger is nil")
+	}
+	mounts, err := checkBundleMounts(mounts)
+	if err != nil {
+		l.Error("invalid bundle mounts", zap.Error(err))
+		return nil, err
+	}
+
+	var (
+		prefetchMemory int64
+		leafSize       uint32
+		served         []string
+	)
+	for _, mount := range mounts {
+		bundle := mount.Bundle
+		if err = Publish(context.Background(), bundle); err != nil {
+			l.Error("Failed to publish bundle", zap.String("repo", bundle.RepoID), zap.String("id", bundle.BundleID),
+				zap.Error(err))
+			return nil, err
+		}
+		if bundle.prefetchMemory > prefetchMemory {
+			prefetchMemory = bundle.prefetchMemory
+		}
+		if bundle.Streamed && bundle.BundleDescriptor.LeafSize > leafSize {
+			leafSize = bundle.BundleDescriptor.LeafSize
+		}
+		served = append(served, bundle.RepoID+"/"+bundle.BundleID)
+	}
+	leafCache := cafs.NewLeafCache(prefetchMemory, leafSize)
+	for _, mount := range mounts {
+		bundle := mount.Bundle
+		if !bundle.Streamed {
+			continue
+		}
+		bundle.leafCache = leafCache
+		if err = bundle.initStreaming(); err != nil {
+			l.Error("Failed to stream bundle", zap.String("repo", bundle.RepoID), zap.String("id", bundle.BundleID),
+				zap.Error(err))
+			return nil, err
+		}
+	}
+
+	fs := &readOnlyFsInternal{
+		readDirMap:   make(map[fuseops.InodeID][]fuseutil.Dirent),
+		fsEntryStore: iradix.New(),
+		lookupTree:   iradix.New(),
+		fsDirStore:   iradix.New(),
+		l:            l.With(zap.Strings("bundles", served)),
+	}
+	return fs.populateMounts(mounts)
+}
+
+// checkBundleMounts cleans the paths of bundle mounts, which may not be nested in one another
+func checkBundleMounts(mounts []BundleMount) ([]BundleMount, error) {
+	if len(mounts) == 0 {
+		return nil, fmt.Errorf("no bundle to mount")
+	}
+	cleaned := make([]BundleMount, 0, len(mounts))
+	for _, mount := range mounts {
+		if mount.Bundle == nil {
+			return nil, fmt.Errorf("bundle is nil for path %q", mount.Path)
+		}
+		p := path.Clean(strings.Trim(mount.Path, "/"))
+		if p == "." || p == ".." || strings.HasPrefix(p, "../") {
+			return nil, fmt.Errorf("invalid path %q to mount bundle %s", mount.Path, mount.Bundle.BundleID)
+		}
+		for _, other := range cleaned {
+			if p == other.Path || strings.HasPrefix(p, other.Path+"/") || strings.HasPrefix(other.Path, p+"/") {
+				return nil, fmt.Errorf("bundles mounted at %q and %q overlap", other.Path, p)
+			}
+		}
+		cleaned = append(cleaned, BundleMount{Path: p, Bundle: mount.Bundle})
+	}
+	return cleaned, nil
+}
+
+// MutableFSOption configures a mutable filesystem
+type MutableFSOption func(*fsMutable)
+
+// SourceBundle seeds a mutable filesystem with the entries of an existing bundle, which becomes the parent of the
+// committed bundle.
+//
+// The files of the source bundle are streamed until they are modified, when they are staged locally.
+// Unchanged files keep their hash in the committed bundle, which has the leaf size and chunking of the source.
+func SourceBundle(source *Bundle) MutableFSOption {
+	return func(fs *fsMutable) {
+		fs.source = source
+	}
+}
+
+// RecoverStaging rebuilds a mutable filesystem interrupted before its commit, from the journal of its staging
+// directory. The filesystem is seeded again from its source bundle, if any.
+//
+// The recovered filesystem may be mounted to resume the changes, then committed.
+// The staging directory of an unwanted filesystem is cleaned up with DiscardStaging.
+func RecoverStaging() MutableFSOption {
+	return func(fs *fsMutable) {
+		fs.recovering = true
+	}
+}
+
 // NewMutableFS creates a new instance of the datamon filesystem.
-func NewMutableFS(bundle *Bundle, pathToStaging string) (*MutableFS, error) {
+//
+// Namespace operations are journaled to the staging directory, which must not hold the journal of an interrupted
+// filesystem, unless it is recovered.
+func NewMutableFS(bundle *Bundle, pathToStaging string, opts ...MutableFSOption) (*MutableFS, error) {
 	logger, _ := zap.NewProduction()
 	fs := &fsMutable{
 		bundle:       bundle,
@@ -99,10 +225,29 @@ func NewMutableFS(bundle *Bundle, pathToStaging string) (*MutableFS, error) {
 		localCache: afero.NewBasePathFs(afero.NewOsFs(), pathToStaging),
 		l:          logger.With(zap.String("bundle", bundle.BundleID)),
 	}
+	for _, apply := range opts {
+		apply(fs)
+	}
 	err := fs.initRoot()
 	if err != nil {
 		return nil, err
 	}
+	switch {
+	case fs.recovering:
+		err = fs.recoverJournal(context.Background())
+	case fs.hasStagingJournal():
+		err = fmt.Errorf("staging directory %s holds the journal of an interrupted filesystem: recover or discard it",
+			pathToStaging)
+	case fs.source != nil:
+		if err = fs.seed(context.Background()); err == nil {
+			err = fs.startJournal()
+		}
+	default:
+		err = fs.startJournal()
+	}
+	if err != nil {
+		return nil, err
+	}
 	return &MutableFS{
 		mfs:        nil,
 		fsInternal: fs,
@@ -126,9 +271,13 @@ func (dfs *ReadOnlyFS) MountReadOnly(path string) error {
 	// Reminder: Options are OS specific
 	// options := make(map[string]string)
 	// options["allow_other"] = ""
+	fsName, volumeName := "datamon", "bundles"
+	if bundle := dfs.fsInternal.bundle; bundle != nil {
+		fsName, volumeName = bundle.RepoID, bundle.BundleID
+	}
 	mountCfg := &fuse.MountConfig{
-		FSName:      dfs.fsInternal.bundle.RepoID,
-		VolumeName:  dfs.fsInternal.bundle.BundleID,
+		FSName:      fsName,
+		VolumeName:  volumeName,
 		ErrorLogger: log.New(os.Stderr, "fuse: ", log.Flags()),
 		// Options:     options,
 	}
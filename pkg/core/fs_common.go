@@ -47,6 +47,7 @@ func (fs *fsMutable) initRoot() (err error) {
 		rootPath,
 		nil,
 		fuseutil.DT_Directory,
+		0,
 		true)
 	return
 }
@@ -87,13 +88,10 @@ func (fs *fsMutable) insertLookupEntry(id fuseops.InodeID, child string, entry l
 }
 
 // Create a node. Need to hold the locks before calling.
+//
+// The permission bits of the node default to those of its type when perm is zero.
 func (fs *fsMutable) createNode(lk []byte, parentINode fuseops.InodeID, childName string,
-	entry *fuseops.ChildInodeEntry, nodeType fuseutil.DirentType, isRoot bool) error {
-
-	// Create lookup key if not already created.
-	if lk == nil {
-		lk = formLookupKey(parentINode, childName)
-	}
+	entry *fuseops.ChildInodeEntry, nodeType fuseutil.DirentType, perm os.FileMode, isRoot bool) error {
 
 	var iNodeID fuseops.InodeID
 	if !isRoot {
@@ -102,6 +100,33 @@ func (fs *fsMutable) createNode(lk []byte, parentINode fuseops.InodeID, childNam
 		iNodeID = parentINode
 	}
 
+	if nodeType != fuseutil.DT_Directory && nodeType != fuseutil.DT_Link {
+		// dont return error as open file will retry this.
+		file, err := fs.localCache.Create(fmt.Sprint(iNodeID))
+		if err == nil {
+			fs.backingFiles[iNodeID] = &file
+		} else {
+			fs.l.Error("failed to create backing file",
+				zap.Error(err),
+				zap.String("child", childName),
+				zap.Uint64("parent", uint64(parentINode)))
+		}
+	}
+	fs.linkNode(lk, iNodeID, parentINode, childName, entry, nodeType, perm, isRoot)
+	return nil
+}
+
+// Add a node to the namespace, with an allocated iNode. Need to hold the locks before calling.
+//
+// Unlike createNode, the backing file of a file is not created.
+func (fs *fsMutable) linkNode(lk []byte, iNodeID fuseops.InodeID, parentINode fuseops.InodeID, childName string,
+	entry *fuseops.ChildInodeEntry, nodeType fuseutil.DirentType, perm os.FileMode, isRoot bool) {
+
+	// Create lookup key if not already created.
+	if lk == nil {
+		lk = formLookupKey(parentINode, childName)
+	}
+
 	// lookup
 	fs.lookupTree, _, _ = fs.lookupTree.Insert(lk, lookupEntry{iNode: iNodeID})
 
@@ -110,22 +135,18 @@ func (fs *fsMutable) createNode(lk []byte, parentINode fuseops.InodeID, childNam
 	var defaultMode os.FileMode = fileDefaultMode
 	var defaultSize uint64
 
-	if nodeType == fuseutil.DT_Directory {
+	switch nodeType {
+	case fuseutil.DT_Directory:
 		linkCount = dirLinkCount
 		defaultMode = dirDefaultMode
 		defaultSize = dirInitialSize
 		fs.readDirMap[iNodeID] = make(map[fuseops.InodeID]*fuseutil.Dirent)
-	} else {
-		// dont return error as open file will retry this.
-		file, err := fs.localCache.Create(fmt.Sprint(iNodeID))
-		if err != nil {
-			fs.backingFiles[iNodeID] = &file
-		} else {
-			fs.l.Error("failed to create backing file",
-				zap.Error(err),
-				zap.String("child", childName),
-				zap.Uint64("parent", uint64(parentINode)))
-		}
+	case fuseutil.DT_Link:
+		// symbolic links have no backing file
+		defaultMode = linkDefaultMode
+	}
+	if perm != 0 && nodeType != fuseutil.DT_Link {
+		defaultMode = defaultMode&os.ModeType | perm.Perm()
 	}
 
 	d := &fuseutil.Dirent{
@@ -172,7 +193,6 @@ func (fs *fsMutable) createNode(lk []byte, parentINode fuseops.InodeID, childNam
 		entry.AttributesExpiration = time.Now().Add(cacheYearLong)
 		entry.Child = iNodeID
 	}
-	return nil
 }
 
 func getPathToBackingFile(iNode fuseops.InodeID) string {
@@ -0,0 +1,595 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+	"go.uber.org/zap"
+
+	"github.com/oneconcern/datamon/pkg/cafs"
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/model"
+)
+
+const (
+	// listings of repos, bundles, labels and branches are fetched again once they are older than this
+	explorerRefresh = time.Minute
+
+	explorerBundles  = "bundles"
+	explorerLabels   = "labels"
+	explorerBranches = "branches"
+)
+
+// ExplorerFS is a read only filesystem to browse all the repos of a context:
+//
+//	/<repo>/bundles/<bundle>/...  the files of a bundle
+//	/<repo>/labels/<label>        a symbolic link to the bundle of a label
+//	/<repo>/branches/<branch>     a symbolic link to the head of a branch
+//
+// Directories are listed when they are first browsed, and listings of repos, bundles, labels and branches are
+// refreshed once they are older than a minute. The files of a bundle are streamed as they are read,
+// and all the bundles share the memory used to cache them. Listings are released once the kernel forgets them.
+type ExplorerFS struct {
+	mfs        *fuse.MountedFileSystem // The mounted filesystem
+	fsInternal *explorerFsInternal     // The core of the filesystem
+	server     fuse.Server             // Fuse server
+}
+
+// NewExplorerFS creates a filesystem to browse the repos of a context.
+//
+// The bundle options apply to every bundle browsed, e.g. PrefetchLeaves or ConcurrentFilelistDownloads.
+func NewExplorerFS(stores context2.Stores, l *zap.Logger, bundleOpts ...BundleOption) (*ExplorerFS, error) {
+	if l == nil {
+		return nil, fmt.Errorf("logger is nil")
+	}
+	template := NewBundle(NewBDescriptor(), bundleOpts...)
+	fs := &explorerFsInternal{
+		stores:     stores,
+		bundleOpts: bundleOpts,
+		leafCache:  cafs.NewLeafCache(template.prefetchMemory, cafs.DefaultLeafSize),
+		nodes:      make(map[fuseops.InodeID]*explorerNode),
+		lastINode:  firstINode,
+		l:          l,
+	}
+	root := &explorerNode{
+		iNode:      fuseops.RootInodeID,
+		attributes: newDatamonFSEntry(generateBundleDirEntry(rootPath), time.Now(), fuseops.RootInodeID, dirLinkCount).attributes,
+		load:       (*explorerFsInternal).loadRepos,
+	}
+	fs.nodes[root.iNode] = root
+	return &ExplorerFS{
+		fsInternal: fs,
+		server:     fuseutil.NewFileSystemServer(fs),
+	}, nil
+}
+
+func (dfs *ExplorerFS) MountReadOnly(path string) error {
+	err := prepPath(path)
+	if err != nil {
+		return err
+	}
+	mountCfg := &fuse.MountConfig{
+		FSName:      "datamon",
+		VolumeName:  "explorer",
+		ErrorLogger: log.New(os.Stderr, "fuse: ", log.Flags()),
+	}
+	dfs.mfs, err = fuse.Mount(path, dfs.server, mountCfg)
+	return err
+}
+
+func (dfs *ExplorerFS) Unmount(path string) error {
+	return fuse.Unmount(path)
+}
+
+func (dfs *ExplorerFS) JoinMount(ctx context.Context) error {
+	return dfs.mfs.Join(ctx)
+}
+
+// explorerNode is a node of the explorer filesystem.
+type explorerNode struct {
+	iNode      fuseops.InodeID
+	attributes fuseops.InodeAttributes
+	target     string   // Set for symbolic links
+	file       *fsEntry // Set for the files of bundles
+	moves      bool     // Set for labels and branches, which the kernel doesn't cache
+	lookups    uint64   // References held by the kernel
+	unlinked   bool     // Set once the node is not listed anymore, until the kernel forgets it
+
+	// Directories with a loader list their children on first use. Loaders which don't set listed as well
+	// are called again once the listing is older than explorerRefresh.
+	load     func(fs *explorerFsInternal, ctx context.Context, dir *explorerNode) error
+	loadMu   sync.Mutex
+	loadedAt time.Time
+	listed   bool
+	children map[string]fuseops.InodeID
+
+	// data needed by loaders
+	repo   string
+	bundle string
+}
+
+type explorerFsInternal struct {
+	fuseutil.NotImplementedFileSystem
+
+	stores     context2.Stores
+	bundleOpts []BundleOption
+	leafCache  *cafs.LeafCache // shared by all bundles
+
+	mu        sync.Mutex // protects nodes, and the children and lookups of nodes
+	nodes     map[fuseops.InodeID]*explorerNode
+	lastINode fuseops.InodeID
+
+	l *zap.Logger
+}
+
+func (fs *explorerFsInternal) getNode(iNode fuseops.InodeID) (*explorerNode, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	node, found := fs.nodes[iNode]
+	if !found {
+		return nil, fuse.ENOENT
+	}
+	return node, nil
+}
+
+// ensureLoaded lists the children of a directory, unless they're listed already and not outdated
+func (fs *explorerFsInternal) ensureLoaded(ctx context.Context, dir *explorerNode) error {
+	if dir.load == nil {
+		return nil
+	}
+	dir.loadMu.Lock()
+	defer dir.loadMu.Unlock()
+	if dir.listed || (!dir.loadedAt.IsZero() && time.Since(dir.loadedAt) < explorerRefresh) {
+		return nil
+	}
+	if err := dir.load(fs, ctx, dir); err != nil {
+		fs.l.Error("failed to list directory",
+			zap.String("repo", dir.repo),
+			zap.String("bundle", dir.bundle),
+			zap.Uint64("inode", uint64(dir.iNode)),
+			zap.Error(err))
+		return fuse.EIO
+	}
+	dir.loadedAt = time.Now()
+	return nil
+}
+
+// setChildren replaces the children of a directory with a listing.
+//
+// Children which were listed before keep their iNode, with the attributes of the new listing.
+// Children which are not listed anymore are released.
+func (fs *explorerFsInternal) setChildren(dir *explorerNode, listing map[string]*explorerNode) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	children := make(map[string]fuseops.InodeID, len(listing))
+	for name, child := range listing {
+		if iNode, found := dir.children[name]; found {
+			existing := fs.nodes[iNode]
+			existing.attributes = child.attributes
+			existing.target = child.target
+			existing.moves = child.moves
+			children[name] = iNode
+			continue
+		}
+		children[name] = fs.addNode(child)
+	}
+	for name, iNode := range dir.children {
+		if _, found := children[name]; !found {
+			fs.unlink(fs.nodes[iNode])
+		}
+	}
+	dir.children = children
+}
+
+// unlink releases a node which is not listed anymore, unless the kernel still refers to it. The caller holds fs.mu.
+func (fs *explorerFsInternal) unlink(node *explorerNode) {
+	if node == nil {
+		return
+	}
+	if node.lookups > 0 {
+		node.unlinked = true
+		return
+	}
+	fs.releaseChildren(node)
+	delete(fs.nodes, node.iNode)
+}
+
+// releaseChildren unlinks all the nodes below a directory. The caller holds fs.mu.
+func (fs *explorerFsInternal) releaseChildren(dir *explorerNode) {
+	for _, iNode := range dir.children {
+		fs.unlink(fs.nodes[iNode])
+	}
+	dir.children = nil
+}
+
+// addNode registers a new node. The caller holds fs.mu.
+func (fs *explorerFsInternal) addNode(node *explorerNode) fuseops.InodeID {
+	fs.lastINode++
+	node.iNode = fs.lastINode
+	fs.nodes[node.iNode] = node
+	return node.iNode
+}
+
+func newExplorerDir(timestamp time.Time, repo string, load func(*explorerFsInternal, context.Context, *explorerNode) error) *explorerNode {
+	return &explorerNode{
+		attributes: newDatamonFSEntry(generateBundleDirEntry(""), timestamp, 0, dirLinkCount).attributes,
+		load:       load,
+		repo:       repo,
+	}
+}
+
+func newExplorerSymlink(timestamp time.Time, target string) *explorerNode {
+	entry := &model.BundleEntry{FileMode: os.ModeSymlink | os.ModePerm, Target: target, Size: uint64(len(target))}
+	return &explorerNode{
+		attributes: newDatamonFSEntry(entry, timestamp, 0, fileLinkCount).attributes,
+		target:     target,
+		moves:      true,
+	}
+}
+
+// validExplorerName tells if some name may be used as a file name
+func validExplorerName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+func (fs *explorerFsInternal) loadRepos(ctx context.Context, root *explorerNode) error {
+	repos, err := ListRepos(fs.stores)
+	if err != nil {
+		return err
+	}
+	listing := make(map[string]*explorerNode, len(repos))
+	for _, repo := range repos {
+		if validExplorerName(repo.Name) {
+			listing[repo.Name] = newExplorerDir(repo.Timestamp, repo.Name, (*explorerFsInternal).loadRepo)
+		}
+	}
+	fs.setChildren(root, listing)
+	return nil
+}
+
+func (fs *explorerFsInternal) loadRepo(ctx context.Context, dir *explorerNode) error {
+	timestamp := dir.attributes.Mtime
+	fs.setChildren(dir, map[string]*explorerNode{
+		explorerBundles:  newExplorerDir(timestamp, dir.repo, (*explorerFsInternal).loadBundles),
+		explorerLabels:   newExplorerDir(timestamp, dir.repo, (*explorerFsInternal).loadLabels),
+		explorerBranches: newExplorerDir(timestamp, dir.repo, (*explorerFsInternal).loadBranches),
+	})
+	dir.listed = true
+	return nil
+}
+
+func (fs *explorerFsInternal) loadBundles(ctx context.Context, dir *explorerNode) error {
+	bundles, err := ListBundles(dir.repo, fs.stores)
+	if err != nil {
+		return err
+	}
+	listing := make(map[string]*explorerNode, len(bundles))
+	for _, bundle := range bundles {
+		if !validExplorerName(bundle.ID) {
+			continue
+		}
+		node := newExplorerDir(bundle.Timestamp, dir.repo, (*explorerFsInternal).loadBundle)
+		node.bundle = bundle.ID
+		listing[bundle.ID] = node
+	}
+	fs.setChildren(dir, listing)
+	return nil
+}
+
+func (fs *explorerFsInternal) loadLabels(ctx context.Context, dir *explorerNode) error {
+	labels, err := ListLabels(dir.repo, fs.stores, "")
+	if err != nil {
+		return err
+	}
+	listing := make(map[string]*explorerNode, len(labels))
+	for _, label := range labels {
+		if validExplorerName(label.Name) {
+			listing[label.Name] = newExplorerSymlink(label.Timestamp, path.Join("..", explorerBundles, label.BundleID))
+		}
+	}
+	fs.setChildren(dir, listing)
+	return nil
+}
+
+func (fs *explorerFsInternal) loadBranches(ctx context.Context, dir *explorerNode) error {
+	branches, err := ListBranches(ctx, fs.stores, dir.repo)
+	if err != nil {
+		return err
+	}
+	listing := make(map[string]*explorerNode, len(branches))
+	for _, branch := range branches {
+		if branch.BundleID != "" && validExplorerName(branch.Name) {
+			listing[branch.Name] = newExplorerSymlink(branch.Timestamp, path.Join("..", explorerBundles, branch.BundleID))
+		}
+	}
+	fs.setChildren(dir, listing)
+	return nil
+}
+
+// loadBundle downloads the file list of a bundle, and adds all its entries to the filesystem
+func (fs *explorerFsInternal) loadBundle(ctx context.Context, dir *explorerNode) error {
+	opts := append([]BundleOption{
+		Repo(dir.repo),
+		BundleID(dir.bundle),
+		ContextStores(fs.stores),
+		Streaming(true),
+	}, fs.bundleOpts...)
+	bundle := NewBundle(NewBDescriptor(), opts...)
+	if err := DownloadMetadata(ctx, bundle); err != nil {
+		return err
+	}
+	bundle.leafCache = fs.leafCache
+	if err := bundle.initStreaming(); err != nil {
+		return err
+	}
+	fs.l.Info("Populating bundle",
+		zap.String("repo", dir.repo),
+		zap.String("bundle ID", dir.bundle),
+		zap.Int("entryCount", len(bundle.BundleEntries)),
+	)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	dirs := map[string]*explorerNode{"": dir}
+	dir.children = make(map[string]fuseops.InodeID)
+	var mkdirAll func(name string) *explorerNode
+	mkdirAll = func(name string) *explorerNode {
+		if d, found := dirs[name]; found {
+			return d
+		}
+		parent := mkdirAll(parentPath(name))
+		d := &explorerNode{
+			attributes: newDatamonFSEntry(generateBundleDirEntry(name), bundle.BundleDescriptor.Timestamp, 0, dirLinkCount).attributes,
+			listed:     true,
+			children:   make(map[string]fuseops.InodeID),
+		}
+		parent.children[path.Base(name)] = fs.addNode(d)
+		dirs[name] = d
+		return d
+	}
+
+	for _, bundleEntry := range bundle.GetBundleEntries() {
+		be := bundleEntry
+		if be.IsDir() {
+			mkdirAll(be.NameWithPath).attributes = newDatamonFSEntry(&be, bundle.BundleDescriptor.Timestamp, 0, dirLinkCount).attributes
+			continue
+		}
+		parent := mkdirAll(parentPath(be.NameWithPath))
+		node := &explorerNode{}
+		iNode := fs.addNode(node)
+		entry := newDatamonFSEntry(&be, bundle.BundleDescriptor.Timestamp, iNode, fileLinkCount)
+		entry.bundle = bundle
+		entry.name = be.NameWithPath
+		node.attributes = entry.attributes
+		node.target = entry.target
+		if !be.IsSymlink() {
+			node.file = entry
+		}
+		parent.children[path.Base(be.NameWithPath)] = iNode
+	}
+	dir.listed = true
+	return nil
+}
+
+// parentPath returns the parent of a path in a bundle, "" for the root of the bundle
+func parentPath(name string) string {
+	parent := path.Dir(name)
+	if parent == "." || parent == "/" {
+		return ""
+	}
+	return parent
+}
+
+// expiration tells until when the kernel may cache a node. The caller holds fs.mu.
+func (node *explorerNode) expiration() time.Time {
+	if node.moves {
+		return time.Now()
+	}
+	return time.Now().Add(cacheYearLong)
+}
+
+func (fs *explorerFsInternal) StatFS(
+	ctx context.Context,
+	op *fuseops.StatFSOp) (err error) {
+	return statFS()
+}
+
+func (fs *explorerFsInternal) LookUpInode(ctx context.Context, op *fuseops.LookUpInodeOp) error {
+	parent, err := fs.getNode(op.Parent)
+	if err != nil {
+		return err
+	}
+	if err = fs.ensureLoaded(ctx, parent); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	iNode, found := parent.children[op.Name]
+	if !found {
+		return fuse.ENOENT
+	}
+	child := fs.nodes[iNode]
+	child.lookups++
+	op.Entry.Child = child.iNode
+	op.Entry.Generation = 1
+	op.Entry.Attributes = child.attributes
+	op.Entry.AttributesExpiration = child.expiration()
+	op.Entry.EntryExpiration = op.Entry.AttributesExpiration
+	return nil
+}
+
+func (fs *explorerFsInternal) GetInodeAttributes(
+	ctx context.Context,
+	op *fuseops.GetInodeAttributesOp) error {
+	node, err := fs.getNode(op.Inode)
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	op.Attributes = node.attributes
+	op.AttributesExpiration = node.expiration()
+	return nil
+}
+
+// ForgetInode releases the nodes which the kernel doesn't refer to anymore.
+//
+// Nodes which are not listed anymore are released once forgotten. A forgotten directory with a loader releases
+// its children, which are loaded again on next use: the trees of the bundles no longer browsed don't stay in memory.
+func (fs *explorerFsInternal) ForgetInode(
+	ctx context.Context,
+	op *fuseops.ForgetInodeOp) error {
+	node, err := fs.getNode(op.Inode)
+	if err != nil || node.iNode == fuseops.RootInodeID {
+		return nil
+	}
+	if node.load != nil {
+		node.loadMu.Lock()
+		defer node.loadMu.Unlock()
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if op.N < node.lookups {
+		node.lookups -= op.N
+		return nil
+	}
+	node.lookups = 0
+	switch {
+	case node.unlinked:
+		fs.unlink(node)
+	case node.load != nil:
+		fs.releaseChildren(node)
+		node.listed = false
+		node.loadedAt = time.Time{}
+	}
+	return nil
+}
+
+func (fs *explorerFsInternal) OpenDir(ctx context.Context, op *fuseops.OpenDirOp) error {
+	dir, err := fs.getNode(op.Inode)
+	if err != nil {
+		return err
+	}
+	if !dir.attributes.Mode.IsDir() {
+		return fuse.ENOTDIR
+	}
+	return fs.ensureLoaded(ctx, dir)
+}
+
+func (fs *explorerFsInternal) ReadDir(ctx context.Context, op *fuseops.ReadDirOp) error {
+	dir, err := fs.getNode(op.Inode)
+	if err != nil {
+		return err
+	}
+	if err = fs.ensureLoaded(ctx, dir); err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	names := make([]string, 0, len(dir.children))
+	for name := range dir.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	dirents := make([]fuseutil.Dirent, 0, len(names))
+	for i, name := range names {
+		child := fs.nodes[dir.children[name]]
+		direntType := fuseutil.DT_File
+		switch {
+		case child.attributes.Mode.IsDir():
+			direntType = fuseutil.DT_Directory
+		case child.attributes.Mode&os.ModeSymlink != 0:
+			direntType = fuseutil.DT_Link
+		}
+		dirents = append(dirents, fuseutil.Dirent{
+			Offset: fuseops.DirOffset(i + 1),
+			Inode:  child.iNode,
+			Name:   name,
+			Type:   direntType,
+		})
+	}
+	fs.mu.Unlock()
+
+	offset := int(op.Offset)
+	if offset > len(dirents) {
+		return fuse.ENOENT
+	}
+	for _, dirent := range dirents[offset:] {
+		n := fuseutil.WriteDirent(op.Dst[op.BytesRead:], dirent)
+		if n == 0 {
+			break
+		}
+		op.BytesRead += n
+	}
+	return nil
+}
+
+func (fs *explorerFsInternal) ReleaseDirHandle(
+	ctx context.Context,
+	op *fuseops.ReleaseDirHandleOp) error {
+	return nil
+}
+
+func (fs *explorerFsInternal) OpenFile(
+	ctx context.Context,
+	op *fuseops.OpenFileOp) error {
+	node, err := fs.getNode(op.Inode)
+	if err != nil {
+		return err
+	}
+	if node.file == nil {
+		return fuse.EINVAL
+	}
+	return nil
+}
+
+func (fs *explorerFsInternal) ReadFile(
+	ctx context.Context,
+	op *fuseops.ReadFileOp) error {
+	node, err := fs.getNode(op.Inode)
+	if err != nil {
+		return err
+	}
+	if node.file == nil {
+		return fuse.EINVAL
+	}
+	fs.l.Debug("reading file", zap.String("file", node.file.fullPath), zap.Uint64("inode", uint64(node.iNode)))
+	op.BytesRead, err = node.file.bundle.ReadAt(node.file, op.Dst, op.Offset)
+	return err
+}
+
+func (fs *explorerFsInternal) ReleaseFileHandle(
+	ctx context.Context,
+	op *fuseops.ReleaseFileHandleOp) error {
+	return nil
+}
+
+func (fs *explorerFsInternal) ReadSymlink(
+	ctx context.Context,
+	op *fuseops.ReadSymlinkOp) error {
+	node, err := fs.getNode(op.Inode)
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if node.attributes.Mode&os.ModeSymlink == 0 {
+		return fuse.EINVAL
+	}
+	op.Target = node.target
+	return nil
+}
+
+func (fs *explorerFsInternal) Destroy() {
+	fs.l.Info("Destroy explorer")
+}
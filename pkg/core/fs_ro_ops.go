@@ -29,8 +29,6 @@ func (fs *readOnlyFsInternal) opStart(op interface{}) {
 	case *fuseops.ReadFileOp:
 		fs.l.Debug("Start",
 			zap.String("Request", fmt.Sprintf("%T", op)),
-			zap.String("repo", fs.bundle.RepoID),
-			zap.String("bundle", fs.bundle.BundleID),
 			zap.Uint64("inode", uint64(t.Inode)),
 			zap.Int("buffer", len(t.Dst)),
 			zap.Int64("offset", t.Offset),
@@ -39,24 +37,18 @@ func (fs *readOnlyFsInternal) opStart(op interface{}) {
 	case *fuseops.WriteFileOp:
 		fs.l.Debug("Start",
 			zap.String("Request", fmt.Sprintf("%T", op)),
-			zap.String("repo", fs.bundle.RepoID),
-			zap.String("bundle", fs.bundle.BundleID),
 			zap.Uint64("inode", uint64(t.Inode)),
 		)
 		return
 	case *fuseops.ReadDirOp:
 		fs.l.Debug("Start",
 			zap.String("Request", fmt.Sprintf("%T", op)),
-			zap.String("repo", fs.bundle.RepoID),
-			zap.String("bundle", fs.bundle.BundleID),
 			zap.Uint64("inode", uint64(t.Inode)),
 		)
 		return
 	}
 	fs.l.Debug("Start",
 		zap.String("Request", fmt.Sprintf("%T", op)),
-		zap.String("repo", fs.bundle.RepoID),
-		zap.String("bundle", fs.bundle.BundleID),
 		zap.Any("op", op),
 	)
 }
@@ -65,8 +57,6 @@ func (fs *readOnlyFsInternal) opEnd(op interface{}, err error) {
 	case *fuseops.ReadFileOp:
 		fs.l.Debug("End",
 			zap.String("Request", fmt.Sprintf("%T", op)),
-			zap.String("repo", fs.bundle.RepoID),
-			zap.String("bundle", fs.bundle.BundleID),
 			zap.Uint64("inode", uint64(t.Inode)),
 			zap.Int64("offset", t.Offset),
 			zap.Error(err),
@@ -75,8 +65,6 @@ func (fs *readOnlyFsInternal) opEnd(op interface{}, err error) {
 	case *fuseops.WriteFileOp:
 		fs.l.Debug("End",
 			zap.String("Request", fmt.Sprintf("%T", op)),
-			zap.String("repo", fs.bundle.RepoID),
-			zap.String("bundle", fs.bundle.BundleID),
 			zap.Uint64("inode", uint64(t.Inode)),
 			zap.Error(err),
 		)
@@ -84,8 +72,6 @@ func (fs *readOnlyFsInternal) opEnd(op interface{}, err error) {
 	case *fuseops.ReadDirOp:
 		fs.l.Debug("End",
 			zap.String("Request", fmt.Sprintf("%T", op)),
-			zap.String("repo", fs.bundle.RepoID),
-			zap.String("bundle", fs.bundle.BundleID),
 			zap.Uint64("inode", uint64(t.Inode)),
 			zap.Error(err),
 		)
@@ -93,8 +79,6 @@ func (fs *readOnlyFsInternal) opEnd(op interface{}, err error) {
 	}
 	fs.l.Debug("End",
 		zap.String("Request", fmt.Sprintf("%T", op)),
-		zap.String("repo", fs.bundle.RepoID),
-		zap.String("bundle", fs.bundle.BundleID),
 		zap.Any("op", op),
 		zap.Error(err),
 	)
@@ -310,7 +294,7 @@ func (fs *readOnlyFsInternal) ReadFile(
 	fe := typeAssertToFsEntry(p)
 	fs.l.Debug("reading file", zap.String("file", fe.fullPath), zap.Uint64("inode", uint64(fe.iNode)))
 
-	n, err := fs.bundle.ReadAt(fe, op.Dst, op.Offset)
+	n, err := fe.bundle.ReadAt(fe, op.Dst, op.Offset)
 	op.BytesRead = n
 	return err
 }
@@ -355,8 +339,18 @@ func (fs *readOnlyFsInternal) ReadSymlink(
 	op *fuseops.ReadSymlinkOp) (err error) {
 	fs.opStart(op)
 	defer fs.opEnd(op, err)
-	err = fuse.ENOSYS
-	return
+	p, found := fs.fsEntryStore.Get(formKey(op.Inode))
+	if !found {
+		err = fuse.ENOENT
+		return
+	}
+	fe := typeAssertToFsEntry(p)
+	if fe.attributes.Mode&os.ModeSymlink == 0 {
+		err = fuse.EINVAL
+		return
+	}
+	op.Target = fe.target
+	return nil
 }
 
 func (fs *readOnlyFsInternal) RemoveXattr(
@@ -396,10 +390,7 @@ func (fs *readOnlyFsInternal) SetXattr(
 }
 
 func (fs *readOnlyFsInternal) Destroy() {
-	fs.l.Info("Destroy",
-		zap.String("repo", fs.bundle.RepoID),
-		zap.String("bundle", fs.bundle.BundleID),
-	)
+	fs.l.Info("Destroy")
 }
 
 func isDir(fsEntry *fsEntry) bool {
@@ -408,12 +399,19 @@ func isDir(fsEntry *fsEntry) bool {
 
 func newDatamonFSEntry(bundleEntry *model.BundleEntry, time time.Time, id fuseops.InodeID, linkCount uint32) *fsEntry {
 	var mode os.FileMode = fileReadOnlyMode
-	if bundleEntry.Hash == "" {
+	switch {
+	case bundleEntry.IsDir():
 		mode = dirReadOnlyMode
+	case bundleEntry.IsSymlink():
+		mode = os.ModeSymlink | os.ModePerm
+	case bundleEntry.HasFileMode():
+		// keep the execute bits, the mount is read only
+		mode = bundleEntry.FileMode.Perm() &^ 0222
 	}
 	return &fsEntry{
 		fullPath: bundleEntry.NameWithPath,
 		hash:     bundleEntry.Hash,
+		target:   bundleEntry.Target,
 		iNode:    id,
 		attributes: fuseops.InodeAttributes{
 			Size:   bundleEntry.Size,
@@ -459,6 +457,7 @@ func populateFSBundleEntryToNodes(
 	txns *populateFSTxns,
 	nodesToAdd []fsNodeToAdd,
 	iNode *fuseops.InodeID,
+	prefix string,
 	bundleEntry model.BundleEntry,
 ) []fsNodeToAdd {
 
@@ -468,13 +467,20 @@ func populateFSBundleEntryToNodes(
 	}
 
 	be := bundleEntry
+	be.NameWithPath = path.Join(prefix, be.NameWithPath)
+	linkCount := fileLinkCount
+	if be.IsDir() {
+		linkCount = dirLinkCount
+	}
 	// Generate the fsEntry
 	newFsEntry := newDatamonFSEntry(
 		&be,
 		bundle.BundleDescriptor.Timestamp,
 		generateNextINode(iNode),
-		fileLinkCount,
+		linkCount,
 	)
+	newFsEntry.bundle = bundle
+	newFsEntry.name = bundleEntry.NameWithPath
 
 	// Add parents if first visit
 	// If a parent has been visited, all the parent's parents in the path have been visited
@@ -571,6 +577,7 @@ func populateFSAddBundleEntry(
 	txns *populateFSTxns,
 	nodesToAdd []fsNodeToAdd,
 	iNode *fuseops.InodeID,
+	prefix string,
 	bundleEntry model.BundleEntry,
 ) error {
 
@@ -580,12 +587,13 @@ func populateFSAddBundleEntry(
 		txns,
 		nodesToAdd,
 		iNode,
+		prefix,
 		bundleEntry,
 	)
 
 	fs.l.Debug("Nodes added",
-		zap.String("repo", fs.bundle.RepoID),
-		zap.String("bundle ID", fs.bundle.BundleID),
+		zap.String("repo", bundle.RepoID),
+		zap.String("bundle ID", bundle.BundleID),
 		zap.Int("count", len(nodesToAdd)),
 	)
 	if err := populateFSAddNodes(
@@ -602,20 +610,36 @@ func populateFSAddBundleEntries(
 	fs *readOnlyFsInternal,
 	bundle *Bundle,
 	txns *populateFSTxns,
+	iNode *fuseops.InodeID,
+	prefix string,
 ) error {
 
 	// For a Bundle Entry there might be intermediate directories that need adding.
 	var nodesToAdd []fsNodeToAdd
-	// iNode for fs entries
-	var iNode = firstINode
 
-	for _, bundleEntry := range fs.bundle.GetBundleEntries() {
+	if prefix != "" {
+		// The directory of the bundle exists even when the bundle is empty.
 		if err := populateFSAddBundleEntry(
 			fs,
 			bundle,
 			txns,
 			nodesToAdd,
-			&iNode,
+			iNode,
+			"",
+			*generateBundleDirEntry(prefix),
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, bundleEntry := range bundle.GetBundleEntries() {
+		if err := populateFSAddBundleEntry(
+			fs,
+			bundle,
+			txns,
+			nodesToAdd,
+			iNode,
+			prefix,
 			bundleEntry,
 		); err != nil {
 			return err
@@ -627,15 +651,29 @@ func populateFSAddBundleEntries(
 }
 
 func (fs *readOnlyFsInternal) populateFS(bundle *Bundle) (*ReadOnlyFS, error) {
+	return fs.populateMounts([]BundleMount{{Bundle: bundle}})
+}
+
+// populateMounts populates the filesystem with the entries of several bundles, each under its own path.
+// A bundle mounted at the root has an empty path.
+func (fs *readOnlyFsInternal) populateMounts(mounts []BundleMount) (*ReadOnlyFS, error) {
 	txns := new(populateFSTxns)
 	txns.dirStore = fs.fsDirStore.Txn()
 	txns.lookupTree = fs.lookupTree.Txn()
 	txns.fsEntryStore = fs.fsEntryStore.Txn()
 
+	// The root is as old as the latest bundle.
+	var rootTime time.Time
+	for _, mount := range mounts {
+		if mount.Bundle.BundleDescriptor.Timestamp.After(rootTime) {
+			rootTime = mount.Bundle.BundleDescriptor.Timestamp
+		}
+	}
+
 	// Add root.
 	dirFsEntry := newDatamonFSEntry(
 		generateBundleDirEntry(rootPath),
-		bundle.BundleDescriptor.Timestamp,
+		rootTime,
 		fuseops.RootInodeID,
 		dirLinkCount,
 	)
@@ -647,27 +685,32 @@ func (fs *readOnlyFsInternal) populateFS(bundle *Bundle) (*ReadOnlyFS, error) {
 		return nil, err
 	}
 
-	fs.l.Info("Populating fs",
-		zap.String("repo", fs.bundle.RepoID),
-		zap.String("bundle ID", fs.bundle.BundleID),
-		zap.Int("entryCount", len(fs.bundle.BundleEntries)),
-	)
+	// iNode for fs entries
+	var iNode = firstINode
 
-	if err := populateFSAddBundleEntries(
-		fs,
-		bundle,
-		txns,
-	); err != nil {
-		return nil, err
+	for _, mount := range mounts {
+		fs.l.Info("Populating fs",
+			zap.String("repo", mount.Bundle.RepoID),
+			zap.String("bundle ID", mount.Bundle.BundleID),
+			zap.String("path", mount.Path),
+			zap.Int("entryCount", len(mount.Bundle.BundleEntries)),
+		)
+
+		if err := populateFSAddBundleEntries(
+			fs,
+			mount.Bundle,
+			txns,
+			&iNode,
+			mount.Path,
+		); err != nil {
+			return nil, err
+		}
 	}
 
 	txns.commitToFS(fs)
 
 	fs.isReadOnly = true
-	fs.l.Info("Populating fs done",
-		zap.String("repo", fs.bundle.RepoID),
-		zap.String("bundle ID", fs.bundle.BundleID),
-	)
+	fs.l.Info("Populating fs done", zap.Int("bundles", len(mounts)))
 	return &ReadOnlyFS{
 		fsInternal: fs,
 		server:     fuseutil.NewFileSystemServer(fs),
@@ -695,6 +738,11 @@ func (fs *readOnlyFsInternal) insertDatamonFSDirEntry(
 		return errors.New("fsEntryStore updates are not expected: /")
 	}
 
+	// empty directories have entries to read as well
+	if _, found := fs.readDirMap[dirFsEntry.iNode]; !found {
+		fs.readDirMap[dirFsEntry.iNode] = make([]fuseutil.Dirent, 0)
+	}
+
 	if dirFsEntry.iNode != fuseops.RootInodeID {
 		key = formLookupKey(parentInode, path.Base(dirFsEntry.fullPath))
 
@@ -746,12 +794,16 @@ func (fs *readOnlyFsInternal) insertDatamonFSEntry(
 		return errors.New("lookupTree updates are not expected: " + fsEntry.fullPath)
 	}
 
+	direntType := fuseutil.DT_File
+	if fsEntry.attributes.Mode&os.ModeSymlink != 0 {
+		direntType = fuseutil.DT_Link
+	}
 	childEntries := fs.readDirMap[parentInode]
 	childEntries = append(childEntries, fuseutil.Dirent{
 		Offset: fuseops.DirOffset(len(childEntries) + 1),
 		Inode:  fsEntry.iNode,
 		Name:   path.Base(fsEntry.fullPath),
-		Type:   fuseutil.DT_File,
+		Type:   direntType,
 	})
 	fs.readDirMap[parentInode] = childEntries
 
@@ -760,7 +812,7 @@ func (fs *readOnlyFsInternal) insertDatamonFSEntry(
 
 type readOnlyFsInternal struct {
 
-	// Backing bundle for this FS.
+	// Backing bundle for this FS, nil when several bundles are mounted.
 	bundle *Bundle
 
 	// Get iNode for path. This is needed to generate directory entries without imposing a strict order of traversal.
@@ -785,7 +837,8 @@ type readOnlyFsInternal struct {
 
 // fsEntry is a node in the filesystem.
 type fsEntry struct {
-	hash string // Set for files, empty for directories
+	hash   string // Set for files, empty for directories and symbolic links
+	target string // Set for symbolic links
 
 	// iNode ID is generated on the fly for a bundle that is committed. Since the file list
 	// for a bundle is static and the list of files is frozen, multiple mounts of the same
@@ -794,6 +847,11 @@ type fsEntry struct {
 	iNode      fuseops.InodeID         // Unique ID for Fuse
 	attributes fuseops.InodeAttributes // Fuse Attributes
 	fullPath   string
+
+	// Set for the entries of a bundle: the bundle holding the entry, and the path of the entry in this bundle,
+	// which differs from the full path when several bundles are mounted.
+	bundle *Bundle
+	name   string
 }
 
 type fsNodeToAdd struct {
@@ -0,0 +1,353 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/spf13/afero"
+	"go.uber.org/zap"
+)
+
+// stagingJournalFile is the name of the journal in the staging directory. Backing files are named after their
+// iNode, so that they never collide with it.
+const stagingJournalFile = ".datamon-journal"
+
+// Kinds of records in a staging journal
+const (
+	stagingHeader  = "header"
+	stagingCreate  = "create"
+	stagingRename  = "rename"
+	stagingUnlink  = "unlink"
+	stagingSetAttr = "setattr"
+	stagingStage   = "stage"
+)
+
+// stagingRecord is a line of a staging journal.
+//
+// A staging journal is an append-only file of JSON records, next to the backing files of a mutable filesystem.
+// It records the namespace operations, which are otherwise only kept in memory:
+//   - a header, with the repo and the source bundle, if any
+//   - create, rename and unlink records, with the iNodes and names involved
+//   - setattr records, with the new mode or modification time of a node
+//   - stage records, when a file of the source bundle gets its backing file
+//
+// File sizes are not journaled: they are those of the backing files.
+// A record is written on a single line: a truncated last line, left by a crash, is ignored.
+type stagingRecord struct {
+	Kind string `json:"kind"`
+
+	// header
+	Repo   string `json:"repo,omitempty"`
+	Source string `json:"source,omitempty"`
+
+	// create, rename, unlink
+	Parent fuseops.InodeID     `json:"parent,omitempty"`
+	Name   string              `json:"name,omitempty"`
+	Type   fuseutil.DirentType `json:"type,omitempty"`
+	Target string              `json:"target,omitempty"`
+
+	// rename
+	NewParent fuseops.InodeID `json:"newParent,omitempty"`
+	NewName   string          `json:"newName,omitempty"`
+
+	// create, setattr, stage
+	Inode fuseops.InodeID `json:"inode,omitempty"`
+	Mode  *os.FileMode    `json:"mode,omitempty"`
+	Mtime *time.Time      `json:"mtime,omitempty"`
+}
+
+type stagingJournal struct {
+	mu sync.Mutex
+	fs afero.Fs
+	f  afero.File
+}
+
+func openStagingJournal(staging afero.Fs) (*stagingJournal, error) {
+	f, err := staging.OpenFile(stagingJournalFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open staging journal: %w", err)
+	}
+	return &stagingJournal{fs: staging, f: f}, nil
+}
+
+func readStagingJournal(staging afero.Fs) ([]stagingRecord, error) {
+	f, err := staging.Open(stagingJournalFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open staging journal: %w", err)
+	}
+	defer f.Close()
+
+	var records []stagingRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record stagingRecord
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// truncated record, left by a crash
+			break
+		}
+		records = append(records, record)
+	}
+	if len(records) == 0 || records[0].Kind != stagingHeader {
+		return nil, fmt.Errorf("invalid staging journal: no header")
+	}
+	return records, nil
+}
+
+// rewriteStagingJournal replaces the journal with some records, leaving out what follows them, such as a truncated
+// record. The records are written to a temporary file, renamed over the journal.
+func rewriteStagingJournal(staging afero.Fs, records []stagingRecord) error {
+	tmp := stagingJournalFile + ".tmp"
+	f, err := staging.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite staging journal: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	for _, record := range records {
+		var b []byte
+		if b, err = json.Marshal(record); err != nil {
+			break
+		}
+		if _, err = w.Write(append(b, '\n')); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = staging.Rename(tmp, stagingJournalFile)
+	}
+	if err != nil {
+		_ = staging.Remove(tmp)
+		return fmt.Errorf("failed to rewrite staging journal: %w", err)
+	}
+	return nil
+}
+
+// append writes a record, synced before the operation is acknowledged
+func (j *stagingJournal) append(record stagingRecord) error {
+	if j == nil {
+		return nil
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return fmt.Errorf("staging journal is closed")
+	}
+	if _, err = j.f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("failed to write staging journal: %w", err)
+	}
+	return j.f.Sync()
+}
+
+// remove the journal of a committed filesystem
+func (j *stagingJournal) remove() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	_ = j.f.Close()
+	j.f = nil
+	return j.fs.Remove(stagingJournalFile)
+}
+
+// journalOp records a namespace operation. The operation fails when it can't be journaled.
+func (fs *fsMutable) journalOp(record stagingRecord) error {
+	if err := fs.journal.append(record); err != nil {
+		fs.l.Error("failed to journal operation", zap.String("kind", record.Kind), zap.Error(err))
+		return fuse.EIO
+	}
+	return nil
+}
+
+// hasStagingJournal tells if the staging directory holds the journal of an interrupted filesystem
+func (fs *fsMutable) hasStagingJournal() bool {
+	_, err := fs.localCache.Stat(stagingJournalFile)
+	return err == nil
+}
+
+// startJournal starts the journal of a new filesystem
+func (fs *fsMutable) startJournal() error {
+	journal, err := openStagingJournal(fs.localCache)
+	if err != nil {
+		return err
+	}
+	fs.journal = journal
+	header := stagingRecord{Kind: stagingHeader, Repo: fs.bundle.RepoID}
+	if fs.source != nil {
+		header.Source = fs.source.BundleID
+	}
+	return fs.journal.append(header)
+}
+
+// recoverJournal rebuilds the namespace of an interrupted filesystem from its journal: the source bundle is seeded
+// again, then the journaled operations are replayed with the same iNodes, so that they find their backing files.
+func (fs *fsMutable) recoverJournal(ctx context.Context) error {
+	records, err := readStagingJournal(fs.localCache)
+	if err != nil {
+		return err
+	}
+	header := records[0]
+	if header.Repo != fs.bundle.RepoID {
+		return fmt.Errorf("staging journal is for repo %s, not %s", header.Repo, fs.bundle.RepoID)
+	}
+	if header.Source != "" {
+		if fs.source == nil {
+			fs.source = NewBundle(NewBDescriptor(),
+				Repo(header.Repo),
+				BundleID(header.Source),
+				ContextStores(fs.bundle.contextStores),
+			)
+		}
+		if fs.source.BundleID != header.Source {
+			return fmt.Errorf("staging journal is seeded from bundle %s, not %s", header.Source, fs.source.BundleID)
+		}
+		if err = fs.seed(ctx); err != nil {
+			return err
+		}
+	}
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	for _, record := range records[1:] {
+		if err = fs.replay(record); err != nil {
+			return fmt.Errorf("failed to replay staging journal: %s %q: %w", record.Kind, record.Name, err)
+		}
+	}
+	fs.resizeStaged()
+
+	// the next records must not follow a truncated record, which would hide them at the next recovery
+	if err = rewriteStagingJournal(fs.localCache, records); err != nil {
+		return err
+	}
+	if fs.journal, err = openStagingJournal(fs.localCache); err != nil {
+		return err
+	}
+	fs.l.Info("recovered from staging journal",
+		zap.String("source", header.Source),
+		zap.Int("operations", len(records)-1))
+	return nil
+}
+
+// replay applies a journaled operation. Need to hold the locks before calling.
+func (fs *fsMutable) replay(record stagingRecord) error {
+	switch record.Kind {
+	case stagingCreate:
+		if err := fs.preCreateCheck(record.Parent, formLookupKey(record.Parent, record.Name)); err != nil {
+			return err
+		}
+		if record.Type != fuseutil.DT_Directory && record.Type != fuseutil.DT_Link {
+			if _, err := fs.localCache.Stat(getPathToBackingFile(record.Inode)); os.IsNotExist(err) {
+				// the crash occurred before the backing file was created
+				file, err := fs.localCache.Create(getPathToBackingFile(record.Inode))
+				if err != nil {
+					return err
+				}
+				_ = file.Close()
+			}
+		}
+		fs.linkNode(nil, record.Inode, record.Parent, record.Name, nil, record.Type, 0, false)
+		n := fs.node(record.Inode)
+		if record.Mode != nil {
+			n.attr.Mode = *record.Mode
+		}
+		if record.Type == fuseutil.DT_Link {
+			n.target = record.Target
+			n.attr.Size = uint64(len(record.Target))
+		}
+		if record.Inode > fs.iNodeGenerator.highestInode {
+			fs.iNodeGenerator.highestInode = record.Inode
+		}
+	case stagingRename:
+		return fs.rename(record.Parent, record.Name, record.NewParent, record.NewName)
+	case stagingUnlink:
+		return fs.deleteNSEntry(record.Parent, record.Name)
+	case stagingSetAttr:
+		n := fs.node(record.Inode)
+		if n == nil {
+			return fuse.ENOENT
+		}
+		if record.Mode != nil {
+			n.attr.Mode = n.attr.Mode&os.ModeType | record.Mode.Perm()
+		}
+		if record.Mtime != nil {
+			n.attr.Mtime = *record.Mtime
+		}
+	case stagingStage:
+		n := fs.node(record.Inode)
+		if n == nil {
+			return fuse.ENOENT
+		}
+		n.hash = ""
+	}
+	return nil
+}
+
+// node returns the node of an iNode, nil if not found. Need to hold the locks before calling.
+func (fs *fsMutable) node(iNode fuseops.InodeID) *nodeEntry {
+	e, found := fs.iNodeStore.Get(formKey(iNode))
+	if !found {
+		return nil
+	}
+	return e.(*nodeEntry)
+}
+
+// resizeStaged sets the size of the files with a backing file, which is not journaled. Need to hold the locks
+// before calling.
+func (fs *fsMutable) resizeStaged() {
+	fs.iNodeStore.Root().Walk(func(_ []byte, v interface{}) bool {
+		n := v.(*nodeEntry)
+		if !n.attr.Mode.IsRegular() || n.hash != "" {
+			return false
+		}
+		if info, err := fs.localCache.Stat(n.pathToBackingFile); err == nil {
+			n.attr.Size = uint64(info.Size())
+		}
+		return false
+	})
+}
+
+// DiscardStaging removes the journal and the backing files of an interrupted mutable filesystem.
+func DiscardStaging(pathToStaging string) error {
+	staging := afero.NewBasePathFs(afero.NewOsFs(), pathToStaging)
+	if _, err := staging.Stat(stagingJournalFile); err != nil {
+		return fmt.Errorf("no staging journal in %s: %w", pathToStaging, err)
+	}
+	infos, err := afero.ReadDir(staging, "/")
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if _, err = strconv.ParseUint(info.Name(), 10, 64); err != nil || info.IsDir() {
+			continue
+		}
+		if err = staging.Remove(info.Name()); err != nil {
+			return err
+		}
+	}
+	// the journal goes last, so that an interrupted discard may be run again
+	return staging.Remove(stagingJournalFile)
+}
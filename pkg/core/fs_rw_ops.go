@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"path"
 	"sync"
 	"time"
 
@@ -27,6 +28,9 @@ type fsMutable struct {
 	// Bundle to commit.
 	bundle *Bundle
 
+	// Bundle the filesystem is seeded with, if any.
+	source *Bundle
+
 	// Get fsEntry for an iNode. Speed up stat and other calls keyed by iNode
 	iNodeStore *iradix.Tree
 
@@ -48,6 +52,12 @@ type fsMutable struct {
 	// local fs cache that mirrors the files.
 	localCache afero.Fs
 
+	// Journal of the namespace operations, to recover from a crash before the commit.
+	journal *stagingJournal
+
+	// Rebuild the namespace from the journal found in the staging directory.
+	recovering bool
+
 	// Logger
 	l *zap.Logger
 }
@@ -177,11 +187,6 @@ func (fs *fsMutable) GetInodeAttributes(ctx context.Context, op *fuseops.GetInod
 func (fs *fsMutable) SetInodeAttributes(ctx context.Context, op *fuseops.SetInodeAttributesOp) (err error) {
 	fs.l.Info("setAttr", zap.Uint64("id", uint64(op.Inode)))
 
-	if op.Mode != nil { // File permissions not supported
-		fs.l.Info("set mode", zap.Uint32("mode", uint32(*op.Mode)))
-		return fuse.ENOSYS
-	}
-
 	nodeStore, _ := fs.atomicGetReferences()
 
 	// Get the node.
@@ -193,6 +198,13 @@ func (fs *fsMutable) SetInodeAttributes(ctx context.Context, op *fuseops.SetInod
 
 	n := e.(*nodeEntry)
 
+	if op.Size != nil {
+		// a file of the source bundle is staged before it is truncated
+		if err = fs.stage(ctx, op.Inode, *op.Size > 0); err != nil {
+			return err
+		}
+	}
+
 	// lock the entry
 	n.lock.Lock()
 	defer n.lock.Unlock()
@@ -225,6 +237,23 @@ func (fs *fsMutable) SetInodeAttributes(ctx context.Context, op *fuseops.SetInod
 		n.attr.Mtime = *op.Mtime
 	}
 
+	if op.Mode != nil {
+		// only permissions may change, not the type of the node
+		fs.l.Info("set mode", zap.Uint32("mode", uint32(*op.Mode)))
+		n.attr.Mode = n.attr.Mode&os.ModeType | op.Mode.Perm()
+	}
+
+	if op.Mode != nil || op.Mtime != nil {
+		record := stagingRecord{Kind: stagingSetAttr, Inode: op.Inode, Mtime: op.Mtime}
+		if op.Mode != nil {
+			mode := n.attr.Mode
+			record.Mode = &mode
+		}
+		if err = fs.journalOp(record); err != nil {
+			return err
+		}
+	}
+
 	op.AttributesExpiration = time.Now().Add(cacheYearLong)
 
 	// Send new attr back
@@ -294,12 +323,14 @@ func (fs *fsMutable) MkDir(
 
 	err = fs.preCreateCheck(op.Parent, lk)
 	if err != nil {
-		fs.lock.Unlock()
 		return
 	}
 
-	err = fs.createNode(lk, op.Parent, op.Name, &op.Entry, fuseutil.DT_Directory, false)
-	return
+	err = fs.createNode(lk, op.Parent, op.Name, &op.Entry, fuseutil.DT_Directory, op.Mode, false)
+	if err != nil {
+		return
+	}
+	return fs.journalCreate(op.Parent, op.Name, &op.Entry, fuseutil.DT_Directory, "")
 }
 
 // TODO: Should file and dir node be supported via this call? So far no..
@@ -332,17 +363,55 @@ func (fs *fsMutable) CreateFile(
 		return
 	}
 
-	err = fs.createNode(lk, op.Parent, op.Name, &op.Entry, fuseutil.DT_File, false)
-	return
+	err = fs.createNode(lk, op.Parent, op.Name, &op.Entry, fuseutil.DT_File, op.Mode, false)
+	if err != nil {
+		return
+	}
+	return fs.journalCreate(op.Parent, op.Name, &op.Entry, fuseutil.DT_File, "")
 }
 
-// No sym link support in datamon
 func (fs *fsMutable) CreateSymlink(
 	ctx context.Context,
 	op *fuseops.CreateSymlinkOp) (err error) {
-	fs.l.Info("createSymLink", zap.Uint64("id", uint64(op.Parent)), zap.String("name", op.Name))
-	err = fuse.ENOSYS
-	return
+	fs.l.Info("createSymLink", zap.Uint64("id", uint64(op.Parent)), zap.String("name", op.Name),
+		zap.String("target", op.Target))
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	lk := formLookupKey(op.Parent, op.Name)
+
+	err = fs.preCreateCheck(op.Parent, lk)
+	if err != nil {
+		return
+	}
+
+	err = fs.createNode(lk, op.Parent, op.Name, &op.Entry, fuseutil.DT_Link, 0, false)
+	if err != nil {
+		return
+	}
+
+	e, _ := fs.iNodeStore.Get(formKey(op.Entry.Child))
+	n := e.(*nodeEntry)
+	n.target = op.Target
+	n.attr.Size = uint64(len(op.Target))
+	op.Entry.Attributes = n.attr
+	return fs.journalCreate(op.Parent, op.Name, &op.Entry, fuseutil.DT_Link, op.Target)
+}
+
+// journalCreate records the creation of a node. Need to hold the locks before calling.
+func (fs *fsMutable) journalCreate(parent fuseops.InodeID, name string, entry *fuseops.ChildInodeEntry,
+	nodeType fuseutil.DirentType, target string) error {
+	mode := entry.Attributes.Mode
+	return fs.journalOp(stagingRecord{
+		Kind:   stagingCreate,
+		Parent: parent,
+		Name:   name,
+		Inode:  entry.Child,
+		Type:   nodeType,
+		Mode:   &mode,
+		Target: target,
+	})
 }
 
 // no create link support in datamon
@@ -365,37 +434,51 @@ func (fs *fsMutable) Rename(ctx context.Context, op *fuseops.RenameOp) (err erro
 	fs.lock.Lock()
 	defer fs.lock.Unlock()
 
+	if err = fs.rename(op.OldParent, op.OldName, op.NewParent, op.NewName); err != nil {
+		return
+	}
+	return fs.journalOp(stagingRecord{
+		Kind:      stagingRename,
+		Parent:    op.OldParent,
+		Name:      op.OldName,
+		NewParent: op.NewParent,
+		NewName:   op.NewName,
+	})
+}
+
+// Move a node in the namespace. Need to hold the locks before calling.
+func (fs *fsMutable) rename(oldParent fuseops.InodeID, oldName string, newParent fuseops.InodeID, newName string) error {
 	// Find the old child
-	oldChild, found, _ := fs.lookup(op.OldParent, op.OldName)
+	oldChild, found, _ := fs.lookup(oldParent, oldName)
 	if !found {
 		return fuse.ENOENT
 	}
-	newChild, found, _ := fs.lookup(op.NewParent, op.NewName)
+	newChild, found, _ := fs.lookup(newParent, newName)
 	if found {
 		if newChild.mode.IsDir() {
 			return fuse.ENOSYS
 		}
 		// Delete new child, ignore if not present
-		_ = fs.deleteNSEntry(op.NewParent, op.NewName)
+		_ = fs.deleteNSEntry(newParent, newName)
 	}
 
 	// Insert iNode into new readDir and lookup and remove from old.
-	rC := fs.readDirMap[op.OldParent][oldChild.iNode]
+	rC := fs.readDirMap[oldParent][oldChild.iNode]
 
 	newRC := fuseutil.Dirent{
 		Inode: rC.Inode,
-		Name:  op.NewName,
+		Name:  newName,
 		Type:  rC.Type,
 	}
 
 	// Delete from old parent
-	delete(fs.readDirMap[op.OldParent], rC.Inode)
+	delete(fs.readDirMap[oldParent], rC.Inode)
 	var l interface{}
-	fs.lookupTree, l, _ = fs.lookupTree.Delete(formLookupKey(op.OldParent, op.OldName)) // lookupEntry remains the same
+	fs.lookupTree, l, _ = fs.lookupTree.Delete(formLookupKey(oldParent, oldName)) // lookupEntry remains the same
 
 	// Insert into new.
-	fs.insertReadDirEntry(op.NewParent, &newRC)
-	fs.insertLookupEntry(op.NewParent, op.NewName, l.(lookupEntry))
+	fs.insertReadDirEntry(newParent, &newRC)
+	fs.insertLookupEntry(newParent, newName, l.(lookupEntry))
 
 	return nil
 }
@@ -405,7 +488,7 @@ func (fs *fsMutable) RmDir(
 	op *fuseops.RmDirOp) (err error) {
 	fs.l.Info("rmdir", zap.Uint64("id", uint64(op.Parent)), zap.String("name", op.Name))
 
-	return fs.deleteNSEntry(op.Parent, op.Name)
+	return fs.unlink(op.Parent, op.Name)
 }
 
 func (fs *fsMutable) Unlink(
@@ -413,7 +496,18 @@ func (fs *fsMutable) Unlink(
 	op *fuseops.UnlinkOp) (err error) {
 	fs.l.Info("unlink", zap.Uint64("id", uint64(op.Parent)), zap.String("name", op.Name))
 	// TODO: remove from lookup and readdir
-	return fs.deleteNSEntry(op.Parent, op.Name)
+	return fs.unlink(op.Parent, op.Name)
+}
+
+// unlink deletes a journaled entry from the namespace
+func (fs *fsMutable) unlink(p fuseops.InodeID, c string) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if err := fs.deleteNSEntry(p, c); err != nil {
+		return err
+	}
+	return fs.journalOp(stagingRecord{Kind: stagingUnlink, Parent: p, Name: c})
 }
 
 func (fs *fsMutable) OpenDir(
@@ -478,22 +572,29 @@ func (fs *fsMutable) ReadFile(
 	ctx context.Context,
 	op *fuseops.ReadFileOp) (err error) {
 	fs.l.Info("readFile", zap.Uint64("id", uint64(op.Inode)))
+	if hash := fs.sourceHash(op.Inode); hash != "" {
+		op.BytesRead, err = fs.readSource(op.Inode, hash, op.Dst, op.Offset)
+		return
+	}
 	file, err := fs.localCache.OpenFile(getPathToBackingFile(op.Inode), os.O_RDONLY|os.O_SYNC, fileDefaultMode)
 	if err != nil {
 		return fuse.EIO
 	}
 	fs.backingFiles[op.Inode] = &file
 	op.BytesRead, err = file.ReadAt(op.Dst, op.Offset)
-	if err != nil {
+	if errNotEOF(err) {
 		return fuse.EIO
 	}
-	return
+	return nil
 }
 
 func (fs *fsMutable) WriteFile(
 	ctx context.Context,
 	op *fuseops.WriteFileOp) (err error) {
 	fs.l.Info("writeFile", zap.Uint64("id", uint64(op.Inode)))
+	if err = fs.stage(ctx, op.Inode, true); err != nil {
+		return err
+	}
 	file, err := fs.localCache.OpenFile(getPathToBackingFile(op.Inode), os.O_WRONLY|os.O_SYNC, fileDefaultMode)
 	if err != nil {
 		return fuse.EIO
@@ -554,7 +655,18 @@ func (fs *fsMutable) ReleaseFileHandle(
 func (fs *fsMutable) ReadSymlink(
 	ctx context.Context,
 	op *fuseops.ReadSymlinkOp) (err error) {
-	err = fuse.ENOSYS
+	fs.l.Info("readSymlink", zap.Uint64("id", uint64(op.Inode)))
+
+	nodeStore, _ := fs.atomicGetReferences()
+	e, found := nodeStore.Get(formKey(op.Inode))
+	if !found {
+		return fuse.ENOENT
+	}
+	n := e.(*nodeEntry)
+	if n.attr.Mode&os.ModeSymlink == 0 {
+		return fuse.EINVAL
+	}
+	op.Target = n.target
 	return
 }
 
@@ -638,7 +750,7 @@ func commitFileUpload(
 	be := model.BundleEntry{
 		Hash:         putRes.Key.String(),
 		NameWithPath: uploadTask.name,
-		FileMode:     0, // #TODO: #35 file mode support
+		FileMode:     fs.commitMode(uploadTask.inodeID),
 		Size:         uint64(putRes.Written),
 	}
 	select {
@@ -648,6 +760,44 @@ func commitFileUpload(
 
 }
 
+// commitNoContent sends the bundle entry for a symbolic link or an empty directory
+func commitNoContent(
+	fs *fsMutable,
+	chans commitChans,
+	uploadTask commitUploadTask) {
+	e, found := fs.iNodeStore.Get(formKey(uploadTask.inodeID))
+	if !found {
+		select {
+		case chans.error <- fmt.Errorf("commit: node not found for %s", uploadTask.name):
+		case <-chans.done:
+		}
+		return
+	}
+	n := e.(*nodeEntry)
+	be := model.BundleEntry{
+		NameWithPath: uploadTask.name,
+		FileMode:     n.attr.Mode & (os.ModeType | os.ModePerm),
+		Size:         uint64(len(n.target)),
+		Target:       n.target,
+	}
+	select {
+	case chans.bundleEntry <- be:
+	case <-chans.done:
+	}
+}
+
+// commitMode returns the permission bits of a file node
+func (fs *fsMutable) commitMode(iNode fuseops.InodeID) os.FileMode {
+	e, found := fs.iNodeStore.Get(formKey(iNode))
+	if !found {
+		return fileDefaultMode
+	}
+	n := e.(*nodeEntry)
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.attr.Mode.Perm()
+}
+
 /* these are the concurrency primitives used to get bounded concurrency in the
  * directory upload.  the idea of using a buffered channel to set a bounds on concurrency is
  * from, for example, TestTCPSpuriousConnSetupCompletionWithCancel in the stdlib net package.
@@ -695,9 +845,13 @@ func commitUploadDir(
 		defer func() { <-dirUploadSync.bufferedChanSem }()
 		directoryUploadTasks = make([]commitUploadTask, 0)
 		for currInode, currEnt := range fs.readDirMap[uploadTask.inodeID] {
-			tsk := commitUploadTask{inodeID: currInode, name: uploadTask.name + "/" + currEnt.Name}
+			tsk := commitUploadTask{inodeID: currInode, name: path.Join(uploadTask.name, currEnt.Name)}
 			switch currEnt.Type {
 			case fuseutil.DT_File:
+				if hash := fs.sourceHash(currInode); hash != "" {
+					commitSourceFile(fs, chans, tsk, hash)
+					continue
+				}
 				bundleUploadWaitGroup.Add(1)
 				go commitFileUpload(
 					ctx,
@@ -706,7 +860,13 @@ func commitUploadDir(
 					bundleUploadWaitGroup,
 					caFs,
 					tsk)
+			case fuseutil.DT_Link:
+				commitNoContent(fs, chans, tsk)
 			case fuseutil.DT_Directory:
+				if len(fs.readDirMap[currInode]) == 0 {
+					commitNoContent(fs, chans, tsk)
+					continue
+				}
 				directoryUploadTasks = append(directoryUploadTasks, tsk)
 			default:
 				fs.l.Warn("unexpected file type", zap.String("file type", fuseDirentTypeString(currEnt.Type)))
@@ -829,9 +989,16 @@ func (fs *fsMutable) commitImpl(caFs cafs.Fs) error {
 			}
 		}
 	}
+	if err := appendWALEntry(ctx, fs.bundle.contextStores, fs.l,
+		model.NewBundleCommitPayload(fs.bundle.RepoID, fs.bundle.BundleDescriptor)); err != nil {
+		return err
+	}
 	if err := uploadBundleDescriptor(ctx, fs.bundle); err != nil {
 		return err
 	}
+	if err := fs.journal.remove(); err != nil {
+		fs.l.Warn("Commit: failed to remove staging journal", zap.Error(err))
+	}
 	fs.l.Info("Commit: ok.")
 	return nil
 }
@@ -839,6 +1006,8 @@ func (fs *fsMutable) commitImpl(caFs cafs.Fs) error {
 func (fs *fsMutable) Commit() error {
 	caFs, err := cafs.New(
 		cafs.LeafSize(fs.bundle.BundleDescriptor.LeafSize),
+		cafs.Chunking(cafs.ChunkingScheme(fs.bundle.BundleDescriptor.Chunking)),
+		cafs.Compression(fs.bundle.compression),
 		cafs.Backend(fs.bundle.BlobStore()),
 	)
 	if err != nil {
@@ -0,0 +1,238 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+	"go.uber.org/zap"
+
+	"github.com/oneconcern/datamon/pkg/cafs"
+	"github.com/oneconcern/datamon/pkg/model"
+)
+
+// seed adds the entries of the source bundle to the filesystem. Their files are read from the source bundle
+// until they are modified.
+func (fs *fsMutable) seed(ctx context.Context) error {
+	source := fs.source
+	source.Streamed = true
+	if err := DownloadMetadata(ctx, source); err != nil {
+		return err
+	}
+	if source.BundleDescriptor.Version < 1 {
+		return fmt.Errorf("bundle %s has a legacy leaf layout and can't be the source of a new bundle", source.BundleID)
+	}
+	if err := source.initStreaming(); err != nil {
+		return err
+	}
+	// the hashes of unchanged files are only valid with the leaves of the source
+	fs.bundle.BundleDescriptor.LeafSize = source.BundleDescriptor.LeafSize
+	fs.bundle.BundleDescriptor.Chunking = source.BundleDescriptor.Chunking
+	fs.bundle.BundleDescriptor.Parents = []string{source.BundleID}
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	dirs := map[string]fuseops.InodeID{"": fuseops.RootInodeID}
+	var mkdirAll func(name string) (fuseops.InodeID, error)
+	mkdirAll = func(name string) (fuseops.InodeID, error) {
+		if iNode, found := dirs[name]; found {
+			return iNode, nil
+		}
+		parent, err := mkdirAll(parentPath(name))
+		if err != nil {
+			return 0, err
+		}
+		var entry fuseops.ChildInodeEntry
+		if err = fs.createNode(nil, parent, path.Base(name), &entry, fuseutil.DT_Directory, 0, false); err != nil {
+			return 0, err
+		}
+		dirs[name] = entry.Child
+		return entry.Child, nil
+	}
+
+	// entries are seeded in order, so that they get the same iNodes when a journaled filesystem is recovered
+	entries := make([]model.BundleEntry, len(source.GetBundleEntries()))
+	copy(entries, source.GetBundleEntries())
+	sort.Slice(entries, func(i, j int) bool { return entries[i].NameWithPath < entries[j].NameWithPath })
+	for _, bundleEntry := range entries {
+		be := bundleEntry
+		if be.IsDir() {
+			iNode, err := mkdirAll(be.NameWithPath)
+			if err != nil {
+				return err
+			}
+			e, _ := fs.iNodeStore.Get(formKey(iNode))
+			n := e.(*nodeEntry)
+			n.attr.Mode = dirDefaultMode&os.ModeType | be.FileMode.Perm()
+			continue
+		}
+		parent, err := mkdirAll(parentPath(be.NameWithPath))
+		if err != nil {
+			return err
+		}
+		fs.seedNode(parent, be, source)
+	}
+	fs.l.Info("seeded from source bundle",
+		zap.String("repo", source.RepoID),
+		zap.String("source", source.BundleID),
+		zap.Int("entryCount", len(source.BundleEntries)))
+	return nil
+}
+
+// seedNode adds a file or a symbolic link of the source bundle. Need to hold the locks before calling.
+//
+// Unlike createNode, no backing file is created: it is staged when the file is first modified.
+func (fs *fsMutable) seedNode(parentINode fuseops.InodeID, be model.BundleEntry, source *Bundle) {
+	iNodeID := fs.iNodeGenerator.allocINode()
+	name := path.Base(be.NameWithPath)
+
+	nodeType := fuseutil.DT_File
+	var mode os.FileMode = fileDefaultMode
+	switch {
+	case be.IsSymlink():
+		nodeType = fuseutil.DT_Link
+		mode = linkDefaultMode
+	case be.HasFileMode():
+		mode = be.FileMode.Perm()
+	}
+
+	fs.insertLookupEntry(parentINode, name, lookupEntry{iNode: iNodeID})
+	fs.insertReadDirEntry(parentINode, &fuseutil.Dirent{
+		Inode: iNodeID,
+		Name:  name,
+		Type:  nodeType,
+	})
+
+	ts := source.BundleDescriptor.Timestamp
+	n := &nodeEntry{
+		lock:              sync.Mutex{},
+		refCount:          1,
+		pathToBackingFile: getPathToBackingFile(iNodeID),
+		target:            be.Target,
+		attr: fuseops.InodeAttributes{
+			Size:   be.Size,
+			Nlink:  fileLinkCount,
+			Mode:   mode,
+			Atime:  ts,
+			Mtime:  ts,
+			Ctime:  ts,
+			Crtime: ts,
+			Uid:    defaultGID,
+			Gid:    defaultUID,
+		},
+	}
+	if nodeType == fuseutil.DT_File {
+		n.hash = be.Hash
+	}
+	fs.iNodeStore, _, _ = fs.iNodeStore.Insert(formKey(iNodeID), n)
+}
+
+// sourceHash returns the hash of a file which still has the content of the source bundle, "" for other nodes
+func (fs *fsMutable) sourceHash(iNode fuseops.InodeID) string {
+	nodeStore, _ := fs.atomicGetReferences()
+	e, found := nodeStore.Get(formKey(iNode))
+	if !found {
+		return ""
+	}
+	n := e.(*nodeEntry)
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.hash
+}
+
+// readSource reads a file which still has the content of the source bundle
+func (fs *fsMutable) readSource(iNode fuseops.InodeID, hash string, dst []byte, offset int64) (int, error) {
+	return fs.source.ReadAt(&fsEntry{
+		hash:     hash,
+		iNode:    iNode,
+		fullPath: getPathToBackingFile(iNode),
+	}, dst, offset)
+}
+
+// stage copies a file of the source bundle to its backing file, before it is modified.
+//
+// The content is not fetched when the file is about to be truncated.
+func (fs *fsMutable) stage(ctx context.Context, iNode fuseops.InodeID, keepContent bool) error {
+	nodeStore, _ := fs.atomicGetReferences()
+	e, found := nodeStore.Get(formKey(iNode))
+	if !found {
+		return fuse.ENOENT
+	}
+	n := e.(*nodeEntry)
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.hash == "" {
+		return nil
+	}
+
+	file, err := fs.localCache.Create(n.pathToBackingFile)
+	if err != nil {
+		fs.l.Error("failed to create backing file", zap.Error(err), zap.Uint64("inode", uint64(iNode)))
+		return fuse.EIO
+	}
+	defer file.Close()
+	if keepContent {
+		key, err := cafs.KeyFromString(n.hash)
+		if err != nil {
+			return err
+		}
+		rdr, err := fs.source.cafs.Get(ctx, key)
+		if err != nil {
+			fs.l.Error("failed to get source file", zap.Error(err), zap.String("hash", n.hash))
+			return fuse.EIO
+		}
+		defer rdr.Close()
+		if _, err = io.Copy(file, rdr); err != nil {
+			fs.l.Error("failed to stage source file", zap.Error(err), zap.String("hash", n.hash))
+			return fuse.EIO
+		}
+	}
+	if err = file.Sync(); err != nil {
+		fs.l.Error("failed to stage source file", zap.Error(err), zap.String("hash", n.hash))
+		return fuse.EIO
+	}
+	if err = fs.journalOp(stagingRecord{Kind: stagingStage, Inode: iNode}); err != nil {
+		return err
+	}
+	fs.l.Debug("staged source file", zap.Uint64("inode", uint64(iNode)), zap.Bool("content", keepContent))
+	n.hash = ""
+	return nil
+}
+
+// commitSourceFile sends the bundle entry of a file which still has the content of the source bundle,
+// without uploading it again
+func commitSourceFile(
+	fs *fsMutable,
+	chans commitChans,
+	uploadTask commitUploadTask,
+	hash string) {
+	e, found := fs.iNodeStore.Get(formKey(uploadTask.inodeID))
+	if !found {
+		select {
+		case chans.error <- fmt.Errorf("commit: node not found for %s", uploadTask.name):
+		case <-chans.done:
+		}
+		return
+	}
+	n := e.(*nodeEntry)
+	n.lock.Lock()
+	be := model.BundleEntry{
+		Hash:         hash,
+		NameWithPath: uploadTask.name,
+		FileMode:     n.attr.Mode.Perm(),
+		Size:         n.attr.Size,
+	}
+	n.lock.Unlock()
+	select {
+	case chans.bundleEntry <- be:
+	case <-chans.done:
+	}
+}
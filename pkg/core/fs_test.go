@@ -1,13 +1,22 @@
 package core
 
 import (
+	"context"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	iradix "github.com/hashicorp/go-immutable-radix"
 	"github.com/spf13/afero"
+	"go.uber.org/zap"
+
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/oneconcern/datamon/pkg/storage/localfs"
 
 	"github.com/stretchr/testify/assert"
 
@@ -70,18 +79,18 @@ func TestCreate(t *testing.T) {
 	})
 	childInodeEntry := fuseops.ChildInodeEntry{}
 	parent := firstINode
-	err := fs.createNode(nil, parent, child, &childInodeEntry, fuseutil.DT_Directory, false)
+	err := fs.createNode(nil, parent, child, &childInodeEntry, fuseutil.DT_Directory, 0, false)
 	assert.NoError(t, err)
 	validateChild(t, child, &fs, parent, 3, firstINode+1, 1, &childInodeEntry)
 
 	child2 := "child2"
-	err = fs.createNode(nil, parent, child2, &childInodeEntry, fuseutil.DT_Directory, false)
+	err = fs.createNode(nil, parent, child2, &childInodeEntry, fuseutil.DT_Directory, 0, false)
 	assert.NoError(t, err)
 
 	validateChild(t, child2, &fs, parent, 4, firstINode+2, 2, &childInodeEntry)
 
 	child3 := "child3"
-	err = fs.createNode(nil, parent, child3, &childInodeEntry, fuseutil.DT_Directory, false)
+	err = fs.createNode(nil, parent, child3, &childInodeEntry, fuseutil.DT_Directory, 0, false)
 	assert.NoError(t, err)
 	validateChild(t, child3, &fs, parent, 5, firstINode+3, 3, &childInodeEntry)
 }
@@ -152,3 +161,381 @@ func validateChild(t *testing.T, name string, fs *fsMutable, parent fuseops.Inod
 
 	// TODO: Add timestamp checks
 }
+
+func TestReadOnlyFSFileModes(t *testing.T) {
+	bundle := NewBundle(NewBDescriptor())
+	bundle.BundleEntries = []model.BundleEntry{
+		{NameWithPath: "legacy.txt", Hash: "h1", Size: 4},
+		{NameWithPath: "bin/run.sh", Hash: "h2", FileMode: 0755, Size: 4},
+		{NameWithPath: "run", FileMode: os.ModeSymlink | 0777, Target: "bin/run.sh", Size: 10},
+		{NameWithPath: "output/empty", FileMode: os.ModeDir | 0700},
+	}
+	fs := &readOnlyFsInternal{
+		bundle:       bundle,
+		readDirMap:   make(map[fuseops.InodeID][]fuseutil.Dirent),
+		fsEntryStore: iradix.New(),
+		lookupTree:   iradix.New(),
+		fsDirStore:   iradix.New(),
+		l:            zap.NewNop(),
+	}
+	_, err := fs.populateFS(bundle)
+	require.NoError(t, err)
+
+	lookUp := func(parent fuseops.InodeID, name string) fuseops.ChildInodeEntry {
+		op := &fuseops.LookUpInodeOp{Parent: parent, Name: name}
+		require.NoError(t, fs.LookUpInode(context.Background(), op))
+		return op.Entry
+	}
+	assert.Equal(t, os.FileMode(fileReadOnlyMode), lookUp(fuseops.RootInodeID, "legacy.txt").Attributes.Mode)
+	bin := lookUp(fuseops.RootInodeID, "bin")
+	assert.Equal(t, os.FileMode(0555), lookUp(bin.Child, "run.sh").Attributes.Mode)
+
+	link := lookUp(fuseops.RootInodeID, "run")
+	assert.Equal(t, os.ModeSymlink|os.ModePerm, link.Attributes.Mode)
+	readLink := &fuseops.ReadSymlinkOp{Inode: link.Child}
+	require.NoError(t, fs.ReadSymlink(context.Background(), readLink))
+	assert.Equal(t, "bin/run.sh", readLink.Target)
+
+	output := lookUp(fuseops.RootInodeID, "output")
+	empty := lookUp(output.Child, "empty")
+	assert.True(t, empty.Attributes.Mode.IsDir())
+	readDir := &fuseops.ReadDirOp{Inode: empty.Child, Dst: make([]byte, 1024)}
+	require.NoError(t, fs.ReadDir(context.Background(), readDir))
+	assert.Zero(t, readDir.BytesRead)
+}
+
+func TestReadOnlyFSStreamed(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, nil, memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+	content := strings.Repeat("streamed content\n", 1000)
+	uploaded := uploadTestBundle(t, stores, map[string]string{"dir/file": content})
+
+	bundle := NewBundle(NewBDescriptor(), Repo(repo), BundleID(uploaded.BundleID), ContextStores(stores),
+		ConsumableStore(localfs.New(afero.NewMemMapFs())), Streaming(true), PrefetchLeaves(2))
+	rofs, err := NewReadOnlyFS(bundle, zap.NewNop())
+	require.NoError(t, err)
+	fs := rofs.fsInternal
+
+	lookUp := func(parent fuseops.InodeID, name string) fuseops.ChildInodeEntry {
+		op := &fuseops.LookUpInodeOp{Parent: parent, Name: name}
+		require.NoError(t, fs.LookUpInode(ctx, op))
+		return op.Entry
+	}
+	file := lookUp(lookUp(fuseops.RootInodeID, "dir").Child, "file")
+	read := make([]byte, 0, len(content))
+	for len(read) < len(content) {
+		op := &fuseops.ReadFileOp{Inode: file.Child, Offset: int64(len(read)), Dst: make([]byte, 4096)}
+		require.NoError(t, fs.ReadFile(ctx, op))
+		require.NotZero(t, op.BytesRead)
+		read = append(read, op.Dst[:op.BytesRead]...)
+	}
+	assert.Equal(t, content, string(read))
+}
+
+func TestMultiReadOnlyFS(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, nil, memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+	first := uploadTestBundle(t, stores, map[string]string{"dir/file": "first content"})
+	second := uploadTestBundle(t, stores, map[string]string{"dir/file": "second content"})
+
+	newBundle := func(id string, streamed bool) *Bundle {
+		return NewBundle(NewBDescriptor(), Repo(repo), BundleID(id), ContextStores(stores),
+			ConsumableStore(localfs.New(afero.NewMemMapFs())), Streaming(streamed))
+	}
+	_, err := NewMultiReadOnlyFS([]BundleMount{
+		{Path: repo + "/first", Bundle: newBundle(first.BundleID, true)},
+		{Path: repo + "/first/nested", Bundle: newBundle(second.BundleID, true)},
+	}, zap.NewNop())
+	require.Error(t, err)
+
+	rofs, err := NewMultiReadOnlyFS([]BundleMount{
+		{Path: repo + "/first", Bundle: newBundle(first.BundleID, true)},
+		{Path: "/" + repo + "/second/", Bundle: newBundle(second.BundleID, false)},
+	}, zap.NewNop())
+	require.NoError(t, err)
+	fs := rofs.fsInternal
+
+	lookUp := func(parent fuseops.InodeID, name string) fuseops.ChildInodeEntry {
+		op := &fuseops.LookUpInodeOp{Parent: parent, Name: name}
+		require.NoError(t, fs.LookUpInode(ctx, op))
+		return op.Entry
+	}
+	readDir := &fuseops.ReadDirOp{Inode: fuseops.RootInodeID, Dst: make([]byte, 1024)}
+	require.NoError(t, fs.ReadDir(ctx, readDir))
+	assert.NotZero(t, readDir.BytesRead)
+
+	repoDir := lookUp(fuseops.RootInodeID, repo)
+	for name, content := range map[string]string{"first": "first content", "second": "second content"} {
+		file := lookUp(lookUp(lookUp(repoDir.Child, name).Child, "dir").Child, "file")
+		op := &fuseops.ReadFileOp{Inode: file.Child, Dst: make([]byte, 4096)}
+		require.NoError(t, fs.ReadFile(ctx, op))
+		assert.Equal(t, content, string(op.Dst[:op.BytesRead]))
+	}
+}
+
+func TestExplorerFS(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, nil, memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+	first := uploadTestBundle(t, stores, map[string]string{"dir/file": "first content"})
+	second := uploadTestBundle(t, stores, map[string]string{"dir/file": "second content"})
+	require.NoError(t, NewLabel(nil, LabelName("latest")).UploadDescriptor(ctx, second))
+	_, err := CreateBranch(ctx, stores, repo, "main", first.BundleID, model.Contributor{Email: "dev@example.com"})
+	require.NoError(t, err)
+
+	explorer, err := NewExplorerFS(stores, zap.NewNop())
+	require.NoError(t, err)
+	fs := explorer.fsInternal
+
+	lookUp := func(parent fuseops.InodeID, name string) fuseops.ChildInodeEntry {
+		op := &fuseops.LookUpInodeOp{Parent: parent, Name: name}
+		require.NoError(t, fs.LookUpInode(ctx, op))
+		return op.Entry
+	}
+	readLink := func(entry fuseops.ChildInodeEntry) string {
+		op := &fuseops.ReadSymlinkOp{Inode: entry.Child}
+		require.NoError(t, fs.ReadSymlink(ctx, op))
+		return op.Target
+	}
+	readFile := func(bundleDir fuseops.InodeID) string {
+		file := lookUp(lookUp(bundleDir, "dir").Child, "file")
+		op := &fuseops.ReadFileOp{Inode: file.Child, Dst: make([]byte, 4096)}
+		require.NoError(t, fs.ReadFile(ctx, op))
+		return string(op.Dst[:op.BytesRead])
+	}
+
+	readDir := &fuseops.ReadDirOp{Inode: fuseops.RootInodeID, Dst: make([]byte, 1024)}
+	require.NoError(t, fs.ReadDir(ctx, readDir))
+	assert.NotZero(t, readDir.BytesRead)
+
+	repoDir := lookUp(fuseops.RootInodeID, repo)
+	bundles := lookUp(repoDir.Child, explorerBundles)
+	assert.Equal(t, "first content", readFile(lookUp(bundles.Child, first.BundleID).Child))
+
+	// labels and branches link to bundles
+	label := lookUp(lookUp(repoDir.Child, explorerLabels).Child, "latest")
+	assert.Equal(t, "../bundles/"+second.BundleID, readLink(label))
+	branch := lookUp(lookUp(repoDir.Child, explorerBranches).Child, "main")
+	assert.Equal(t, "../bundles/"+first.BundleID, readLink(branch))
+	assert.Equal(t, "second content", readFile(lookUp(bundles.Child, second.BundleID).Child))
+
+	op := &fuseops.LookUpInodeOp{Parent: bundles.Child, Name: "nosuchbundle"}
+	assert.Error(t, fs.LookUpInode(ctx, op))
+
+	// the tree of a bundle is released once the kernel forgets it, and loaded again on next use
+	countNodes := func() int {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		return len(fs.nodes)
+	}
+	nodes := countNodes()
+	bundleDir := lookUp(bundles.Child, first.BundleID)
+	dir := lookUp(bundleDir.Child, "dir")
+	file := lookUp(dir.Child, "file")
+	require.NoError(t, fs.ForgetInode(ctx, &fuseops.ForgetInodeOp{Inode: file.Child, N: 2}))
+	require.NoError(t, fs.ForgetInode(ctx, &fuseops.ForgetInodeOp{Inode: dir.Child, N: 1}))
+	assert.Equal(t, nodes, countNodes())
+	require.NoError(t, fs.ForgetInode(ctx, &fuseops.ForgetInodeOp{Inode: bundleDir.Child, N: 2}))
+	assert.Equal(t, nodes, countNodes(), "a directory still referred to by the kernel is kept")
+	require.NoError(t, fs.ForgetInode(ctx, &fuseops.ForgetInodeOp{Inode: dir.Child, N: 1}))
+	assert.Equal(t, nodes-2, countNodes())
+	assert.Equal(t, "first content", readFile(lookUp(bundles.Child, first.BundleID).Child))
+	assert.Equal(t, nodes, countNodes())
+
+	// nodes which are not listed anymore are released once forgotten
+	labels := lookUp(repoDir.Child, explorerLabels)
+	labelsDir, err := fs.getNode(labels.Child)
+	require.NoError(t, err)
+	fs.setChildren(labelsDir, map[string]*explorerNode{})
+	assert.Equal(t, nodes, countNodes())
+	require.NoError(t, fs.ForgetInode(ctx, &fuseops.ForgetInodeOp{Inode: label.Child, N: 1}))
+	assert.Equal(t, nodes-1, countNodes())
+}
+
+func TestMutableFSFromBundle(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, nil, memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+	source := uploadTestBundle(t, stores, map[string]string{
+		"dir/unchanged": "unchanged content",
+		"dir/modified":  "modified content",
+		"removed":       "removed content",
+	})
+	staging, err := ioutil.TempDir("", "datamon-mutable-staging")
+	require.NoError(t, err)
+	defer os.RemoveAll(staging)
+
+	bundle := NewBundle(NewBDescriptor(Message("incremental"), Contributor(model.Contributor{Email: "dev@example.com"})),
+		Repo(repo), ContextStores(stores))
+	mfs, err := NewMutableFS(bundle, staging,
+		SourceBundle(NewBundle(NewBDescriptor(), Repo(repo), BundleID(source.BundleID), ContextStores(stores))))
+	require.NoError(t, err)
+	fs := mfs.fsInternal
+
+	lookUp := func(parent fuseops.InodeID, name string) fuseops.ChildInodeEntry {
+		op := &fuseops.LookUpInodeOp{Parent: parent, Name: name}
+		require.NoError(t, fs.LookUpInode(ctx, op))
+		return op.Entry
+	}
+	readFile := func(iNode fuseops.InodeID) string {
+		op := &fuseops.ReadFileOp{Inode: iNode, Dst: make([]byte, 4096)}
+		require.NoError(t, fs.ReadFile(ctx, op))
+		return string(op.Dst[:op.BytesRead])
+	}
+
+	// files of the source are streamed, and staged once modified
+	dir := lookUp(fuseops.RootInodeID, "dir")
+	unchanged := lookUp(dir.Child, "unchanged")
+	assert.Equal(t, "unchanged content", readFile(unchanged.Child))
+	modified := lookUp(dir.Child, "modified")
+	require.NoError(t, fs.WriteFile(ctx, &fuseops.WriteFileOp{Inode: modified.Child, Offset: 9, Data: []byte("CONTENT")}))
+	assert.Equal(t, "modified CONTENT", readFile(modified.Child))
+	require.NoError(t, fs.Unlink(ctx, &fuseops.UnlinkOp{Parent: fuseops.RootInodeID, Name: "removed"}))
+	added := &fuseops.CreateFileOp{Parent: fuseops.RootInodeID, Name: "added", Mode: 0644}
+	require.NoError(t, fs.CreateFile(ctx, added))
+	require.NoError(t, fs.WriteFile(ctx, &fuseops.WriteFileOp{Inode: added.Entry.Child, Data: []byte("added content")}))
+
+	require.NoError(t, mfs.Commit())
+	assert.Equal(t, []string{source.BundleID}, bundle.BundleDescriptor.Parents)
+
+	hashes := func(id string) map[string]string {
+		b := NewBundle(NewBDescriptor(), Repo(repo), BundleID(id), ContextStores(stores))
+		require.NoError(t, PopulateFiles(ctx, b))
+		res := make(map[string]string)
+		for _, entry := range b.BundleEntries {
+			res[entry.NameWithPath] = entry.Hash
+		}
+		return res
+	}
+	before, after := hashes(source.BundleID), hashes(bundle.BundleID)
+	assert.Len(t, after, 3)
+	assert.Equal(t, before["dir/unchanged"], after["dir/unchanged"])
+	assert.NotEqual(t, before["dir/modified"], after["dir/modified"])
+	assert.NotEmpty(t, after["added"])
+
+	committed := NewBundle(NewBDescriptor(), Repo(repo), BundleID(bundle.BundleID), ContextStores(stores),
+		ConsumableStore(localfs.New(afero.NewMemMapFs())))
+	require.NoError(t, Publish(ctx, committed))
+	for name, content := range map[string]string{
+		"dir/unchanged": "unchanged content", "dir/modified": "modified CONTENT", "added": "added content",
+	} {
+		rdr, err := committed.ConsumableStore.Get(ctx, name)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(rdr)
+		require.NoError(t, err)
+		assert.Equal(t, content, string(b))
+	}
+}
+
+func TestMutableFSRecover(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, nil, memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+	source := uploadTestBundle(t, stores, map[string]string{
+		"dir/unchanged": "unchanged content",
+		"dir/modified":  "modified content",
+		"removed":       "removed content",
+	})
+	staging, err := ioutil.TempDir("", "datamon-mutable-staging")
+	require.NoError(t, err)
+	defer os.RemoveAll(staging)
+
+	newBundle := func() *Bundle {
+		return NewBundle(NewBDescriptor(Message("recovered"), Contributor(model.Contributor{Email: "dev@example.com"})),
+			Repo(repo), ContextStores(stores))
+	}
+	mfs, err := NewMutableFS(newBundle(), staging,
+		SourceBundle(NewBundle(NewBDescriptor(), Repo(repo), BundleID(source.BundleID), ContextStores(stores))))
+	require.NoError(t, err)
+	fs := mfs.fsInternal
+
+	lookUp := func(fs *fsMutable, parent fuseops.InodeID, name string) fuseops.ChildInodeEntry {
+		op := &fuseops.LookUpInodeOp{Parent: parent, Name: name}
+		require.NoError(t, fs.LookUpInode(ctx, op))
+		return op.Entry
+	}
+
+	// changes interrupted before the commit
+	dir := lookUp(fs, fuseops.RootInodeID, "dir")
+	modified := lookUp(fs, dir.Child, "modified")
+	require.NoError(t, fs.WriteFile(ctx, &fuseops.WriteFileOp{Inode: modified.Child, Offset: 9, Data: []byte("CONTENT")}))
+	require.NoError(t, fs.Unlink(ctx, &fuseops.UnlinkOp{Parent: fuseops.RootInodeID, Name: "removed"}))
+	newDir := &fuseops.MkDirOp{Parent: fuseops.RootInodeID, Name: "new", Mode: 0750}
+	require.NoError(t, fs.MkDir(ctx, newDir))
+	added := &fuseops.CreateFileOp{Parent: newDir.Entry.Child, Name: "draft", Mode: 0644}
+	require.NoError(t, fs.CreateFile(ctx, added))
+	require.NoError(t, fs.WriteFile(ctx, &fuseops.WriteFileOp{Inode: added.Entry.Child, Data: []byte("added content")}))
+	require.NoError(t, fs.Rename(ctx, &fuseops.RenameOp{
+		OldParent: newDir.Entry.Child, OldName: "draft", NewParent: newDir.Entry.Child, NewName: "added",
+	}))
+	mode := os.FileMode(0600)
+	require.NoError(t, fs.SetInodeAttributes(ctx, &fuseops.SetInodeAttributesOp{Inode: added.Entry.Child, Mode: &mode}))
+	require.NoError(t, fs.CreateSymlink(ctx, &fuseops.CreateSymlinkOp{
+		Parent: fuseops.RootInodeID, Name: "link", Target: "new/added",
+	}))
+
+	// the staging directory is not reused by mistake
+	_, err = NewMutableFS(newBundle(), staging)
+	require.Error(t, err)
+
+	// the crash left a truncated record, then the recovered filesystem is interrupted again
+	journal, err := os.OpenFile(filepath.Join(staging, stagingJournalFile), os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = journal.WriteString(`{"kind":"unlink","par`)
+	require.NoError(t, err)
+	require.NoError(t, journal.Close())
+	interrupted, err := NewMutableFS(newBundle(), staging, RecoverStaging())
+	require.NoError(t, err)
+	later := &fuseops.MkDirOp{Parent: fuseops.RootInodeID, Name: "later", Mode: 0750}
+	require.NoError(t, interrupted.fsInternal.MkDir(ctx, later))
+
+	bundle := newBundle()
+	recovered, err := NewMutableFS(bundle, staging, RecoverStaging())
+	require.NoError(t, err)
+	rfs := recovered.fsInternal
+	_, found, _ := rfs.lookup(fuseops.RootInodeID, "removed")
+	assert.False(t, found)
+	_, found, _ = rfs.lookup(newDir.Entry.Child, "draft")
+	assert.False(t, found)
+	entry := lookUp(rfs, newDir.Entry.Child, "added")
+	assert.Equal(t, added.Entry.Child, entry.Child)
+	assert.Equal(t, os.FileMode(0600), entry.Attributes.Mode)
+	assert.Equal(t, uint64(len("added content")), entry.Attributes.Size)
+	assert.Equal(t, dirDefaultMode&os.ModeType|0750, lookUp(rfs, fuseops.RootInodeID, "new").Attributes.Mode)
+	assert.Equal(t, later.Entry.Child, lookUp(rfs, fuseops.RootInodeID, "later").Child)
+
+	require.NoError(t, recovered.Commit())
+	assert.Equal(t, []string{source.BundleID}, bundle.BundleDescriptor.Parents)
+	assert.False(t, rfs.hasStagingJournal())
+
+	published, err := ioutil.TempDir("", "datamon-mutable-published")
+	require.NoError(t, err)
+	defer os.RemoveAll(published)
+	committed := NewBundle(NewBDescriptor(), Repo(repo), BundleID(bundle.BundleID), ContextStores(stores),
+		ConsumableStore(localfs.New(afero.NewBasePathFs(afero.NewOsFs(), published))))
+	require.NoError(t, Publish(ctx, committed))
+	for name, content := range map[string]string{
+		"dir/unchanged": "unchanged content", "dir/modified": "modified CONTENT", "new/added": "added content",
+	} {
+		rdr, err := committed.ConsumableStore.Get(ctx, name)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(rdr)
+		require.NoError(t, err)
+		assert.Equal(t, content, string(b))
+	}
+	_, err = committed.ConsumableStore.Get(ctx, "removed")
+	assert.Error(t, err)
+	target, err := os.Readlink(filepath.Join(published, "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "new/added", target)
+
+	// an interrupted filesystem may be discarded instead
+	_, err = NewMutableFS(newBundle(), staging)
+	require.NoError(t, err)
+	require.NoError(t, DiscardStaging(staging))
+	infos, err := ioutil.ReadDir(staging)
+	require.NoError(t, err)
+	assert.Empty(t, infos)
+}
@@ -0,0 +1,229 @@
+/*
+ * Copyright © 2019 One Concern
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/oneconcern/datamon/pkg/cafs"
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/dlogger"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/oneconcern/datamon/pkg/storage"
+)
+
+// FsckOption sets options for the integrity check of a repo
+type FsckOption func(*fsckSettings)
+
+type fsckSettings struct {
+	rehash   bool
+	listOpts []ListOption
+	l        *zap.Logger
+}
+
+// FsckRehash reads back all the blobs of the repo, and checks that their content matches their key
+func FsckRehash(rehash bool) FsckOption {
+	return func(s *fsckSettings) {
+		s.rehash = rehash
+	}
+}
+
+// FsckListOptions sets the options used to list bundles
+func FsckListOptions(opts ...ListOption) FsckOption {
+	return func(s *fsckSettings) {
+		s.listOpts = append(s.listOpts, opts...)
+	}
+}
+
+// FsckLogger sets the logger of the integrity check
+func FsckLogger(l *zap.Logger) FsckOption {
+	return func(s *fsckSettings) {
+		if l != nil {
+			s.l = l
+		}
+	}
+}
+
+func defaultFsckSettings() fsckSettings {
+	l, _ := dlogger.GetLogger("info")
+	return fsckSettings{
+		l: l,
+	}
+}
+
+// FsckReport describes the damage found in the bundles of a repo
+type FsckReport struct {
+	Repo     string          `json:"repo" yaml:"repo"`
+	Bundles  int             `json:"bundles" yaml:"bundles"`
+	Files    int             `json:"files" yaml:"files"`
+	Roots    int             `json:"roots" yaml:"roots"` // distinct root keys checked
+	Rehashed bool            `json:"rehashed" yaml:"rehashed"`
+	Damaged  []DamagedBundle `json:"damaged" yaml:"damaged"`
+}
+
+// DamagedBundle is a bundle which some files can't be downloaded
+type DamagedBundle struct {
+	BundleID string        `json:"bundle" yaml:"bundle"`
+	Error    string        `json:"error,omitempty" yaml:"error,omitempty"` // the file lists of the bundle can't be read
+	Files    []DamagedFile `json:"files,omitempty" yaml:"files,omitempty"`
+}
+
+// DamagedFile is a file of a bundle with missing or corrupt blobs
+type DamagedFile struct {
+	Name    string   `json:"name" yaml:"name"`
+	Hash    string   `json:"hash" yaml:"hash"`
+	Missing []string `json:"missing,omitempty" yaml:"missing,omitempty"` // keys absent from the blob store
+	Corrupt []string `json:"corrupt,omitempty" yaml:"corrupt,omitempty"` // blobs which content doesn't match their key
+}
+
+// Healthy tells if no damage was found
+func (r FsckReport) Healthy() bool {
+	return len(r.Damaged) == 0
+}
+
+// Fsck checks that every bundle of a repo is fully backed by blobs.
+//
+// The file lists of all bundles are walked, and every root and leaf key they reference is looked up in
+// the blob store. With FsckRehash, blobs are also read back to detect bit rot.
+//
+// Damaged bundles are reported, and don't interrupt the check: an error is only returned when the repo
+// can't be walked.
+func Fsck(ctx context.Context, repo string, stores context2.Stores, opts ...FsckOption) (FsckReport, error) {
+	settings := defaultFsckSettings()
+	for _, apply := range opts {
+		apply(&settings)
+	}
+	report := FsckReport{Repo: repo, Rehashed: settings.rehash, Damaged: make([]DamagedBundle, 0)}
+	if getBlobStore(stores) == nil || getMetaStore(stores) == nil {
+		return report, fmt.Errorf("integrity check requires both blob and metadata stores")
+	}
+	if err := RepoExists(repo, stores); err != nil {
+		return report, err
+	}
+
+	// roots are shared by bundles, so they are only checked once
+	checked := make(map[cafs.Key]DamagedFile)
+	err := ListBundlesApply(repo, stores, func(bd model.BundleDescriptor) error {
+		report.Bundles++
+		damaged, err := fsckBundle(ctx, stores, repo, bd, settings, checked, &report)
+		if err != nil {
+			return err
+		}
+		if damaged.Error != "" || len(damaged.Files) != 0 {
+			report.Damaged = append(report.Damaged, damaged)
+		}
+		return nil
+	}, settings.listOpts...)
+	return report, err
+}
+
+func fsckBundle(ctx context.Context, stores context2.Stores, repo string, bd model.BundleDescriptor,
+	settings fsckSettings, checked map[cafs.Key]DamagedFile, report *FsckReport) (DamagedBundle, error) {
+	damaged := DamagedBundle{BundleID: bd.ID}
+	bundle := NewBundle(&bd,
+		Repo(repo),
+		BundleID(bd.ID),
+		ContextStores(stores),
+		Logger(settings.l),
+	)
+	if err := unpackBundleFileList(ctx, bundle, false, defaultBundleEntriesPerFile); err != nil {
+		settings.l.Warn("bundle file lists can't be read",
+			zap.String("repo", repo),
+			zap.String("bundle", bd.ID),
+			zap.Error(err),
+		)
+		damaged.Error = err.Error()
+		return damaged, nil
+	}
+
+	blobs := bundle.BlobStore()
+	fs, err := cafs.New(
+		cafs.LeafSize(bd.LeafSize),
+		cafs.Backend(blobs),
+	)
+	if err != nil {
+		return damaged, err
+	}
+	for _, entry := range bundle.BundleEntries {
+		report.Files++
+		if entry.Hash == "" {
+			// directories and symlinks have no content
+			continue
+		}
+		root, err := cafs.KeyFromString(entry.Hash)
+		if err != nil {
+			damaged.Files = append(damaged.Files, DamagedFile{Name: entry.NameWithPath, Hash: entry.Hash,
+				Corrupt: []string{entry.Hash}})
+			continue
+		}
+		damage, ok := checked[root]
+		if !ok {
+			report.Roots++
+			damage, err = fsckRoot(ctx, fs, blobs, bd, root, settings)
+			if err != nil {
+				return damaged, fmt.Errorf("bundle %s, file %s: %w", bd.ID, entry.NameWithPath, err)
+			}
+			checked[root] = damage
+		}
+		if len(damage.Missing) != 0 || len(damage.Corrupt) != 0 {
+			settings.l.Warn("bundle file is damaged",
+				zap.String("repo", repo),
+				zap.String("bundle", bd.ID),
+				zap.String("file", entry.NameWithPath),
+				zap.Strings("missing", damage.Missing),
+				zap.Strings("corrupt", damage.Corrupt),
+			)
+			damage.Name = entry.NameWithPath
+			damage.Hash = entry.Hash
+			damaged.Files = append(damaged.Files, damage)
+		}
+	}
+	return damaged, nil
+}
+
+// fsckRoot checks the blobs of a root key
+func fsckRoot(ctx context.Context, fs cafs.Fs, blobs storage.Store, bd model.BundleDescriptor, root cafs.Key, settings fsckSettings) (DamagedFile, error) {
+	var damage DamagedFile
+	has, missing, err := fs.Has(ctx, root, cafs.HasGatherIncomplete())
+	if err != nil {
+		return damage, err
+	}
+	if !has {
+		// the root is either missing, or doesn't decode into leaves
+		found, _, err := fs.Has(ctx, root)
+		if err != nil {
+			return damage, err
+		}
+		if found {
+			damage.Corrupt = []string{root.String()}
+		} else {
+			damage.Missing = []string{root.String()}
+		}
+		return damage, nil
+	}
+	for _, key := range missing {
+		damage.Missing = append(damage.Missing, key.String())
+	}
+	if !settings.rehash {
+		return damage, nil
+	}
+	if bd.Version < 1 {
+		// leaves of legacy bundles are padded, so they can't be re-hashed
+		settings.l.Warn("legacy bundle blobs are not re-hashed", zap.String("bundle", bd.ID))
+		return damage, nil
+	}
+	corrupt, err := cafs.CorruptLeafs(ctx, blobs, root, bd.LeafSize, "")
+	if err != nil {
+		return damage, err
+	}
+	for _, key := range corrupt {
+		damage.Corrupt = append(damage.Corrupt, key.String())
+	}
+	return damage, nil
+}
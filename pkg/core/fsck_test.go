@@ -0,0 +1,91 @@
+/*
+ * Copyright © 2019 One Concern
+ *
+ */
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/oneconcern/datamon/pkg/cafs"
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFsck(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, nil, memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+	blobs := getBlobStore(stores)
+
+	healthy := uploadTestBundle(t, stores, map[string]string{"shared.txt": "shared content", "healthy.txt": "healthy content"})
+	damaged := uploadTestBundle(t, stores, map[string]string{
+		"shared.txt":  "shared content",
+		"no-root.txt": "content without root",
+		"no-leaf.txt": "content without leaf",
+		"rotten.txt":  "rotten content",
+	})
+
+	report, err := Fsck(ctx, repo, stores)
+	require.NoError(t, err)
+	require.True(t, report.Healthy())
+	require.Equal(t, 2, report.Bundles)
+	require.Equal(t, 6, report.Files)
+	require.Equal(t, 5, report.Roots)
+
+	metadata := NewBundle(NewBDescriptor(), Repo(repo), BundleID(damaged.BundleID), ContextStores(stores))
+	require.NoError(t, DownloadMetadata(ctx, metadata))
+	roots := make(map[string]cafs.Key)
+	leaves := make(map[string]cafs.Key)
+	for _, entry := range metadata.BundleEntries {
+		root, err := cafs.KeyFromString(entry.Hash)
+		require.NoError(t, err)
+		keys, err := cafs.LeafsForHash(blobs, root, metadata.BundleDescriptor.LeafSize, "")
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		roots[entry.NameWithPath], leaves[entry.NameWithPath] = root, keys[0]
+	}
+	require.NoError(t, blobs.Delete(ctx, roots["no-root.txt"].String()))
+	require.NoError(t, blobs.Delete(ctx, leaves["no-leaf.txt"].String()))
+	require.NoError(t, blobs.Put(ctx, leaves["rotten.txt"].String(), bytes.NewBufferString("bit rot"), storage.OverWrite))
+
+	damage := func(report FsckReport) map[string]DamagedFile {
+		require.Len(t, report.Damaged, 1)
+		require.Equal(t, damaged.BundleID, report.Damaged[0].BundleID)
+		require.Empty(t, report.Damaged[0].Error)
+		files := make(map[string]DamagedFile)
+		for _, file := range report.Damaged[0].Files {
+			require.Equal(t, roots[file.Name].String(), file.Hash)
+			files[file.Name] = file
+		}
+		return files
+	}
+
+	// bit rot goes unnoticed without re-hashing
+	report, err = Fsck(ctx, repo, stores)
+	require.NoError(t, err)
+	require.False(t, report.Healthy())
+	files := damage(report)
+	require.Len(t, files, 2)
+	require.Equal(t, []string{roots["no-root.txt"].String()}, files["no-root.txt"].Missing)
+	require.Equal(t, []string{leaves["no-leaf.txt"].String()}, files["no-leaf.txt"].Missing)
+
+	report, err = Fsck(ctx, repo, stores, FsckRehash(true))
+	require.NoError(t, err)
+	require.True(t, report.Rehashed)
+	files = damage(report)
+	require.Len(t, files, 3)
+	require.Equal(t, []string{leaves["rotten.txt"].String()}, files["rotten.txt"].Corrupt)
+	require.Empty(t, files["rotten.txt"].Missing)
+
+	// a bundle which file list is gone
+	require.NoError(t, getMetaStore(stores).Delete(ctx, model.GetArchivePathToBundleFileList(repo, healthy.BundleID, 0)))
+	report, err = Fsck(ctx, repo, stores)
+	require.NoError(t, err)
+	require.Len(t, report.Damaged, 2)
+}
@@ -0,0 +1,243 @@
+/*
+ * Copyright © 2019 One Concern
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/oneconcern/datamon/pkg/cafs"
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/dlogger"
+	"github.com/oneconcern/datamon/pkg/model"
+)
+
+const (
+	// DefaultGCGracePeriod protects recently written blobs, e.g. from uploads in progress, against collection
+	DefaultGCGracePeriod = 4 * cafs.BlobTouchAge
+)
+
+// GCOption sets options for the garbage collection of blobs
+type GCOption func(*gcSettings)
+
+type gcSettings struct {
+	dryRun      bool
+	gracePeriod time.Duration
+	listOpts    []ListOption
+	l           *zap.Logger
+}
+
+// GCDryRun reports what would be collected, without deleting anything
+func GCDryRun(dryRun bool) GCOption {
+	return func(s *gcSettings) {
+		s.dryRun = dryRun
+	}
+}
+
+// GCGracePeriod sets the minimum age of an unreferenced blob to be collected. It defaults to DefaultGCGracePeriod.
+//
+// Uploads only touch the blobs they reuse once older than cafs.BlobTouchAge: a shorter grace period may collect them.
+func GCGracePeriod(gracePeriod time.Duration) GCOption {
+	return func(s *gcSettings) {
+		s.gracePeriod = gracePeriod
+	}
+}
+
+// GCListOptions sets the options used to list repos, bundles and blobs
+func GCListOptions(opts ...ListOption) GCOption {
+	return func(s *gcSettings) {
+		s.listOpts = append(s.listOpts, opts...)
+	}
+}
+
+// GCLogger sets the logger of the garbage collector
+func GCLogger(l *zap.Logger) GCOption {
+	return func(s *gcSettings) {
+		if l != nil {
+			s.l = l
+		}
+	}
+}
+
+func defaultGCSettings() gcSettings {
+	l, _ := dlogger.GetLogger("info")
+	return gcSettings{
+		gracePeriod: DefaultGCGracePeriod,
+		l:           l,
+	}
+}
+
+// GCReport summarizes a garbage collection of the blob store
+type GCReport struct {
+	Repos          int
+	Bundles        int
+	Referenced     int   // blobs referenced by some bundle
+	MissingRoots   int   // root keys referenced by some bundle, but absent from the blob store
+	Orphans        int   // unreferenced blobs
+	Skipped        int   // unreferenced blobs still within the grace period
+	Deleted        int   // unreferenced blobs collected (or that would be, when dry-running)
+	BytesReclaimed int64 // size of the collected blobs
+	DryRun         bool
+}
+
+// CollectGarbage removes the blobs that no bundle in any repo of the context references.
+//
+// The mark phase walks the file lists of all bundles, including deleted bundles which are not purged yet,
+// and expands every referenced root key into its leaves.
+// The sweep phase then deletes all other blobs, provided they are older than the grace period.
+//
+// Any error during the mark phase aborts the collection, so that no referenced blob is ever removed.
+func CollectGarbage(ctx context.Context, stores context2.Stores, opts ...GCOption) (GCReport, error) {
+	settings := defaultGCSettings()
+	for _, apply := range opts {
+		apply(&settings)
+	}
+	report := GCReport{DryRun: settings.dryRun}
+	if getBlobStore(stores) == nil || getMetaStore(stores) == nil {
+		return report, fmt.Errorf("garbage collection requires both blob and metadata stores")
+	}
+
+	marked, err := markReferencedBlobs(ctx, stores, settings, &report)
+	if err != nil {
+		return report, fmt.Errorf("mark phase: %w", err)
+	}
+	report.Referenced = len(marked)
+
+	if err = sweepOrphanBlobs(ctx, stores, settings, marked, &report); err != nil {
+		return report, fmt.Errorf("sweep phase: %w", err)
+	}
+	return report, nil
+}
+
+func markReferencedBlobs(ctx context.Context, stores context2.Stores, settings gcSettings, report *GCReport) (map[cafs.Key]struct{}, error) {
+	marked := make(map[cafs.Key]struct{})
+
+	// deleted repos and bundles keep their blobs until purged
+	listOpts := append([]ListOption{IncludeDeleted(true)}, settings.listOpts...)
+	repos, err := ListRepos(stores, listOpts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, repo := range repos {
+		report.Repos++
+		err = ListBundlesApply(repo.Name, stores, func(bd model.BundleDescriptor) error {
+			report.Bundles++
+			return markBundle(ctx, stores, repo.Name, bd, settings, marked, report)
+		}, listOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("repo %s: %w", repo.Name, err)
+		}
+	}
+	return marked, nil
+}
+
+func markBundle(ctx context.Context, stores context2.Stores, repo string, bd model.BundleDescriptor,
+	settings gcSettings, marked map[cafs.Key]struct{}, report *GCReport) error {
+	bundle := NewBundle(&bd,
+		Repo(repo),
+		BundleID(bd.ID),
+		ContextStores(stores),
+		Logger(settings.l),
+	)
+	if err := unpackBundleFileList(ctx, bundle, false, defaultBundleEntriesPerFile); err != nil {
+		return fmt.Errorf("bundle %s: %w", bd.ID, err)
+	}
+
+	blobs := bundle.BlobStore()
+	for _, entry := range bundle.BundleEntries {
+		if entry.Hash == "" {
+			// directories and symlinks have no content
+			continue
+		}
+		root, err := cafs.KeyFromString(entry.Hash)
+		if err != nil {
+			return fmt.Errorf("bundle %s, file %s: %w", bd.ID, entry.NameWithPath, err)
+		}
+		if _, ok := marked[root]; ok {
+			continue
+		}
+		found, err := blobs.Has(ctx, root.String())
+		if err != nil {
+			return err
+		}
+		if !found {
+			settings.l.Warn("bundle references a missing root key",
+				zap.String("repo", repo),
+				zap.String("bundle", bd.ID),
+				zap.String("file", entry.NameWithPath),
+				zap.String("key", entry.Hash),
+			)
+			report.MissingRoots++
+			continue
+		}
+		leaves, err := cafs.LeafsForHash(blobs, root, bd.LeafSize, "")
+		if err != nil {
+			return fmt.Errorf("bundle %s, file %s: %w", bd.ID, entry.NameWithPath, err)
+		}
+		marked[root] = struct{}{}
+		for _, leaf := range leaves {
+			marked[leaf] = struct{}{}
+		}
+	}
+	return nil
+}
+
+func sweepOrphanBlobs(ctx context.Context, stores context2.Stores, settings gcSettings,
+	marked map[cafs.Key]struct{}, report *GCReport) error {
+	blobs := getBlobStore(stores)
+	listSettings := defaultSettings()
+	for _, apply := range settings.listOpts {
+		apply(&listSettings)
+	}
+
+	// orphans are collected before any deletion, so as not to disrupt the paging of keys
+	orphans := make([]string, 0)
+	var pageToken string
+	for {
+		keys, next, err := blobs.KeysPrefix(ctx, pageToken, "", "", listSettings.batchSize)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			k, err := cafs.KeyFromString(key)
+			if err != nil {
+				// not a blob: leave it alone
+				continue
+			}
+			if _, ok := marked[k]; !ok {
+				orphans = append(orphans, key)
+			}
+		}
+		if next == "" || next == pageToken {
+			break
+		}
+		pageToken = next
+	}
+	report.Orphans = len(orphans)
+
+	cutoff := time.Now().Add(-settings.gracePeriod)
+	for _, key := range orphans {
+		attrs, err := blobs.GetAttr(ctx, key)
+		if err != nil {
+			return err
+		}
+		if attrs.Updated.After(cutoff) {
+			report.Skipped++
+			continue
+		}
+		if !settings.dryRun {
+			if err = blobs.Delete(ctx, key); err != nil {
+				return err
+			}
+		}
+		report.Deleted++
+		report.BytesReclaimed += attrs.Size
+	}
+	return nil
+}
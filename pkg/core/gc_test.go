@@ -0,0 +1,156 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/localfs"
+)
+
+func createTestRepo(t *testing.T, stores context2.Stores) {
+	require.NoError(t, CreateRepo(model.RepoDescriptor{
+		Name:        repo,
+		Description: "test",
+		Timestamp:   time.Now(),
+		Contributor: model.Contributor{Name: "test", Email: "t@test.com"},
+	}, stores, nil))
+}
+
+// uploadTestBundle uploads a bundle made of some files to the test repo
+func uploadTestBundle(t *testing.T, stores context2.Stores, files map[string]string) *Bundle {
+	ctx := context.Background()
+	source := localfs.New(afero.NewMemMapFs())
+	for name, content := range files {
+		require.NoError(t, source.Put(ctx, name, bytes.NewBufferString(content), storage.NoOverWrite))
+	}
+	bundle := NewBundle(NewBDescriptor(), Repo(repo), ConsumableStore(source), ContextStores(stores))
+	require.NoError(t, Upload(ctx, bundle))
+	return bundle
+}
+
+func TestCollectGarbage(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, nil, memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+
+	kept := uploadTestBundle(t, stores, map[string]string{"shared.txt": "shared content", "kept.txt": "kept content"})
+	dropped := uploadTestBundle(t, stores, map[string]string{"shared.txt": "shared content", "dropped.txt": "dropped content"})
+
+	hashes := func(bundle *Bundle) map[string]string {
+		metadata := NewBundle(NewBDescriptor(), Repo(repo), BundleID(bundle.BundleID), ContextStores(stores))
+		require.NoError(t, DownloadMetadata(ctx, metadata))
+		res := make(map[string]string)
+		for _, entry := range metadata.BundleEntries {
+			res[entry.NameWithPath] = entry.Hash
+		}
+		return res
+	}
+	keptHashes, droppedHashes := hashes(kept), hashes(dropped)
+
+	// unreference the blobs of the dropped bundle
+	require.NoError(t, stores.Metadata().Delete(ctx, model.GetArchivePathToBundle(repo, dropped.BundleID)))
+
+	// foreign objects in the blob store are left alone
+	blobs := stores.Blob()
+	require.NoError(t, blobs.Put(ctx, "not-a-blob", bytes.NewBufferString("x"), storage.NoOverWrite))
+	before, err := blobs.Keys(ctx)
+	require.NoError(t, err)
+
+	report, err := CollectGarbage(ctx, stores, GCGracePeriod(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Repos)
+	require.Equal(t, 1, report.Bundles)
+	require.NotZero(t, report.Orphans)
+	require.Equal(t, report.Orphans, report.Skipped)
+	require.Zero(t, report.Deleted)
+
+	report, err = CollectGarbage(ctx, stores, GCGracePeriod(0), GCDryRun(true), GCListOptions(BatchSize(2)))
+	require.NoError(t, err)
+	require.True(t, report.DryRun)
+	require.NotZero(t, report.Deleted)
+	require.Equal(t, report.Orphans, report.Deleted)
+	require.True(t, report.BytesReclaimed > 0)
+	after, err := blobs.Keys(ctx)
+	require.NoError(t, err)
+	require.Len(t, after, len(before))
+
+	dryRun := report
+	report, err = CollectGarbage(ctx, stores, GCGracePeriod(0))
+	require.NoError(t, err)
+	require.False(t, report.DryRun)
+	require.Equal(t, dryRun.Deleted, report.Deleted)
+	require.Equal(t, dryRun.BytesReclaimed, report.BytesReclaimed)
+	require.Zero(t, report.MissingRoots)
+	after, err = blobs.Keys(ctx)
+	require.NoError(t, err)
+	require.Len(t, after, len(before)-report.Deleted)
+
+	has := func(key string) bool {
+		found, e := blobs.Has(ctx, key)
+		require.NoError(t, e)
+		return found
+	}
+	require.True(t, has("not-a-blob"))
+	require.True(t, has(keptHashes["shared.txt"]))
+	require.True(t, has(keptHashes["kept.txt"]))
+	require.Equal(t, keptHashes["shared.txt"], droppedHashes["shared.txt"])
+	require.False(t, has(droppedHashes["dropped.txt"]))
+
+	// the remaining bundle is intact
+	destination := localfs.New(afero.NewMemMapFs())
+	downloaded := NewBundle(NewBDescriptor(), Repo(repo), BundleID(kept.BundleID),
+		ConsumableStore(destination), ContextStores(stores))
+	require.NoError(t, Publish(ctx, downloaded))
+	require.Len(t, downloaded.BundleEntries, 2)
+
+	report, err = CollectGarbage(ctx, stores, GCGracePeriod(0))
+	require.NoError(t, err)
+	require.Zero(t, report.Orphans)
+}
+
+func TestCollectGarbageReusedOrphans(t *testing.T) {
+	ctx := context.Background()
+	blobFs := afero.NewMemMapFs()
+	stores := context2.NewStores(nil, nil, localfs.New(blobFs), memStore(), memStore())
+	createTestRepo(t, stores)
+	files := map[string]string{"data.txt": "orphan content"}
+
+	// the blobs of an interrupted upload, or of a deleted bundle, have expired
+	orphaned := uploadTestBundle(t, stores, files)
+	require.NoError(t, stores.Metadata().Delete(ctx, model.GetArchivePathToBundle(repo, orphaned.BundleID)))
+	expired := time.Now().Add(-2 * DefaultGCGracePeriod)
+	keys, err := stores.Blob().Keys(ctx)
+	require.NoError(t, err)
+	for _, key := range keys {
+		require.NoError(t, blobFs.Chtimes(key, expired, expired))
+	}
+
+	// an upload in progress reuses them, and is not committed yet when blobs are collected
+	reusing := uploadTestBundle(t, stores, files)
+	descriptorPath := model.GetArchivePathToBundle(repo, reusing.BundleID)
+	rdr, err := stores.Metadata().Get(ctx, descriptorPath)
+	require.NoError(t, err)
+	descriptor, err := ioutil.ReadAll(rdr)
+	require.NoError(t, err)
+	require.NoError(t, stores.Metadata().Delete(ctx, descriptorPath))
+
+	report, err := CollectGarbage(ctx, stores)
+	require.NoError(t, err)
+	require.NotZero(t, report.Orphans)
+	require.Zero(t, report.Deleted)
+
+	// the upload completes with all its blobs
+	require.NoError(t, stores.Metadata().Put(ctx, descriptorPath, bytes.NewReader(descriptor), storage.NoOverWrite))
+	downloaded := NewBundle(NewBDescriptor(), Repo(repo), BundleID(reusing.BundleID),
+		ConsumableStore(localfs.New(afero.NewMemMapFs())), ContextStores(stores))
+	require.NoError(t, Publish(ctx, downloaded))
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright © 2019 One Concern
+ *
+ */
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"hash/crc32"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/oneconcern/datamon/pkg/core/status"
+	"github.com/oneconcern/datamon/pkg/errors"
+	"github.com/oneconcern/datamon/pkg/storage"
+)
+
+// generationRef is a mutable ref, such as a branch or a label, which moves are written as successive generations.
+//
+// A generation is written once, at a key of its own, so that only one of several concurrent moves from the same
+// generation succeeds: this relies on the precondition on the absence of an object supported by stores.
+// The latest generation is also written at the key of the ref. This is only a hint, which lags behind when a writer
+// is interrupted, or overwrites it with an older generation: the following generations are always looked up.
+type generationRef struct {
+	store storage.Store
+	// hint is the key of the latest known generation
+	hint string
+	// generation returns the key of some generation
+	generation func(uint64) string
+}
+
+// generationOf a descriptor, as written in yaml by branches and labels
+type generationOf struct {
+	Generation uint64 `yaml:"generation"`
+}
+
+// head returns the hint and the latest generation of the ref, in yaml. Both are nil when the ref has never been written.
+func (r generationRef) head(ctx context.Context) (hint []byte, head []byte, err error) {
+	hint, err = getDescriptor(ctx, r.store, r.hint)
+	if err != nil && !errors.Is(err, status.ErrNotFound) {
+		return nil, nil, err
+	}
+	head = hint
+	var current generationOf
+	if err = yaml.Unmarshal(hint, &current); err != nil {
+		return nil, nil, err
+	}
+	for {
+		next, err := getDescriptor(ctx, r.store, r.generation(current.Generation+1))
+		if errors.Is(err, status.ErrNotFound) {
+			return hint, head, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = yaml.Unmarshal(next, &current); err != nil {
+			return nil, nil, err
+		}
+		head = next
+	}
+}
+
+// put writes a generation, unless it exists already: moved is then true.
+func (r generationRef) put(ctx context.Context, generation uint64, buffer []byte) (moved bool, err error) {
+	key := r.generation(generation)
+	if err = putDescriptor(ctx, r.store, key, buffer, storage.NoOverWrite); err != nil {
+		// stores report conflicting writes with different errors
+		if exists, e := r.store.Has(ctx, key); e == nil && exists {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// setHint writes the latest generation at the key of the ref
+func (r generationRef) setHint(ctx context.Context, buffer []byte) error {
+	return putDescriptor(ctx, r.store, r.hint, buffer, storage.OverWrite)
+}
+
+// getDescriptor reads a generation, or a hint, or fails with status.ErrNotFound
+func getDescriptor(ctx context.Context, store storage.Store, key string) ([]byte, error) {
+	has, err := store.Has(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, status.ErrNotFound
+	}
+	rdr, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	return ioutil.ReadAll(rdr)
+}
+
+func putDescriptor(ctx context.Context, store storage.Store, key string, buffer []byte, newKey storage.NewKey) error {
+	crcStore, ok := store.(storage.StoreCRC)
+	if ok {
+		crc := crc32.Checksum(buffer, crc32.MakeTable(crc32.Castagnoli))
+		return crcStore.PutCRC(ctx, key, bytes.NewReader(buffer), newKey, crc)
+	}
+	return store.Put(ctx, key, bytes.NewReader(buffer), newKey)
+}
@@ -24,6 +24,8 @@ type nodeEntry struct {
 	refCount          int
 	attr              fuseops.InodeAttributes
 	pathToBackingFile string // empty for directory
+	target            string // symbolic links only
+	hash              string // files which still have the content of the source bundle only
 }
 
 func (g *iNodeGenerator) allocINode() fuseops.InodeID {
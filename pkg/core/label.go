@@ -1,10 +1,9 @@
 package core
 
 import (
-	"bytes"
 	"context"
-	"hash/crc32"
-	"io/ioutil"
+	"fmt"
+	"sort"
 	"time"
 
 	context2 "github.com/oneconcern/datamon/pkg/context"
@@ -12,6 +11,7 @@ import (
 	"gopkg.in/yaml.v2"
 
 	"github.com/oneconcern/datamon/pkg/core/status"
+	"github.com/oneconcern/datamon/pkg/errors"
 	"github.com/oneconcern/datamon/pkg/model"
 	"github.com/oneconcern/datamon/pkg/storage"
 )
@@ -66,32 +66,141 @@ func NewLabel(ld *model.LabelDescriptor, labelOps ...LabelOption) *Label {
 	return &label
 }
 
+// UploadDescriptor points the label to a bundle.
+//
+// The move is recorded as a new generation of the label. When the label is moved concurrently, the move is
+// retried after it, so that the last writer wins and both moves are kept in the history of the label.
 func (label *Label) UploadDescriptor(ctx context.Context, bundle *Bundle) error {
+	return label.uploadDescriptor(ctx, bundle, nil)
+}
+
+// UploadDescriptorIf points the label to a bundle, provided the label still points to some expected bundle.
+//
+// This is a compare-and-swap: it fails with status.ErrLabelMoved when the label points to another bundle,
+// or is moved concurrently. An empty expected bundle means that the label doesn't exist yet.
+func (label *Label) UploadDescriptorIf(ctx context.Context, bundle *Bundle, expectedBundleID string) error {
+	return label.uploadDescriptor(ctx, bundle, &expectedBundleID)
+}
+
+func (label *Label) uploadDescriptor(ctx context.Context, bundle *Bundle, expectedBundleID *string) error {
 	e := RepoExists(bundle.RepoID, bundle.contextStores)
 	if e != nil {
 		return e
 	}
 	label.Descriptor.BundleID = bundle.BundleID
-	buffer, err := yaml.Marshal(label.Descriptor)
-	if err != nil {
+	store := getLabelStore(bundle.contextStores)
+	for {
+		head, found, err := getLabelHead(ctx, store, bundle.RepoID, label.Descriptor.Name)
+		if err != nil {
+			return err
+		}
+		if expectedBundleID != nil && *expectedBundleID == "" && found {
+			return fmt.Errorf("%w: label %s of repo %s exists already, pointing to bundle %s",
+				status.ErrLabelMoved, label.Descriptor.Name, bundle.RepoID, head.BundleID)
+		}
+		if expectedBundleID != nil && head.BundleID != *expectedBundleID {
+			return fmt.Errorf("%w: label %s of repo %s points to bundle %q, not %q",
+				status.ErrLabelMoved, label.Descriptor.Name, bundle.RepoID, head.BundleID, *expectedBundleID)
+		}
+		err = moveLabel(ctx, bundle, head, found, &label.Descriptor)
+		if errors.Is(err, status.ErrLabelMoved) && expectedBundleID == nil {
+			continue
+		}
 		return err
 	}
-	lsCRC, ok := bundle.contextStores.VMetadata().(storage.StoreCRC)
-	if ok {
-		crc := crc32.Checksum(buffer, crc32.MakeTable(crc32.Castagnoli))
-		err = lsCRC.PutCRC(ctx,
-			model.GetArchivePathToLabel(bundle.RepoID, label.Descriptor.Name),
-			bytes.NewReader(buffer), storage.OverWrite, crc)
+}
 
-	} else {
-		err = bundle.contextStores.VMetadata().Put(ctx,
-			model.GetArchivePathToLabel(bundle.RepoID, label.Descriptor.Name),
-			bytes.NewReader(buffer), storage.OverWrite)
+// labelRef locates the generations of a label
+func labelRef(store storage.Store, repo, name string) generationRef {
+	return generationRef{
+		store: store,
+		hint:  model.GetArchivePathToLabel(repo, name),
+		generation: func(generation uint64) string {
+			return model.GetArchivePathToLabelGeneration(repo, name, generation)
+		},
+	}
+}
+
+// getLabelHead returns the latest generation of a label, and whether the label exists.
+//
+// Clients which don't record generations move a label by overwriting the hint with a descriptor without generation.
+// Such a hint is the head when it is more recent than the latest generation: it then stands at that generation,
+// so that the next move follows it.
+func getLabelHead(ctx context.Context, store storage.Store, repo, name string) (model.LabelDescriptor, bool, error) {
+	head := model.LabelDescriptor{Name: name}
+	hintBuffer, headBuffer, err := labelRef(store, repo, name).head(ctx)
+	if err != nil || headBuffer == nil {
+		return head, false, err
+	}
+	var hint model.LabelDescriptor
+	if err = yaml.Unmarshal(hintBuffer, &hint); err != nil {
+		return head, false, err
+	}
+	if err = yaml.Unmarshal(headBuffer, &head); err != nil {
+		return head, false, err
+	}
+	if isLegacyLabelMove(hint, head) {
+		hint.Generation = head.Generation
+		return hint, true, nil
+	}
+	return head, true, nil
+}
+
+// isLegacyLabelMove tells if a descriptor without generation moves a label after its latest generation.
+//
+// Both descriptors may be written by different clients: this compares their clocks. A move by a client which clock
+// lags behind the one of the latest generation is missed, and a stale descriptor written by a client which clock is
+// ahead is taken for a move.
+func isLegacyLabelMove(ld, latest model.LabelDescriptor) bool {
+	return ld.Generation == 0 && ld.Name != "" && latest.Generation > 0 && ld.Timestamp.After(latest.Timestamp)
+}
+
+func getLabelDescriptor(ctx context.Context, store storage.Store, key string) (model.LabelDescriptor, error) {
+	var label model.LabelDescriptor
+	buffer, err := getDescriptor(ctx, store, key)
+	if err != nil {
+		return label, err
+	}
+	err = yaml.Unmarshal(buffer, &label)
+	return label, err
+}
+
+// moveLabel writes the generation of a label following head.
+func moveLabel(ctx context.Context, bundle *Bundle, head model.LabelDescriptor, found bool, next *model.LabelDescriptor) error {
+	ref := labelRef(getLabelStore(bundle.contextStores), bundle.RepoID, next.Name)
+	if found && head.Generation == 0 {
+		// the label was set before generations were recorded: its current target starts the history
+		buffer, err := yaml.Marshal(head)
+		if err != nil {
+			return err
+		}
+		if _, err = ref.put(ctx, 0, buffer); err != nil {
+			return err
+		}
 	}
+	next.Generation = head.Generation + 1
+	buffer, err := yaml.Marshal(next)
 	if err != nil {
 		return err
 	}
-	return nil
+	moved, err := ref.put(ctx, next.Generation, buffer)
+	if err != nil {
+		return err
+	}
+	if moved {
+		return fmt.Errorf("%w: label %s of repo %s is no longer at generation %d (bundle %s)",
+			status.ErrLabelMoved, head.Name, bundle.RepoID, head.Generation, head.BundleID)
+	}
+	// the WAL records the move once it has happened, since it may be rejected
+	if err = appendWALEntry(ctx, bundle.contextStores, bundle.l,
+		model.NewLabelSetPayload(bundle.RepoID, *next)); err != nil {
+		return err
+	}
+	return ref.setHint(ctx, buffer)
+}
+
+func uploadLabelDescriptor(ctx context.Context, store storage.Store, repo, name string, buffer []byte) error {
+	return labelRef(store, repo, name).setHint(ctx, buffer)
 }
 
 func (label *Label) DownloadDescriptor(ctx context.Context, bundle *Bundle, checkRepoExists bool) error {
@@ -101,27 +210,66 @@ func (label *Label) DownloadDescriptor(ctx context.Context, bundle *Bundle, chec
 			return e
 		}
 	}
-	archivePath := model.GetArchivePathToLabel(bundle.RepoID, label.Descriptor.Name)
-	has, err := getLabelStore(bundle.contextStores).Has(context.Background(), archivePath)
+	head, found, err := getLabelHead(ctx, getLabelStore(bundle.contextStores), bundle.RepoID, label.Descriptor.Name)
 	if err != nil {
 		return err
 	}
-	if !has {
+	if !found {
 		return status.ErrNotFound
 	}
-	rdr, err := getLabelStore(bundle.contextStores).Get(context.Background(), archivePath)
-	if err != nil {
-		return err
+	label.Descriptor = head
+	return nil
+}
+
+// LabelHistory returns the successive generations of a label, the latest first.
+//
+// The history of a label set before generations were recorded starts with its target at that time, as generation 0.
+// A move by a client which doesn't record generations comes first, at the generation it followed: it is left out of
+// the history once the label is moved again.
+// It fails with status.ErrNotFound when the label has never been set.
+func LabelHistory(ctx context.Context, stores context2.Stores, repo, name string, opts ...ListOption) (model.LabelDescriptors, error) {
+	settings := defaultSettings()
+	for _, apply := range opts {
+		apply(&settings)
 	}
-	o, err := ioutil.ReadAll(rdr)
-	if err != nil {
-		return err
+	if err := RepoExists(repo, stores); err != nil {
+		return nil, err
 	}
-	err = yaml.Unmarshal(o, &label.Descriptor)
+	store := getLabelStore(stores)
+	keys, err := listKeysPrefix(ctx, store, model.GetArchivePathPrefixToLabelGenerationsOf(repo, name), settings.batchSize)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	history := make(model.LabelDescriptors, 0, len(keys)+1)
+	for _, key := range keys {
+		generation, err := getLabelDescriptor(ctx, store, key)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, generation)
+	}
+	if len(history) == 0 {
+		head, found, err := getLabelHead(ctx, store, repo, name)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: no label %s in repo %s", status.ErrNotFound, name, repo)
+		}
+		history = append(history, head)
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].Generation > history[j].Generation })
+
+	// the label may have been moved since by a client which doesn't record generations
+	hint, err := getLabelDescriptor(ctx, store, model.GetArchivePathToLabel(repo, name))
+	if err != nil && !errors.Is(err, status.ErrNotFound) {
+		return nil, err
+	}
+	if err == nil && isLegacyLabelMove(hint, history[0]) {
+		hint.Generation = history[0].Generation
+		history = append(model.LabelDescriptors{hint}, history...)
+	}
+	return history, nil
 }
 
 func GetLabelStore(stores context2.Stores) storage.Store {
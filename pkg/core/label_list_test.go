@@ -119,9 +119,9 @@ func extractID(pth string) string {
 func mockedLabelStore(testcase string) storage.Store {
 	switch testcase {
 	case happyPath:
-		return &mockstorage.StoreMock{
-			HasFunc: func(_ context.Context, _ string) (bool, error) {
-				return true, nil
+		return withoutTombstones(&mockstorage.StoreMock{
+			HasFunc: func(_ context.Context, pth string) (bool, error) {
+				return !strings.HasPrefix(pth, "label-generations/"), nil
 			},
 			KeysPrefixFunc: func(_ context.Context, _ string, prefix string, delimiter string, count int) ([]string, string, error) {
 				return []string{"labels/myRepo/myLabel-test.yaml"}, "", nil
@@ -133,11 +133,11 @@ func mockedLabelStore(testcase string) storage.Store {
 				extractID(pth)
 				return ioutil.NopCloser(strings.NewReader(buildLabelYaml(extractID(pth)))), nil
 			},
-		}
+		})
 	case happyWithBatches:
-		return &mockstorage.StoreMock{
-			HasFunc: func(_ context.Context, _ string) (bool, error) {
-				return true, nil
+		return withoutTombstones(&mockstorage.StoreMock{
+			HasFunc: func(_ context.Context, pth string) (bool, error) {
+				return !strings.HasPrefix(pth, "label-generations/"), nil
 			},
 			KeysPrefixFunc: func(_ context.Context, _ string, prefix string, delimiter string, count int) ([]string, string, error) {
 				return labelBatchFixture, "", nil
@@ -148,7 +148,7 @@ func mockedLabelStore(testcase string) storage.Store {
 			GetFunc: func(_ context.Context, pth string) (io.ReadCloser, error) {
 				return ioutil.NopCloser(strings.NewReader(buildLabelYaml(extractID(pth)))), nil
 			},
-		}
+		})
 	default:
 		return nil
 	}
@@ -0,0 +1,106 @@
+/*
+ * Copyright © 2019 One Concern
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/core/status"
+	"github.com/oneconcern/datamon/pkg/errors"
+	"github.com/oneconcern/datamon/pkg/model"
+)
+
+func TestLabelHistory(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, nil, memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+	first := uploadTestBundle(t, stores, map[string]string{"file": "first"})
+	second := uploadTestBundle(t, stores, map[string]string{"file": "second"})
+	third := uploadTestBundle(t, stores, map[string]string{"file": "third"})
+
+	setLabel := func(name string, bundle *Bundle, expected ...string) error {
+		label := NewLabel(nil, LabelName(name))
+		if len(expected) > 0 {
+			return label.UploadDescriptorIf(ctx, bundle, expected[0])
+		}
+		return label.UploadDescriptor(ctx, bundle)
+	}
+	history := func(name string) []string {
+		generations, err := LabelHistory(ctx, stores, repo, name)
+		require.NoError(t, err)
+		res := make([]string, 0, len(generations))
+		for _, generation := range generations {
+			res = append(res, generation.BundleID)
+		}
+		return res
+	}
+
+	_, err := LabelHistory(ctx, stores, repo, "nosuchlabel")
+	require.True(t, errors.Is(err, status.ErrNotFound))
+
+	require.NoError(t, setLabel("latest", first))
+	require.NoError(t, setLabel("latest", second))
+	require.Equal(t, []string{second.BundleID, first.BundleID}, history("latest"))
+
+	// compare-and-swap
+	require.True(t, errors.Is(setLabel("latest", third, first.BundleID), status.ErrLabelMoved))
+	require.True(t, errors.Is(setLabel("latest", third, ""), status.ErrLabelMoved))
+	require.NoError(t, setLabel("latest", third, second.BundleID))
+	require.NoError(t, setLabel("new", first, ""))
+
+	label := NewLabel(nil, LabelName("latest"))
+	require.NoError(t, label.DownloadDescriptor(ctx, NewBundle(NewBDescriptor(), Repo(repo), ContextStores(stores)), true))
+	require.Equal(t, third.BundleID, label.Descriptor.BundleID)
+	require.Equal(t, uint64(3), label.Descriptor.Generation)
+
+	// a concurrent writer moved the label from the same head
+	head, found, err := getLabelHead(ctx, getLabelStore(stores), repo, "new")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.NoError(t, setLabel("new", second))
+	next := model.LabelDescriptor{Name: "new", BundleID: third.BundleID}
+	err = moveLabel(ctx, NewBundle(NewBDescriptor(), Repo(repo), ContextStores(stores)), head, found, &next)
+	require.True(t, errors.Is(err, status.ErrLabelMoved))
+
+	// a label set before generations were recorded keeps its target in the history
+	legacy, err := yaml.Marshal(model.LabelDescriptor{Name: "legacy", BundleID: first.BundleID})
+	require.NoError(t, err)
+	require.NoError(t, uploadLabelDescriptor(ctx, getLabelStore(stores), repo, "legacy", legacy))
+	require.Equal(t, []string{first.BundleID}, history("legacy"))
+	require.NoError(t, setLabel("legacy", second, first.BundleID))
+	require.Equal(t, []string{second.BundleID, first.BundleID}, history("legacy"))
+
+	// an older client moves the label by overwriting the hint, without generation
+	moved, err := yaml.Marshal(model.LabelDescriptor{Name: "latest", BundleID: first.BundleID, Timestamp: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	require.NoError(t, uploadLabelDescriptor(ctx, getLabelStore(stores), repo, "latest", moved))
+	label = NewLabel(nil, LabelName("latest"))
+	require.NoError(t, label.DownloadDescriptor(ctx, NewBundle(NewBDescriptor(), Repo(repo), ContextStores(stores)), true))
+	require.Equal(t, first.BundleID, label.Descriptor.BundleID)
+	require.Equal(t, uint64(3), label.Descriptor.Generation)
+	require.Equal(t, []string{first.BundleID, third.BundleID, second.BundleID, first.BundleID}, history("latest"))
+	require.NoError(t, setLabel("latest", second, first.BundleID))
+	require.Equal(t, []string{second.BundleID, third.BundleID, second.BundleID, first.BundleID}, history("latest"))
+
+	// a stale hint without generation doesn't win over the latest generation
+	stale, err := yaml.Marshal(model.LabelDescriptor{Name: "latest", BundleID: third.BundleID, Timestamp: time.Now().Add(-time.Hour)})
+	require.NoError(t, err)
+	require.NoError(t, uploadLabelDescriptor(ctx, getLabelStore(stores), repo, "latest", stale))
+	head, _, err = getLabelHead(ctx, getLabelStore(stores), repo, "latest")
+	require.NoError(t, err)
+	require.Equal(t, second.BundleID, head.BundleID)
+	require.Equal(t, uint64(4), head.Generation)
+
+	labels, err := ListLabels(repo, stores, "")
+	require.NoError(t, err)
+	require.Len(t, labels, 3)
+}
@@ -10,6 +10,7 @@ type Settings struct {
 	concurrentList int
 	batchSize      int
 	doneChannel    chan struct{}
+	includeDeleted bool
 }
 
 const (
@@ -49,6 +50,13 @@ func WithDoneChan(done chan struct{}) ListOption {
 	}
 }
 
+// IncludeDeleted lists repos and bundles which have been deleted but not purged yet
+func IncludeDeleted(includeDeleted bool) ListOption {
+	return func(s *Settings) {
+		s.includeDeleted = includeDeleted
+	}
+}
+
 func defaultSettings() Settings {
 	return Settings{
 		concurrentList: defaultListConcurrency,
@@ -0,0 +1,107 @@
+/*
+ * Copyright © 2019 One Concern
+ *
+ */
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/segmentio/ksuid"
+	"gopkg.in/yaml.v2"
+
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/oneconcern/datamon/pkg/storage"
+)
+
+// GetReadLogStore returns the store of the read log
+func GetReadLogStore(stores context2.Stores) storage.Store {
+	return getReadLogStore(stores)
+}
+
+// AppendReadLog records a read of a bundle in the read log of the context.
+//
+// Contexts without a read log store are not logged.
+func AppendReadLog(ctx context.Context, stores context2.Stores, entry model.ReadLogEntry) error {
+	store := getReadLogStore(stores)
+	if store == nil {
+		return nil
+	}
+	if entry.Repo == "" || entry.BundleID == "" {
+		return fmt.Errorf("read log entry requires a repo and a bundle: %v", entry)
+	}
+	id, err := ksuid.NewRandomWithTime(entry.Timestamp)
+	if err != nil {
+		return err
+	}
+	buffer, err := yaml.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	err = store.Put(ctx,
+		model.GetArchivePathToReadLogEntry(entry.Repo, entry.BundleID, id.String()),
+		bytes.NewReader(buffer), storage.NoOverWrite)
+	if err != nil {
+		return fmt.Errorf("failed to write read log entry: %v", err)
+	}
+	return nil
+}
+
+// ListReadLog returns the reads of a bundle recorded in the read log, sorted by time.
+func ListReadLog(ctx context.Context, stores context2.Stores, repo, bundleID string, opts ...ListOption) (model.ReadLogEntries, error) {
+	store := getReadLogStore(stores)
+	if store == nil {
+		return nil, fmt.Errorf("no read log store in context")
+	}
+	settings := defaultSettings()
+	for _, apply := range opts {
+		apply(&settings)
+	}
+
+	entries := make(model.ReadLogEntries, 0)
+	prefix := model.GetArchivePathPrefixToReadLog(repo, bundleID)
+	var pageToken string
+	for {
+		keys, next, err := store.KeysPrefix(ctx, pageToken, prefix, "", settings.batchSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			entry, err := getReadLogEntry(ctx, store, key)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+		if next == "" || next == pageToken {
+			break
+		}
+		pageToken = next
+	}
+	sort.Stable(entries)
+	return entries, nil
+}
+
+func getReadLogEntry(ctx context.Context, store storage.Store, key string) (model.ReadLogEntry, error) {
+	var entry model.ReadLogEntry
+	rdr, err := store.Get(ctx, key)
+	if err != nil {
+		return entry, err
+	}
+	defer rdr.Close()
+	o, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		return entry, err
+	}
+	err = yaml.Unmarshal(o, &entry)
+	if err != nil {
+		return entry, fmt.Errorf("failed to read log entry %s: %v", key, err)
+	}
+	return entry, nil
+}
@@ -0,0 +1,61 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/model"
+)
+
+func TestReadLog(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, memStore(), nil, nil, nil)
+	now := time.Now()
+	reads := []model.ReadLogEntry{
+		{
+			Repo:        repo,
+			BundleID:    bundleID,
+			Label:       "latest",
+			Operation:   model.ReadOperationMount,
+			Contributor: model.Contributor{Name: "pipeline-b", Email: "b@test.com"},
+			Timestamp:   now.Add(time.Minute),
+		},
+		{
+			Repo:        repo,
+			BundleID:    bundleID,
+			Operation:   model.ReadOperationDownload,
+			Contributor: model.Contributor{Name: "pipeline-a", Email: "a@test.com"},
+			Timestamp:   now,
+		},
+		{
+			Repo:        repo,
+			BundleID:    "other-bundle",
+			Operation:   model.ReadOperationDownloadFile,
+			File:        "some/file",
+			Contributor: model.Contributor{Name: "pipeline-c", Email: "c@test.com"},
+			Timestamp:   now,
+		},
+	}
+	for _, read := range reads {
+		require.NoError(t, AppendReadLog(ctx, stores, read))
+	}
+
+	entries, err := ListReadLog(ctx, stores, repo, bundleID, BatchSize(1))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "pipeline-a", entries[0].Contributor.Name)
+	require.Equal(t, "pipeline-b", entries[1].Contributor.Name)
+	require.Equal(t, "latest", entries[1].Label)
+
+	entries, err = ListReadLog(ctx, stores, repo, "other-bundle")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "some/file", entries[0].File)
+
+	require.Error(t, AppendReadLog(ctx, stores, model.ReadLogEntry{Repo: repo}))
+	require.NoError(t, AppendReadLog(ctx, context2.Stores{}, reads[0]))
+}
@@ -7,23 +7,37 @@ import (
 	"strings"
 
 	context2 "github.com/oneconcern/datamon/pkg/context"
+	"go.uber.org/zap"
 
 	"github.com/oneconcern/datamon/pkg/model"
 	"github.com/oneconcern/datamon/pkg/storage"
 	"gopkg.in/yaml.v2"
 )
 
-func CreateRepo(repo model.RepoDescriptor, stores context2.Stores) error {
-	store := GetRepoStore(stores) // TODO: Integrate with WAL.
+// CreateRepo creates a repo, unless it exists already. The creation is logged in the WAL of the context.
+func CreateRepo(repo model.RepoDescriptor, stores context2.Stores, logger *zap.Logger) error {
+	store := GetRepoStore(stores)
 	err := model.Validate(repo)
 	if err != nil {
 		return err
 	}
 	r, e := yaml.Marshal(repo)
 	if e != nil {
-		return err
+		return e
 	}
 	path := model.GetArchivePathToRepoDescriptor(repo.Name)
+	// an existing repo is not logged again
+	exists, err := store.Has(context.Background(), path)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("repo already exists: %s", repo.Name)
+	}
+	err = appendWALEntry(context.Background(), stores, logger, model.NewRepoCreatePayload(repo))
+	if err != nil {
+		return err
+	}
 	err = store.Put(context.Background(), path, bytes.NewReader(r), storage.NoOverWrite)
 	if err != nil {
 		if strings.Contains(err.Error(), "googleapi: Error 412: Precondition Failed, conditionNotMet") {
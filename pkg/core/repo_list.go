@@ -19,14 +19,21 @@ const (
 	typicalReposNum = 1000 // default number of allocated slots for repos
 )
 
-// GetRepoDescriptorByRepoName returns the descriptor of a named repo
+// GetRepoDescriptorByRepoName returns the descriptor of a named repo. Deleted repos are not found.
 func GetRepoDescriptorByRepoName(stores context2.Stores, repoName string) (model.RepoDescriptor, error) {
+	deleted, err := GetRepoStore(stores).Has(context.Background(), model.GetArchivePathToRepoTombstone(repoName))
+	if err != nil {
+		return model.RepoDescriptor{}, err
+	}
+	if deleted {
+		return model.RepoDescriptor{}, status.ErrNotFound
+	}
 	return getRepoDescriptorByRepoName(stores, repoName)
 }
 
 func getRepoDescriptorByRepoName(stores context2.Stores, repoName string) (model.RepoDescriptor, error) {
 	var rd model.RepoDescriptor
-	store := GetRepoStore(stores) // TODO: ReadLog integration
+	store := GetRepoStore(stores)
 	archivePathToRepoDescriptor := model.GetArchivePathToRepoDescriptor(repoName)
 	has, err := GetRepoStore(stores).Has(context.Background(), archivePathToRepoDescriptor)
 	if err != nil {
@@ -152,6 +159,13 @@ func listReposChan(stores context2.Stores, opts ...ListOption) (chan reposEvent,
 
 	batchChan := make(chan reposEvent, 1) // buffered to 1 to avoid blocking on early errors
 
+	deleted, err := listDeleted(stores, model.GetArchivePathPrefixToRepoTombstones(), settings)
+	if err != nil {
+		batchChan <- reposEvent{err: err}
+		close(batchChan)
+		return batchChan, &wg
+	}
+
 	// internal signaling channels
 	doneWithKeysChan := make(chan struct{}, 1)
 	doneWithReposChan := make(chan struct{}, 1)
@@ -173,7 +187,7 @@ func listReposChan(stores context2.Stores, opts ...ListOption) (chan reposEvent,
 
 	// start repo metadata retrieval
 	wg.Add(1)
-	go fetchRepos(stores, settings, keysChan, batchChan, doneWithKeysChan, doneWithReposChan, &wg)
+	go fetchRepos(stores, settings, deleted, keysChan, batchChan, doneWithKeysChan, doneWithReposChan, &wg)
 
 	// let the gc clean up internal signaling channels left open after wg goroutines are done.
 
@@ -193,7 +207,7 @@ func doSelectRepos(reposChan <-chan reposEvent, do func(model.RepoDescriptors))
 }
 
 // fetchRepos waits on a channel of key batches and outputs batches of descriptors corresponding to these keys
-func fetchRepos(stores context2.Stores, settings Settings,
+func fetchRepos(stores context2.Stores, settings Settings, deleted map[string]struct{},
 	keysChan <-chan keyBatchEvent, batchChan chan<- reposEvent,
 	doneWithKeysChan chan<- struct{}, doneChan <-chan struct{}, wg *sync.WaitGroup) {
 	defer func() {
@@ -221,7 +235,7 @@ func fetchRepos(stores context2.Stores, settings Settings,
 				return
 			}
 			// send out a single batch of (ordered) bundle descriptors
-			batchChan <- reposEvent{repos: batch}
+			batchChan <- reposEvent{repos: skipDeletedRepos(batch, deleted)}
 		}
 	}
 }
@@ -81,7 +81,7 @@ func buildRepoBatchFixture(t *testing.T) func() {
 func mockedRepoStore(testcase string) storage.Store {
 	switch testcase {
 	case happyPath:
-		return &mockstorage.StoreMock{
+		return withoutTombstones(&mockstorage.StoreMock{
 			HasFunc: func(_ context.Context, _ string) (bool, error) {
 				return true, nil
 			},
@@ -96,9 +96,9 @@ func mockedRepoStore(testcase string) storage.Store {
 				repo := parts[1]
 				return ioutil.NopCloser(strings.NewReader(buildRepoYaml(repo))), nil
 			},
-		}
+		})
 	case happyWithBatches:
-		return &mockstorage.StoreMock{
+		return withoutTombstones(&mockstorage.StoreMock{
 			HasFunc: func(_ context.Context, _ string) (bool, error) {
 				return true, nil
 			},
@@ -113,7 +113,7 @@ func mockedRepoStore(testcase string) storage.Store {
 				repo := parts[1]
 				return ioutil.NopCloser(strings.NewReader(buildRepoYaml(repo))), nil
 			},
-		}
+		})
 	default:
 		return nil
 	}
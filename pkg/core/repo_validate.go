@@ -13,6 +13,11 @@ import (
 )
 
 func RepoExists(repo string, stores context2.Stores) error {
+	return repoExists(repo, stores, false)
+}
+
+// repoExists checks for the descriptor of a repo and, unless includeDeleted is set, for the absence of a tombstone
+func repoExists(repo string, stores context2.Stores, includeDeleted bool) error {
 	exists, err := GetRepoStore(stores).Has(context.Background(), model.GetArchivePathToRepoDescriptor(repo))
 	if err != nil {
 		return fmt.Errorf("repo validation failed: Hit err:%s", err)
@@ -20,6 +25,16 @@ func RepoExists(repo string, stores context2.Stores) error {
 	if !exists {
 		return fmt.Errorf("repo validation: Repo:%s does not exist", repo)
 	}
+	if includeDeleted {
+		return nil
+	}
+	deleted, err := GetRepoStore(stores).Has(context.Background(), model.GetArchivePathToRepoTombstone(repo))
+	if err != nil {
+		return fmt.Errorf("repo validation failed: Hit err:%s", err)
+	}
+	if deleted {
+		return fmt.Errorf("repo validation: Repo:%s has been deleted", repo)
+	}
 	return nil
 }
 
@@ -9,4 +9,14 @@ var (
 	ErrInterrupted = errors.New("background processing interrupted")
 	// ErrNotFound indicates an object was not found
 	ErrNotFound = errors.New("not found")
+	// ErrLabelled indicates a bundle cannot be deleted while labels or branches point to it
+	ErrLabelled = errors.New("bundle is labelled")
+	// ErrBranchExists indicates a branch cannot be created, because it exists already
+	ErrBranchExists = errors.New("branch exists already")
+	// ErrBranchMoved indicates the head of a branch has been moved concurrently
+	ErrBranchMoved = errors.New("branch head moved")
+	// ErrLabelMoved indicates a label doesn't point to the expected bundle, e.g. because it has been moved concurrently
+	ErrLabelMoved = errors.New("label moved")
+	// ErrConflict indicates bundles cannot be merged, because some paths are changed differently on both sides
+	ErrConflict = errors.New("merge conflict")
 )
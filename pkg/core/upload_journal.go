@@ -0,0 +1,396 @@
+// Copyright © 2019 One Concern
+
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/oneconcern/datamon/pkg/cafs"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/status"
+	"go.uber.org/zap"
+)
+
+// Kinds of records in an upload journal
+const (
+	journalHeader = "header"
+	journalFile   = "file"
+	journalPage   = "page"
+)
+
+// journalRecord is a line of an upload journal.
+//
+// An upload journal is a local, append-only file of JSON records, which checkpoints the progress of a
+// bundle upload:
+//   - a header, written when the upload starts or resumes
+//   - a file record for each file packed, with the size and modification time of the source file
+//   - a page record for each bundle entries file uploaded to the metadata store
+//
+// A record is written on a single line: a truncated last line, left by a crash, is ignored.
+type journalRecord struct {
+	Kind string `json:"kind"`
+
+	// header
+	Repo     string `json:"repo,omitempty"`
+	BundleID string `json:"bundleID,omitempty"`
+	LeafSize uint32 `json:"leafSize,omitempty"`
+	Chunking string `json:"chunking,omitempty"`
+	Pages    uint64 `json:"pages,omitempty"`
+
+	// file
+	Entry      *model.BundleEntry `json:"entry,omitempty"`
+	SourceSize int64              `json:"sourceSize,omitempty"`
+	ModTime    time.Time          `json:"modTime,omitempty"`
+
+	// page
+	Index   uint64              `json:"index,omitempty"`
+	Entries []model.BundleEntry `json:"entries,omitempty"`
+}
+
+type journaledFile struct {
+	entry   model.BundleEntry
+	size    int64
+	modTime time.Time
+}
+
+// uploadCheckpoint is the progress of an interrupted upload, as recorded in its journal
+type uploadCheckpoint struct {
+	bundleID string
+	leafSize uint32
+	chunking string
+	files    map[string]journaledFile
+	pages    [][]model.BundleEntry
+}
+
+type uploadJournal struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	w    *bufio.Writer
+}
+
+func readUploadJournal(pth, repo string) (*uploadCheckpoint, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload journal: %w", err)
+	}
+	defer f.Close()
+
+	checkpoint := &uploadCheckpoint{files: make(map[string]journaledFile)}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 256*1024*1024)
+	for scanner.Scan() {
+		var record journalRecord
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// truncated record, left by a crash
+			break
+		}
+		switch record.Kind {
+		case journalHeader:
+			if record.Repo != repo {
+				return nil, fmt.Errorf("upload journal %s is for repo %s, not %s", pth, record.Repo, repo)
+			}
+			checkpoint.bundleID = record.BundleID
+			checkpoint.leafSize = record.LeafSize
+			checkpoint.chunking = record.Chunking
+			if uint64(len(checkpoint.pages)) > record.Pages {
+				checkpoint.pages = checkpoint.pages[:record.Pages]
+			}
+		case journalFile:
+			if record.Entry != nil {
+				checkpoint.files[record.Entry.NameWithPath] = journaledFile{
+					entry:   *record.Entry,
+					size:    record.SourceSize,
+					modTime: record.ModTime,
+				}
+			}
+		case journalPage:
+			if record.Index != uint64(len(checkpoint.pages)) {
+				return nil, fmt.Errorf("corrupted upload journal %s: unexpected page %d", pth, record.Index)
+			}
+			checkpoint.pages = append(checkpoint.pages, record.Entries)
+		}
+	}
+	if checkpoint.bundleID == "" {
+		return nil, fmt.Errorf("invalid upload journal %s: no bundle", pth)
+	}
+	return checkpoint, nil
+}
+
+func createUploadJournal(pth string) (*uploadJournal, error) {
+	if err := os.MkdirAll(filepath.Dir(pth), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create upload journal: %w", err)
+	}
+	f, err := os.OpenFile(pth, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload journal: %w", err)
+	}
+	return &uploadJournal{
+		path: pth,
+		f:    f,
+		w:    bufio.NewWriter(f),
+	}, nil
+}
+
+func (j *uploadJournal) append(record journalRecord, sync bool) error {
+	if j == nil {
+		return nil
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return fmt.Errorf("upload journal %s is closed", j.path)
+	}
+	if _, err = j.w.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("failed to write upload journal: %w", err)
+	}
+	if !sync {
+		return nil
+	}
+	if err = j.w.Flush(); err != nil {
+		return fmt.Errorf("failed to write upload journal: %w", err)
+	}
+	return j.f.Sync()
+}
+
+func (j *uploadJournal) header(bundle *Bundle, pages uint64) error {
+	return j.append(journalRecord{
+		Kind:     journalHeader,
+		Repo:     bundle.RepoID,
+		BundleID: bundle.BundleID,
+		LeafSize: bundle.BundleDescriptor.LeafSize,
+		Chunking: bundle.BundleDescriptor.Chunking,
+		Pages:    pages,
+	}, true)
+}
+
+// file records a packed file. File records are synced with the next page.
+func (j *uploadJournal) file(packed filePacked, attrs storage.Attributes) error {
+	entry := filePacked2BundleEntry(packed)
+	return j.append(journalRecord{
+		Kind:       journalFile,
+		Entry:      &entry,
+		SourceSize: attrs.Size,
+		ModTime:    attrs.Updated,
+	}, false)
+}
+
+func (j *uploadJournal) page(index uint64, entries []model.BundleEntry) error {
+	return j.append(journalRecord{
+		Kind:    journalPage,
+		Index:   index,
+		Entries: entries,
+	}, true)
+}
+
+func (j *uploadJournal) close() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	f := j.f
+	j.f = nil
+	if err := j.w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// remove the journal of a completed upload
+func (j *uploadJournal) remove() error {
+	if j == nil {
+		return nil
+	}
+	if err := j.close(); err != nil {
+		return err
+	}
+	return os.Remove(j.path)
+}
+
+// startUpload sets the ID of the bundle to upload, which is the one of the interrupted upload when resuming.
+//
+// done is true when the interrupted upload actually completed.
+func startUpload(ctx context.Context, bundle *Bundle) (resume uploadResume, done bool, err error) {
+	if bundle.resumeUpload && bundle.uploadJournal == "" {
+		return resume, false, fmt.Errorf("an upload journal is required to resume an upload")
+	}
+	if bundle.resumeUpload {
+		resume.checkpoint, err = readUploadJournal(bundle.uploadJournal, bundle.RepoID)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return resume, false, err
+		}
+	}
+	if resume.checkpoint == nil {
+		// a new upload
+		if bundle.uploadJournal != "" {
+			if err = os.Remove(bundle.uploadJournal); err != nil && !os.IsNotExist(err) {
+				return resume, false, fmt.Errorf("failed to reset upload journal: %w", err)
+			}
+		}
+		return resume, false, bundle.InitializeBundleID()
+	}
+
+	checkpoint := resume.checkpoint
+	if checkpoint.leafSize != bundle.BundleDescriptor.LeafSize || checkpoint.chunking != bundle.BundleDescriptor.Chunking {
+		return resume, false, fmt.Errorf("cannot resume upload of bundle %s with a different leaf size or chunking",
+			checkpoint.bundleID)
+	}
+	bundle.setBundleID(checkpoint.bundleID)
+	exists, err := bundle.Exists(ctx)
+	if err != nil {
+		return resume, false, err
+	}
+	if exists {
+		bundle.l.Info("interrupted upload already completed", zap.String("bundleID", bundle.BundleID))
+		return resume, true, os.Remove(bundle.uploadJournal)
+	}
+
+	kept := checkpoint.keptPages(ctx, bundle.ConsumableStore, bundle.BlobStore(), bundle.concurrentFileUploads)
+	resume.kept = make(map[string]bool)
+	for _, page := range kept {
+		for _, entry := range page {
+			resume.kept[entry.NameWithPath] = true
+		}
+	}
+	// pages listing changed files are uploaded again, as well as the page which upload may have been interrupted
+	// before it was journaled
+	for index := uint64(len(kept)); index <= uint64(len(checkpoint.pages)); index++ {
+		pth := model.GetArchivePathToBundleFileList(bundle.RepoID, bundle.BundleID, index)
+		if err = bundle.MetaStore().Delete(ctx, pth); err != nil && !errors.Is(err, status.ErrNotExists) {
+			return resume, false, fmt.Errorf("failed to remove stale bundle entries file %s: %w", pth, err)
+		}
+	}
+	bundle.BundleDescriptor.BundleEntriesFileCount = uint64(len(kept))
+	bundle.l.Info("resuming upload",
+		zap.String("bundleID", bundle.BundleID),
+		zap.Int("files already uploaded", len(resume.kept)),
+		zap.Int("files already packed", len(checkpoint.files)),
+	)
+	return resume, false, nil
+}
+
+// openUploadJournal opens the journal of the upload, if any, and records the pages of bundle entries kept
+// from an interrupted upload
+func openUploadJournal(bundle *Bundle, pages uint64) (*uploadJournal, error) {
+	if bundle.uploadJournal == "" {
+		return nil, nil
+	}
+	journal, err := createUploadJournal(bundle.uploadJournal)
+	if err != nil {
+		return nil, err
+	}
+	if err = journal.header(bundle, pages); err != nil {
+		_ = journal.close()
+		return nil, err
+	}
+	return journal, nil
+}
+
+// unchanged tells if a source file is the same as when it was packed, and if its blobs are still stored
+func (c *uploadCheckpoint) unchanged(ctx context.Context, source, blobs storage.Store, name string) (journaledFile, bool) {
+	if c == nil {
+		return journaledFile{}, false
+	}
+	file, ok := c.files[name]
+	if !ok {
+		return journaledFile{}, false
+	}
+	attrs, err := source.GetAttr(ctx, name)
+	if err != nil || attrs.Size != file.size || !attrs.Updated.Equal(file.modTime) {
+		return journaledFile{}, false
+	}
+	if file.entry.Hash != "" && !c.stored(ctx, blobs, file.entry.Hash) {
+		return journaledFile{}, false
+	}
+	return file, true
+}
+
+// stored tells if the root and leaves of a file packed before the interruption are all in the blob store.
+//
+// Blobs uploaded by an interrupted upload are orphans, which the garbage collector may have removed since.
+// They are touched, so that it spares them until the upload is completed.
+func (c *uploadCheckpoint) stored(ctx context.Context, blobs storage.Store, hash string) bool {
+	root, err := cafs.KeyFromString(hash)
+	if err != nil {
+		return false
+	}
+	leaves, err := cafs.LeafsForHash(blobs, root, c.leafSize, "")
+	if err != nil {
+		return false
+	}
+	for _, key := range append(leaves, root) {
+		if found, e := blobs.Has(ctx, key.String()); e != nil || !found {
+			return false
+		}
+		if e := blobs.Touch(ctx, key.String()); e != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// keptPages returns the pages of bundle entries uploaded before the interruption, which only hold unchanged files.
+//
+// Pages are kept in order, up to the first one with a changed file. Files are checked concurrently: once a changed
+// file is found, the files of the pages after it are no longer checked.
+func (c *uploadCheckpoint) keptPages(ctx context.Context, source, blobs storage.Store, concurrency int) [][]model.BundleEntry {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		kept = len(c.pages)
+	)
+	keeps := func(index int) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return index < kept
+	}
+	concurrencyControl := make(chan struct{}, concurrency)
+	for i, page := range c.pages {
+		for _, entry := range page {
+			if !keeps(i) {
+				break
+			}
+			concurrencyControl <- struct{}{}
+			wg.Add(1)
+			go func(index int, name string) {
+				defer func() {
+					<-concurrencyControl
+					wg.Done()
+				}()
+				if !keeps(index) {
+					return
+				}
+				if _, ok := c.unchanged(ctx, source, blobs, name); !ok {
+					mu.Lock()
+					if index < kept {
+						kept = index
+					}
+					mu.Unlock()
+				}
+			}(i, entry.NameWithPath)
+		}
+	}
+	wg.Wait()
+	return c.pages[:kept]
+}
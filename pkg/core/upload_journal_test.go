@@ -0,0 +1,252 @@
+// Copyright © 2019 One Concern
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/localfs"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+// readsStore records the files read from a store, and fails reading some
+type readsStore struct {
+	storage.Store
+	mu     sync.Mutex
+	reads  map[string]int
+	broken map[string]bool
+}
+
+func (s *readsStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.broken[key] {
+		return nil, errors.New("broken file")
+	}
+	s.reads[key]++
+	return s.Store.Get(ctx, key)
+}
+
+func TestResumeUpload(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, nil, memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+	dir, err := ioutil.TempDir("", "journal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	journal := filepath.Join(dir, "upload.journal")
+
+	source := localfs.New(afero.NewMemMapFs())
+	files := make(map[string]string)
+	for i := 0; i < 6; i++ {
+		name := fmt.Sprintf("file%d", i)
+		files[name] = "content of " + name
+		require.NoError(t, source.Put(ctx, name, bytes.NewBufferString(files[name]), storage.NoOverWrite))
+	}
+	keys := func() ([]string, error) {
+		names := make([]string, 0, len(files))
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names, nil
+	}
+
+	// the upload is interrupted
+	broken := &readsStore{Store: source, reads: map[string]int{}, broken: map[string]bool{"file5": true}}
+	interrupted := NewBundle(NewBDescriptor(), Repo(repo), ConsumableStore(broken), ContextStores(stores),
+		ConcurrentFileUploads(1), UploadJournal(journal))
+	require.Error(t, uploadBundle(ctx, interrupted, 1, keys))
+	checkpoint, err := readUploadJournal(journal, repo)
+	require.NoError(t, err)
+	require.Equal(t, interrupted.BundleID, checkpoint.bundleID)
+	require.NotEmpty(t, checkpoint.files)
+
+	// a file is changed before resuming
+	files["file3"] = "changed content of file3"
+	require.NoError(t, source.Put(ctx, "file3", bytes.NewBufferString(files["file3"]), storage.OverWrite))
+
+	reads := &readsStore{Store: source, reads: map[string]int{}}
+	resumed := NewBundle(NewBDescriptor(), Repo(repo), ConsumableStore(reads), ContextStores(stores),
+		UploadJournal(journal), ResumeUpload(true))
+	require.NoError(t, uploadBundle(ctx, resumed, 1, keys))
+	require.Equal(t, interrupted.BundleID, resumed.BundleID)
+	for name := range files {
+		_, packed := checkpoint.files[name]
+		if packed && name != "file3" {
+			require.Zero(t, reads.reads[name], "%s was packed before the interruption", name)
+		} else {
+			require.Equal(t, 1, reads.reads[name], name)
+		}
+	}
+	_, err = os.Stat(journal)
+	require.True(t, os.IsNotExist(err))
+
+	// the resumed bundle holds the current content of all files
+	destination := localfs.New(afero.NewMemMapFs())
+	downloaded := NewBundle(NewBDescriptor(), Repo(repo), BundleID(resumed.BundleID), ContextStores(stores),
+		ConsumableStore(destination))
+	require.NoError(t, implPublish(ctx, downloaded, 1, func(string) (bool, error) { return true, nil }))
+	require.Len(t, downloaded.BundleEntries, len(files))
+	for name, content := range files {
+		rdr, err := destination.Get(ctx, name)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(rdr)
+		require.NoError(t, err)
+		require.Equal(t, content, string(b))
+	}
+}
+
+func TestResumeUploadTwice(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, nil, memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+	dir, err := ioutil.TempDir("", "journal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	journal := filepath.Join(dir, "upload.journal")
+
+	source := localfs.New(afero.NewMemMapFs())
+	names := make([]string, 0, 6)
+	for i := 0; i < 6; i++ {
+		name := fmt.Sprintf("file%d", i)
+		names = append(names, name)
+		require.NoError(t, source.Put(ctx, name, bytes.NewBufferString("content of "+name), storage.NoOverWrite))
+	}
+	keys := func() ([]string, error) { return names, nil }
+	const entriesPerPage = 2
+
+	// the upload is interrupted, after two pages of bundle entries
+	broken := &readsStore{Store: source, reads: map[string]int{}, broken: map[string]bool{"file5": true}}
+	interrupted := NewBundle(NewBDescriptor(), Repo(repo), ConsumableStore(broken), ContextStores(stores),
+		ConcurrentFileUploads(1), UploadJournal(journal))
+	require.Error(t, uploadBundle(ctx, interrupted, entriesPerPage, keys))
+	checkpoint, err := readUploadJournal(journal, repo)
+	require.NoError(t, err)
+	require.Len(t, checkpoint.pages, 2)
+
+	// a file of the second page is changed, so that only the first page is kept by the resumed upload,
+	// which is interrupted again
+	require.NoError(t, source.Put(ctx, "file3", bytes.NewBufferString("changed content of file3"), storage.OverWrite))
+	broken = &readsStore{Store: source, reads: map[string]int{}, broken: map[string]bool{"file5": true}}
+	interrupted = NewBundle(NewBDescriptor(), Repo(repo), ConsumableStore(broken), ContextStores(stores),
+		ConcurrentFileUploads(1), UploadJournal(journal), ResumeUpload(true))
+	require.Error(t, uploadBundle(ctx, interrupted, entriesPerPage, keys))
+	checkpoint, err = readUploadJournal(journal, repo)
+	require.NoError(t, err)
+	require.Len(t, checkpoint.pages, 2)
+	require.Equal(t, "file0", checkpoint.pages[0][0].NameWithPath)
+
+	// the second resumption completes the upload
+	resumed := NewBundle(NewBDescriptor(), Repo(repo), ConsumableStore(source), ContextStores(stores),
+		UploadJournal(journal), ResumeUpload(true))
+	require.NoError(t, uploadBundle(ctx, resumed, entriesPerPage, keys))
+	require.Equal(t, checkpoint.bundleID, resumed.BundleID)
+
+	destination := localfs.New(afero.NewMemMapFs())
+	downloaded := NewBundle(NewBDescriptor(), Repo(repo), BundleID(resumed.BundleID), ContextStores(stores),
+		ConsumableStore(destination))
+	require.NoError(t, implPublish(ctx, downloaded, entriesPerPage, func(string) (bool, error) { return true, nil }))
+	require.Len(t, downloaded.BundleEntries, len(names))
+	for _, name := range names {
+		rdr, err := destination.Get(ctx, name)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(rdr)
+		require.NoError(t, err)
+		expected := "content of " + name
+		if name == "file3" {
+			expected = "changed " + expected
+		}
+		require.Equal(t, expected, string(b))
+	}
+}
+
+func TestResumeUploadCollectedBlobs(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, nil, memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+	dir, err := ioutil.TempDir("", "journal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	journal := filepath.Join(dir, "upload.journal")
+
+	source := localfs.New(afero.NewMemMapFs())
+	names := []string{"file0", "file1", "file2"}
+	for _, name := range names {
+		require.NoError(t, source.Put(ctx, name, bytes.NewBufferString("content of "+name), storage.NoOverWrite))
+	}
+	keys := func() ([]string, error) { return names, nil }
+
+	broken := &readsStore{Store: source, reads: map[string]int{}, broken: map[string]bool{"file2": true}}
+	interrupted := NewBundle(NewBDescriptor(), Repo(repo), ConsumableStore(broken), ContextStores(stores),
+		ConcurrentFileUploads(1), UploadJournal(journal))
+	require.Error(t, uploadBundle(ctx, interrupted, 1, keys))
+
+	// the orphan blobs of the interrupted upload are collected before it is resumed
+	blobs, err := stores.Blob().Keys(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, blobs)
+	for _, key := range blobs {
+		require.NoError(t, stores.Blob().Delete(ctx, key))
+	}
+
+	// files which blobs are gone are packed again
+	reads := &readsStore{Store: source, reads: map[string]int{}}
+	resumed := NewBundle(NewBDescriptor(), Repo(repo), ConsumableStore(reads), ContextStores(stores),
+		UploadJournal(journal), ResumeUpload(true))
+	require.NoError(t, uploadBundle(ctx, resumed, 1, keys))
+	for _, name := range names {
+		require.Equal(t, 1, reads.reads[name], name)
+	}
+
+	destination := localfs.New(afero.NewMemMapFs())
+	downloaded := NewBundle(NewBDescriptor(), Repo(repo), BundleID(resumed.BundleID), ContextStores(stores),
+		ConsumableStore(destination))
+	require.NoError(t, implPublish(ctx, downloaded, 1, func(string) (bool, error) { return true, nil }))
+	for _, name := range names {
+		rdr, err := destination.Get(ctx, name)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(rdr)
+		require.NoError(t, err)
+		require.Equal(t, "content of "+name, string(b))
+	}
+}
+
+func TestResumeUploadWithoutJournal(t *testing.T) {
+	ctx := context.Background()
+	stores := context2.NewStores(nil, nil, memStore(), memStore(), memStore())
+	createTestRepo(t, stores)
+	dir, err := ioutil.TempDir("", "journal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	journal := filepath.Join(dir, "upload.journal")
+
+	source := localfs.New(afero.NewMemMapFs())
+	require.NoError(t, source.Put(ctx, "file", bytes.NewBufferString("content"), storage.NoOverWrite))
+
+	// no upload to resume: a new bundle is uploaded
+	bundle := NewBundle(NewBDescriptor(), Repo(repo), ConsumableStore(source), ContextStores(stores),
+		UploadJournal(journal), ResumeUpload(true))
+	require.NoError(t, Upload(ctx, bundle))
+	require.NotEmpty(t, bundle.BundleID)
+	_, err = os.Stat(journal)
+	require.True(t, os.IsNotExist(err))
+
+	// a journal is required to resume
+	bundle = NewBundle(NewBDescriptor(), Repo(repo), ConsumableStore(source), ContextStores(stores),
+		ResumeUpload(true))
+	require.Error(t, Upload(ctx, bundle))
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright © 2019 One Concern
+ *
+ */
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/oneconcern/datamon/pkg/storage"
+	wal2 "github.com/oneconcern/datamon/pkg/wal"
+)
+
+// GetWAL returns the WAL for a context, or nil when the context has no WAL store.
+//
+// The token generator object is kept in the mutable (versioned) metadata store.
+func GetWAL(stores context2.Stores, logger *zap.Logger) (*wal2.WAL, error) {
+	if stores.Wal() == nil {
+		return nil, nil
+	}
+	if stores.VMetadata() == nil {
+		return nil, fmt.Errorf("wal requires a vmetadata store to generate tokens")
+	}
+	return wal2.NewWAL(stores.VMetadata(), stores.Wal(), logger), nil
+}
+
+// appendWALEntry records the intent of a metadata mutation in the WAL.
+//
+// The mutation is only complete once its descriptor is written, after the WAL entry.
+// Contexts without a WAL store are not logged.
+func appendWALEntry(ctx context.Context, stores context2.Stores, logger *zap.Logger, payload model.Payload) error {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	w, err := GetWAL(stores, logger)
+	if err != nil {
+		return err
+	}
+	if w == nil {
+		logger.Debug("no wal store in context, skipping wal entry", zap.Stringer("payload", payload))
+		return nil
+	}
+	token, err := w.Add(ctx, payload)
+	if err != nil {
+		return fmt.Errorf("failed to write wal entry: %v", err)
+	}
+	logger.Debug("wrote wal entry", zap.String("token", token))
+	return nil
+}
+
+// ReplayLabels rebuilds the labels in the vmetadata store from the label-set entries in the WAL.
+//
+// The generations of the labels found in the WAL are written again, unless they exist, and the label points to
+// its latest generation. Descriptors without generation, which are logged by older clients, only win when more
+// recent than the latest generation.
+// When repo is not empty, only the labels of that repo are replayed.
+// The replayed label-set payloads are returned, with one payload per label: the head of the label.
+func ReplayLabels(ctx context.Context, stores context2.Stores, repo string, logger *zap.Logger) ([]model.Payload, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	w, err := GetWAL(stores, logger)
+	if err != nil {
+		return nil, err
+	}
+	if w == nil {
+		return nil, fmt.Errorf("no wal store in context")
+	}
+
+	moves := make(map[string][]model.Payload)
+	order := make([]string, 0)
+	err = w.Walk(ctx, "", func(entry *model.Entry) error {
+		p := entry.Payload
+		if p.Type != model.PayloadTypeLabelSet || p.LabelDescriptor == nil {
+			return nil
+		}
+		if repo != "" && p.Repo != repo {
+			return nil
+		}
+		key := model.GetArchivePathToLabel(p.Repo, p.LabelDescriptor.Name)
+		if _, ok := moves[key]; !ok {
+			order = append(order, key)
+		}
+		moves[key] = append(moves[key], p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	store := getLabelStore(stores)
+	replayed := make([]model.Payload, 0, len(order))
+	for _, key := range order {
+		head, err := replayLabel(ctx, store, moves[key])
+		if err != nil {
+			return replayed, fmt.Errorf("failed to replay label %s: %v", key, err)
+		}
+		logger.Debug("replayed label",
+			zap.String("repo", head.Repo),
+			zap.String("label", head.LabelDescriptor.Name),
+			zap.Uint64("generation", head.LabelDescriptor.Generation))
+		replayed = append(replayed, head)
+	}
+	return replayed, nil
+}
+
+// replayLabel writes the generations of a label found in the WAL, then points the label to its head
+func replayLabel(ctx context.Context, store storage.Store, moves []model.Payload) (model.Payload, error) {
+	sort.SliceStable(moves, func(i, j int) bool {
+		gi, gj := moves[i].LabelDescriptor.Generation, moves[j].LabelDescriptor.Generation
+		if gi != gj {
+			return gi < gj
+		}
+		return moves[i].LabelDescriptor.Timestamp.Before(moves[j].LabelDescriptor.Timestamp)
+	})
+	var latest, legacy *model.Payload
+	for i, p := range moves {
+		if p.LabelDescriptor.Generation == 0 {
+			legacy = &moves[i]
+			continue
+		}
+		latest = &moves[i]
+		buffer, err := yaml.Marshal(p.LabelDescriptor)
+		if err != nil {
+			return p, err
+		}
+		// generations already written are kept
+		ref := labelRef(store, p.Repo, p.LabelDescriptor.Name)
+		if _, err = ref.put(ctx, p.LabelDescriptor.Generation, buffer); err != nil {
+			return p, err
+		}
+	}
+	head := latest
+	if head == nil || (legacy != nil && isLegacyLabelMove(*legacy.LabelDescriptor, *latest.LabelDescriptor)) {
+		head = legacy
+	}
+	buffer, err := yaml.Marshal(head.LabelDescriptor)
+	if err != nil {
+		return *head, err
+	}
+	return *head, uploadLabelDescriptor(ctx, store, head.Repo, head.LabelDescriptor.Name, buffer)
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"context"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+
+	context2 "github.com/oneconcern/datamon/pkg/context"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/localfs"
+)
+
+func memStore() storage.Store {
+	return localfs.New(afero.NewMemMapFs())
+}
+
+func readWALPayloads(t *testing.T, walStore storage.Store) []string {
+	keys, err := walStore.Keys(context.Background())
+	require.NoError(t, err)
+	payloads := make([]string, 0, len(keys))
+	for _, key := range keys {
+		rdr, err := walStore.Get(context.Background(), key)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(rdr)
+		require.NoError(t, err)
+		entry, err := model.UnmarshalWAL(b)
+		require.NoError(t, err)
+		require.Equal(t, key, entry.Token)
+		require.Equal(t, []model.Contributor{{Name: "test", Email: "t@test.com"}}, entry.Payload.Contributors())
+		payloads = append(payloads, entry.Payload.String())
+	}
+	sort.Strings(payloads)
+	return payloads
+}
+
+func TestWALMetadataMutations(t *testing.T) {
+	ctx := context.Background()
+	walStore := memStore()
+	stores := context2.NewStores(walStore, memStore(), memStore(), memStore(), memStore())
+	contributor := model.Contributor{Name: "test", Email: "t@test.com"}
+
+	require.NoError(t, CreateRepo(model.RepoDescriptor{
+		Name:        repo,
+		Description: "test",
+		Timestamp:   time.Now(),
+		Contributor: contributor,
+	}, stores, nil))
+	// creating an existing repo fails, and is not logged
+	require.Error(t, CreateRepo(model.RepoDescriptor{
+		Name:        repo,
+		Description: "again",
+		Timestamp:   time.Now(),
+		Contributor: contributor,
+	}, stores, nil))
+
+	source := memStore()
+	require.NoError(t, source.Put(ctx, "file", strings.NewReader("some data"), storage.NoOverWrite))
+	bundle := NewBundle(NewBDescriptor(Contributor(contributor)),
+		Repo(repo),
+		ConsumableStore(source),
+		ContextStores(stores),
+	)
+	require.NoError(t, Upload(ctx, bundle))
+
+	label := NewLabel(NewLabelDescriptor(LabelContributor(contributor)), LabelName("latest"))
+	require.NoError(t, label.UploadDescriptor(ctx, bundle))
+
+	payloads := readWALPayloads(t, walStore)
+	require.Equal(t, []string{
+		"bundle-commit repo=" + repo + " bundle=" + bundle.BundleID,
+		"label-set repo=" + repo + " label=latest bundle=" + bundle.BundleID,
+		"repo-create repo=" + repo,
+	}, payloads)
+}
+
+func TestReplayLabels(t *testing.T) {
+	ctx := context.Background()
+	vmetaStore := memStore()
+	stores := context2.NewStores(memStore(), memStore(), memStore(), memStore(), vmetaStore)
+	contributor := model.Contributor{Name: "test", Email: "t@test.com"}
+	require.NoError(t, CreateRepo(model.RepoDescriptor{
+		Name:        repo,
+		Description: "test",
+		Timestamp:   time.Now(),
+		Contributor: contributor,
+	}, stores, nil))
+
+	// the clock of the second writer lags behind: generations tell the order of moves
+	bundleIDs := []string{"bundle-1", "bundle-2"}
+	for i, id := range bundleIDs {
+		bundle := NewBundle(NewBDescriptor(), Repo(repo), BundleID(id), ContextStores(stores))
+		ld := NewLabelDescriptor(LabelContributor(contributor))
+		ld.Timestamp = ld.Timestamp.Add(time.Duration(-i) * time.Second)
+		label := NewLabel(ld, LabelName("latest"))
+		require.NoError(t, label.UploadDescriptor(ctx, bundle))
+	}
+
+	// corrupt the label store
+	keys, err := vmetaStore.Keys(ctx)
+	require.NoError(t, err)
+	for _, key := range keys {
+		if strings.Contains(key, "latest") {
+			require.NoError(t, vmetaStore.Delete(ctx, key))
+		}
+	}
+
+	replayed, err := ReplayLabels(ctx, stores, "", nil)
+	require.NoError(t, err)
+	require.Len(t, replayed, 1)
+
+	label := NewLabel(nil, LabelName("latest"))
+	bundle := NewBundle(NewBDescriptor(), Repo(repo), ContextStores(stores))
+	require.NoError(t, label.DownloadDescriptor(ctx, bundle, true))
+	require.Equal(t, "bundle-2", label.Descriptor.BundleID)
+	require.Equal(t, uint64(2), label.Descriptor.Generation)
+	history, err := LabelHistory(ctx, stores, repo, "latest")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+
+	// a more recent move logged by a client which doesn't record generations wins
+	legacy := NewLabelDescriptor(LabelContributor(contributor))
+	legacy.Name = "latest"
+	legacy.BundleID = "bundle-3"
+	legacy.Timestamp = legacy.Timestamp.Add(time.Hour)
+	require.NoError(t, appendWALEntry(ctx, stores, nil, model.NewLabelSetPayload(repo, *legacy)))
+	_, err = ReplayLabels(ctx, stores, "", nil)
+	require.NoError(t, err)
+	require.NoError(t, label.DownloadDescriptor(ctx, bundle, true))
+	require.Equal(t, "bundle-3", label.Descriptor.BundleID)
+	require.Equal(t, uint64(2), label.Descriptor.Generation)
+
+	replayed, err = ReplayLabels(ctx, stores, "other-repo", nil)
+	require.NoError(t, err)
+	require.Empty(t, replayed)
+}
+
+func TestWALSkippedWithoutStore(t *testing.T) {
+	stores := context2.NewStores(nil, nil, nil, memStore(), nil)
+	require.NoError(t, CreateRepo(model.RepoDescriptor{
+		Name:        repo,
+		Description: "test",
+		Timestamp:   time.Now(),
+		Contributor: model.Contributor{Name: "test", Email: "t@test.com"},
+	}, stores, nil))
+	require.NoError(t, RepoExists(repo, stores))
+}
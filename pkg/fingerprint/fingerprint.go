@@ -2,8 +2,8 @@ package fingerprint
 
 import (
 	"bytes"
+	"fmt"
 	"io"
-	"log"
 	"os"
 	"runtime"
 	"sync"
@@ -18,11 +18,13 @@ type chunkInput struct {
 	lastChunk  bool
 	leafSize   uint32
 	level      int
+	nodeOffset uint64
 }
 
 type chunkOutput struct {
 	digest []byte
 	part   int
+	err    error
 }
 
 type Option func(*Maker)
@@ -64,11 +66,21 @@ type Maker struct {
 	numberOfWorkers int
 }
 
+// Process computes the fingerprint of a file
 func (m *Maker) Process(path string) (digest []byte, err error) {
-	r, size, err := m.openPath(path)
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
+	return m.ProcessReader(f)
+}
+
+// ProcessReader computes the fingerprint of some content.
+//
+// The fingerprint is the same as the key of the content stored in a content-addressable FS with the same
+// (fixed) leaf size: leaves are numbered from 1, and only a trailing partial leaf is the last node of the tree.
+func (m *Maker) ProcessReader(r io.Reader) (digest []byte, err error) {
 	var wg sync.WaitGroup
 	chunks := make(chan chunkInput)
 	results := make(chan chunkOutput)
@@ -81,30 +93,30 @@ func (m *Maker) Process(path string) (digest []byte, err error) {
 		}()
 	}
 
+	readErr := make(chan error, 1)
 	go func() {
-		for part, totalSize := 0, int64(0); ; part++ {
+		// Close input channel
+		defer close(chunks)
+		for part := 0; ; part++ {
 			partBuffer := make([]byte, m.leafSize)
-			n, e := r.Read(partBuffer)
-			if e != nil {
-				if e == io.EOF {
-					break
-				}
+			n, e := io.ReadFull(r, partBuffer)
+			switch {
+			case e == io.EOF:
+				readErr <- nil
+				return
+			case e == io.ErrUnexpectedEOF:
+				// trailing partial leaf
+				chunks <- chunkInput{part: part, partBuffer: partBuffer[:n], lastChunk: true, leafSize: m.leafSize,
+					level: 0, nodeOffset: uint64(part)}
+				readErr <- nil
+				return
+			case e != nil:
+				readErr <- e
 				return
 			}
-			partBuffer = partBuffer[:n]
-
-			totalSize += int64(n)
-			lastChunk := uint32(n) < m.leafSize || uint32(n) == m.leafSize && totalSize == size
-
-			chunks <- chunkInput{part: part, partBuffer: partBuffer, lastChunk: lastChunk, leafSize: m.leafSize, level: 0}
-
-			if lastChunk {
-				break
-			}
+			chunks <- chunkInput{part: part, partBuffer: partBuffer, leafSize: m.leafSize, level: 0,
+				nodeOffset: uint64(part + 1)}
 		}
-
-		// Close input channel
-		close(chunks)
 	}()
 
 	// Wait for workers to complete
@@ -117,8 +129,17 @@ func (m *Maker) Process(path string) (digest []byte, err error) {
 	// (number of chunks upfront is unknown for stdin stream)
 	digestHash := make(map[int][]byte)
 	for r := range results {
+		if r.err != nil && err == nil {
+			err = r.err
+		}
 		digestHash[r.part] = r.digest
 	}
+	if e := <-readErr; e != nil {
+		return nil, e
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	// Concatenate digests of chunks
 	sz := int(m.size)
@@ -155,20 +176,6 @@ func (m *Maker) Process(path string) (digest []byte, err error) {
 	return digest, nil
 }
 
-func (m *Maker) openPath(path string) (io.ReadCloser, int64, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, 0, err
-	}
-
-	fi, err := f.Stat()
-	if err != nil {
-		return nil, 0, err
-	}
-
-	return f, fi.Size(), nil
-}
-
 // Worker routine for computing hash for a chunk
 func (m *Maker) processChunk(rx <-chan chunkInput, tx chan<- chunkOutput) {
 	for c := range rx {
@@ -178,22 +185,21 @@ func (m *Maker) processChunk(rx <-chan chunkInput, tx chan<- chunkOutput) {
 				Fanout:        0,
 				MaxDepth:      2,
 				LeafSize:      c.leafSize,
-				NodeOffset:    uint64(c.part),
+				NodeOffset:    c.nodeOffset,
 				NodeDepth:     0,
 				InnerHashSize: m.size,
 				IsLastNode:    c.lastChunk,
 			},
 		})
 		if err != nil {
-			log.Println("Failing to create algorithm: ", err)
-			return
+			tx <- chunkOutput{part: c.part, err: fmt.Errorf("failing to create algorithm: %w", err)}
+			continue
 		}
 
 		blake.Reset()
 		_, err = io.Copy(blake, bytes.NewBuffer(c.partBuffer))
 		if err != nil {
-			log.Println("Failing to compute hash: ", err)
-			tx <- chunkOutput{digest: []byte(""), part: c.part}
+			tx <- chunkOutput{part: c.part, err: fmt.Errorf("failing to compute hash: %w", err)}
 		} else {
 			digest := blake.Sum(nil)
 			tx <- chunkOutput{digest: digest, part: c.part}
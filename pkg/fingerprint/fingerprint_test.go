@@ -0,0 +1,42 @@
+package fingerprint
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/oneconcern/datamon/pkg/cafs"
+	"github.com/oneconcern/datamon/pkg/storage/localfs"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFingerprintMatchesCAFSKey(t *testing.T) {
+	const leafSize = 1024
+	for _, size := range []int{0, 1, leafSize - 1, leafSize, leafSize + 1, 2 * leafSize, 5*leafSize + 3} {
+		data := make([]byte, size)
+		_, _ = rand.Read(data)
+
+		fs, err := cafs.New(cafs.LeafSize(leafSize), cafs.Backend(localfs.New(afero.NewMemMapFs())))
+		require.NoError(t, err)
+		res, err := fs.Put(context.Background(), bytes.NewReader(data))
+		require.NoError(t, err)
+
+		digest, err := New(LeafSize(leafSize)).ProcessReader(bytes.NewReader(data))
+		require.NoError(t, err)
+		require.Equal(t, res.Key[:], digest, "size %d", size)
+
+		f, err := ioutil.TempFile("", "fingerprint")
+		require.NoError(t, err)
+		_, err = f.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		digest, err = New(LeafSize(leafSize)).Process(f.Name())
+		_ = os.Remove(f.Name())
+		require.NoError(t, err)
+		require.Equal(t, res.Key[:], digest, "size %d", size)
+	}
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright © 2019 One Concern
+ *
+ */
+
+package model
+
+import (
+	"fmt"
+	"time"
+	"unicode"
+)
+
+// BranchDescriptor describes the head of a branch.
+//
+// Each move of the head is recorded as a new generation of the branch, so that concurrent moves are detected:
+// a generation may only be written once.
+type BranchDescriptor struct {
+	Name        string      `json:"name" yaml:"name"`
+	BundleID    string      `json:"id,omitempty" yaml:"id,omitempty"` // Empty for a branch without bundle yet
+	Generation  uint64      `json:"generation" yaml:"generation"`
+	Deleted     bool        `json:"deleted,omitempty" yaml:"deleted,omitempty"`
+	Timestamp   time.Time   `json:"timestamp,omitempty" yaml:"timestamp,omitempty"`
+	Contributor Contributor `json:"contributor" yaml:"contributor"`
+	_           struct{}
+}
+
+// BranchDescriptors is a slice of BranchDescriptor sortable by name
+type BranchDescriptors []BranchDescriptor
+
+func (b BranchDescriptors) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+func (b BranchDescriptors) Len() int {
+	return len(b)
+}
+func (b BranchDescriptors) Less(i, j int) bool {
+	return b[i].Name < b[j].Name
+}
+
+func getArchivePathToBranches() string {
+	return "branches/"
+}
+
+func getArchivePathToBranchGenerations() string {
+	return "branch-generations/"
+}
+
+// GetArchivePathPrefixToBranches gets the path to the branches of a repo.
+func GetArchivePathPrefixToBranches(repo string) string {
+	return fmt.Sprint(getArchivePathToBranches(), repo, "/")
+}
+
+// GetArchivePathToBranch gets the path to the latest known head of a branch.
+func GetArchivePathToBranch(repo string, name string) string {
+	return fmt.Sprint(GetArchivePathPrefixToBranches(repo), name, ".yaml")
+}
+
+// GetArchivePathPrefixToBranchGenerations gets the path to the generations of the branches of a repo.
+func GetArchivePathPrefixToBranchGenerations(repo string) string {
+	return fmt.Sprint(getArchivePathToBranchGenerations(), repo, "/")
+}
+
+// GetArchivePathToBranchGeneration gets the path to some generation of a branch.
+//
+// Generations are zero-padded, so that they are listed in order.
+func GetArchivePathToBranchGeneration(repo string, name string, generation uint64) string {
+	return fmt.Sprintf("%s%s/%020d.yaml", GetArchivePathPrefixToBranchGenerations(repo), name, generation)
+}
+
+// ValidateBranchName checks that a branch name only holds letters, digits, hyphens, dots and underscores,
+// and starts with a letter or a digit, so that names such as ".." don't walk out of the paths to branches
+func ValidateBranchName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty field: branch name is empty")
+	}
+	for i, c := range name {
+		if i == 0 && !unicode.IsDigit(c) && !unicode.IsLetter(c) {
+			return fmt.Errorf("invalid name: branch name:%s must start with a letter or a digit", name)
+		}
+		if !unicode.IsDigit(c) && !unicode.IsLetter(c) && !unicode.Is(unicode.Hyphen, c) && c != '.' && c != '_' {
+			return fmt.Errorf("invalid name: branch name:%s contains unsupported character \"%s\"",
+				name,
+				string(c))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetArchivePathToBranch(t *testing.T) {
+	require.Equal(t, "branches/myrepo/main.yaml", GetArchivePathToBranch("myrepo", "main"))
+	require.Equal(t, "branch-generations/myrepo/main/00000000000000000012.yaml",
+		GetArchivePathToBranchGeneration("myrepo", "main", 12))
+
+	// generations are listed in order
+	paths := []string{
+		GetArchivePathToBranchGeneration("myrepo", "main", 10),
+		GetArchivePathToBranchGeneration("myrepo", "main", 9),
+	}
+	require.False(t, sort.StringsAreSorted(paths))
+}
+
+func TestValidateBranchName(t *testing.T) {
+	for _, name := range []string{"main", "feature-1", "v1.2_rc", "1.x"} {
+		require.NoError(t, ValidateBranchName(name), name)
+	}
+	for _, name := range []string{"", "feature/1", "a b", ".", "..", ".hidden", "-rc", "_tmp", "é/x"} {
+		require.Error(t, ValidateBranchName(name), name)
+	}
+}
@@ -10,7 +10,10 @@ import (
 )
 
 const (
-	CurrentBundleVersion = 1
+	// CurrentBundleVersion is the version of newly created bundles.
+	//
+	// Version 2 records file modes, symbolic links and empty directories in bundle entries.
+	CurrentBundleVersion = 2
 )
 
 // BundleDescriptor represents a commit which is a file tree with the changes to the repository.
@@ -21,8 +24,9 @@ type BundleDescriptor struct {
 	Parents                []string      `json:"parents,omitempty" yaml:"parents,omitempty"`
 	Timestamp              time.Time     `json:"timestamp,omitempty" yaml:"timestamp,omitempty"`
 	Contributors           []Contributor `json:"contributors" yaml:"contributors"`
-	BundleEntriesFileCount uint64        `json:"count" yaml:"count"`                         // Number of files which have BundleDescriptor Entries
-	Version                uint64        `json:"version,omitempty" yaml:"version,omitempty"` // Version for the bundle
+	BundleEntriesFileCount uint64        `json:"count" yaml:"count"`                           // Number of files which have BundleDescriptor Entries
+	Version                uint64        `json:"version,omitempty" yaml:"version,omitempty"`   // Version for the bundle
+	Chunking               string        `json:"chunking,omitempty" yaml:"chunking,omitempty"` // How files are split into blobs (defaults to fixed size leaves)
 	_                      struct{}
 }
 
@@ -55,15 +59,34 @@ type ArchivePathComponents struct {
 	LabelName       string
 }
 
-// List of files, directories (empty) skipped
+// List of files, symbolic links and empty directories.
+//
+// Symbolic links and directories have no hash. Entries of bundles before version 2 are all
+// regular files, with no file mode recorded.
 type BundleEntry struct {
 	Hash         string      `json:"hash" yaml:"hash"`
 	NameWithPath string      `json:"name" yaml:"name"`
 	FileMode     os.FileMode `json:"mode" yaml:"mode"`
 	Size         uint64      `json:"size" yaml:"size"`
+	Target       string      `json:"target,omitempty" yaml:"target,omitempty"` // Target of a symbolic link
 	_            struct{}
 }
 
+// IsDir tells if the entry is an empty directory
+func (b BundleEntry) IsDir() bool {
+	return b.FileMode.IsDir()
+}
+
+// IsSymlink tells if the entry is a symbolic link
+func (b BundleEntry) IsSymlink() bool {
+	return b.FileMode&os.ModeSymlink != 0
+}
+
+// HasFileMode tells if the permissions of the entry have been recorded
+func (b BundleEntry) HasFileMode() bool {
+	return b.FileMode != 0
+}
+
 const (
 	ConsumableStorePathTypeDescriptor = iota
 	ConsumableStorePathTypeFileList
@@ -22,7 +22,31 @@ type Context struct {
 	Metadata  string `json:"metadata" yaml:"metadata"`   // Metadata is the location for the immutable metadata
 	VMetadata string `json:"vmetadata" yaml:"vmetadata"` // VMetadata is the location for the mutable versioned metadata.
 	Version   uint64 `json:"version" yaml:"version"`     // Version for the
-	_         struct{}
+	// Encryption configures the client-side encryption of blobs and metadata. Nothing is encrypted when nil.
+	Encryption *Encryption `json:"encryption,omitempty" yaml:"encryption,omitempty"`
+	_          struct{}
+}
+
+// Encryption configures the client-side encryption of a context.
+//
+// The master key is held either in a local key file, or in a KMS under some key id.
+type Encryption struct {
+	KeyFile string `json:"keyFile,omitempty" yaml:"keyFile,omitempty"` // Path to a local master key file
+	KMSDir  string `json:"kmsDir,omitempty" yaml:"kmsDir,omitempty"`   // Directory of the file-based KMS
+	KeyID   string `json:"keyID,omitempty" yaml:"keyID,omitempty"`     // Id of the master key in the KMS
+}
+
+// Validate an encryption configuration
+func (e Encryption) Validate() error {
+	switch {
+	case e.KeyFile == "" && e.KMSDir == "":
+		return fmt.Errorf("encryption requires either a key file or a KMS")
+	case e.KeyFile != "" && e.KMSDir != "":
+		return fmt.Errorf("encryption requires either a key file or a KMS, not both")
+	case e.KMSDir != "" && e.KeyID == "":
+		return fmt.Errorf("encryption with a KMS requires a key id")
+	}
+	return nil
 }
 
 // GetPathToContext returns the path to the context descriptor.
@@ -98,6 +122,11 @@ func ValidateContext(context Context) error {
 	case context.Version > ContextVersion:
 		cause += "Version higher than supported version"
 	}
+	if context.Encryption != nil {
+		if err := context.Encryption.Validate(); err != nil {
+			cause += err.Error()
+		}
+	}
 	if cause != "" {
 		return fmt.Errorf("validation failed, cause = %s", cause)
 	}
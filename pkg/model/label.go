@@ -6,12 +6,17 @@ import (
 	"time"
 )
 
-// LabelDescriptor describes a label
+// LabelDescriptor describes a label.
+//
+// Each move of a label is recorded as a new generation of the label, so that its history is kept and concurrent
+// moves are detected: a generation may only be written once. Labels set before generations were recorded have
+// generation 0.
 type LabelDescriptor struct {
 	Name         string        `json:"name,omitempty" yaml:"name,omitempty"`
 	BundleID     string        `json:"id" yaml:"id"`
 	Timestamp    time.Time     `json:"timestamp,omitempty" yaml:"timestamp,omitempty"`
 	Contributors []Contributor `json:"contributors" yaml:"contributors"`
+	Generation   uint64        `json:"generation,omitempty" yaml:"generation,omitempty"`
 	_            struct{}
 }
 
@@ -35,6 +40,10 @@ func getArchivePathToLabels() string {
 	return "labels/"
 }
 
+func getArchivePathToLabelGenerations() string {
+	return "label-generations/"
+}
+
 func GetArchivePathPrefixToLabels(repo string, prefixes ...string) string {
 	return fmt.Sprint(getArchivePathToLabels(), repo+"/"+strings.Join(prefixes, "/"))
 }
@@ -48,3 +57,20 @@ func GetArchivePathPrefixToLabelPrefix(repo string, prefix string) string {
 func GetArchivePathToLabel(repo string, labelName string) string {
 	return fmt.Sprint(GetArchivePathPrefixToLabels(repo), labelName, ".yaml")
 }
+
+// GetArchivePathPrefixToLabelGenerations gets the path to the generations of the labels of a repo.
+func GetArchivePathPrefixToLabelGenerations(repo string) string {
+	return fmt.Sprint(getArchivePathToLabelGenerations(), repo, "/")
+}
+
+// GetArchivePathPrefixToLabelGenerationsOf gets the path to the generations of a label.
+func GetArchivePathPrefixToLabelGenerationsOf(repo string, labelName string) string {
+	return fmt.Sprint(GetArchivePathPrefixToLabelGenerations(repo), labelName, "/")
+}
+
+// GetArchivePathToLabelGeneration gets the path to some generation of a label.
+//
+// Generations are zero-padded, so that they are listed in order.
+func GetArchivePathToLabelGeneration(repo string, labelName string, generation uint64) string {
+	return fmt.Sprintf("%s%020d.yaml", GetArchivePathPrefixToLabelGenerationsOf(repo, labelName), generation)
+}
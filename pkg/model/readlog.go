@@ -0,0 +1,64 @@
+/*
+ * Copyright © 2019 One Concern
+ *
+ */
+
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReadOperation is the way a bundle has been read
+type ReadOperation string
+
+const (
+	ReadOperationDownload     ReadOperation = "download"
+	ReadOperationDownloadFile ReadOperation = "download-file"
+	ReadOperationMount        ReadOperation = "mount"
+)
+
+// ReadLogEntry records which contributor read a bundle of a repo, and when.
+type ReadLogEntry struct {
+	Repo        string        `json:"repo" yaml:"repo"`
+	BundleID    string        `json:"bundleID" yaml:"bundleID"`
+	Label       string        `json:"label,omitempty" yaml:"label,omitempty"` // Label used to resolve the bundle, if any
+	File        string        `json:"file,omitempty" yaml:"file,omitempty"`   // File read, when a single file is read
+	Operation   ReadOperation `json:"operation" yaml:"operation"`
+	Contributor Contributor   `json:"contributor" yaml:"contributor"`
+	Timestamp   time.Time     `json:"timestamp" yaml:"timestamp"`
+	_           struct{}
+}
+
+// ReadLogEntries is a slice of ReadLogEntry sortable by time
+type ReadLogEntries []ReadLogEntry
+
+func (r ReadLogEntries) Swap(i, j int) {
+	r[i], r[j] = r[j], r[i]
+}
+func (r ReadLogEntries) Len() int {
+	return len(r)
+}
+func (r ReadLogEntries) Less(i, j int) bool {
+	return r[i].Timestamp.Before(r[j].Timestamp)
+}
+
+func getArchivePathToReadLog() string {
+	return "readlog/"
+}
+
+// GetArchivePathPrefixToRepoReadLog gets the path to the read log entries of all the bundles of a repo.
+func GetArchivePathPrefixToRepoReadLog(repo string) string {
+	return fmt.Sprint(getArchivePathToReadLog(), repo, "/")
+}
+
+// GetArchivePathPrefixToReadLog gets the path to the read log entries of a bundle.
+func GetArchivePathPrefixToReadLog(repo string, bundleID string) string {
+	return fmt.Sprint(GetArchivePathPrefixToRepoReadLog(repo), bundleID, "/")
+}
+
+// GetArchivePathToReadLogEntry gets the path to a read log entry.
+func GetArchivePathToReadLogEntry(repo string, bundleID string, id string) string {
+	return fmt.Sprint(GetArchivePathPrefixToReadLog(repo, bundleID), id, ".yaml")
+}
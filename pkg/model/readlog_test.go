@@ -0,0 +1,14 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetArchivePathToReadLogEntry(t *testing.T) {
+	require.Equal(t, "readlog/myrepo/123/",
+		GetArchivePathPrefixToReadLog("myrepo", "123"))
+	require.Equal(t, "readlog/myrepo/123/abc.yaml",
+		GetArchivePathToReadLogEntry("myrepo", "123", "abc"))
+}
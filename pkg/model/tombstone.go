@@ -0,0 +1,85 @@
+/*
+ * Copyright © 2019 One Concern
+ *
+ */
+
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Tombstone marks a repo, or a bundle of a repo, as deleted.
+//
+// Deleted objects are no longer listed, but their metadata is only removed when the tombstone is purged,
+// after some retention period.
+type Tombstone struct {
+	Repo        string      `json:"repo" yaml:"repo"`
+	BundleID    string      `json:"bundleID,omitempty" yaml:"bundleID,omitempty"` // Empty when the whole repo is deleted
+	Contributor Contributor `json:"contributor" yaml:"contributor"`
+	Timestamp   time.Time   `json:"timestamp" yaml:"timestamp"`
+	_           struct{}
+}
+
+// IsRepo tells if the tombstone marks a whole repo as deleted
+func (t Tombstone) IsRepo() bool {
+	return t.BundleID == ""
+}
+
+// ArchivePath returns the path to the tombstone in the metadata archive
+func (t Tombstone) ArchivePath() string {
+	if t.IsRepo() {
+		return GetArchivePathToRepoTombstone(t.Repo)
+	}
+	return GetArchivePathToBundleTombstone(t.Repo, t.BundleID)
+}
+
+// Tombstones is a slice of Tombstone sortable by time
+type Tombstones []Tombstone
+
+func (t Tombstones) Swap(i, j int) {
+	t[i], t[j] = t[j], t[i]
+}
+func (t Tombstones) Len() int {
+	return len(t)
+}
+func (t Tombstones) Less(i, j int) bool {
+	return t[i].Timestamp.Before(t[j].Timestamp)
+}
+
+func getArchivePathToTombstones() string {
+	return "tombstones/"
+}
+
+// GetArchivePathPrefixToTombstones gets the path to all tombstones.
+func GetArchivePathPrefixToTombstones() string {
+	return getArchivePathToTombstones()
+}
+
+// GetArchivePathPrefixToRepoTombstones gets the path to the tombstones of repos.
+func GetArchivePathPrefixToRepoTombstones() string {
+	return fmt.Sprint(getArchivePathToTombstones(), "repos/")
+}
+
+// GetArchivePathToRepoTombstone gets the path to the tombstone of a repo.
+func GetArchivePathToRepoTombstone(repo string) string {
+	return fmt.Sprint(GetArchivePathPrefixToRepoTombstones(), repo, ".yaml")
+}
+
+// GetArchivePathPrefixToBundleTombstones gets the path to the tombstones of the bundles of a repo.
+func GetArchivePathPrefixToBundleTombstones(repo string) string {
+	return fmt.Sprint(getArchivePathToTombstones(), "bundles/", repo, "/")
+}
+
+// GetArchivePathToBundleTombstone gets the path to the tombstone of a bundle.
+func GetArchivePathToBundleTombstone(repo string, bundleID string) string {
+	return fmt.Sprint(GetArchivePathPrefixToBundleTombstones(repo), bundleID, ".yaml")
+}
+
+// GetTombstoneIDFromArchivePath returns the repo or bundle id marked by a tombstone, given its path.
+func GetTombstoneIDFromArchivePath(archivePath string) string {
+	base := archivePath[strings.LastIndex(archivePath, "/")+1:]
+	return strings.TrimSuffix(base, ".yaml")
+}
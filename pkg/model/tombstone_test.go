@@ -0,0 +1,19 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetArchivePathToTombstone(t *testing.T) {
+	repoTombstone := Tombstone{Repo: "myrepo"}
+	require.True(t, repoTombstone.IsRepo())
+	require.Equal(t, "tombstones/repos/myrepo.yaml", repoTombstone.ArchivePath())
+	require.Equal(t, "myrepo", GetTombstoneIDFromArchivePath(repoTombstone.ArchivePath()))
+
+	bundleTombstone := Tombstone{Repo: "myrepo", BundleID: "123"}
+	require.False(t, bundleTombstone.IsRepo())
+	require.Equal(t, "tombstones/bundles/myrepo/123.yaml", bundleTombstone.ArchivePath())
+	require.Equal(t, "123", GetTombstoneIDFromArchivePath(bundleTombstone.ArchivePath()))
+}
@@ -14,13 +14,131 @@ import (
 // All the serializable model for the WAL.
 
 type Entry struct {
-	Token   string `json:"token" yaml:"token"`
-	Payload string `json:"payload" yaml:"payload"`
+	Token   string  `json:"token" yaml:"token"`
+	Payload Payload `json:"payload" yaml:"payload"`
+}
+
+// PayloadType identifies the metadata mutation recorded by a WAL entry.
+type PayloadType string
+
+const (
+	PayloadTypeRepoCreate   PayloadType = "repo-create"
+	PayloadTypeBundleCommit PayloadType = "bundle-commit"
+	PayloadTypeLabelSet     PayloadType = "label-set"
+	PayloadTypeRepoDelete   PayloadType = "repo-delete"
+	PayloadTypeBundleDelete PayloadType = "bundle-delete"
+	PayloadTypePurge        PayloadType = "purge"
+	PayloadTypeBranchSet    PayloadType = "branch-set"
+)
+
+// Payload is the typed record of a metadata mutation. Only the descriptor matching the type is set.
+type Payload struct {
+	Type             PayloadType       `json:"type" yaml:"type"`
+	Repo             string            `json:"repo" yaml:"repo"`
+	RepoDescriptor   *RepoDescriptor   `json:"repoDescriptor,omitempty" yaml:"repoDescriptor,omitempty"`
+	BundleDescriptor *BundleDescriptor `json:"bundleDescriptor,omitempty" yaml:"bundleDescriptor,omitempty"`
+	LabelDescriptor  *LabelDescriptor  `json:"labelDescriptor,omitempty" yaml:"labelDescriptor,omitempty"`
+	Tombstone        *Tombstone        `json:"tombstone,omitempty" yaml:"tombstone,omitempty"`
+	BranchDescriptor *BranchDescriptor `json:"branchDescriptor,omitempty" yaml:"branchDescriptor,omitempty"`
+	_                struct{}
+}
+
+// NewRepoCreatePayload records the creation of a repo.
+func NewRepoCreatePayload(repo RepoDescriptor) Payload {
+	return Payload{
+		Type:           PayloadTypeRepoCreate,
+		Repo:           repo.Name,
+		RepoDescriptor: &repo,
+	}
+}
+
+// NewBundleCommitPayload records a bundle committed to a repo.
+func NewBundleCommitPayload(repo string, bundle BundleDescriptor) Payload {
+	return Payload{
+		Type:             PayloadTypeBundleCommit,
+		Repo:             repo,
+		BundleDescriptor: &bundle,
+	}
+}
+
+// NewLabelSetPayload records a label set on a bundle of a repo.
+func NewLabelSetPayload(repo string, label LabelDescriptor) Payload {
+	return Payload{
+		Type:            PayloadTypeLabelSet,
+		Repo:            repo,
+		LabelDescriptor: &label,
+	}
+}
+
+// NewBranchSetPayload records a move of the head of a branch of a repo, including its creation and deletion.
+func NewBranchSetPayload(repo string, branch BranchDescriptor) Payload {
+	return Payload{
+		Type:             PayloadTypeBranchSet,
+		Repo:             repo,
+		BranchDescriptor: &branch,
+	}
+}
+
+// NewDeletePayload records the deletion of a repo or of a bundle.
+func NewDeletePayload(tombstone Tombstone) Payload {
+	t := PayloadTypeBundleDelete
+	if tombstone.IsRepo() {
+		t = PayloadTypeRepoDelete
+	}
+	return Payload{
+		Type:      t,
+		Repo:      tombstone.Repo,
+		Tombstone: &tombstone,
+	}
+}
+
+// NewPurgePayload records the removal of the metadata of a deleted repo or bundle.
+func NewPurgePayload(tombstone Tombstone) Payload {
+	return Payload{
+		Type:      PayloadTypePurge,
+		Repo:      tombstone.Repo,
+		Tombstone: &tombstone,
+	}
+}
+
+// Contributors returns the contributors responsible for the mutation.
+func (p Payload) Contributors() []Contributor {
+	switch {
+	case p.RepoDescriptor != nil:
+		return []Contributor{p.RepoDescriptor.Contributor}
+	case p.BundleDescriptor != nil:
+		return p.BundleDescriptor.Contributors
+	case p.LabelDescriptor != nil:
+		return p.LabelDescriptor.Contributors
+	case p.Tombstone != nil:
+		return []Contributor{p.Tombstone.Contributor}
+	case p.BranchDescriptor != nil:
+		return []Contributor{p.BranchDescriptor.Contributor}
+	}
+	return nil
+}
+
+// String returns a short description of the mutation.
+func (p Payload) String() string {
+	switch {
+	case p.RepoDescriptor != nil:
+		return fmt.Sprintf("%s repo=%s", p.Type, p.Repo)
+	case p.BundleDescriptor != nil:
+		return fmt.Sprintf("%s repo=%s bundle=%s", p.Type, p.Repo, p.BundleDescriptor.ID)
+	case p.LabelDescriptor != nil:
+		return fmt.Sprintf("%s repo=%s label=%s bundle=%s", p.Type, p.Repo, p.LabelDescriptor.Name, p.LabelDescriptor.BundleID)
+	case p.BranchDescriptor != nil:
+		return fmt.Sprintf("%s repo=%s branch=%s bundle=%s generation=%d", p.Type, p.Repo,
+			p.BranchDescriptor.Name, p.BranchDescriptor.BundleID, p.BranchDescriptor.Generation)
+	case p.Tombstone != nil && !p.Tombstone.IsRepo():
+		return fmt.Sprintf("%s repo=%s bundle=%s", p.Type, p.Repo, p.Tombstone.BundleID)
+	}
+	return fmt.Sprintf("%s repo=%s", p.Type, p.Repo)
 }
 
 const TokenGeneratorPath = "WALTokenGeneratorPath"
 
-func NewEntry(token string, payload string) *Entry {
+func NewEntry(token string, payload Payload) *Entry {
 	return &Entry{
 		Token:   token,
 		Payload: payload,
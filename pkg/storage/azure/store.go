@@ -0,0 +1,427 @@
+// Copyright © 2019 One Concern
+
+// Package azure implements storage.Store on Azure Blob Storage.
+package azure
+
+import (
+	"context"
+	"crypto/md5" // nolint:gosec
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/status"
+)
+
+// Environment variables holding the default account and its credentials
+const (
+	EnvAccount  = "AZURE_STORAGE_ACCOUNT"
+	EnvKey      = "AZURE_STORAGE_KEY"
+	EnvEndpoint = "AZURE_STORAGE_ENDPOINT"
+)
+
+const (
+	// PageSize is the maximum number of keys listed by a single request
+	PageSize = 5000
+
+	uploadBufferSize = 2 * 1024 * 1024
+	uploadMaxBuffers = 4
+	maxRetryRequests = 3
+
+	// maxMarkers is the number of continuation markers remembered to start listings at a key
+	maxMarkers = 1024
+
+	// crc32cMetadata is the user metadata holding the CRC32C checksum of blobs, in the same format as GCS
+	crc32cMetadata = "crc32c"
+)
+
+type Option func(*azureFS)
+
+// Container sets the container holding the blobs of the store
+func Container(container string) Option {
+	return func(fs *azureFS) {
+		fs.container = container
+	}
+}
+
+// Account sets the storage account and its shared key.
+//
+// Defaults to the AZURE_STORAGE_ACCOUNT and AZURE_STORAGE_KEY environment variables.
+func Account(name, key string) Option {
+	return func(fs *azureFS) {
+		fs.account = name
+		fs.key = key
+	}
+}
+
+// Endpoint sets the URL of the blob service, e.g. http://127.0.0.1:10000/devstoreaccount1 for the Azurite emulator.
+//
+// Defaults to the AZURE_STORAGE_ENDPOINT environment variable, then to https://<account>.blob.core.windows.net.
+func Endpoint(endpoint string) Option {
+	return func(fs *azureFS) {
+		fs.endpoint = endpoint
+	}
+}
+
+// New creates a store on an Azure Blob Storage container
+func New(option Option, options ...Option) (storage.Store, error) {
+	fs := &azureFS{
+		account:  os.Getenv(EnvAccount),
+		key:      os.Getenv(EnvKey),
+		endpoint: os.Getenv(EnvEndpoint),
+	}
+	option(fs)
+	for _, apply := range options {
+		apply(fs)
+	}
+	if fs.container == "" {
+		return nil, fmt.Errorf("azure store: missing container")
+	}
+	if fs.account == "" {
+		return nil, fmt.Errorf("azure store: missing storage account, set $%s", EnvAccount)
+	}
+	if fs.endpoint == "" {
+		fs.endpoint = "https://" + fs.account + ".blob.core.windows.net"
+	}
+
+	credential, err := azblob.NewSharedKeyCredential(fs.account, fs.key)
+	if err != nil {
+		return nil, fmt.Errorf("azure store: invalid credentials for account %s: %w", fs.account, err)
+	}
+	u, err := url.Parse(strings.TrimSuffix(fs.endpoint, "/") + "/" + fs.container)
+	if err != nil {
+		return nil, fmt.Errorf("azure store: invalid endpoint %s: %w", fs.endpoint, err)
+	}
+	fs.containerURL = azblob.NewContainerURL(*u, azblob.NewPipeline(credential, azblob.PipelineOptions{}))
+	return fs, nil
+}
+
+type azureFS struct {
+	container    string
+	account      string
+	key          string
+	endpoint     string
+	containerURL azblob.ContainerURL
+
+	// markers are continuation markers of flat listings, sorted by the key they follow
+	markersLock sync.Mutex
+	markers     map[string][]keyMarker
+}
+
+// keyMarker is a continuation marker, which resumes a listing after some key
+type keyMarker struct {
+	key    string
+	marker string
+}
+
+func toSentinelErrors(err error) error {
+	// return sentinel errors defined by the status package
+	// see: https://docs.microsoft.com/en-us/rest/api/storageservices/blob-service-error-codes
+	if serr, ok := err.(azblob.StorageError); ok {
+		switch serr.ServiceCode() {
+		case azblob.ServiceCodeBlobNotFound, azblob.ServiceCodeContainerNotFound:
+			return status.ErrNotExists
+		case azblob.ServiceCodeBlobAlreadyExists, azblob.ServiceCodeConditionNotMet:
+			return storage.ErrExists
+		}
+	}
+	return err
+}
+
+func accessConditions(doesNotExist storage.NewKey) azblob.BlobAccessConditions {
+	if !doesNotExist {
+		return azblob.BlobAccessConditions{}
+	}
+	return azblob.BlobAccessConditions{
+		ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfNoneMatch: azblob.ETagAny},
+	}
+}
+
+func (a *azureFS) String() string {
+	return "azure://" + a.account + "/" + a.container
+}
+
+func (a *azureFS) blob(key string) azblob.BlobURL {
+	return a.containerURL.NewBlobURL(key)
+}
+
+func (a *azureFS) Has(ctx context.Context, key string) (bool, error) {
+	_, err := a.blob(key).GetProperties(ctx, azblob.BlobAccessConditions{})
+	if err != nil {
+		if toSentinelErrors(err) == status.ErrNotExists {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get blob properties: %v", err)
+	}
+	return true, nil
+}
+
+func (a *azureFS) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := a.blob(key).Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		return nil, toSentinelErrors(err)
+	}
+	return resp.Body(azblob.RetryReaderOptions{MaxRetryRequests: maxRetryRequests}), nil
+}
+
+type azureReader struct {
+	a   *azureFS
+	ctx context.Context
+	key string
+}
+
+// ReadAt reads a range of the blob
+func (r *azureReader) ReadAt(p []byte, offset int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	resp, err := r.a.blob(r.key).Download(r.ctx, offset, int64(len(p)), azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		if serr, ok := err.(azblob.StorageError); ok && serr.ServiceCode() == azblob.ServiceCodeInvalidRange {
+			// the offset is past the end of the blob
+			return 0, io.EOF
+		}
+		return 0, toSentinelErrors(err)
+	}
+	body := resp.Body(azblob.RetryReaderOptions{MaxRetryRequests: maxRetryRequests})
+	defer body.Close()
+
+	n, err := io.ReadFull(body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (a *azureFS) GetAt(ctx context.Context, key string) (io.ReaderAt, error) {
+	return &azureReader{
+		a:   a,
+		ctx: ctx,
+		key: key,
+	}, nil
+}
+
+func (a *azureFS) GetAttr(ctx context.Context, key string) (storage.Attributes, error) {
+	props, err := a.blob(key).GetProperties(ctx, azblob.BlobAccessConditions{})
+	if err != nil {
+		return storage.Attributes{}, toSentinelErrors(err)
+	}
+	return storage.Attributes{
+		Created: props.CreationTime(),
+		Updated: props.LastModified(),
+		Size:    props.ContentLength(),
+	}, nil
+}
+
+// Touch updates the modification time of a blob, by setting its metadata again
+func (a *azureFS) Touch(ctx context.Context, key string) error {
+	props, err := a.blob(key).GetProperties(ctx, azblob.BlobAccessConditions{})
+	if err != nil {
+		return toSentinelErrors(err)
+	}
+	_, err = a.blob(key).SetMetadata(ctx, props.NewMetadata(), azblob.BlobAccessConditions{})
+	return toSentinelErrors(err)
+}
+
+func (a *azureFS) Put(ctx context.Context, key string, rdr io.Reader, doesNotExist storage.NewKey) error {
+	_, err := azblob.UploadStreamToBlockBlob(ctx, rdr, a.containerURL.NewBlockBlobURL(key),
+		azblob.UploadStreamToBlockBlobOptions{
+			BufferSize:       uploadBufferSize,
+			MaxBuffers:       uploadMaxBuffers,
+			AccessConditions: accessConditions(doesNotExist),
+		})
+	return toSentinelErrors(err)
+}
+
+// PutCRC uploads a blob, after checking its CRC32C checksum.
+//
+// The blob is checked in transit with its MD5 checksum, and the CRC32C checksum is kept as user metadata on the blob.
+func (a *azureFS) PutCRC(ctx context.Context, key string, rdr io.Reader, doesNotExist storage.NewKey, crc uint32) error {
+	b, err := ioutil.ReadAll(io.LimitReader(rdr, storage.MaxObjectSizeInMemory+1))
+	if err != nil {
+		return err
+	}
+	if len(b) > storage.MaxObjectSizeInMemory {
+		return storage.ErrObjectTooBig
+	}
+	if actual := crc32.Checksum(b, crc32.MakeTable(crc32.Castagnoli)); actual != crc {
+		return fmt.Errorf("crc32c mismatch for %s: expected %08x, got %08x", key, crc, actual)
+	}
+
+	md5sum := md5.Sum(b) // nolint:gosec
+	crcBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(crcBytes, crc)
+	_, err = azblob.UploadBufferToBlockBlob(ctx, b, a.containerURL.NewBlockBlobURL(key),
+		azblob.UploadToBlockBlobOptions{
+			BlobHTTPHeaders: azblob.BlobHTTPHeaders{ContentMD5: md5sum[:]},
+			Metadata: azblob.Metadata{
+				crc32cMetadata: base64.StdEncoding.EncodeToString(crcBytes),
+			},
+			AccessConditions: accessConditions(doesNotExist),
+		})
+	return toSentinelErrors(err)
+}
+
+func (a *azureFS) Delete(ctx context.Context, key string) error {
+	_, err := a.blob(key).Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+	return toSentinelErrors(err)
+}
+
+func (a *azureFS) Keys(ctx context.Context) ([]string, error) {
+	var keys []string
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		resp, err := a.containerURL.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{})
+		if err != nil {
+			return nil, toSentinelErrors(err)
+		}
+		for _, item := range resp.Segment.BlobItems {
+			keys = append(keys, item.Name)
+		}
+		marker = resp.NextMarker
+	}
+	return keys, nil
+}
+
+// markerToken prefixes the page tokens made of an Azure continuation marker
+const markerToken = "marker:"
+
+// KeysPrefix lists a page of keys with some prefix. With a delimiter, keys are grouped by common prefixes, as for
+// directories.
+//
+// The page token is either one returned by a previous call, or a key to start at. Since Azure doesn't list from a key,
+// the listing resumes after the closest key below it that ended a segment listed before, as the WAL lists from later
+// keys every time. The keys below the key to start at are skipped.
+func (a *azureFS) KeysPrefix(ctx context.Context, pageToken, prefix, delimiter string, count int) ([]string, string, error) {
+	if count <= 0 || count > PageSize {
+		count = PageSize
+	}
+	var (
+		marker  azblob.Marker
+		startAt string
+	)
+	switch {
+	case strings.HasPrefix(pageToken, markerToken):
+		val := strings.TrimPrefix(pageToken, markerToken)
+		marker.Val = &val
+	case pageToken != "":
+		startAt = pageToken
+		if delimiter == "" {
+			marker = a.markerBefore(prefix, startAt)
+		}
+	}
+
+	keys := make([]string, 0, count)
+	for len(keys) < count {
+		segment, next, err := a.listSegment(ctx, marker, prefix, delimiter, count-len(keys))
+		if err != nil {
+			return nil, "", err
+		}
+		for _, key := range segment {
+			// a common prefix is kept when it holds the key to start at
+			if key < startAt && !(delimiter != "" && strings.HasSuffix(key, delimiter) && strings.HasPrefix(startAt, key)) {
+				continue
+			}
+			keys = append(keys, key)
+		}
+		if next.Val == nil || *next.Val == "" {
+			return keys, "", nil
+		}
+		if delimiter == "" && len(segment) > 0 {
+			a.rememberMarker(prefix, segment[len(segment)-1], *next.Val)
+		}
+		marker = next
+	}
+	return keys, markerToken + *marker.Val, nil
+}
+
+// markerBefore finds the marker resuming a flat listing after the closest known key below some key
+func (a *azureFS) markerBefore(prefix, key string) azblob.Marker {
+	a.markersLock.Lock()
+	defer a.markersLock.Unlock()
+
+	markers := a.markers[prefix]
+	i := sort.Search(len(markers), func(i int) bool { return markers[i].key >= key })
+	if i == 0 {
+		return azblob.Marker{}
+	}
+	val := markers[i-1].marker
+	return azblob.Marker{Val: &val}
+}
+
+// rememberMarker keeps the marker resuming a flat listing after some key. When there are too many of them, markers
+// of the lowest keys are forgotten first.
+func (a *azureFS) rememberMarker(prefix, key, marker string) {
+	a.markersLock.Lock()
+	defer a.markersLock.Unlock()
+
+	if a.markers == nil {
+		a.markers = make(map[string][]keyMarker)
+	}
+	markers := a.markers[prefix]
+	i := sort.Search(len(markers), func(i int) bool { return markers[i].key >= key })
+	if i < len(markers) && markers[i].key == key {
+		markers[i].marker = marker
+		return
+	}
+	markers = append(markers, keyMarker{})
+	copy(markers[i+1:], markers[i:])
+	markers[i] = keyMarker{key: key, marker: marker}
+	if len(markers) > maxMarkers {
+		markers = markers[len(markers)-maxMarkers:]
+	}
+	a.markers[prefix] = markers
+}
+
+// listSegment lists a segment of at most count keys and common prefixes, sorted
+func (a *azureFS) listSegment(ctx context.Context, marker azblob.Marker, prefix, delimiter string, count int) ([]string, azblob.Marker, error) {
+	options := azblob.ListBlobsSegmentOptions{
+		Prefix:     prefix,
+		MaxResults: int32(count),
+	}
+	var keys []string
+	if delimiter == "" {
+		resp, err := a.containerURL.ListBlobsFlatSegment(ctx, marker, options)
+		if err != nil {
+			return nil, marker, toSentinelErrors(err)
+		}
+		for _, item := range resp.Segment.BlobItems {
+			keys = append(keys, item.Name)
+		}
+		return keys, resp.NextMarker, nil
+	}
+	resp, err := a.containerURL.ListBlobsHierarchySegment(ctx, marker, delimiter, options)
+	if err != nil {
+		return nil, marker, toSentinelErrors(err)
+	}
+	for _, item := range resp.Segment.BlobPrefixes {
+		keys = append(keys, item.Name)
+	}
+	for _, item := range resp.Segment.BlobItems {
+		keys = append(keys, item.Name)
+	}
+	sort.Strings(keys)
+	return keys, resp.NextMarker, nil
+}
+
+func (a *azureFS) Clear(ctx context.Context) error {
+	keys, err := a.Keys(ctx)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = a.Delete(ctx, key); err != nil && err != status.ErrNotExists {
+			return err
+		}
+	}
+	return nil
+}
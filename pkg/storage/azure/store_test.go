@@ -0,0 +1,306 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/oneconcern/datamon/internal"
+	"github.com/oneconcern/datamon/pkg/model"
+	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/status"
+	"github.com/oneconcern/datamon/pkg/wal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// well-known account of the Azurite emulator
+const (
+	azuriteAddress  = "127.0.0.1:10000"
+	azuriteAccount  = "devstoreaccount1"
+	azuriteKey      = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+	azuriteEndpoint = "http://" + azuriteAddress + "/" + azuriteAccount
+)
+
+func TestHas(t *testing.T) {
+	bs, cleanup := setupStore(t)
+	defer cleanup()
+
+	has, err := bs.Has(context.Background(), "sixteentons")
+	require.NoError(t, err)
+	require.True(t, has)
+
+	has, err = bs.Has(context.Background(), "fifteentons")
+	require.NoError(t, err)
+	require.False(t, has)
+}
+
+func TestGet(t *testing.T) {
+	bs, cleanup := setupStore(t)
+	defer cleanup()
+
+	rdr, err := bs.Get(context.Background(), "sixteentons")
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(rdr)
+	require.NoError(t, err)
+	require.NoError(t, rdr.Close())
+	assert.Equal(t, "this is the text", string(b))
+
+	_, err = bs.Get(context.Background(), "fifteentons")
+	require.True(t, errors.Is(err, status.ErrNotExists), "got %v", err)
+}
+
+func TestGetAt(t *testing.T) {
+	bs, cleanup := setupStore(t)
+	defer cleanup()
+
+	rdrAt, err := bs.GetAt(context.Background(), "dir/seventeentons")
+	require.NoError(t, err)
+	p := make([]byte, 4)
+	n, err := rdrAt.ReadAt(p, 8)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, "the ", string(p))
+
+	// "this is the text for another thing" is 34 bytes long
+	n, err = rdrAt.ReadAt(p, 32)
+	require.Equal(t, io.EOF, err)
+	assert.Equal(t, "ng", string(p[:n]))
+	_, err = rdrAt.ReadAt(p, 100)
+	require.Equal(t, io.EOF, err)
+}
+
+func TestPut(t *testing.T) {
+	bs, cleanup := setupStore(t)
+	defer cleanup()
+
+	err := bs.Put(context.Background(), "sixteentons", bytes.NewBufferString("other text"), storage.NoOverWrite)
+	require.True(t, errors.Is(err, storage.ErrExists), "got %v", err)
+	require.NoError(t, bs.Put(context.Background(), "sixteentons", bytes.NewBufferString("other text"), storage.OverWrite))
+
+	// larger than the upload buffer, so uploaded in blocks
+	large := strings.Repeat("0123456789abcdef", uploadBufferSize/8)
+	require.NoError(t, bs.Put(context.Background(), "large", bytes.NewBufferString(large), storage.NoOverWrite))
+	err = bs.Put(context.Background(), "large", bytes.NewBufferString(large), storage.NoOverWrite)
+	require.True(t, errors.Is(err, storage.ErrExists), "got %v", err)
+
+	for key, expected := range map[string]string{"sixteentons": "other text", "large": large} {
+		rdr, err := bs.Get(context.Background(), key)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(rdr)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(b))
+	}
+}
+
+func TestPutCRC(t *testing.T) {
+	bs, cleanup := setupStore(t)
+	defer cleanup()
+	crcStore, ok := bs.(storage.StoreCRC)
+	require.True(t, ok)
+
+	content := []byte("content with a checksum")
+	crc := crc32.Checksum(content, crc32.MakeTable(crc32.Castagnoli))
+	require.NoError(t, crcStore.PutCRC(context.Background(), "crc", bytes.NewReader(content), storage.NoOverWrite, crc))
+	err := crcStore.PutCRC(context.Background(), "crc", bytes.NewReader(content), storage.NoOverWrite, crc)
+	require.True(t, errors.Is(err, storage.ErrExists), "got %v", err)
+	require.NoError(t, crcStore.PutCRC(context.Background(), "crc", bytes.NewReader(content), storage.OverWrite, crc))
+	require.Error(t, crcStore.PutCRC(context.Background(), "bad-crc", bytes.NewReader(content), storage.OverWrite, crc+1))
+
+	has, err := bs.Has(context.Background(), "bad-crc")
+	require.NoError(t, err)
+	require.False(t, has)
+}
+
+func TestGetAttrAndTouch(t *testing.T) {
+	bs, cleanup := setupStore(t)
+	defer cleanup()
+
+	attrs, err := bs.GetAttr(context.Background(), "sixteentons")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("this is the text")), attrs.Size)
+
+	time.Sleep(1100 * time.Millisecond)
+	require.NoError(t, bs.Touch(context.Background(), "sixteentons"))
+	touched, err := bs.GetAttr(context.Background(), "sixteentons")
+	require.NoError(t, err)
+	assert.True(t, touched.Updated.After(attrs.Updated))
+
+	_, err = bs.GetAttr(context.Background(), "fifteentons")
+	require.True(t, errors.Is(err, status.ErrNotExists), "got %v", err)
+}
+
+func TestKeysPrefix(t *testing.T) {
+	bs, cleanup := setupStore(t)
+	defer cleanup()
+
+	keys, err := bs.Keys(context.Background())
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"sixteentons", "dir/seventeentons"}, keys)
+
+	keys, token, err := bs.KeysPrefix(context.Background(), "", "", "/", 100)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"dir/", "sixteentons"}, keys)
+	require.Empty(t, token)
+
+	keys, token, err = bs.KeysPrefix(context.Background(), "", "", "", 1)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.NotEmpty(t, token)
+	next, token, err := bs.KeysPrefix(context.Background(), token, "", "", 1)
+	require.NoError(t, err)
+	require.Len(t, next, 1)
+	require.Empty(t, token)
+	require.ElementsMatch(t, []string{"sixteentons", "dir/seventeentons"}, append(keys, next...))
+
+	// a key as page token: listing starts at this key
+	keys, token, err = bs.KeysPrefix(context.Background(), "dir/z", "", "", 100)
+	require.NoError(t, err)
+	require.Equal(t, []string{"sixteentons"}, keys)
+	require.Empty(t, token)
+
+	keys, token, err = bs.KeysPrefix(context.Background(), "dir/seventeentons", "", "/", 100)
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir/", "sixteentons"}, keys)
+	require.Empty(t, token)
+}
+
+func TestWALTokens(t *testing.T) {
+	ctx := context.Background()
+	mutable, cleanupMutable := setupEmptyStore(t)
+	defer cleanupMutable()
+	walStore, cleanupWAL := setupEmptyStore(t)
+	defer cleanupWAL()
+
+	w := wal.NewWAL(mutable, walStore, zap.NewNop())
+	tokens := make([]string, 0, 5)
+	for i := 0; i < 5; i++ {
+		token, err := w.Add(ctx, model.NewRepoCreatePayload(model.RepoDescriptor{Name: fmt.Sprintf("repo%d", i)}))
+		require.NoError(t, err)
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+
+	// the WAL pages its tokens from a token, i.e. a key
+	listed, _, err := w.ListTokens(ctx, tokens[2], 100)
+	require.NoError(t, err)
+	require.Subset(t, listed, tokens[2:])
+
+	var walked []string
+	require.NoError(t, w.WalkTokens(ctx, tokens[0], func(token string) error {
+		walked = append(walked, token)
+		return nil
+	}))
+	require.Equal(t, tokens, walked)
+
+	// pages of tokens
+	var paged []string
+	next := ""
+	for {
+		var page []string
+		page, next, err = walStore.KeysPrefix(ctx, next, "", "", 2)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page), 2)
+		paged = append(paged, page...)
+		if next == "" {
+			break
+		}
+	}
+	require.Equal(t, tokens, paged)
+
+	// once paged, listings from a key resume from the markers of the pages before
+	keys, _, err := walStore.KeysPrefix(ctx, tokens[3], "", "", 100)
+	require.NoError(t, err)
+	require.Equal(t, tokens[3:], keys)
+}
+
+func TestMarkerBefore(t *testing.T) {
+	a := &azureFS{}
+	require.Nil(t, a.markerBefore("", "b").Val)
+
+	a.rememberMarker("", "c", "after-c")
+	a.rememberMarker("", "a", "after-a")
+	a.rememberMarker("other/", "a", "other-after-a")
+
+	require.Nil(t, a.markerBefore("", "a").Val)
+	for key, expected := range map[string]string{"b": "after-a", "c": "after-a", "d": "after-c"} {
+		marker := a.markerBefore("", key)
+		require.NotNil(t, marker.Val)
+		assert.Equal(t, expected, *marker.Val, key)
+	}
+
+	// markers of the lowest keys are forgotten first
+	for i := 0; i < maxMarkers; i++ {
+		a.rememberMarker("", fmt.Sprintf("k%05d", i), fmt.Sprintf("after-k%05d", i))
+	}
+	require.Len(t, a.markers[""], maxMarkers)
+	assert.Nil(t, a.markerBefore("", "d").Val)
+	assert.Equal(t, "after-k00000", *a.markerBefore("", "k00001").Val)
+	assert.Equal(t, "other-after-a", *a.markerBefore("other/", "b").Val)
+}
+
+func TestDeleteAndClear(t *testing.T) {
+	bs, cleanup := setupStore(t)
+	defer cleanup()
+
+	require.NoError(t, bs.Delete(context.Background(), "sixteentons"))
+	keys, err := bs.Keys(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir/seventeentons"}, keys)
+
+	require.NoError(t, bs.Clear(context.Background()))
+	keys, err = bs.Keys(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
+
+// setupStore creates a container on the Azurite emulator, with a couple of blobs
+func setupStore(t testing.TB) (storage.Store, func()) {
+	t.Helper()
+
+	bs, cleanup := setupEmptyStore(t)
+	require.NoError(t, bs.Put(context.Background(), "sixteentons", bytes.NewBufferString("this is the text"), storage.NoOverWrite))
+	require.NoError(t, bs.Put(context.Background(), "dir/seventeentons",
+		bytes.NewBufferString("this is the text for another thing"), storage.NoOverWrite))
+	return bs, cleanup
+}
+
+// setupEmptyStore creates an empty container on the Azurite emulator
+func setupEmptyStore(t testing.TB) (storage.Store, func()) {
+	t.Helper()
+
+	conn, err := net.DialTimeout("tcp", azuriteAddress, time.Second)
+	if err != nil {
+		t.Skipf("azurite is not running")
+	}
+	_ = conn.Close()
+
+	container := strings.ToLower(internal.RandStringBytesMaskImprSrc(15))
+	credential, err := azblob.NewSharedKeyCredential(azuriteAccount, azuriteKey)
+	require.NoError(t, err)
+	u, err := url.Parse(azuriteEndpoint + "/" + container)
+	require.NoError(t, err)
+	containerURL := azblob.NewContainerURL(*u, azblob.NewPipeline(credential, azblob.PipelineOptions{}))
+	_, err = containerURL.Create(context.Background(), azblob.Metadata{}, azblob.PublicAccessNone)
+	require.NoError(t, err)
+
+	cleanup := func() {
+		_, _ = containerURL.Delete(context.Background(), azblob.ContainerAccessConditions{})
+	}
+
+	bs, err := New(Container(container), Account(azuriteAccount, azuriteKey), Endpoint(azuriteEndpoint))
+	require.NoError(t, err)
+	return bs, cleanup
+}
@@ -0,0 +1,386 @@
+// Copyright © 2019 One Concern
+
+// Package cache implements a read-through cache of a remote store, in a local directory.
+//
+// The cache is meant for content-addressed stores, such as the blob store of a context, which objects
+// never change once written: cached objects are never refreshed from the remote store.
+//
+// The cache directory may be shared by several processes on the same host: objects are written to temporary
+// files then renamed into place, and evictions are serialized with a lock file.
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/oneconcern/datamon/pkg/storage"
+)
+
+const (
+	objectsDir = "objects"
+	tmpDir     = "tmp"
+	lockFile   = ".lock"
+
+	// staleTmp is the age after which temporary files are considered to be left over by crashed processes
+	staleTmp = time.Hour
+)
+
+// New fronts a remote store with a cache in a local directory, holding up to maxSize bytes.
+//
+// Least recently used objects are evicted when the cache grows over maxSize. Writes go to the remote store.
+func New(remote storage.Store, dir string, maxSize int64) (storage.Store, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("invalid cache size %d", maxSize)
+	}
+	for _, d := range []string{filepath.Join(dir, objectsDir), filepath.Join(dir, tmpDir)} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+	}
+	c := &cacheStore{
+		remote:  remote,
+		dir:     dir,
+		maxSize: maxSize,
+	}
+	if err := c.removeStaleTmp(); err != nil {
+		return nil, err
+	}
+	if err := c.evict(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+type cacheStore struct {
+	remote  storage.Store
+	dir     string
+	maxSize int64
+
+	// size is the size of the cache as known by this process: other processes sharing the cache add
+	// to the actual size, which is accounted for at eviction time
+	size     int64
+	evicting sync.Mutex
+}
+
+func (c *cacheStore) String() string {
+	return "cache(" + c.dir + ")@" + c.remote.String()
+}
+
+// path to the cached copy of an object.
+//
+// Keys are hashed, so that any key maps to a valid file name. The remote store is hashed with the key, so that
+// several remote stores, e.g. of different contexts, may share a cache directory.
+func (c *cacheStore) path(key string) string {
+	h := sha256.Sum256([]byte(c.remote.String() + "\x00" + key))
+	name := hex.EncodeToString(h[:])
+	return filepath.Join(c.dir, objectsDir, name[:2], name)
+}
+
+// open returns the cached copy of an object, after fetching it from the remote store if needed
+func (c *cacheStore) open(ctx context.Context, key string) (*os.File, error) {
+	pth := c.path(key)
+	f, err := os.Open(pth)
+	if err == nil {
+		// the modification time of cached objects tracks their last use
+		now := time.Now()
+		_ = os.Chtimes(pth, now, now)
+		return f, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return c.fetch(ctx, key, pth)
+}
+
+// fetch copies an object from the remote store to the cache.
+//
+// Objects are first written to a temporary file, synced, then renamed into place, so that the cache
+// never holds partial objects, even after a crash.
+func (c *cacheStore) fetch(ctx context.Context, key, pth string) (*os.File, error) {
+	rdr, err := c.remote.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	tmp, err := ioutil.TempFile(filepath.Join(c.dir, tmpDir), "fetch-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cache file: %w", err)
+	}
+	size, err := io.Copy(tmp, rdr)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to cache %s: %w", key, err)
+	}
+
+	if size > c.maxSize {
+		// too large to be cached: the object is removed once read
+		_ = os.Remove(tmp.Name())
+		return tmp, nil
+	}
+	if err = os.MkdirAll(filepath.Dir(pth), 0700); err == nil {
+		err = os.Rename(tmp.Name(), pth)
+	}
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to cache %s: %w", key, err)
+	}
+
+	if atomic.AddInt64(&c.size, size) > c.maxSize {
+		// the returned file remains readable, even if evicted
+		if err = c.evict(); err != nil {
+			_ = tmp.Close()
+			return nil, err
+		}
+	}
+	return tmp, nil
+}
+
+type cachedObject struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// evict removes the least recently used objects, until the cache is back to 90% of its maximum size
+func (c *cacheStore) evict() error {
+	c.evicting.Lock()
+	defer c.evicting.Unlock()
+
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var (
+		objects []cachedObject
+		total   int64
+	)
+	err = filepath.Walk(filepath.Join(c.dir, objectsDir), func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				// removed concurrently
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			objects = append(objects, cachedObject{path: pth, size: info.Size(), modTime: info.ModTime()})
+			total += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan cache: %w", err)
+	}
+
+	if total > c.maxSize {
+		sort.Slice(objects, func(i, j int) bool { return objects[i].modTime.Before(objects[j].modTime) })
+		target := c.maxSize / 10 * 9
+		for _, object := range objects {
+			if total <= target {
+				break
+			}
+			if err = os.Remove(object.path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to evict cached object: %w", err)
+			}
+			total -= object.size
+		}
+	}
+	atomic.StoreInt64(&c.size, total)
+	return nil
+}
+
+// lock the cache directory against evictions by other processes
+func (c *cacheStore) lock() (func(), error) {
+	f, err := os.OpenFile(filepath.Join(c.dir, lockFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache lock: %w", err)
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to lock cache: %w", err)
+	}
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		_ = f.Close()
+	}, nil
+}
+
+func (c *cacheStore) removeStaleTmp() error {
+	infos, err := ioutil.ReadDir(filepath.Join(c.dir, tmpDir))
+	if err != nil {
+		return fmt.Errorf("failed to scan cache: %w", err)
+	}
+	for _, info := range infos {
+		if time.Since(info.ModTime()) > staleTmp {
+			_ = os.Remove(filepath.Join(c.dir, tmpDir, info.Name()))
+		}
+	}
+	return nil
+}
+
+func (c *cacheStore) Has(ctx context.Context, key string) (bool, error) {
+	if _, err := os.Stat(c.path(key)); err == nil {
+		return true, nil
+	}
+	return c.remote.Has(ctx, key)
+}
+
+func (c *cacheStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return c.open(ctx, key)
+}
+
+// cachedReaderAt reads from the cached copy of an object, kept open until the reader is closed.
+//
+// The copy stays readable once evicted, as long as it is open. Should it fail to read nonetheless, the object is
+// opened again, hence fetched again if evicted. The last use of the copy is only recorded when it is opened.
+type cachedReaderAt struct {
+	c   *cacheStore
+	ctx context.Context
+	key string
+
+	mu     sync.Mutex
+	f      *os.File
+	closed bool
+}
+
+func (r *cachedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	f, err := r.file()
+	if err != nil {
+		return 0, err
+	}
+	n, err := f.ReadAt(p, off)
+	if err == nil || err == io.EOF {
+		return n, err
+	}
+	r.release(f)
+	if f, err = r.file(); err != nil {
+		return 0, err
+	}
+	return f.ReadAt(p, off)
+}
+
+// Close closes the cached copy of the object. The reader can't be read from afterwards.
+func (r *cachedReaderAt) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return os.ErrClosed
+	}
+	r.closed = true
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// file returns the open copy of the object, opening it if needed
+func (r *cachedReaderAt) file() (*os.File, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, os.ErrClosed
+	}
+	if r.f != nil {
+		return r.f, nil
+	}
+	f, err := r.c.open(r.ctx, r.key)
+	if err != nil {
+		return nil, err
+	}
+	r.f = f
+	return f, nil
+}
+
+// release closes a copy which failed to read, unless it has already been replaced
+func (r *cachedReaderAt) release(f *os.File) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == f {
+		_ = f.Close()
+		r.f = nil
+	}
+}
+
+// GetAt returns a reader of the cached copy of an object, which holds a file descriptor until closed: the reader
+// implements io.Closer.
+func (c *cacheStore) GetAt(ctx context.Context, key string) (io.ReaderAt, error) {
+	f, err := c.open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedReaderAt{c: c, ctx: ctx, key: key, f: f}, nil
+}
+
+func (c *cacheStore) GetAttr(ctx context.Context, key string) (storage.Attributes, error) {
+	return c.remote.GetAttr(ctx, key)
+}
+
+func (c *cacheStore) Touch(ctx context.Context, key string) error {
+	return c.remote.Touch(ctx, key)
+}
+
+func (c *cacheStore) Put(ctx context.Context, key string, rdr io.Reader, newKey storage.NewKey) error {
+	return c.remote.Put(ctx, key, rdr, newKey)
+}
+
+func (c *cacheStore) PutCRC(ctx context.Context, key string, rdr io.Reader, newKey bool, crc uint32) error {
+	if crcStore, ok := c.remote.(storage.StoreCRC); ok {
+		return crcStore.PutCRC(ctx, key, rdr, newKey, crc)
+	}
+	return c.remote.Put(ctx, key, rdr, newKey)
+}
+
+func (c *cacheStore) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(c.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return c.remote.Delete(ctx, key)
+}
+
+func (c *cacheStore) Keys(ctx context.Context) ([]string, error) {
+	return c.remote.Keys(ctx)
+}
+
+func (c *cacheStore) KeysPrefix(ctx context.Context, token, prefix, delimiter string, count int) ([]string, string, error) {
+	return c.remote.KeysPrefix(ctx, token, prefix, delimiter, count)
+}
+
+func (c *cacheStore) Clear(ctx context.Context) error {
+	if err := c.remote.Clear(ctx); err != nil {
+		return err
+	}
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err = os.RemoveAll(filepath.Join(c.dir, objectsDir)); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&c.size, 0)
+	return os.MkdirAll(filepath.Join(c.dir, objectsDir), 0700)
+}
@@ -0,0 +1,210 @@
+// Copyright © 2019 One Concern
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/localfs"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStore counts the objects read from a store
+type countingStore struct {
+	storage.Store
+	gets int64
+}
+
+func (s *countingStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	atomic.AddInt64(&s.gets, 1)
+	return s.Store.Get(ctx, key)
+}
+
+func testRemote(t *testing.T, objects map[string]string) *countingStore {
+	remote := &countingStore{Store: localfs.New(afero.NewMemMapFs())}
+	for key, content := range objects {
+		require.NoError(t, remote.Put(context.Background(), key, bytes.NewBufferString(content), storage.NoOverWrite))
+	}
+	return remote
+}
+
+func testDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cache")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+func readAll(t *testing.T, store storage.Store, key string) string {
+	rdr, err := store.Get(context.Background(), key)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(rdr)
+	require.NoError(t, err)
+	require.NoError(t, rdr.Close())
+	return string(b)
+}
+
+func cachedSize(t *testing.T, dir string) int64 {
+	var total int64
+	require.NoError(t, filepath.Walk(filepath.Join(dir, objectsDir), func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return err
+	}))
+	return total
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	remote := testRemote(t, map[string]string{"a/key": "content a", "b/key": "content b"})
+	dir := testDir(t)
+	store, err := New(remote, dir, 1024)
+	require.NoError(t, err)
+
+	require.Equal(t, "content a", readAll(t, store, "a/key"))
+	require.Equal(t, "content a", readAll(t, store, "a/key"))
+	require.Equal(t, int64(1), remote.gets)
+
+	rdrAt, err := store.GetAt(ctx, "b/key")
+	require.NoError(t, err)
+	p := make([]byte, 1)
+	_, err = rdrAt.ReadAt(p, 8)
+	require.NoError(t, err)
+	require.Equal(t, "b", string(p))
+	require.Equal(t, int64(2), remote.gets)
+
+	// the copy is kept open: reads don't record its use again, and go on once it is evicted
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	pth := store.(*cacheStore).path("b/key")
+	require.NoError(t, os.Chtimes(pth, past, past))
+	_, err = rdrAt.ReadAt(p, 0)
+	require.NoError(t, err)
+	require.Equal(t, "c", string(p))
+	info, err := os.Stat(pth)
+	require.NoError(t, err)
+	require.Equal(t, past, info.ModTime())
+	require.NoError(t, os.Remove(pth))
+	_, err = rdrAt.ReadAt(p, 1)
+	require.NoError(t, err)
+	require.Equal(t, "o", string(p))
+	require.Equal(t, int64(2), remote.gets)
+
+	// the copy is released when the reader is closed
+	closer, ok := rdrAt.(io.Closer)
+	require.True(t, ok)
+	require.NoError(t, closer.Close())
+	_, err = rdrAt.ReadAt(p, 0)
+	require.Equal(t, os.ErrClosed, err)
+	require.Equal(t, os.ErrClosed, closer.Close())
+
+	// another process sharing the cache directory hits the cache
+	other, err := New(remote, dir, 1024)
+	require.NoError(t, err)
+	require.Equal(t, "content a", readAll(t, other, "a/key"))
+	require.Equal(t, int64(2), remote.gets)
+
+	has, err := store.Has(ctx, "a/key")
+	require.NoError(t, err)
+	require.True(t, has)
+	_, err = store.Get(ctx, "missing")
+	require.Error(t, err)
+
+	require.NoError(t, store.Delete(ctx, "a/key"))
+	has, err = store.Has(ctx, "a/key")
+	require.NoError(t, err)
+	require.False(t, has)
+}
+
+func TestCacheSharedByRemotes(t *testing.T) {
+	ctx := context.Background()
+	fs := afero.NewMemMapFs()
+	dir := testDir(t)
+	stores := make([]storage.Store, 0, 2)
+	for _, name := range []string{"one", "two"} {
+		remote := localfs.New(afero.NewBasePathFs(fs, "/"+name))
+		require.NoError(t, remote.Put(ctx, "key", bytes.NewBufferString("content "+name), storage.NoOverWrite))
+		store, err := New(remote, dir, 1024)
+		require.NoError(t, err)
+		stores = append(stores, store)
+	}
+
+	// the same key in different remote stores is cached separately
+	require.Equal(t, "content one", readAll(t, stores[0], "key"))
+	require.Equal(t, "content two", readAll(t, stores[1], "key"))
+	require.Equal(t, "content one", readAll(t, stores[0], "key"))
+}
+
+func TestCacheEviction(t *testing.T) {
+	objects := map[string]string{}
+	for _, key := range []string{"1", "2", "3", "4", "5"} {
+		objects[key] = strings.Repeat(key, 100)
+	}
+	objects["large"] = strings.Repeat("x", 1000)
+	remote := testRemote(t, objects)
+	dir := testDir(t)
+	store, err := New(remote, dir, 350)
+	require.NoError(t, err)
+
+	past := time.Now().Add(-time.Hour)
+	for i, key := range []string{"1", "2", "3"} {
+		require.Equal(t, objects[key], readAll(t, store, key))
+		// make the order of use unambiguous
+		require.NoError(t, os.Chtimes(store.(*cacheStore).path(key), past, past.Add(time.Duration(i)*time.Minute)))
+	}
+	require.Equal(t, int64(300), cachedSize(t, dir))
+
+	// reading 1 again makes 2 the least recently used object
+	require.Equal(t, objects["1"], readAll(t, store, "1"))
+	require.Equal(t, int64(3), remote.gets)
+	require.Equal(t, objects["4"], readAll(t, store, "4"))
+	require.True(t, cachedSize(t, dir) <= 350)
+	_, err = os.Stat(store.(*cacheStore).path("2"))
+	require.True(t, os.IsNotExist(err))
+	for _, key := range []string{"1", "3", "4"} {
+		_, err = os.Stat(store.(*cacheStore).path(key))
+		require.NoError(t, err, key)
+	}
+
+	// evicted objects are fetched again
+	require.Equal(t, objects["2"], readAll(t, store, "2"))
+	require.Equal(t, int64(5), remote.gets)
+
+	// objects larger than the cache are not kept
+	require.Equal(t, objects["large"], readAll(t, store, "large"))
+	_, err = os.Stat(store.(*cacheStore).path("large"))
+	require.True(t, os.IsNotExist(err))
+	require.True(t, cachedSize(t, dir) <= 350)
+}
+
+func TestCacheStaleTmp(t *testing.T) {
+	dir := testDir(t)
+	_, err := New(testRemote(t, nil), dir, 1024)
+	require.NoError(t, err)
+
+	// leftovers of a crashed process are removed, ongoing fetches are not
+	stale := filepath.Join(dir, tmpDir, "fetch-stale")
+	ongoing := filepath.Join(dir, tmpDir, "fetch-ongoing")
+	require.NoError(t, ioutil.WriteFile(stale, []byte("partial"), 0600))
+	require.NoError(t, ioutil.WriteFile(ongoing, []byte("partial"), 0600))
+	past := time.Now().Add(-2 * staleTmp)
+	require.NoError(t, os.Chtimes(stale, past, past))
+
+	_, err = New(testRemote(t, nil), dir, 1024)
+	require.NoError(t, err)
+	_, err = os.Stat(stale)
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(ongoing)
+	require.NoError(t, err)
+}
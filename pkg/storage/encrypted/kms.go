@@ -0,0 +1,170 @@
+// Copyright © 2019 One Concern
+
+package encrypted
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// MasterKeySize is the size of master keys, for AES-256
+const MasterKeySize = 32
+
+// KMS encrypts and decrypts data keys with master keys, which never leave the KMS.
+//
+// This is the interface of key management services such as Google Cloud KMS or AWS KMS.
+type KMS interface {
+	Encrypt(ctx context.Context, keyID string, plaintext []byte) ([]byte, error)
+	Decrypt(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error)
+}
+
+// NewKeyFileKMS builds a KMS from a single master key, held in a local key file.
+//
+// The key file holds the hex encoding of a 32 bytes key: see GenerateKeyFile. Key ids are ignored.
+func NewKeyFileKMS(pth string) (KMS, error) {
+	key, err := readKeyFile(pth)
+	if err != nil {
+		return nil, err
+	}
+	return &keyFileKMS{key: key}, nil
+}
+
+type keyFileKMS struct {
+	key []byte
+}
+
+func (k *keyFileKMS) Encrypt(_ context.Context, _ string, plaintext []byte) ([]byte, error) {
+	return wrapKey(k.key, plaintext)
+}
+
+func (k *keyFileKMS) Decrypt(_ context.Context, _ string, ciphertext []byte) ([]byte, error) {
+	return unwrapKey(k.key, ciphertext)
+}
+
+// NewFileKMS builds a KMS stand-in, with master keys held as key files in a local directory.
+//
+// The master key with id "name" is held in the key file "name.key". Key files are created with GenerateKeyFile.
+func NewFileKMS(dir string) KMS {
+	return &fileKMS{
+		dir:  dir,
+		keys: make(map[string][]byte),
+	}
+}
+
+type fileKMS struct {
+	dir  string
+	keys map[string][]byte
+	mu   sync.Mutex
+}
+
+func (f *fileKMS) masterKey(keyID string) ([]byte, error) {
+	if keyID == "" || strings.ContainsAny(keyID, `/\`) || strings.HasPrefix(keyID, ".") {
+		return nil, fmt.Errorf("invalid master key id %q", keyID)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if key, ok := f.keys[keyID]; ok {
+		return key, nil
+	}
+	key, err := readKeyFile(filepath.Join(f.dir, keyID+".key"))
+	if err != nil {
+		return nil, err
+	}
+	f.keys[keyID] = key
+	return key, nil
+}
+
+func (f *fileKMS) Encrypt(_ context.Context, keyID string, plaintext []byte) ([]byte, error) {
+	key, err := f.masterKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return wrapKey(key, plaintext)
+}
+
+func (f *fileKMS) Decrypt(_ context.Context, keyID string, ciphertext []byte) ([]byte, error) {
+	key, err := f.masterKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return unwrapKey(key, ciphertext)
+}
+
+// GenerateKeyFile writes a new random master key to a key file, which must not exist yet
+func GenerateKeyFile(pth string) error {
+	key := make([]byte, MasterKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(pth, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readKeyFile(pth string) ([]byte, error) {
+	b, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", pth, err)
+	}
+	if len(key) != MasterKeySize {
+		return nil, fmt.Errorf("invalid key file %s: expected a %d bytes key, got %d", pth, MasterKeySize, len(key))
+	}
+	return key, nil
+}
+
+// wrapKey encrypts a data key with AES-GCM and a random nonce
+func wrapKey(masterKey, plaintext []byte) ([]byte, error) {
+	aead, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func unwrapKey(masterKey, ciphertext []byte) ([]byte, error) {
+	aead, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("wrapped key is too short")
+	}
+	plaintext, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key, is this the right master key? %w", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,395 @@
+// Copyright © 2019 One Concern
+
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/oneconcern/datamon/pkg/storage"
+)
+
+const (
+	// KeyPrefix is where wrapped data keys are kept in the encrypted store. Keys under this prefix are not listed.
+	KeyPrefix = ".datamon-keys/"
+
+	// DefaultKeyName is the name of the data key used when none is specified
+	DefaultKeyName = "default"
+
+	algorithm   = "AES-256-GCM-HMAC-SHA256-SIV"
+	dataKeySize = 32
+)
+
+// ErrNotEncrypted is returned when reading an object which was not written by an encrypted store
+var ErrNotEncrypted = errors.New("object is not encrypted")
+
+/* encrypted objects are stored as:
+ *
+ *    magic | nonce | AES-GCM ciphertext and tag
+ *
+ * the nonce is synthetic: it is derived from the key and the plaintext of the object, so that encryption is
+ * deterministic under a given data key and content addressed objects still dedupe.
+ * The key of the object is authenticated, so that encrypted objects may not be moved around.
+ */
+var magic = []byte("DMENC001")
+
+const (
+	nonceSize = 12
+	tagSize   = 16
+	overhead  = 8 + nonceSize + tagSize
+)
+
+// Option sets options for an encrypted store
+type Option func(*encryptedStore)
+
+// KeyName sets the name of the data key of the store, e.g. a context or a repo.
+//
+// Objects are encrypted deterministically under a given data key: they only dedupe across stores using the same key.
+func KeyName(name string) Option {
+	return func(e *encryptedStore) {
+		if name != "" {
+			e.keyName = name
+		}
+	}
+}
+
+// New wraps a store with client-side encryption.
+//
+// Objects are encrypted with AES-GCM under a data key, which is itself encrypted by the master key keyID
+// of a KMS (envelope encryption). The data key is created on first use, and kept in the store, wrapped.
+// Object keys are left in clear.
+func New(store storage.Store, kms KMS, keyID string, opts ...Option) storage.Store {
+	e := &encryptedStore{
+		store:   store,
+		kms:     kms,
+		keyID:   keyID,
+		keyName: DefaultKeyName,
+	}
+	for _, apply := range opts {
+		apply(e)
+	}
+	return e
+}
+
+type encryptedStore struct {
+	store   storage.Store
+	kms     KMS
+	keyID   string
+	keyName string
+	keys    *dataKeys
+	mu      sync.Mutex
+}
+
+// wrappedKey is the record of a data key, as kept in the store
+type wrappedKey struct {
+	KeyID      string `json:"keyID" yaml:"keyID"` // the id of the master key in the KMS
+	Algorithm  string `json:"algorithm" yaml:"algorithm"`
+	WrappedKey string `json:"wrappedKey" yaml:"wrappedKey"` // base64 encoded
+}
+
+// dataKeys holds the keys derived from a data key
+type dataKeys struct {
+	encryption []byte
+	nonce      []byte
+}
+
+func deriveKeys(dataKey []byte) *dataKeys {
+	derive := func(label string) []byte {
+		mac := hmac.New(sha256.New, dataKey)
+		_, _ = mac.Write([]byte(label))
+		return mac.Sum(nil)
+	}
+	return &dataKeys{
+		encryption: derive("datamon encryption key"),
+		nonce:      derive("datamon nonce key"),
+	}
+}
+
+func (e *encryptedStore) keyPath() string {
+	return KeyPrefix + e.keyName + ".yaml"
+}
+
+func (e *encryptedStore) dataKeys(ctx context.Context) (*dataKeys, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.keys != nil {
+		return e.keys, nil
+	}
+	has, err := e.store.Has(ctx, e.keyPath())
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		if err = e.createDataKey(ctx); err != nil {
+			// some other writer may have created it concurrently: fall back to reading it
+			if has, _ = e.store.Has(ctx, e.keyPath()); !has {
+				return nil, err
+			}
+		}
+	}
+	dataKey, err := e.readDataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	e.keys = deriveKeys(dataKey)
+	return e.keys, nil
+}
+
+func (e *encryptedStore) createDataKey(ctx context.Context) error {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return err
+	}
+	wrapped, err := e.kms.Encrypt(ctx, e.keyID, dataKey)
+	if err != nil {
+		return fmt.Errorf("failed to wrap data key %s: %w", e.keyName, err)
+	}
+	b, err := yaml.Marshal(wrappedKey{
+		KeyID:      e.keyID,
+		Algorithm:  algorithm,
+		WrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+	})
+	if err != nil {
+		return err
+	}
+	return e.store.Put(ctx, e.keyPath(), bytes.NewReader(b), storage.NoOverWrite)
+}
+
+func (e *encryptedStore) readDataKey(ctx context.Context) ([]byte, error) {
+	rdr, err := e.store.Get(ctx, e.keyPath())
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	b, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		return nil, err
+	}
+	var record wrappedKey
+	if err = yaml.Unmarshal(b, &record); err != nil {
+		return nil, fmt.Errorf("invalid data key %s: %w", e.keyName, err)
+	}
+	if record.Algorithm != algorithm {
+		return nil, fmt.Errorf("unsupported encryption algorithm %q for data key %s", record.Algorithm, e.keyName)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(record.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data key %s: %w", e.keyName, err)
+	}
+	dataKey, err := e.kms.Decrypt(ctx, record.KeyID, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key %s: %w", e.keyName, err)
+	}
+	if len(dataKey) != dataKeySize {
+		return nil, fmt.Errorf("invalid data key %s", e.keyName)
+	}
+	return dataKey, nil
+}
+
+func (k *dataKeys) syntheticNonce(key string, plaintext []byte) []byte {
+	mac := hmac.New(sha256.New, k.nonce)
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(len(key)))
+	_, _ = mac.Write(size[:])
+	_, _ = mac.Write([]byte(key))
+	_, _ = mac.Write(plaintext)
+	return mac.Sum(nil)[:nonceSize]
+}
+
+func (e *encryptedStore) encrypt(ctx context.Context, key string, plaintext []byte) ([]byte, error) {
+	keys, err := e.dataKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(keys.encryption)
+	if err != nil {
+		return nil, err
+	}
+	nonce := keys.syntheticNonce(key, plaintext)
+	out := make([]byte, 0, len(plaintext)+overhead)
+	out = append(out, magic...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plaintext, []byte(key)), nil
+}
+
+func (e *encryptedStore) decrypt(ctx context.Context, key string, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < overhead || !bytes.HasPrefix(ciphertext, magic) {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotEncrypted)
+	}
+	keys, err := e.dataKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(keys.encryption)
+	if err != nil {
+		return nil, err
+	}
+	nonce := ciphertext[len(magic) : len(magic)+nonceSize]
+	plaintext, err := aead.Open(nil, nonce, ciphertext[len(magic)+nonceSize:], []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", key, err)
+	}
+	return plaintext, nil
+}
+
+func (e *encryptedStore) read(ctx context.Context, key string) ([]byte, error) {
+	rdr, err := e.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	ciphertext, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		return nil, err
+	}
+	return e.decrypt(ctx, key, ciphertext)
+}
+
+func isKey(key string) bool {
+	return strings.HasPrefix(key, KeyPrefix)
+}
+
+func (e *encryptedStore) String() string {
+	return e.store.String()
+}
+
+func (e *encryptedStore) Has(ctx context.Context, key string) (bool, error) {
+	return e.store.Has(ctx, key)
+}
+
+func (e *encryptedStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	plaintext, err := e.read(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(plaintext)), nil
+}
+
+// GetAt reads and decrypts the whole object
+func (e *encryptedStore) GetAt(ctx context.Context, key string) (io.ReaderAt, error) {
+	plaintext, err := e.read(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(plaintext), nil
+}
+
+// GetAttr returns the attributes of an object, with the size of its plaintext
+func (e *encryptedStore) GetAttr(ctx context.Context, key string) (storage.Attributes, error) {
+	attrs, err := e.store.GetAttr(ctx, key)
+	if err != nil {
+		return attrs, err
+	}
+	if attrs.Size >= overhead {
+		attrs.Size -= overhead
+	}
+	return attrs, nil
+}
+
+func (e *encryptedStore) Touch(ctx context.Context, key string) error {
+	return e.store.Touch(ctx, key)
+}
+
+func (e *encryptedStore) Put(ctx context.Context, key string, rdr io.Reader, newKey storage.NewKey) error {
+	ciphertext, err := e.encryptReader(ctx, key, rdr)
+	if err != nil {
+		return err
+	}
+	return e.store.Put(ctx, key, bytes.NewReader(ciphertext), newKey)
+}
+
+// PutCRC checks the CRC32C checksum of the plaintext, then checks the CRC of the encrypted object when the
+// underlying store supports it
+func (e *encryptedStore) PutCRC(ctx context.Context, key string, rdr io.Reader, newKey bool, crc uint32) error {
+	plaintext, err := e.readPlaintext(key, rdr)
+	if err != nil {
+		return err
+	}
+	if actual := crc32.Checksum(plaintext, crc32.MakeTable(crc32.Castagnoli)); actual != crc {
+		return fmt.Errorf("crc32c mismatch for %s: expected %08x, got %08x", key, crc, actual)
+	}
+	ciphertext, err := e.encrypt(ctx, key, plaintext)
+	if err != nil {
+		return err
+	}
+	if s, ok := e.store.(storage.StoreCRC); ok {
+		return s.PutCRC(ctx, key, bytes.NewReader(ciphertext), newKey,
+			crc32.Checksum(ciphertext, crc32.MakeTable(crc32.Castagnoli)))
+	}
+	return e.store.Put(ctx, key, bytes.NewReader(ciphertext), newKey)
+}
+
+func (e *encryptedStore) encryptReader(ctx context.Context, key string, rdr io.Reader) ([]byte, error) {
+	plaintext, err := e.readPlaintext(key, rdr)
+	if err != nil {
+		return nil, err
+	}
+	return e.encrypt(ctx, key, plaintext)
+}
+
+func (e *encryptedStore) readPlaintext(key string, rdr io.Reader) ([]byte, error) {
+	if isKey(key) {
+		return nil, fmt.Errorf("%s: reserved key", key)
+	}
+	plaintext, err := ioutil.ReadAll(io.LimitReader(rdr, storage.MaxObjectSizeInMemory+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(plaintext) > storage.MaxObjectSizeInMemory {
+		return nil, storage.ErrObjectTooBig
+	}
+	return plaintext, nil
+}
+
+func (e *encryptedStore) Delete(ctx context.Context, key string) error {
+	return e.store.Delete(ctx, key)
+}
+
+// Keys lists the keys of the store, except for the data keys
+func (e *encryptedStore) Keys(ctx context.Context) ([]string, error) {
+	keys, err := e.store.Keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return withoutDataKeys(keys), nil
+}
+
+func (e *encryptedStore) KeysPrefix(ctx context.Context, pageToken string, prefix string, delimiter string, count int) ([]string, string, error) {
+	keys, next, err := e.store.KeysPrefix(ctx, pageToken, prefix, delimiter, count)
+	if err != nil {
+		return nil, "", err
+	}
+	return withoutDataKeys(keys), next, nil
+}
+
+func withoutDataKeys(keys []string) []string {
+	kept := keys[:0]
+	for _, key := range keys {
+		if !isKey(key) {
+			kept = append(kept, key)
+		}
+	}
+	return kept
+}
+
+// Clear removes all objects, including the data key: a new one is created on next use
+func (e *encryptedStore) Clear(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.keys = nil
+	return e.store.Clear(ctx)
+}
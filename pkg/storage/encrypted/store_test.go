@@ -0,0 +1,133 @@
+// Copyright © 2019 One Concern
+
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/localfs"
+	"github.com/spf13/afero"
+)
+
+func testKMS(t *testing.T) (KMS, string) {
+	dir, err := ioutil.TempDir("", "encrypted-kms")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	require.NoError(t, GenerateKeyFile(filepath.Join(dir, "master.key")))
+	require.Error(t, GenerateKeyFile(filepath.Join(dir, "master.key")))
+	return NewFileKMS(dir), dir
+}
+
+func readAll(t *testing.T, store storage.Store, key string) []byte {
+	rdr, err := store.Get(context.Background(), key)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(rdr)
+	require.NoError(t, err)
+	require.NoError(t, rdr.Close())
+	return b
+}
+
+func TestEncryptedStore(t *testing.T) {
+	ctx := context.Background()
+	kms, _ := testKMS(t)
+	backend := localfs.New(afero.NewMemMapFs())
+	store := New(backend, kms, "master", KeyName("repo"))
+
+	content := []byte("name,ssn\njohn,123-45-6789\n")
+	require.NoError(t, store.Put(ctx, "data/file.csv", bytes.NewReader(content), storage.NoOverWrite))
+	require.Equal(t, content, readAll(t, store, "data/file.csv"))
+
+	// nothing is stored in clear
+	stored := readAll(t, backend, "data/file.csv")
+	require.False(t, bytes.Contains(stored, []byte("john")))
+	require.Len(t, stored, len(content)+overhead)
+
+	attrs, err := store.GetAttr(ctx, "data/file.csv")
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)), attrs.Size)
+
+	rdrAt, err := store.GetAt(ctx, "data/file.csv")
+	require.NoError(t, err)
+	p := make([]byte, 4)
+	_, err = rdrAt.ReadAt(p, 9)
+	require.NoError(t, err)
+	require.Equal(t, "john", string(p))
+
+	// encryption is deterministic under a data key, so that content addressed objects dedupe
+	crc, ok := store.(storage.StoreCRC)
+	require.True(t, ok)
+	checksum := crc32.Checksum(content, crc32.MakeTable(crc32.Castagnoli))
+	require.Error(t, crc.PutCRC(ctx, "data/copy.csv", bytes.NewReader(content), storage.NoOverWrite, checksum+1))
+	require.NoError(t, crc.PutCRC(ctx, "data/copy.csv", bytes.NewReader(content), storage.NoOverWrite, checksum))
+	require.NoError(t, store.Put(ctx, "data/file.csv", bytes.NewReader(content), storage.OverWrite))
+	require.Equal(t, stored, readAll(t, backend, "data/file.csv"))
+
+	// data keys are not listed
+	keys, err := store.Keys(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"data/copy.csv", "data/file.csv"}, keys)
+	keys, _, err = store.KeysPrefix(ctx, "", "", "/", 100)
+	require.NoError(t, err)
+	require.Equal(t, []string{"data/"}, keys)
+	all, err := backend.Keys(ctx)
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	require.Error(t, store.Put(ctx, KeyPrefix+"x", bytes.NewReader(content), storage.OverWrite))
+
+	// another store on the same backend and key reads the data; other keys don't
+	require.Equal(t, content, readAll(t, New(backend, kms, "master", KeyName("repo")), "data/file.csv"))
+	_, err = New(backend, kms, "master", KeyName("other")).Get(ctx, "data/file.csv")
+	require.Error(t, err)
+
+	// objects may not be moved around
+	require.NoError(t, backend.Put(ctx, "data/moved.csv", bytes.NewReader(stored), storage.NoOverWrite))
+	_, err = store.Get(ctx, "data/moved.csv")
+	require.Error(t, err)
+
+	// unencrypted objects are rejected
+	require.NoError(t, backend.Put(ctx, "data/clear.csv", bytes.NewReader(content), storage.NoOverWrite))
+	_, err = store.Get(ctx, "data/clear.csv")
+	require.True(t, errors.Is(err, ErrNotEncrypted))
+}
+
+func TestKMS(t *testing.T) {
+	ctx := context.Background()
+	kms, dir := testKMS(t)
+
+	wrapped, err := kms.Encrypt(ctx, "master", []byte("data key"))
+	require.NoError(t, err)
+	unwrapped, err := kms.Decrypt(ctx, "master", wrapped)
+	require.NoError(t, err)
+	require.Equal(t, "data key", string(unwrapped))
+
+	_, err = kms.Encrypt(ctx, "missing", []byte("data key"))
+	require.Error(t, err)
+	_, err = kms.Encrypt(ctx, "../master", []byte("data key"))
+	require.Error(t, err)
+
+	keyFile, err := NewKeyFileKMS(filepath.Join(dir, "master.key"))
+	require.NoError(t, err)
+	unwrapped, err = keyFile.Decrypt(ctx, "", wrapped)
+	require.NoError(t, err)
+	require.Equal(t, "data key", string(unwrapped))
+
+	require.NoError(t, GenerateKeyFile(filepath.Join(dir, "other.key")))
+	_, err = kms.Decrypt(ctx, "other", wrapped)
+	require.Error(t, err)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "short.key"), []byte("abcd"), 0600))
+	_, err = NewKeyFileKMS(filepath.Join(dir, "short.key"))
+	require.Error(t, err)
+	_, err = NewKeyFileKMS(filepath.Join(dir, "missing.key"))
+	require.Error(t, err)
+}
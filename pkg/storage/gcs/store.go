@@ -122,6 +122,7 @@ func (g *gcs) GetAttr(ctx context.Context, objectName string) (storage.Attribute
 		Created: attr.Created,
 		Updated: attr.Updated,
 		Owner:   attr.Owner,
+		Size:    attr.Size,
 	}, nil
 }
 
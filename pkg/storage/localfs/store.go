@@ -4,11 +4,12 @@ package localfs
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
@@ -33,7 +34,7 @@ type localFS struct {
 
 func (l *localFS) Has(ctx context.Context, key string) (bool, error) {
 
-	fi, err := l.fs.Stat(key)
+	fi, err := l.lstat(key)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return false, nil
@@ -94,7 +95,7 @@ func (l *localFS) Put(ctx context.Context, key string, source io.Reader, exclusi
 			return fmt.Errorf("ensuring directories for %q: %v", key, err)
 		}
 	}
-	flag := os.O_CREATE | os.O_WRONLY | os.O_SYNC | 0600
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC | os.O_SYNC
 	if exclusive {
 		flag |= os.O_EXCL
 	}
@@ -139,6 +140,11 @@ func (l *localFS) Keys(ctx context.Context) ([]string, error) {
 		if path == root {
 			return nil
 		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			// symbolic links are keys, whatever they point to
+			res = append(res, path)
+			return nil
+		}
 		fileInfo, err := l.fs.Stat(path)
 		if err != nil {
 			return err
@@ -155,9 +161,41 @@ func (l *localFS) Keys(ctx context.Context) ([]string, error) {
 	return res, nil
 }
 
-//TODO discuss the implementation with @Ivan & @Ritesh
+// KeysPrefix lists keys in lexical order. The page token is the first key to return, and the returned token
+// is the first key of the next page, if any.
+//
+// When a delimiter is set, keys sharing a common prefix up to the delimiter are returned once, as that prefix.
 func (l *localFS) KeysPrefix(ctx context.Context, token, prefix, delimiter string, count int) ([]string, string, error) {
-	return nil, "", errors.New("unimplemented")
+	keys, err := l.Keys(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Strings(keys)
+
+	var (
+		res        []string
+		lastCommon string
+	)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) || key < token {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				common := key[:len(prefix)+i+len(delimiter)]
+				if common == lastCommon {
+					continue
+				}
+				lastCommon = common
+				key = common
+			}
+		}
+		if count > 0 && len(res) == count {
+			return res, key, nil
+		}
+		res = append(res, key)
+	}
+	return res, "", nil
 }
 
 func (l *localFS) Clear(ctx context.Context) error {
@@ -192,14 +230,98 @@ func (l *localFS) GetAttr(ctx context.Context, objectName string) (storage.Attri
 	if err != nil {
 		return storage.Attributes{}, err
 	}
-	sys, ok := stat.Sys().(syscall.Stat_t)
-	if !ok {
+	var owner string
+	switch sys := stat.Sys().(type) {
+	case *syscall.Stat_t:
+		owner = fmt.Sprint(sys.Uid)
+	case syscall.Stat_t:
+		owner = fmt.Sprint(sys.Uid)
+	case nil:
+		// in-memory file systems do not carry ownership
+	default:
 		return storage.Attributes{}, fmt.Errorf("failed to convert sys to Stat_t for object:%s", objectName)
 	}
 	return storage.Attributes{
 		Created: stat.ModTime(), // Fix me: need a platform independent way to extracting timestamps
 		Updated: stat.ModTime(),
-		Owner:   fmt.Sprint(sys.Uid),
+		Owner:   owner,
+		Size:    stat.Size(),
 	}, nil
 
 }
+
+func (l *localFS) lstat(key string) (os.FileInfo, error) {
+	if lstater, ok := l.fs.(afero.Lstater); ok {
+		fi, _, err := lstater.LstatIfPossible(key)
+		return fi, err
+	}
+	return l.fs.Stat(key)
+}
+
+func (l *localFS) Lstat(ctx context.Context, key string) (os.FileInfo, error) {
+	fi, err := l.lstat(key)
+	return fi, toSentinelErrors(err)
+}
+
+func (l *localFS) Readlink(ctx context.Context, key string) (string, error) {
+	reader, ok := l.fs.(afero.LinkReader)
+	if !ok {
+		return "", storage.ErrNotSupported
+	}
+	return reader.ReadlinkIfPossible(key)
+}
+
+func (l *localFS) Symlink(ctx context.Context, target string, key string) error {
+	if err := l.fs.MkdirAll(filepath.Dir(key), 0700); err != nil {
+		return fmt.Errorf("ensuring directories for %q: %v", key, err)
+	}
+	if bp, ok := l.fs.(*afero.BasePathFs); ok {
+		// BasePathFs would rebase the target as well, turning relative links into absolute ones
+		link, err := bp.RealPath(key)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, link)
+	}
+	linker, ok := l.fs.(afero.Linker)
+	if !ok {
+		return storage.ErrNotSupported
+	}
+	return linker.SymlinkIfPossible(target, key)
+}
+
+func (l *localFS) Chmod(ctx context.Context, key string, mode os.FileMode) error {
+	return l.fs.Chmod(key, mode.Perm())
+}
+
+func (l *localFS) Mkdir(ctx context.Context, key string, mode os.FileMode) error {
+	if err := l.fs.MkdirAll(key, 0700); err != nil {
+		return err
+	}
+	return l.fs.Chmod(key, mode.Perm())
+}
+
+func (l *localFS) EmptyDirs(ctx context.Context) ([]string, error) {
+	const root = "."
+	var res []string
+	e := afero.Walk(l.fs, root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root || !info.IsDir() {
+			return nil
+		}
+		entries, err := afero.ReadDir(l.fs, path)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			res = append(res, path)
+		}
+		return nil
+	})
+	if e != nil {
+		return nil, e
+	}
+	return res, nil
+}
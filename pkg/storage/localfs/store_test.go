@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"context"
 	"io/ioutil"
+	"os"
 	"testing"
 
 	"github.com/oneconcern/datamon/pkg/storage"
@@ -95,6 +96,94 @@ func TestPut(t *testing.T) {
 	assert.Len(t, k, 3)
 }
 
+func TestKeysPrefix(t *testing.T) {
+	bs := New(afero.NewMemMapFs())
+	for _, key := range []string{"a/1", "a/2", "b/1/x", "b/1/y", "b/2", "c"} {
+		require.NoError(t, bs.Put(context.Background(), key, bytes.NewBufferString(key), storage.NoOverWrite))
+	}
+
+	keys, next, err := bs.KeysPrefix(context.Background(), "", "", "", 4)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/1", "a/2", "b/1/x", "b/1/y"}, keys)
+	assert.Equal(t, "b/2", next)
+
+	keys, next, err = bs.KeysPrefix(context.Background(), next, "", "", 4)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b/2", "c"}, keys)
+	assert.Empty(t, next)
+
+	keys, next, err = bs.KeysPrefix(context.Background(), "", "b/", "/", 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b/1/"}, keys)
+	assert.Equal(t, "b/2", next)
+
+	keys, next, err = bs.KeysPrefix(context.Background(), next, "b/", "/", 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b/2"}, keys)
+	assert.Empty(t, next)
+}
+
+func TestGetAttr(t *testing.T) {
+	bs, cleanup := setupStore(t)
+	defer cleanup()
+
+	require.NoError(t, bs.Touch(context.Background(), "sixteentons"))
+	attr, err := bs.GetAttr(context.Background(), "sixteentons")
+	require.NoError(t, err)
+	assert.False(t, attr.Updated.IsZero())
+
+	dir, err := ioutil.TempDir("", "localfs-attr")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	osStore := New(afero.NewBasePathFs(afero.NewOsFs(), dir))
+	require.NoError(t, osStore.Put(context.Background(), "key", bytes.NewBufferString("value"), storage.NoOverWrite))
+	attr, err = osStore.GetAttr(context.Background(), "key")
+	require.NoError(t, err)
+	assert.NotEmpty(t, attr.Owner)
+}
+
+func TestStoreFS(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "localfs-modes")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	bs := New(afero.NewBasePathFs(afero.NewOsFs(), dir))
+	fsStore, ok := bs.(storage.StoreFS)
+	require.True(t, ok)
+
+	require.NoError(t, bs.Put(ctx, "a/script.sh", bytes.NewBufferString("#!/bin/sh"), storage.NoOverWrite))
+	require.NoError(t, fsStore.Chmod(ctx, "a/script.sh", 0755))
+	require.NoError(t, fsStore.Symlink(ctx, "script.sh", "a/link"))
+	require.NoError(t, fsStore.Symlink(ctx, "missing", "dangling"))
+	require.NoError(t, fsStore.Mkdir(ctx, "b/empty", 0750))
+
+	fi, err := fsStore.Lstat(ctx, "a/script.sh")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), fi.Mode())
+
+	fi, err = fsStore.Lstat(ctx, "a/link")
+	require.NoError(t, err)
+	assert.True(t, fi.Mode()&os.ModeSymlink != 0)
+	target, err := fsStore.Readlink(ctx, "a/link")
+	require.NoError(t, err)
+	assert.Equal(t, "script.sh", target)
+
+	has, err := bs.Has(ctx, "dangling")
+	require.NoError(t, err)
+	assert.True(t, has)
+
+	keys, err := bs.Keys(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a/script.sh", "a/link", "dangling"}, keys)
+
+	dirs, err := fsStore.EmptyDirs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b/empty"}, dirs)
+	fi, err = fsStore.Lstat(ctx, "b/empty")
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeDir|0750, fi.Mode())
+}
+
 func setupStore(t testing.TB) (storage.Store, func()) {
 	t.Helper()
 
@@ -0,0 +1,116 @@
+// Copyright © 2019 One Concern
+
+// Package location builds stores from location URLs.
+//
+// Supported locations are:
+//   - gs://bucket, or a plain bucket name: a Google Cloud Storage bucket
+//   - s3://bucket: an AWS S3 bucket, configured from the environment (e.g. AWS_REGION)
+//   - az://account/container: an Azure Blob Storage container, with the shared key in AZURE_STORAGE_KEY
+//   - file:///path: a local directory, created if needed
+//   - mem://name: an in-memory store, shared by all locations with the same name in the process
+package location
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/azure"
+	"github.com/oneconcern/datamon/pkg/storage/gcs"
+	"github.com/oneconcern/datamon/pkg/storage/localfs"
+	"github.com/oneconcern/datamon/pkg/storage/sthree"
+	"github.com/spf13/afero"
+)
+
+// Location schemes
+const (
+	SchemeGCS   = "gs"
+	SchemeS3    = "s3"
+	SchemeAzure = "az"
+	SchemeFile  = "file"
+	SchemeMem   = "mem"
+)
+
+var (
+	memStores   = make(map[string]storage.Store)
+	memStoresMu sync.Mutex
+)
+
+// New builds the store for a location.
+//
+// The credential file is only used for GCS locations.
+func New(ctx context.Context, location string, credentialFile string) (storage.Store, error) {
+	if location == "" {
+		return nil, fmt.Errorf("empty store location")
+	}
+	if !strings.Contains(location, "://") {
+		// plain bucket names are GCS buckets
+		return gcs.New(ctx, location, credentialFile)
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid store location %q: %w", location, err)
+	}
+
+	switch u.Scheme {
+	case SchemeGCS:
+		if err = bucketOnly(u); err != nil {
+			return nil, err
+		}
+		return gcs.New(ctx, u.Host, credentialFile)
+
+	case SchemeS3:
+		if err = bucketOnly(u); err != nil {
+			return nil, err
+		}
+		return sthree.New(sthree.Bucket(u.Host)), nil
+
+	case SchemeAzure:
+		container := strings.Trim(u.Path, "/")
+		if u.Host == "" || container == "" || strings.Contains(container, "/") {
+			return nil, fmt.Errorf("invalid store location %q: expected az://account/container", location)
+		}
+		return azure.New(azure.Container(container), azure.Account(u.Host, os.Getenv(azure.EnvKey)))
+
+	case SchemeFile:
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("invalid store location %q: file locations must be absolute, e.g. file:///path", location)
+		}
+		if u.Path == "" {
+			return nil, fmt.Errorf("invalid store location %q: missing path", location)
+		}
+		if err = os.MkdirAll(u.Path, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create store directory %s: %w", u.Path, err)
+		}
+		return localfs.New(afero.NewBasePathFs(afero.NewOsFs(), u.Path)), nil
+
+	case SchemeMem:
+		name := u.Host + u.Path
+		memStoresMu.Lock()
+		defer memStoresMu.Unlock()
+		store, ok := memStores[name]
+		if !ok {
+			store = localfs.New(afero.NewMemMapFs())
+			memStores[name] = store
+		}
+		return store, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q in store location %q", u.Scheme, location)
+	}
+}
+
+func bucketOnly(u *url.URL) error {
+	if u.Host == "" {
+		return fmt.Errorf("invalid store location %q: missing bucket", u.String())
+	}
+	if strings.Trim(u.Path, "/") != "" {
+		return fmt.Errorf("invalid store location %q: paths within buckets are not supported", u.String())
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright © 2019 One Concern
+
+package location
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "location")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fileStore, err := New(ctx, "file://"+filepath.Join(dir, "blob"), "")
+	require.NoError(t, err)
+	require.NoError(t, fileStore.Put(ctx, "a/b", bytes.NewBufferString("content"), storage.NoOverWrite))
+	b, err := ioutil.ReadFile(filepath.Join(dir, "blob", "a", "b"))
+	require.NoError(t, err)
+	require.Equal(t, "content", string(b))
+
+	memStore, err := New(ctx, "mem://test", "")
+	require.NoError(t, err)
+	require.NoError(t, memStore.Put(ctx, "key", bytes.NewBufferString("content"), storage.NoOverWrite))
+	same, err := New(ctx, "mem://test", "")
+	require.NoError(t, err)
+	has, err := same.Has(ctx, "key")
+	require.NoError(t, err)
+	require.True(t, has)
+	other, err := New(ctx, "mem://other", "")
+	require.NoError(t, err)
+	has, err = other.Has(ctx, "key")
+	require.NoError(t, err)
+	require.False(t, has)
+
+	s3Store, err := New(ctx, "s3://bucket", "")
+	require.NoError(t, err)
+	require.NotNil(t, s3Store)
+
+	azureStore, err := New(ctx, "az://devstoreaccount1/container", "")
+	require.NoError(t, err)
+	require.Equal(t, "azure://devstoreaccount1/container", azureStore.String())
+
+	for _, invalid := range []string{
+		"",
+		"ftp://host/path",
+		"file://relative/path",
+		"file://",
+		"s3://",
+		"s3://bucket/path",
+		"gs://bucket/path",
+		"az://account",
+		"az://account/container/path",
+	} {
+		_, err = New(ctx, invalid, "")
+		require.Error(t, err, invalid)
+	}
+}
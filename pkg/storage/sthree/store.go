@@ -1,14 +1,21 @@
 package sthree
 
 import (
+	"bytes"
 	"context"
-	"errors"
+	"crypto/md5" // nolint:gosec
+	"encoding/base64"
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
-	"log"
+	"io/ioutil"
+	"net/url"
+	"sort"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -18,6 +25,9 @@ import (
 
 const PageSize = 1000
 
+// crc32cMetadata is the user metadata holding the CRC32C checksum of objects, in the same format as GCS
+const crc32cMetadata = "Crc32c"
+
 type Option func(*s3FS)
 
 func Bucket(bucket string) Option {
@@ -72,15 +82,29 @@ func toSentinelErrors(err error) error {
 	// return sentinel errors defined by the status package
 	// see: https://docs.aws.amazon.com/AmazonS3/latest/API/ErrorResponses.html#ErrorCodeList
 	if awse, ok := err.(awserr.Error); ok {
-		if awse.Code() == "NoSuchKey" || awse.Code() == "NoSuchBucket" {
+		switch awse.Code() {
+		case s3.ErrCodeNoSuchKey, s3.ErrCodeNoSuchBucket, "NotFound":
+			// NotFound is returned on HEAD requests, which have no body to carry an error code
 			return status.ErrNotExists
+		case "PreconditionFailed":
+			return storage.ErrExists
 		}
 	}
 	return err
 }
 
+// ifNoneMatch makes object creations conditional on the object not existing yet.
+//
+// This is set on the requests which create objects: single part uploads, and the completion of multipart uploads.
+func ifNoneMatch(r *request.Request) {
+	switch r.Operation.Name {
+	case "PutObject", "CompleteMultipartUpload":
+		r.HTTPRequest.Header.Set("If-None-Match", "*")
+	}
+}
+
 func (s *s3FS) Get(ctx context.Context, key string) (io.ReadCloser, error) {
-	obj, err := s.s3.GetObject(&s3.GetObjectInput{
+	obj, err := s.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(key),
 	})
@@ -91,17 +115,62 @@ func (s *s3FS) Get(ctx context.Context, key string) (io.ReadCloser, error) {
 	return obj.Body, nil
 }
 
-func (s *s3FS) Put(ctx context.Context, key string, rdr io.Reader, _ bool) error {
+func (s *s3FS) Put(ctx context.Context, key string, rdr io.Reader, doesNotExist bool) error {
+	var opts []func(*s3manager.Uploader)
+	if doesNotExist {
+		opts = append(opts, s3manager.WithUploaderRequestOptions(ifNoneMatch))
+	}
 	_, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(key),
 		Body:   rdr,
-	})
-	return err
+	}, opts...)
+	if err != nil {
+		return toSentinelErrors(err)
+	}
+	return nil
+}
+
+// PutCRC uploads an object in a single request, after checking its CRC32C checksum.
+//
+// The object is checked in transit with its MD5 checksum, which S3 verifies, and the CRC32C checksum is kept as
+// user metadata on the object.
+func (s *s3FS) PutCRC(ctx context.Context, key string, rdr io.Reader, doesNotExist bool, crc uint32) error {
+	b, err := ioutil.ReadAll(io.LimitReader(rdr, storage.MaxObjectSizeInMemory+1))
+	if err != nil {
+		return err
+	}
+	if len(b) > storage.MaxObjectSizeInMemory {
+		return storage.ErrObjectTooBig
+	}
+	if actual := crc32.Checksum(b, crc32.MakeTable(crc32.Castagnoli)); actual != crc {
+		return fmt.Errorf("crc32c mismatch for %s: expected %08x, got %08x", key, crc, actual)
+	}
+
+	md5sum := md5.Sum(b)
+	crcBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(crcBytes, crc)
+	var opts []request.Option
+	if doesNotExist {
+		opts = append(opts, ifNoneMatch)
+	}
+	_, err = s.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(key),
+		Body:       bytes.NewReader(b),
+		ContentMD5: aws.String(base64.StdEncoding.EncodeToString(md5sum[:])),
+		Metadata: map[string]*string{
+			crc32cMetadata: aws.String(base64.StdEncoding.EncodeToString(crcBytes)),
+		},
+	}, opts...)
+	if err != nil {
+		return toSentinelErrors(err)
+	}
+	return nil
 }
 
 func (s *s3FS) Delete(ctx context.Context, key string) error {
-	_, err := s.s3.DeleteObject(&s3.DeleteObjectInput{
+	_, err := s.s3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(key),
 	})
@@ -128,40 +197,53 @@ func (s *s3FS) Keys(ctx context.Context) ([]string, error) {
 	return keys, nil
 }
 
+// KeysPrefix lists a page of at most count keys (PageSize if not set) under some prefix, starting after the key
+// given as token. With a delimiter, common prefixes are listed as well.
+//
+// The token of the next page is empty on the last page.
 func (s *s3FS) KeysPrefix(ctx context.Context, token, prefix, delimiter string, count int) ([]string, string, error) {
-	var keys []string
-	var isTruncated bool
-
-	eachPage := func(page *s3.ListObjectsOutput, more bool) bool {
-		isTruncated = aws.BoolValue(page.IsTruncated)
-
-		for _, obj := range page.Contents {
-			key := aws.StringValue(obj.Key)
-			if key != "" {
-				keys = append(keys, key)
-			}
-		}
-		return more
+	if count <= 0 || count > PageSize {
+		count = PageSize
 	}
-
 	params := &s3.ListObjectsInput{
-		Bucket:    aws.String(s.bucket),
-		Prefix:    aws.String(prefix),
-		Delimiter: aws.String(delimiter),
-		MaxKeys:   aws.Int64(PageSize),
-		Marker:    aws.String(token),
+		Bucket:  aws.String(s.bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int64(int64(count)),
+	}
+	if delimiter != "" {
+		params.Delimiter = aws.String(delimiter)
+	}
+	if token != "" {
+		params.Marker = aws.String(token)
 	}
 
-	err := s.s3.ListObjectsPagesWithContext(ctx, params, eachPage)
+	page, err := s.s3.ListObjectsWithContext(ctx, params)
 	if err != nil {
 		return nil, "", err
 	}
 
-	log.Printf("Truncated %v ", isTruncated)
-	if isTruncated {
-		token = keys[len(keys)-1]
+	keys := make([]string, 0, len(page.Contents)+len(page.CommonPrefixes))
+	for _, obj := range page.Contents {
+		if key := aws.StringValue(obj.Key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	for _, common := range page.CommonPrefixes {
+		if key := aws.StringValue(common.Prefix); key != "" {
+			keys = append(keys, key)
+		}
 	}
-	return keys, token, nil
+	sort.Strings(keys)
+
+	if !aws.BoolValue(page.IsTruncated) {
+		return keys, "", nil
+	}
+	// NextMarker is only returned with a delimiter: the last key listed is the marker otherwise
+	next := aws.StringValue(page.NextMarker)
+	if next == "" && len(keys) > 0 {
+		next = keys[len(keys)-1]
+	}
+	return keys, next, nil
 }
 
 func (s *s3FS) Clear(ctx context.Context) error {
@@ -174,14 +256,78 @@ func (s *s3FS) String() string {
 	return "s3@" + s.bucket
 }
 
+type s3Reader struct {
+	s   *s3FS
+	ctx context.Context
+	key string
+}
+
+// ReadAt reads a range of the object with a Range request
+func (r *s3Reader) ReadAt(p []byte, offset int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	obj, err := r.s.s3.GetObjectWithContext(r.ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.s.bucket),
+		Key:    aws.String(r.key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+int64(len(p))-1)),
+	})
+	if err != nil {
+		if awse, ok := err.(awserr.Error); ok && awse.Code() == "InvalidRange" {
+			// the offset is past the end of the object
+			return 0, io.EOF
+		}
+		return 0, toSentinelErrors(err)
+	}
+	defer obj.Body.Close()
+
+	n, err := io.ReadFull(obj.Body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
 func (s *s3FS) GetAt(ctx context.Context, objectName string) (io.ReaderAt, error) {
-	return nil, errors.New("unimplemented")
+	return &s3Reader{
+		s:   s,
+		ctx: ctx,
+		key: objectName,
+	}, nil
 }
 
 func (s *s3FS) GetAttr(ctx context.Context, objectName string) (storage.Attributes, error) {
-	panic("implement me")
+	head, err := s.s3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objectName),
+	})
+	if err != nil {
+		return storage.Attributes{}, toSentinelErrors(err)
+	}
+	// S3 objects are immutable: they are created when last modified
+	return storage.Attributes{
+		Created: aws.TimeValue(head.LastModified),
+		Updated: aws.TimeValue(head.LastModified),
+		Size:    aws.Int64Value(head.ContentLength),
+	}, nil
 }
 
+// Touch updates the modification time of an object, by copying it onto itself
 func (s *s3FS) Touch(ctx context.Context, objectName string) error {
-	panic("implement me")
+	head, err := s.s3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objectName),
+	})
+	if err != nil {
+		return toSentinelErrors(err)
+	}
+	_, err = s.s3.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(s.bucket),
+		Key:               aws.String(objectName),
+		CopySource:        aws.String((&url.URL{Path: s.bucket + "/" + objectName}).EscapedPath()),
+		ContentType:       head.ContentType,
+		Metadata:          head.Metadata,
+		MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+	})
+	return toSentinelErrors(err)
 }
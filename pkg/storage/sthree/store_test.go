@@ -3,9 +3,13 @@ package sthree
 import (
 	"bytes"
 	"context"
+	"errors"
+	"hash/crc32"
+	"io"
 	"io/ioutil"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -14,6 +18,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/oneconcern/datamon/internal"
 	"github.com/oneconcern/datamon/pkg/storage"
+	"github.com/oneconcern/datamon/pkg/storage/status"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -103,10 +108,130 @@ func TestKeysPrefix(t *testing.T) {
 	bs, cleanup := setupStore(t)
 	defer cleanup()
 
-	keys, token, err := bs.KeysPrefix(context.Background(), "", "", "", 0)
+	ctx := context.Background()
+	keys, token, err := bs.KeysPrefix(ctx, "", "", "", 0)
 	require.NoError(t, err)
 	require.Len(t, keys, 2)
 	require.Equal(t, token, "")
+
+	for _, key := range []string{"dir/a", "dir/b", "dir/sub/c"} {
+		require.NoError(t, bs.Put(ctx, key, bytes.NewBufferString(key), storage.NoOverWrite))
+	}
+
+	// pages of keys
+	var all []string
+	for {
+		keys, token, err = bs.KeysPrefix(ctx, token, "", "", 2)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(keys), 2)
+		all = append(all, keys...)
+		if token == "" {
+			break
+		}
+	}
+	require.Equal(t, []string{"dir/a", "dir/b", "dir/sub/c", "seventeentons", "sixteentons"}, all)
+
+	// common prefixes
+	keys, token, err = bs.KeysPrefix(ctx, "", "", "/", 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir/", "seventeentons", "sixteentons"}, keys)
+	require.Empty(t, token)
+
+	keys, token, err = bs.KeysPrefix(ctx, "", "dir/", "/", 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir/a", "dir/b"}, keys)
+	require.NotEmpty(t, token)
+	keys, token, err = bs.KeysPrefix(ctx, token, "dir/", "/", 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir/sub/"}, keys)
+	require.Empty(t, token)
+}
+
+func TestPutNoOverWrite(t *testing.T) {
+	bs, cleanup := setupStore(t)
+	defer cleanup()
+
+	err := bs.Put(context.Background(), "sixteentons", bytes.NewBufferString("other text"), storage.NoOverWrite)
+	require.True(t, errors.Is(err, storage.ErrExists), "got %v", err)
+	require.NoError(t, bs.Put(context.Background(), "sixteentons", bytes.NewBufferString("other text"), storage.OverWrite))
+
+	rdr, err := bs.Get(context.Background(), "sixteentons")
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(rdr)
+	require.NoError(t, err)
+	assert.Equal(t, "other text", string(b))
+}
+
+func TestPutCRC(t *testing.T) {
+	bs, cleanup := setupStore(t)
+	defer cleanup()
+	crcStore, ok := bs.(storage.StoreCRC)
+	require.True(t, ok)
+
+	content := []byte("content with a checksum")
+	crc := crc32.Checksum(content, crc32.MakeTable(crc32.Castagnoli))
+	require.NoError(t, crcStore.PutCRC(context.Background(), "crc", bytes.NewReader(content), storage.NoOverWrite, crc))
+	err := crcStore.PutCRC(context.Background(), "crc", bytes.NewReader(content), storage.NoOverWrite, crc)
+	require.True(t, errors.Is(err, storage.ErrExists), "got %v", err)
+	require.NoError(t, crcStore.PutCRC(context.Background(), "crc", bytes.NewReader(content), storage.OverWrite, crc))
+	require.Error(t, crcStore.PutCRC(context.Background(), "bad-crc", bytes.NewReader(content), storage.OverWrite, crc+1))
+
+	has, err := bs.Has(context.Background(), "bad-crc")
+	require.NoError(t, err)
+	require.False(t, has)
+	rdr, err := bs.Get(context.Background(), "crc")
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(rdr)
+	require.NoError(t, err)
+	assert.Equal(t, content, b)
+}
+
+func TestGetAt(t *testing.T) {
+	bs, cleanup := setupStore(t)
+	defer cleanup()
+
+	rdrAt, err := bs.GetAt(context.Background(), "seventeentons")
+	require.NoError(t, err)
+	p := make([]byte, 4)
+	n, err := rdrAt.ReadAt(p, 8)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, "the ", string(p))
+
+	// "this is the text for another thing" is 34 bytes long
+	n, err = rdrAt.ReadAt(p, 32)
+	require.Equal(t, io.EOF, err)
+	assert.Equal(t, "ng", string(p[:n]))
+	_, err = rdrAt.ReadAt(p, 100)
+	require.Equal(t, io.EOF, err)
+
+	missing, err := bs.GetAt(context.Background(), "fifteentons")
+	require.NoError(t, err)
+	_, err = missing.ReadAt(p, 0)
+	require.True(t, errors.Is(err, status.ErrNotExists), "got %v", err)
+}
+
+func TestGetAttrAndTouch(t *testing.T) {
+	bs, cleanup := setupStore(t)
+	defer cleanup()
+
+	attrs, err := bs.GetAttr(context.Background(), "sixteentons")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("this is the text")), attrs.Size)
+	assert.False(t, attrs.Updated.IsZero())
+
+	time.Sleep(1100 * time.Millisecond)
+	require.NoError(t, bs.Touch(context.Background(), "sixteentons"))
+	touched, err := bs.GetAttr(context.Background(), "sixteentons")
+	require.NoError(t, err)
+	assert.True(t, touched.Updated.After(attrs.Updated))
+	assert.Equal(t, attrs.Size, touched.Size)
+
+	_, err = bs.GetAttr(context.Background(), "fifteentons")
+	require.True(t, errors.Is(err, status.ErrNotExists), "got %v", err)
+	require.True(t, errors.Is(bs.Touch(context.Background(), "fifteentons"), status.ErrNotExists))
+	_, err = bs.Get(context.Background(), "fifteentons")
+	require.True(t, errors.Is(err, status.ErrNotExists), "got %v", err)
 }
 
 func setupStore(t testing.TB) (storage.Store, func()) {
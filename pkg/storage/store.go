@@ -5,6 +5,7 @@ package storage
 import (
 	"context"
 	"io"
+	"os"
 	"time"
 )
 
@@ -35,6 +36,7 @@ type Attributes struct {
 	Created time.Time
 	Updated time.Time
 	Owner   string
+	Size    int64
 }
 
 // Store implementations know how to write entries to a K/V model.Store.
@@ -59,6 +61,23 @@ type StoreCRC interface {
 	PutCRC(context.Context, string, io.Reader, bool, uint32) error
 }
 
+// StoreFS is implemented by stores backed by a file system, which know about
+// file modes, symbolic links and directories.
+//
+// The keys of such stores include symbolic links, which are not followed.
+type StoreFS interface {
+	// Lstat describes a key, without following symbolic links
+	Lstat(context.Context, string) (os.FileInfo, error)
+	Readlink(context.Context, string) (string, error)
+	// Symlink creates a symbolic link at key, pointing to target
+	Symlink(ctx context.Context, target string, key string) error
+	Chmod(context.Context, string, os.FileMode) error
+	// Mkdir creates a directory and its parents
+	Mkdir(context.Context, string, os.FileMode) error
+	// EmptyDirs lists the directories without any entry
+	EmptyDirs(context.Context) ([]string, error)
+}
+
 func PipeIO(writer io.Writer, reader io.Reader) (n int64, err error) {
 	pr, pw := io.Pipe()
 	errC := make(chan error, 1)
@@ -1,9 +1,10 @@
 package wal
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"strings"
 	"time"
 
@@ -86,17 +87,21 @@ func (w *WAL) getToken(ctx context.Context) (string, error) {
 }
 
 // Adds a WAL entry to WAL
-func (w *WAL) Add(ctx context.Context, p string) (string, error) {
+func (w *WAL) Add(ctx context.Context, p model.Payload) (string, error) {
 	e := model.Entry{
 		Payload: p,
 	}
 	var err error
 	e.Token, err = w.getToken(ctx)
 	if err != nil {
-		w.l.Error("failed to generate token for entry", zap.Error(err), zap.String("payload", p))
+		w.l.Error("failed to generate token for entry", zap.Error(err), zap.Stringer("payload", p))
 		return "", fmt.Errorf("failed to generate token: %v", err)
 	}
-	err = w.walStore.Put(ctx, e.Token, strings.NewReader(e.Payload), storage.NoOverWrite) // Should be a new entry
+	b, err := model.MarshalWAL(&e)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal wal entry: %v", err)
+	}
+	err = w.walStore.Put(ctx, e.Token, bytes.NewReader(b), storage.NoOverWrite) // Should be a new entry
 	if err != nil {
 		w.l.Error("failed to add wal entry", zap.Error(err), zap.String("token", e.Token))
 		return "", fmt.Errorf("failed to add wal token: %s, entry: %s", e.Token, err.Error())
@@ -124,28 +129,103 @@ func (w *WAL) ListTokens(ctx context.Context, fromToken string, max int) (tokens
 			zap.Int("max", max))
 		return nil, "", fmt.Errorf("max count needs to be greater than 0 : %d, fromToken:%s", max, fromToken)
 	}
-	k, err := ksuid.Parse(fromToken)
+	if max > maxEntriesPerList {
+		max = maxEntriesPerList
+	}
+	if fromToken == "" {
+		tokens, next, err = w.walStore.KeysPrefix(ctx, "", "", "", max)
+		if err != nil {
+			w.l.Error("failed to get tokens", zap.Error(err))
+		}
+		return tokens, next, err
+	}
+	backDatedToken, err := w.backDate(fromToken)
 	if err != nil {
 		return nil, "", err
 	}
-	if max > maxEntriesPerList {
-		max = maxEntriesPerList
+	tokens, next, err = w.walStore.KeysPrefix(ctx, backDatedToken, "", "", max)
+	if err != nil {
+		w.l.Error("failed to get tokens",
+			zap.Error(err),
+			zap.String("backDatedToken", backDatedToken),
+			zap.String("token", fromToken))
 	}
+	return tokens, next, err
+}
 
-	// Go back in time to include the keys that might have been written before the token was generated.
+// backDate goes back in time from a token to include the keys that might have been written before the token was
+// generated.
+func (w *WAL) backDate(fromToken string) (string, error) {
+	k, err := ksuid.Parse(fromToken)
+	if err != nil {
+		return "", err
+	}
 	b := []byte{0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}
 	ksuidOld, err := ksuid.FromParts(k.Time().Add(-w.GetExpirationDuration()*2), b)
 	if err != nil {
 		w.l.Error("failed to calculate first token", zap.Error(err), zap.String("fromToken", fromToken))
 	}
-	tokens, next, err = w.walStore.KeysPrefix(ctx, ksuidOld.String(), "", "", max)
+	return ksuidOld.String(), nil
+}
+
+// Walk reads the entries in the WAL in token order and applies fn to each of them.
+//
+// If fromToken is empty the WAL is read from the beginning. Otherwise, like ListTokens, reading starts back in time
+// from the token so that entries written late are not missed: callers following the WAL must expect duplicates.
+// Unlike ListEntries, Walk pages through the whole WAL store until its end.
+func (w *WAL) Walk(ctx context.Context, fromToken string, fn func(*model.Entry) error) error {
+	return w.WalkTokens(ctx, fromToken, func(token string) error {
+		entry, err := w.GetEntry(ctx, token)
+		if err != nil {
+			return err
+		}
+		return fn(entry)
+	})
+}
+
+// WalkTokens lists the tokens in the WAL like Walk does, without reading the entries.
+func (w *WAL) WalkTokens(ctx context.Context, fromToken string, fn func(string) error) error {
+	var pageToken string
+	if fromToken != "" {
+		var err error
+		pageToken, err = w.backDate(fromToken)
+		if err != nil {
+			return err
+		}
+	}
+	for {
+		tokens, next, err := w.walStore.KeysPrefix(ctx, pageToken, "", "", maxEntriesPerList)
+		if err != nil {
+			return fmt.Errorf("wal failed to list tokens: %v", err)
+		}
+		for _, token := range tokens {
+			if err = fn(token); err != nil {
+				return err
+			}
+		}
+		if next == "" || next == pageToken {
+			return nil
+		}
+		pageToken = next
+	}
+}
+
+// GetEntry reads a single entry from the WAL.
+func (w *WAL) GetEntry(ctx context.Context, token string) (*model.Entry, error) {
+	r, err := w.walStore.Get(ctx, token)
 	if err != nil {
-		w.l.Error("failed to get tokens",
-			zap.Error(err),
-			zap.String("backDatedToken", ksuidOld.String()),
-			zap.String("token", fromToken))
+		return nil, fmt.Errorf("token: %s, err: %s", token, err)
 	}
-	return tokens, next, err
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("token: %s, err: %s", token, err)
+	}
+	entry, err := model.UnmarshalWAL(b)
+	if err != nil {
+		return nil, fmt.Errorf("token: %s, err: %s", token, err)
+	}
+	return entry, nil
 }
 
 func (w *WAL) getConnection() {
@@ -161,7 +241,8 @@ type walChannels struct {
 	entry   chan *model.Entry
 	entries chan []model.Entry
 	count   chan int
-	oops    chan error
+	oops    chan error // reports the final error of a listing
+	readErr chan error // reports errors of individual reads to the collector
 	done    chan struct{}
 }
 
@@ -170,12 +251,16 @@ func newWalChannels() *walChannels {
 	entry := make(chan *model.Entry)
 	entries := make(chan []model.Entry)
 	count := make(chan int)
+	oops := make(chan error)
+	readErr := make(chan error)
 	done := make(chan struct{})
 	return &walChannels{
 		tokens:  token,
 		entry:   entry,
 		entries: entries,
 		count:   count,
+		oops:    oops,
+		readErr: readErr,
 		done:    done,
 	}
 }
@@ -243,24 +328,10 @@ func (w *WAL) issueParallelReads(ctx context.Context, channels *walChannels) {
 
 func (w *WAL) read(ctx context.Context, token string, channels *walChannels) {
 	defer w.releaseConnection() // concurrency control
-	r, err := w.walStore.Get(ctx, token)
+	entry, err := w.GetEntry(ctx, token)
 	w.l.Debug("Read token", zap.String("token", token))
-	defer r.Close()
-	if err != nil {
-		channels.oops <- err
-		return
-	}
-	b := make([]byte, 1024)
-	for {
-		l, e := r.Read(b)
-		if e == io.EOF {
-			b = b[:l]
-			break
-		}
-	}
-	entry, err := model.UnmarshalWAL(b)
 	if err != nil {
-		channels.oops <- fmt.Errorf("token: %s, err: %s", token, err)
+		channels.readErr <- err
 		return
 	}
 	channels.entry <- entry
@@ -312,7 +383,7 @@ func (w *WAL) collectParallelResponses(ctx context.Context, channels *walChannel
 				finalize()
 				return
 			}
-		case err = <-channels.oops:
+		case err = <-channels.readErr:
 			// Log all errors
 			w.l.Error("failed to read token", zap.Error(err))
 			count++
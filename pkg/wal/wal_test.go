@@ -38,6 +38,10 @@ const (
 	touchError = "touchErrorTest"
 )
 
+func testPayload() model.Payload {
+	return model.NewRepoCreatePayload(model.RepoDescriptor{Name: payload})
+}
+
 func constStringWithIndex(i int) string {
 	return longPath + fmt.Sprint(i)
 }
@@ -389,8 +393,15 @@ func (m *mockMutableStoreTestAdd) Put(_ context.Context, key string, reader io.R
 	if err != nil {
 		return err
 	}
-	if payload != string(b) {
-		return fmt.Errorf("payload does not match: %s", string(b))
+	e, err := model.UnmarshalWAL(b)
+	if err != nil {
+		return err
+	}
+	if e.Token != key {
+		return fmt.Errorf("token does not match key: %s", e.Token)
+	}
+	if e.Payload.Type != model.PayloadTypeRepoCreate || e.Payload.Repo != payload {
+		return fmt.Errorf("payload does not match: %s", e.Payload)
 	}
 	if overwrite == storage.OverWrite {
 		return fmt.Errorf("no overwrites expected")
@@ -426,7 +437,7 @@ func TestWAL_Add(t *testing.T) {
 	}
 	type args struct {
 		ctx context.Context
-		p   string
+		p   model.Payload
 	}
 	l, err := zap.NewDevelopment()
 	require.NoError(t, err)
@@ -461,7 +472,7 @@ func TestWAL_Add(t *testing.T) {
 			},
 			args: args{
 				ctx: nil,
-				p:   payload,
+				p:   testPayload(),
 			},
 			wantErr: false,
 		},
@@ -488,7 +499,7 @@ func TestWAL_Add(t *testing.T) {
 			},
 			args: args{
 				ctx: nil,
-				p:   payload,
+				p:   testPayload(),
 			},
 			wantErr: true,
 			validateError: func(err error) bool {
@@ -518,7 +529,7 @@ func TestWAL_Add(t *testing.T) {
 			},
 			args: args{
 				ctx: nil,
-				p:   payload,
+				p:   testPayload(),
 			},
 			wantErr: true,
 			validateError: func(err error) bool {
@@ -548,7 +559,7 @@ func TestWAL_Add(t *testing.T) {
 			},
 			args: args{
 				ctx: nil,
-				p:   payload,
+				p:   testPayload(),
 			},
 			wantErr: true,
 			validateError: func(err error) bool {
@@ -618,7 +629,7 @@ func (r *rc) Close() error {
 func (r *rc) Read(p []byte) (n int, err error) {
 	e := model.Entry{
 		Token:   r.s,
-		Payload: r.s,
+		Payload: model.NewRepoCreatePayload(model.RepoDescriptor{Name: r.s}),
 	}
 	b, err := model.MarshalWAL(&e)
 	if err != nil {
@@ -815,3 +826,38 @@ func TestWAL_ListEntries(t *testing.T) {
 		})
 	}
 }
+
+func TestWAL_Walk(t *testing.T) {
+	t.Parallel()
+	l, err := zap.NewDevelopment()
+	require.NoError(t, err)
+	w := NewWAL(localfs.New(afero.NewMemMapFs()), localfs.New(afero.NewMemMapFs()), l)
+
+	ctx := context.Background()
+	added := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		token, e := w.Add(ctx, model.NewRepoCreatePayload(model.RepoDescriptor{Name: fmt.Sprint(i)}))
+		require.NoError(t, e)
+		added[token] = true
+	}
+
+	var last string
+	walked := make(map[string]bool)
+	require.NoError(t, w.Walk(ctx, "", func(entry *model.Entry) error {
+		require.True(t, entry.Token > last, "entries are walked in token order")
+		last = entry.Token
+		walked[entry.Token] = true
+		return nil
+	}))
+	require.Equal(t, added, walked)
+
+	// walking from a token goes back in time
+	count := 0
+	require.NoError(t, w.Walk(ctx, last, func(entry *model.Entry) error {
+		count++
+		return nil
+	}))
+	require.Equal(t, len(added), count)
+
+	require.Error(t, w.Walk(ctx, "not-a-token", func(*model.Entry) error { return nil }))
+}